@@ -2,21 +2,65 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 
 	"github.com/jasonrowsell/zerocache/internal/cache"
+	"github.com/jasonrowsell/zerocache/internal/script"
 	"github.com/jasonrowsell/zerocache/internal/server"
 )
 
 var (
-	listenAddr       = flag.String("listen", ":6380", "Address to listen on (e.g., :6380 or 127.0.0.1:6380)")
-	shardCount       = flag.Int("shards", 256, "Number of cache shards (must be power of 2)")
-	maxItemsPerShard = flag.Int("max-items", 1024, "Max items per shard (0 for unlimited)")
+	listenAddr        = flag.String("listen", ":6380", "Address to listen on (e.g., :6380 or 127.0.0.1:6380)")
+	shardCount        = flag.Int("shards", 256, "Number of cache shards (must be power of 2)")
+	maxItemsPerShard  = flag.Int("max-items", 1024, "Max items per shard (0 for unlimited)")
+	maxBytesPerShard  = flag.Int("max-bytes", 0, "Approximate max memory in bytes per shard (0 for unlimited)")
+	compressThreshold = flag.Int("compress-threshold", 0, "Size in bytes from which values are stored compressed, if that makes them smaller (0 to disable)")
+	engine            = flag.String("engine", "map", "Storage engine for plain values: map or arena")
+	arenaBytes        = flag.Int("arena-bytes", 1<<20, "Size in bytes of each shard's arena with -engine=arena")
+	hasher            = flag.String("hasher", "fnv1a", "Key hash: fnv1a, or maphash with a random per-process seed against hash flooding")
+	keyspaceEvents    = flag.String("keyspace-events", "", "Keyspace events to publish over pub/sub: comma-separated set, del, expired, evicted, or all (empty for none)")
+	scriptMaxSteps    = flag.Int("script-max-steps", server.DefaultScriptMaxSteps, "Max expressions a script may evaluate per run (0 for unlimited)")
+	scriptTimeout     = flag.Duration("script-timeout", server.DefaultScriptTimeout, "Max time a script may run, holding its keys locked (0 for unlimited)")
+	maxStreamValue    = flag.Int("max-stream-value-size", server.DefaultMaxStreamValueSize, "Max size in bytes of a value stored with SETSTREAM")
+	netEngine         = flag.String("net-engine", "goroutine", "Network engine: goroutine per connection, or epoll with a pool of workers (Linux only)")
+	epollWorkers      = flag.Int("epoll-workers", 0, "Worker goroutines with -net-engine=epoll (0 for GOMAXPROCS)")
+	namespaces        []namespaceSpec
 )
 
+// namespaceSpec is a namespace declared with -namespace. Unset limits (-1)
+// fall back to -max-items and -max-bytes.
+type namespaceSpec struct {
+	name     string
+	maxItems int
+	maxBytes int
+}
+
+func init() {
+	flag.Func("namespace", "Declare a namespace as name[:max-items[:max-bytes]] with its own per-shard limits (repeatable)", func(v string) error {
+		parts := strings.Split(v, ":")
+		if len(parts) > 3 || parts[0] == "" {
+			return fmt.Errorf("expected name[:max-items[:max-bytes]], got %q", v)
+		}
+		spec := namespaceSpec{name: parts[0], maxItems: -1, maxBytes: -1}
+		limits := []*int{&spec.maxItems, &spec.maxBytes}
+		for i, part := range parts[1:] {
+			n, err := strconv.Atoi(part)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid limit %q in %q", part, v)
+			}
+			*limits[i] = n
+		}
+		namespaces = append(namespaces, spec)
+		return nil
+	})
+}
+
 func main() {
 	flag.Parse()
 
@@ -27,23 +71,86 @@ func main() {
 	if *maxItemsPerShard < 0 {
 		log.Fatalf("Error: max items per shard (-max-items=%d) cannot be negative.", *maxItemsPerShard)
 	}
+	if *maxBytesPerShard < 0 {
+		log.Fatalf("Error: max bytes per shard (-max-bytes=%d) cannot be negative.", *maxBytesPerShard)
+	}
+	if *maxStreamValue < 0 {
+		log.Fatalf("Error: max stream value size (-max-stream-value-size=%d) cannot be negative.", *maxStreamValue)
+	}
+	if *compressThreshold < 0 {
+		log.Fatalf("Error: compress threshold (-compress-threshold=%d) cannot be negative.", *compressThreshold)
+	}
+	if *arenaBytes <= 0 {
+		log.Fatalf("Error: arena size (-arena-bytes=%d) must be positive.", *arenaBytes)
+	}
+	storageEngine, err := cache.ParseEngine(*engine)
+	if err != nil {
+		log.Fatalf("Error: -engine=%s: %v", *engine, err)
+	}
+	var keyHasher cache.Hasher
+	switch *hasher {
+	case "fnv1a":
+		keyHasher = cache.FNV1a{}
+	case "maphash":
+		keyHasher = cache.NewMapHasher()
+	default:
+		log.Fatalf("Error: -hasher=%s: expected fnv1a or maphash", *hasher)
+	}
+	if *netEngine != "goroutine" && *netEngine != "epoll" {
+		log.Fatalf("Error: -net-engine=%s: expected goroutine or epoll", *netEngine)
+	}
+	if *epollWorkers < 0 {
+		log.Fatalf("Error: epoll workers (-epoll-workers=%d) cannot be negative.", *epollWorkers)
+	}
+	events, err := cache.ParseEventTypes(*keyspaceEvents)
+	if err != nil {
+		log.Fatalf("Error: -keyspace-events=%s: %v", *keyspaceEvents, err)
+	}
 
 	log.Println("Starting ZeroCache server...")
-	log.Printf("Configuration: Listen Addr=%s, Shards=%d, MaxItems/Shard=%d", *listenAddr, *shardCount, *maxItemsPerShard)
+	log.Printf("Configuration: Listen Addr=%s, Shards=%d, MaxItems/Shard=%d, MaxBytes/Shard=%d, Engine=%s", *listenAddr, *shardCount, *maxItemsPerShard, *maxBytesPerShard, storageEngine)
 
 	cacheConfig := cache.Config{
-		ShardCount:       *shardCount,
-		MaxItemsPerShard: *maxItemsPerShard,
+		ShardCount:         *shardCount,
+		MaxItemsPerShard:   *maxItemsPerShard,
+		MaxBytesPerShard:   *maxBytesPerShard,
+		CompressThreshold:  *compressThreshold,
+		Engine:             storageEngine,
+		ArenaBytesPerShard: *arenaBytes,
+		Hasher:             keyHasher,
 	}
 	c := cache.NewWithConfig(cacheConfig)
 
 	svr := server.New(c)
+	for _, ns := range namespaces {
+		nsConfig := cacheConfig
+		if ns.maxItems >= 0 {
+			nsConfig.MaxItemsPerShard = ns.maxItems
+		}
+		if ns.maxBytes >= 0 {
+			nsConfig.MaxBytesPerShard = ns.maxBytes
+		}
+		if err := svr.AddNamespace(ns.name, cache.NewWithConfig(nsConfig)); err != nil {
+			log.Fatalf("Error: -namespace=%s: %v", ns.name, err)
+		}
+		log.Printf("Namespace %s: MaxItems/Shard=%d, MaxBytes/Shard=%d", ns.name, nsConfig.MaxItemsPerShard, nsConfig.MaxBytesPerShard)
+	}
+	svr.SetScriptLimits(script.Limits{MaxSteps: *scriptMaxSteps, Timeout: *scriptTimeout})
+	svr.SetMaxStreamValueSize(*maxStreamValue)
+	if events != 0 {
+		svr.NotifyKeyspaceEvents(events)
+		log.Printf("Publishing keyspace events: %s", events)
+	}
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
 	go func() {
-		if err := svr.ListenAndServe(*listenAddr); err != nil {
+		serve := svr.ListenAndServe
+		if *netEngine == "epoll" {
+			serve = func(addr string) error { return svr.ListenAndServeEpoll(addr, *epollWorkers) }
+		}
+		if err := serve(*listenAddr); err != nil {
 			log.Fatalf("Failed to start server: %v", err)
 		}
 	}()
@@ -2,12 +2,22 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"math/rand"
 	"net"
 	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -15,6 +25,7 @@ import (
 	zcCache "github.com/jasonrowsell/zerocache/internal/cache"
 	zcServer "github.com/jasonrowsell/zerocache/internal/server"
 	zcClient "github.com/jasonrowsell/zerocache/pkg/client"
+	zcProtocol "github.com/jasonrowsell/zerocache/pkg/protocol"
 )
 
 const benchmarkServerAddr = "127.0.0.1:6381"
@@ -33,6 +44,14 @@ func startBenchmarkServer() {
 	serverErrChan = make(chan error, 1)
 	c := zcCache.New() // Use default settings
 	srv := zcServer.New(c)
+	// Small tenant namespaces for the namespace tests.
+	for _, name := range []string{"tenant_a", "tenant_b"} {
+		if err := srv.AddNamespace(name, zcCache.NewWithConfig(zcCache.Config{ShardCount: 1, MaxItemsPerShard: 100})); err != nil {
+			panic(err)
+		}
+	}
+	srv.NotifyKeyspaceEvents(zcCache.EventAll)
+	srv.SetMaxStreamValueSize(4 << 20)
 
 	go func() {
 		err := srv.ListenAndServe(benchmarkServerAddr)
@@ -363,3 +382,1545 @@ func BenchmarkE2EMixed(b *testing.B) {
 		}
 	})
 }
+
+func TestE2ECompareAndSwap(t *testing.T) {
+	key := fmt.Sprintf("cas_%d", time.Now().UnixNano())
+
+	if stored, err := benchClient.SetNX(key, []byte("a")); err != nil || !stored {
+		t.Fatalf("SetNX: stored=%v err=%v", stored, err)
+	}
+	if stored, err := benchClient.SetNX(key, []byte("b")); err != nil || stored {
+		t.Fatalf("SetNX on existing key: stored=%v err=%v", stored, err)
+	}
+
+	value, version, err := benchClient.GetWithVersion(key)
+	if err != nil || string(value) != "a" {
+		t.Fatalf("GetWithVersion: value=%q err=%v", value, err)
+	}
+	if _, err := benchClient.CompareAndSwap(key, []byte("c"), version); err != nil {
+		t.Fatalf("CompareAndSwap: %v", err)
+	}
+	if _, err := benchClient.CompareAndSwap(key, []byte("d"), version); err != zcClient.ErrVersionMismatch {
+		t.Fatalf("CompareAndSwap with stale version: got %v, want ErrVersionMismatch", err)
+	}
+	if _, err := benchClient.CompareAndSwap(key+"_missing", []byte("d"), version); err != zcClient.ErrNotFound {
+		t.Fatalf("CompareAndSwap on missing key: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestE2ECounters(t *testing.T) {
+	key := fmt.Sprintf("counter_%d", time.Now().UnixNano())
+
+	if v, err := benchClient.Incr(key); err != nil || v != 1 {
+		t.Fatalf("Incr: got %d, %v", v, err)
+	}
+	if v, err := benchClient.IncrBy(key, 41); err != nil || v != 42 {
+		t.Fatalf("IncrBy: got %d, %v", v, err)
+	}
+	if v, err := benchClient.Decr(key); err != nil || v != 41 {
+		t.Fatalf("Decr: got %d, %v", v, err)
+	}
+
+	if err := benchClient.Set(key, []byte("not a number")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := benchClient.Incr(key); err != zcClient.ErrNotInteger {
+		t.Fatalf("Incr on non-numeric value: got %v, want ErrNotInteger", err)
+	}
+
+	// TTLs past the year 2262, up to ones beyond what a time.Duration holds,
+	// keep the counter rather than wrapping around and expiring it at once.
+	// The client cannot send the largest, so they go out as raw frames.
+	conn, err := net.Dial("tcp", benchmarkServerAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	centuries := uint64(250 * 365 * 24 * time.Hour / time.Millisecond)
+	for i, ttlMillis := range []uint64{centuries, math.MaxInt64/uint64(time.Millisecond) + 1, math.MaxUint64} {
+		ttlKey := fmt.Sprintf("%s_ttl%d", key, i)
+		payload := zcProtocol.EncodeArgs(zcProtocol.EncodeInt64(1), zcProtocol.EncodeUint64(ttlMillis))
+		if _, err := conn.Write(rawFrame(zcProtocol.CmdIncrBy, ttlKey, payload)); err != nil {
+			t.Fatal(err)
+		}
+		if respType, msg, err := readRawResponse(conn); err != nil || respType != zcProtocol.RespInt {
+			t.Fatalf("IncrBy with a TTL of %d ms: got type %d %q, %v", ttlMillis, respType, msg, err)
+		}
+		if value, err := benchClient.Get(ttlKey); err != nil || string(value) != "1" {
+			t.Fatalf("Get after IncrBy with a TTL of %d ms: got %q, %v", ttlMillis, value, err)
+		}
+	}
+}
+
+// rawFrame encodes a command frame, for sending what the client would not.
+func rawFrame(cmdType uint8, key string, payload []byte) []byte {
+	b := []byte{cmdType}
+	b = binary.BigEndian.AppendUint32(b, uint32(len(key)))
+	b = binary.BigEndian.AppendUint32(b, uint32(len(payload)))
+	return append(append(b, key...), payload...)
+}
+
+// readRawResponse reads one response frame.
+func readRawResponse(r io.Reader) (uint8, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	body := make([]byte, binary.BigEndian.Uint32(header[1:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header[0], body, nil
+}
+
+func TestE2EHash(t *testing.T) {
+	key := fmt.Sprintf("hash_%d", time.Now().UnixNano())
+
+	added, err := benchClient.HSet(key, map[string][]byte{"a": []byte("1"), "b": []byte("2")})
+	if err != nil || added != 2 {
+		t.Fatalf("HSet: got %d, %v", added, err)
+	}
+	if value, err := benchClient.HGet(key, "b"); err != nil || string(value) != "2" {
+		t.Fatalf("HGet: got %q, %v", value, err)
+	}
+	fields, err := benchClient.HGetAll(key)
+	if err != nil || len(fields) != 2 || string(fields["a"]) != "1" {
+		t.Fatalf("HGetAll: got %v, %v", fields, err)
+	}
+	if _, err := benchClient.Get(key); err != zcClient.ErrWrongType {
+		t.Fatalf("Get on hash: got %v, want ErrWrongType", err)
+	}
+	if removed, err := benchClient.HDel(key, "a"); err != nil || removed != 1 {
+		t.Fatalf("HDel: got %d, %v", removed, err)
+	}
+	if n, err := benchClient.HLen(key); err != nil || n != 1 {
+		t.Fatalf("HLen: got %d, %v", n, err)
+	}
+}
+
+func TestE2EBLPop(t *testing.T) {
+	key := fmt.Sprintf("queue_%d", time.Now().UnixNano())
+
+	if _, _, err := benchClient.BLPop(20*time.Millisecond, key); err != zcClient.ErrNotFound {
+		t.Fatalf("BLPop timeout: got %v, want ErrNotFound", err)
+	}
+
+	consumer, err := zcClient.New(benchmarkServerAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Close()
+
+	type popped struct {
+		key, value string
+		err        error
+	}
+	done := make(chan popped)
+	go func() {
+		k, v, err := consumer.BLPop(5*time.Second, key)
+		done <- popped{k, string(v), err}
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if _, err := benchClient.RPush(key, []byte("job-1"), []byte("job-2")); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-done; got.err != nil || got.key != key || got.value != "job-1" {
+		t.Fatalf("BLPop: got %+v", got)
+	}
+	values, err := benchClient.LRange(key, 0, -1)
+	if err != nil || len(values) != 1 || string(values[0]) != "job-2" {
+		t.Fatalf("LRange: got %q, %v", values, err)
+	}
+
+	// A timeout beyond what a time.Duration holds still blocks until a push.
+	// The client cannot send one, so it goes out as a raw frame.
+	conn, err := net.Dial("tcp", benchmarkServerAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	payload := zcProtocol.EncodeArgs(zcProtocol.EncodeUint64(math.MaxUint64), []byte(key+"_huge"))
+	if _, err := conn.Write(rawFrame(zcProtocol.CmdBLPop, "", payload)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, err := benchClient.RPush(key+"_huge", []byte("job-3")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	respType, body, err := readRawResponse(conn)
+	if err != nil || respType != zcProtocol.RespArray {
+		t.Fatalf("BLPop with a huge timeout: got type %d %q, %v", respType, body, err)
+	}
+	if elems, err := zcProtocol.DecodeArgs(body); err != nil || len(elems) != 2 || string(elems[1]) != "job-3" {
+		t.Fatalf("BLPop with a huge timeout: got %q, %v", elems, err)
+	}
+}
+
+func TestE2ESortedSet(t *testing.T) {
+	key := fmt.Sprintf("board_%d", time.Now().UnixNano())
+
+	_, err := benchClient.ZAdd(key, zcClient.ZMember{Member: "a", Score: 1.5}, zcClient.ZMember{Member: "b", Score: 3}, zcClient.ZMember{Member: "c", Score: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	top, err := benchClient.ZRevRange(key, 0, 1)
+	if err != nil || len(top) != 2 || top[0].Member != "b" || top[1] != (zcClient.ZMember{Member: "c", Score: 2}) {
+		t.Fatalf("ZRevRange: got %v, %v", top, err)
+	}
+	if score, err := benchClient.ZScore(key, "a"); err != nil || score != 1.5 {
+		t.Fatalf("ZScore: got %v, %v", score, err)
+	}
+	if rank, err := benchClient.ZRank(key, "b"); err != nil || rank != 2 {
+		t.Fatalf("ZRank: got %d, %v", rank, err)
+	}
+	if _, err := benchClient.ZRank(key, "missing"); err != zcClient.ErrNotFound {
+		t.Fatalf("ZRank on missing member: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestE2ESet(t *testing.T) {
+	suffix := time.Now().UnixNano()
+	a, b := fmt.Sprintf("set_a_%d", suffix), fmt.Sprintf("set_b_%d", suffix)
+
+	if added, err := benchClient.SAdd(a, "x", "y", "z"); err != nil || added != 3 {
+		t.Fatalf("SAdd: got %d, %v", added, err)
+	}
+	if _, err := benchClient.SAdd(b, "y", "z", "w"); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := benchClient.SIsMember(a, "x"); err != nil || !found {
+		t.Fatalf("SIsMember: got %v, %v", found, err)
+	}
+	inter, err := benchClient.SInter(a, b)
+	sort.Strings(inter)
+	if err != nil || len(inter) != 2 || inter[0] != "y" || inter[1] != "z" {
+		t.Fatalf("SInter: got %v, %v", inter, err)
+	}
+	if diff, err := benchClient.SDiff(a, b); err != nil || len(diff) != 1 || diff[0] != "x" {
+		t.Fatalf("SDiff: got %v, %v", diff, err)
+	}
+	if union, err := benchClient.SUnion(a, b); err != nil || len(union) != 4 {
+		t.Fatalf("SUnion: got %v, %v", union, err)
+	}
+	if n, err := benchClient.SCard(a); err != nil || n != 3 {
+		t.Fatalf("SCard: got %d, %v", n, err)
+	}
+}
+
+func TestE2EScan(t *testing.T) {
+	prefix := fmt.Sprintf("scan_%d_", time.Now().UnixNano())
+	for i := 0; i < 200; i++ {
+		if err := benchClient.Set(fmt.Sprintf("%s%d", prefix, i), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for key, err := range benchClient.ScanKeys(prefix+"*", 25) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[key] = true
+	}
+	if len(seen) != 200 {
+		t.Fatalf("ScanKeys found %d keys, want 200", len(seen))
+	}
+}
+
+func TestE2EDeletePattern(t *testing.T) {
+	prefix := fmt.Sprintf("delpat_%d_", time.Now().UnixNano())
+	for i := 0; i < 50; i++ {
+		if err := benchClient.Set(fmt.Sprintf("%s%d", prefix, i), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if removed, err := benchClient.DeletePattern(prefix + "*"); err != nil || removed != 50 {
+		t.Fatalf("DeletePattern: got %d, %v; want 50", removed, err)
+	}
+	if _, err := benchClient.Get(prefix + "0"); err != zcClient.ErrNotFound {
+		t.Fatalf("Get after DeletePattern: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestE2ETags(t *testing.T) {
+	suffix := time.Now().UnixNano()
+	tag := fmt.Sprintf("product:%d", suffix)
+	keys := []string{fmt.Sprintf("frag_a_%d", suffix), fmt.Sprintf("frag_b_%d", suffix)}
+	for _, key := range keys {
+		if err := benchClient.SetWithTags(key, []byte("html"), tag, "other"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if removed, err := benchClient.InvalidateTag(tag); err != nil || removed != 2 {
+		t.Fatalf("InvalidateTag: got %d, %v; want 2", removed, err)
+	}
+	for _, key := range keys {
+		if _, err := benchClient.Get(key); err != zcClient.ErrNotFound {
+			t.Fatalf("Get(%s) after InvalidateTag: got %v, want ErrNotFound", key, err)
+		}
+	}
+}
+
+func TestE2ENamespaces(t *testing.T) {
+	client, err := zcClient.New(benchmarkServerAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	key := fmt.Sprintf("ns_%d", time.Now().UnixNano())
+
+	if err := client.Select("tenant_a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Set(key, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	// A per-command view addresses another namespace over the same connection.
+	tenantB := client.Namespace("tenant_b")
+	if err := tenantB.Set(key, []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := client.Get(key); err != nil || string(value) != "a" {
+		t.Fatalf("Get in tenant_a: got %q, %v", value, err)
+	}
+	if value, err := tenantB.Get(key); err != nil || string(value) != "b" {
+		t.Fatalf("Get in tenant_b: got %q, %v", value, err)
+	}
+	if _, err := client.Namespace(zcServer.DefaultNamespace).Get(key); err != zcClient.ErrNotFound {
+		t.Fatalf("Get in default namespace: got %v, want ErrNotFound", err)
+	}
+
+	// Churn in tenant_b evicts only tenant_b's keys.
+	for i := 0; i < 500; i++ {
+		if err := tenantB.Set(fmt.Sprintf("%s_churn_%d", key, i), []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := client.Get(key); err != nil {
+		t.Fatalf("tenant_a key lost to tenant_b churn: %v", err)
+	}
+	stats, err := tenantB.Stats()
+	if err != nil || stats.Namespace != "tenant_b" || stats.Keys != 100 || stats.Evictions == 0 {
+		t.Fatalf("tenant_b stats: got %+v, %v", stats, err)
+	}
+
+	if _, err := tenantB.FlushAll(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get(key); err != nil {
+		t.Fatalf("tenant_a key lost to tenant_b flush: %v", err)
+	}
+	if err := client.Select("missing"); err != zcClient.ErrUnknownNamespace {
+		t.Fatalf("Select(missing): got %v, want ErrUnknownNamespace", err)
+	}
+	if _, err := client.Namespace("missing").Get(key); err != zcClient.ErrUnknownNamespace {
+		t.Fatalf("Get in missing namespace: got %v, want ErrUnknownNamespace", err)
+	}
+}
+
+func TestE2EPubSub(t *testing.T) {
+	channel := fmt.Sprintf("news_%d", time.Now().UnixNano())
+
+	subscriber, err := zcClient.New(benchmarkServerAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := subscriber.Subscribe(channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	if _, err := subscriber.Get("taken_over"); err == nil {
+		t.Fatal("client still usable after Subscribe")
+	}
+	if err := sub.PSubscribe(channel + ".*"); err != nil {
+		t.Fatal(err)
+	}
+
+	// PSubscribe is only acknowledged asynchronously; wait until it applies.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		n, err := benchClient.Publish(channel+".sport", []byte("goal"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("pattern subscription never applied")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n, err := benchClient.Publish(channel, []byte("hello")); err != nil || n != 1 {
+		t.Fatalf("Publish: got %d, %v", n, err)
+	}
+	if msg := <-sub.C; msg.Pattern != channel+".*" || msg.Channel != channel+".sport" || string(msg.Payload) != "goal" {
+		t.Fatalf("pattern message: got %+v", msg)
+	}
+	if msg := <-sub.C; msg.Pattern != "" || msg.Channel != channel || string(msg.Payload) != "hello" {
+		t.Fatalf("channel message: got %+v", msg)
+	}
+
+	if err := sub.Unsubscribe(channel); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		n, err := benchClient.Publish(channel, []byte("gone"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("unsubscribe never applied")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestE2EPubSubSlowConsumer(t *testing.T) {
+	channel := fmt.Sprintf("firehose_%d", time.Now().UnixNano())
+
+	subscriber, err := zcClient.New(benchmarkServerAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := subscriber.Subscribe(channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	// Never read from sub.C: once the socket buffers and the server-side queue
+	// are full, the server disconnects the subscriber rather than block.
+	payload := make([]byte, 4096)
+	dropped := false
+	for i := 0; i < 100000 && !dropped; i++ {
+		n, err := benchClient.Publish(channel, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dropped = n == 0
+	}
+	if !dropped {
+		t.Fatal("slow subscriber was never disconnected")
+	}
+
+	for range sub.C {
+	}
+	if sub.Err() == nil {
+		t.Fatal("Err: got nil after disconnect")
+	}
+}
+
+func TestE2EKeyEvents(t *testing.T) {
+	prefix := fmt.Sprintf("watched_%d:", time.Now().UnixNano())
+
+	subscriber, err := zcClient.New(benchmarkServerAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := subscriber.SubscribeKeyEvents(zcServer.DefaultNamespace, prefix, "set", "del")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	if err := benchClient.Set("unwatched_"+prefix, []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := benchClient.Namespace("tenant_a").Set(prefix+"other_namespace", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := benchClient.Set(prefix+"1", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := benchClient.Delete(prefix + "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"set", "del"} {
+		select {
+		case msg := <-sub.C:
+			if msg.Channel != "__keyevent@default__:"+want+":"+prefix+"1" || string(msg.Payload) != prefix+"1" {
+				t.Fatalf("%s event: got %+v", want, msg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s event", want)
+		}
+	}
+}
+
+func TestE2ETransaction(t *testing.T) {
+	key := fmt.Sprintf("tx_%d", time.Now().UnixNano())
+
+	tx := benchClient.Multi()
+	tx.Set(key, []byte("10"))
+	tx.IncrBy(key, 5)
+	tx.Get(key)
+	tx.HSet(key, "field", []byte("x")) // Fails at run time, the rest still applies
+	tx.SAdd(key+":index", "member")
+	replies, err := tx.Exec()
+	if err != nil || len(replies) != 5 {
+		t.Fatalf("Exec: got %v, %v", replies, err)
+	}
+	if n, err := replies[1].Int(); err != nil || n != 15 {
+		t.Fatalf("IncrBy reply: got %d, %v", n, err)
+	}
+	if value, err := replies[2].Bytes(); err != nil || string(value) != "15" {
+		t.Fatalf("Get reply: got %q, %v", value, err)
+	}
+	if err := replies[3].Err(); err == nil || !strings.Contains(err.Error(), "WRONGTYPE") {
+		t.Fatalf("HSet reply: got %v, want WRONGTYPE", err)
+	}
+	if ok, err := benchClient.SIsMember(key+":index", "member"); err != nil || !ok {
+		t.Fatalf("SIsMember after Exec: got %v, %v", ok, err)
+	}
+
+	// A write by another client between WATCH and EXEC aborts the transaction.
+	other, err := zcClient.New(benchmarkServerAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if err := benchClient.Watch(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Set(key, []byte("changed")); err != nil {
+		t.Fatal(err)
+	}
+	tx = benchClient.Multi()
+	tx.Set(key, []byte("overwritten"))
+	if _, err := tx.Exec(); err != zcClient.ErrTxAborted {
+		t.Fatalf("Exec after concurrent write: got %v, want ErrTxAborted", err)
+	}
+	if value, err := benchClient.Get(key); err != nil || string(value) != "changed" {
+		t.Fatalf("Get after aborted Exec: got %q, %v", value, err)
+	}
+
+	// Optimistic increments from several clients all land exactly once.
+	counter := key + ":counter"
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client, err := zcClient.New(benchmarkServerAddr)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer client.Close()
+			for j := 0; j < 25; j++ {
+				for {
+					if err := client.Watch(counter); err != nil {
+						t.Error(err)
+						return
+					}
+					n := 0
+					if value, err := client.Get(counter); err == nil {
+						n, _ = strconv.Atoi(string(value))
+					}
+					tx := client.Multi()
+					tx.Set(counter, []byte(strconv.Itoa(n+1)))
+					if _, err := tx.Exec(); err == nil {
+						break
+					} else if err != zcClient.ErrTxAborted {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if value, err := benchClient.Get(counter); err != nil || string(value) != "100" {
+		t.Fatalf("counter: got %q, %v, want 100", value, err)
+	}
+}
+
+func TestE2EScript(t *testing.T) {
+	key := fmt.Sprintf("script_%d", time.Now().UnixNano())
+
+	// A sliding-window rate limit: at most ARGV[1] calls per ARGV[0] ms. The
+	// comment makes its SHA unique per run, as the server keeps scripts loaded
+	// by earlier runs of the test.
+	limiter := zcClient.NewScript("; " + key + `
+		(let ((key (nth KEYS 0)) (t (now))
+		      (window (int (nth ARGV 0))) (limit (int (nth ARGV 1))))
+		  (let ((old (zrangebyscore key 0 (- t window))) (i 0))
+		    (while (< i (len old))
+		      (zrem key (nth old i))
+		      (set i (+ i 1))))
+		  (if (< (zcard key) limit)
+		    (do (zadd key t (concat t ":" (nth ARGV 2)))
+		        (expire key window)
+		        true)
+		    false))`)
+	if _, err := benchClient.EvalSHA(limiter.SHA(), []string{key}, "60000", "3", "x"); err != zcClient.ErrNoScript {
+		t.Fatalf("EvalSHA before loading: got %v, want ErrNoScript", err)
+	}
+	for i := 0; i < 5; i++ {
+		reply, err := limiter.Run(benchClient, []string{key}, "60000", "3", strconv.Itoa(i))
+		if err != nil {
+			t.Fatalf("limiter run %d: %v", i, err)
+		}
+		if n, err := reply.Int(); err != nil || (n == 1) != (i < 3) {
+			t.Fatalf("limiter run %d: got %d, %v", i, n, err)
+		}
+	}
+
+	sha, err := benchClient.ScriptLoad(`(list (get (nth KEYS 0)) (nth ARGV 0))`)
+	if err != nil || sha == "" {
+		t.Fatalf("ScriptLoad: got %q, %v", sha, err)
+	}
+	if err := benchClient.Set(key+":v", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := benchClient.EvalSHA(sha, []string{key + ":v"}, "arg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items, err := reply.Strings(); err != nil || strings.Join(items, ",") != "value,arg" {
+		t.Fatalf("EvalSHA list: got %q, %v", items, err)
+	}
+
+	reply, err = benchClient.Eval(`(get (nth KEYS 0))`, []string{key + ":missing"})
+	if err != nil || reply.Err() != zcClient.ErrNotFound {
+		t.Fatalf("Eval of nil: got %v, %v", reply, err)
+	}
+	if _, err := benchClient.Eval(`(get "undeclared")`, nil); err == nil || !strings.Contains(err.Error(), "did not declare") {
+		t.Fatalf("Eval touching an undeclared key: got %v", err)
+	}
+	if _, err := benchClient.Eval(`(while true 1)`, nil); err == nil || !strings.Contains(err.Error(), "step limit") {
+		t.Fatalf("Eval of an endless loop: got %v", err)
+	}
+	if _, err := benchClient.Eval(`(+ 1`, nil); err == nil || !strings.Contains(err.Error(), "unclosed") {
+		t.Fatalf("Eval of a syntax error: got %v", err)
+	}
+
+	// set with a key writes it, here with a TTL, as in the README's handover
+	// of a key only while it holds the expected value.
+	handover := `(if (= (get (nth KEYS 0)) (nth ARGV 0)) (set (nth KEYS 0) (nth ARGV 1) 100) false)`
+	if err := benchClient.Set(key+":owner", []byte("owner-a")); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []int64{1, 0} {
+		reply, err := benchClient.Eval(handover, []string{key + ":owner"}, "owner-a", "owner-b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n, err := reply.Int(); err != nil || n != want {
+			t.Fatalf("Eval handover: got %d, %v, want %d", n, err, want)
+		}
+	}
+	if value, err := benchClient.Get(key + ":owner"); err != nil || string(value) != "owner-b" {
+		t.Fatalf("Get after handover: got %q, %v", value, err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if _, err := benchClient.Get(key + ":owner"); err != zcClient.ErrNotFound {
+		t.Fatalf("Get after the script's TTL: got %v, want ErrNotFound", err)
+	}
+
+	// TTLs past the year 2262, up to ones beyond what a time.Duration holds,
+	// keep the key rather than wrapping around and expiring it at once.
+	for i, ttl := range []string{"7884000000000", "9223372036854775807"} {
+		ttlKey := fmt.Sprintf("%s:ttl%d", key, i)
+		for _, src := range []string{`(set (nth KEYS 0) "v" (int (nth ARGV 0)))`, `(expire (nth KEYS 0) (int (nth ARGV 0)))`} {
+			if _, err := benchClient.Eval(src, []string{ttlKey}, ttl); err != nil {
+				t.Fatalf("Eval(%s) with a TTL of %s ms: %v", src, ttl, err)
+			}
+			if value, err := benchClient.Get(ttlKey); err != nil || string(value) != "v" {
+				t.Fatalf("Get after Eval(%s) with a TTL of %s ms: got %q, %v", src, ttl, value, err)
+			}
+		}
+	}
+
+	// Scripts also run inside transactions, on the keys they declare.
+	tx := benchClient.Multi()
+	tx.Set(key+":tx", []byte("1"))
+	tx.Eval(`(incrby (nth KEYS 0) 41)`, []string{key + ":tx"})
+	replies, err := tx.Exec()
+	if err != nil || len(replies) != 2 {
+		t.Fatalf("Exec: got %v, %v", replies, err)
+	}
+	if n, err := replies[1].Int(); err != nil || n != 42 {
+		t.Fatalf("Eval reply in Exec: got %d, %v", n, err)
+	}
+}
+
+func TestE2EThrottle(t *testing.T) {
+	key := fmt.Sprintf("throttle_%d", time.Now().UnixNano())
+
+	// Concurrent clients sharing a limit never exceed it.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client, err := zcClient.New(benchmarkServerAddr)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer client.Close()
+			for j := 0; j < 10; j++ {
+				r, err := client.Throttle(key, 10, time.Minute)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if r.Allowed {
+					mu.Lock()
+					allowed++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 10 {
+		t.Fatalf("allowed %d requests, want 10", allowed)
+	}
+
+	r, err := benchClient.Throttle(key, 10, time.Minute)
+	if err != nil || r.Allowed || r.Remaining != 0 {
+		t.Fatalf("Throttle over the limit: got %+v, %v", r, err)
+	}
+	// One request comes back every 6s.
+	if r.RetryAfter <= 0 || r.RetryAfter > 6*time.Second || r.ResetAfter <= 54*time.Second {
+		t.Fatalf("Throttle timings: got %+v", r)
+	}
+	if _, err := benchClient.ThrottleN(key, 10, time.Minute, 11); err == nil {
+		t.Fatal("ThrottleN with cost above limit: got no error")
+	}
+}
+
+func TestE2ELock(t *testing.T) {
+	key := fmt.Sprintf("lock_%d", time.Now().UnixNano())
+
+	fence, err := benchClient.Lock(key, "a", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := benchClient.Lock(key, "b", time.Minute); err != zcClient.ErrLockHeld {
+		t.Fatalf("Lock by b: got %v, want ErrLockHeld", err)
+	}
+	if err := benchClient.ExtendLock(key, "b", time.Minute); err != zcClient.ErrLockNotHeld {
+		t.Fatalf("ExtendLock by b: got %v, want ErrLockNotHeld", err)
+	}
+	if err := benchClient.Unlock(key, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The largest TTL the server takes holds the lock instead of expiring it
+	// at once. The client cannot send it, so it goes out as a raw frame.
+	conn, err := net.Dial("tcp", benchmarkServerAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	payload := zcProtocol.EncodeArgs([]byte("a"), zcProtocol.EncodeUint64(math.MaxUint64))
+	if _, err := conn.Write(rawFrame(zcProtocol.CmdLock, key+"_forever", payload)); err != nil {
+		t.Fatal(err)
+	}
+	if respType, msg, err := readRawResponse(conn); err != nil || respType != zcProtocol.RespInt {
+		t.Fatalf("Lock with the largest TTL: got type %d %q, %v", respType, msg, err)
+	}
+	if _, err := benchClient.Lock(key+"_forever", "b", time.Minute); err != zcClient.ErrLockHeld {
+		t.Fatalf("Lock by b of a lock held with the largest TTL: got %v, want ErrLockHeld", err)
+	}
+
+	// Mutexes on separate connections exclude each other, and each
+	// acquisition gets a larger fencing token.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	holders, lastFence := 0, fence
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client, err := zcClient.New(benchmarkServerAddr)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer client.Close()
+			m := zcClient.NewMutex(client, key, 30*time.Millisecond)
+			for j := 0; j < 3; j++ {
+				if err := m.Lock(context.Background()); err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				holders++
+				if holders != 1 || m.Fence() <= lastFence {
+					t.Errorf("holders %d, fence %d after %d", holders, m.Fence(), lastFence)
+				}
+				lastFence = m.Fence()
+				mu.Unlock()
+
+				time.Sleep(40 * time.Millisecond) // Longer than the TTL: renewal keeps the lock
+				mu.Lock()
+				holders--
+				mu.Unlock()
+				if err := m.Unlock(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Cancelling the context stops waiting, and releases a held lock.
+	holder := zcClient.NewMutex(benchClient, key, time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer waitCancel()
+	if err := zcClient.NewMutex(benchClient, key, time.Minute).Lock(waitCtx); err != context.DeadlineExceeded {
+		t.Fatalf("Lock of a held mutex: got %v, want DeadlineExceeded", err)
+	}
+	cancel()
+	<-holder.Done()
+	if holder.Err() != context.Canceled {
+		t.Fatalf("Err after cancel: got %v", holder.Err())
+	}
+	if _, err := benchClient.Lock(key, "c", time.Minute); err != nil {
+		t.Fatalf("Lock after the holder's context ended: got %v", err)
+	}
+}
+
+func TestE2EHyperLogLog(t *testing.T) {
+	prefix := fmt.Sprintf("hll_%d_", time.Now().UnixNano())
+	a, b := prefix+"a", prefix+"b"
+
+	for i := 0; i < 2000; i += 100 {
+		var elements []string
+		for j := i; j < i+100; j++ {
+			elements = append(elements, strconv.Itoa(j))
+		}
+		if _, err := benchClient.PFAdd(a, elements...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if changed, err := benchClient.PFAdd(a, "0", "1"); err != nil || changed {
+		t.Fatalf("PFAdd of seen elements: got %v, %v", changed, err)
+	}
+	if n, err := benchClient.PFCount(a); err != nil || n < 1950 || n > 2050 {
+		t.Fatalf("PFCount: got %d, %v, want about 2000", n, err)
+	}
+
+	// A dump taken with GET restores under another key and merges with it.
+	dump, err := benchClient.Get(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := benchClient.Set(b, dump); err != nil {
+		t.Fatal(err)
+	}
+	benchClient.PFAdd(b, "extra")
+	if err := benchClient.PFMerge(prefix+"union", a, b); err != nil {
+		t.Fatal(err)
+	}
+	union, _ := benchClient.PFCount(prefix + "union")
+	if both, _ := benchClient.PFCount(a, b); union != both {
+		t.Errorf("PFCount of merged key %d, of both keys %d", union, both)
+	}
+
+	benchClient.Set(prefix+"plain", []byte("text"))
+	if _, err := benchClient.PFCount(prefix + "plain"); err == nil || !strings.Contains(err.Error(), "WRONGTYPE") {
+		t.Errorf("PFCount of a plain value: got %v", err)
+	}
+}
+
+func TestE2EBloom(t *testing.T) {
+	key := fmt.Sprintf("bloom_%d", time.Now().UnixNano())
+
+	if ok, err := benchClient.BFReserve(key, 0.001, 100); err != nil || !ok {
+		t.Fatalf("BFReserve: got %v, %v", ok, err)
+	}
+	if ok, _ := benchClient.BFReserve(key, 0.001, 100); ok {
+		t.Fatal("BFReserve of an existing key: got true")
+	}
+
+	// Add far more elements than reserved, so the filter has to grow.
+	for i := 0; i < 1000; i += 100 {
+		var ids []string
+		for j := i; j < i+100; j++ {
+			ids = append(ids, "id"+strconv.Itoa(j))
+		}
+		if _, err := benchClient.BFMAdd(key, ids...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if added, err := benchClient.BFAdd(key, "id5"); err != nil || added {
+		t.Fatalf("BFAdd of a present element: got %v, %v", added, err)
+	}
+	found, err := benchClient.BFMExists(key, "id0", "id999", "absent")
+	if err != nil || !found[0] || !found[1] || found[2] {
+		t.Fatalf("BFMExists: got %v, %v", found, err)
+	}
+	if found, err := benchClient.BFExists(key+"_missing", "id0"); err != nil || found {
+		t.Fatalf("BFExists of a missing key: got %v, %v", found, err)
+	}
+	if _, err := benchClient.BFReserve(key+"_huge", 0.01, 1<<30); err == nil {
+		t.Fatal("BFReserve of a huge filter: got no error")
+	}
+}
+
+// failingReader returns data, then fails.
+type failingReader struct {
+	data []byte
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, errors.New("disk on fire")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestE2EStream(t *testing.T) {
+	key := fmt.Sprintf("stream_%d", time.Now().UnixNano())
+	value := generateValueBench(newRandSource(), 3<<20+123) // Not a multiple of the chunk size
+
+	if err := benchClient.SetStream(key, bytes.NewReader(value)); err != nil {
+		t.Fatal(err)
+	}
+	stream, err := benchClient.GetStream(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(stream)
+	if err != nil || !bytes.Equal(got, value) {
+		t.Fatalf("GetStream: read %d bytes, %v; want %d bytes back", len(got), err, len(value))
+	}
+	if err := stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// GET refuses values beyond a frame, without dropping the connection.
+	if _, err := benchClient.Get(key); err == nil || !strings.Contains(err.Error(), "GETSTREAM") {
+		t.Fatalf("Get of a streamed value: got %v", err)
+	}
+
+	// Closing a stream early drains it, so the connection stays in sync.
+	stream, _ = benchClient.GetStream(key)
+	if _, err := io.ReadFull(stream, make([]byte, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Read(make([]byte, 1)); err == nil {
+		t.Fatal("Read after Close: got no error")
+	}
+
+	// Small and empty values stream too, and stay readable with GET.
+	for _, small := range []string{"", "tiny"} {
+		if err := benchClient.SetStream(key+"_small", strings.NewReader(small)); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := benchClient.Get(key + "_small"); err != nil || string(got) != small {
+			t.Fatalf("Get of streamed %q: got %q, %v", small, got, err)
+		}
+	}
+
+	// Values above the server limit and aborted streams are rejected.
+	err = benchClient.SetStream(key+"_big", bytes.NewReader(make([]byte, 5<<20)))
+	if err == nil || !strings.Contains(err.Error(), "limit") {
+		t.Fatalf("SetStream above the limit: got %v", err)
+	}
+	err = benchClient.SetStream(key+"_aborted", &failingReader{data: value[:100000]})
+	if err == nil || !strings.Contains(err.Error(), "disk on fire") {
+		t.Fatalf("SetStream of a failing reader: got %v", err)
+	}
+	for _, k := range []string{key + "_big", key + "_aborted"} {
+		if _, err := benchClient.GetStream(k); err != zcClient.ErrNotFound {
+			t.Fatalf("GetStream of rejected %s: got %v", k, err)
+		}
+	}
+
+	// A stream rejected inside MULTI makes EXEC fail, as does any command
+	// rejected while queuing. The client cannot queue one, so the transaction
+	// goes out as raw frames.
+	conn, err := net.Dial("tcp", benchmarkServerAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	chunk := make([]byte, 4+zcProtocol.MaxChunkSize)
+	binary.BigEndian.PutUint32(chunk, zcProtocol.MaxChunkSize)
+	oversized := binary.BigEndian.AppendUint32(bytes.Repeat(chunk, 5<<20/zcProtocol.MaxChunkSize), 0)
+	aborted := binary.BigEndian.AppendUint32(chunk, zcProtocol.StreamAbort)
+	for j, stream := range [][]byte{oversized, aborted} {
+		frames := rawFrame(zcProtocol.CmdMulti, "", nil)
+		frames = append(frames, rawFrame(zcProtocol.CmdSet, key+"_tx", []byte("v"))...)
+		frames = append(frames, rawFrame(zcProtocol.CmdSetStream, key+"_tx_stream", nil)...)
+		frames = append(frames, stream...)
+		frames = append(frames, rawFrame(zcProtocol.CmdExec, "", nil)...)
+		if _, err := conn.Write(frames); err != nil {
+			t.Fatal(err)
+		}
+		for i, want := range []uint8{zcProtocol.RespOK, zcProtocol.RespOK, zcProtocol.RespError, zcProtocol.RespError} {
+			if respType, msg, err := readRawResponse(conn); err != nil || respType != want {
+				t.Fatalf("response %d to MULTI with rejected SETSTREAM %d: got type %d %q, %v, want type %d", i, j, respType, msg, err, want)
+			}
+		}
+		if _, err := benchClient.Get(key + "_tx"); err != zcClient.ErrNotFound {
+			t.Fatalf("Get of a key set in the failed transaction: got %v, want ErrNotFound", err)
+		}
+	}
+
+	// Streams work in namespaces, and missing keys are reported up front.
+	tenant := benchClient.Namespace("tenant_a")
+	if err := tenant.SetStream(key, bytes.NewReader(value[:100000])); err != nil {
+		t.Fatal(err)
+	}
+	stream, err = tenant.GetStream(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ = io.ReadAll(stream)
+	stream.Close()
+	if !bytes.Equal(got, value[:100000]) {
+		t.Fatalf("GetStream in namespace: read %d bytes", len(got))
+	}
+}
+
+func TestE2EGetLarge(t *testing.T) {
+	prefix := fmt.Sprintf("large_%d_", time.Now().UnixNano())
+	r := newRandSource()
+	tenant := benchClient.Namespace("tenant_b")
+
+	// Sizes around the point where GET switches to writev, interleaved with
+	// small values, must all come back intact on the same connection.
+	for _, size := range []int{0, 100, 4095, 4096, 4097, 20000, 64 << 10} {
+		key := prefix + strconv.Itoa(size)
+		value := generateValueBench(r, size)
+		for _, cli := range []interface {
+			Set(string, []byte) error
+			Get(string) ([]byte, error)
+		}{benchClient, tenant} {
+			if err := cli.Set(key, value); err != nil {
+				t.Fatal(err)
+			}
+			if err := cli.Set(key+"_small", []byte("x")); err != nil {
+				t.Fatal(err)
+			}
+			got, err := cli.Get(key)
+			if err != nil || !bytes.Equal(got, value) {
+				t.Fatalf("Get of %d bytes: got %d bytes, %v", size, len(got), err)
+			}
+			if got, err := cli.Get(key + "_small"); err != nil || string(got) != "x" {
+				t.Fatalf("Get after %d bytes: got %q, %v", size, got, err)
+			}
+		}
+	}
+
+	if _, err := benchClient.Get(prefix + "missing"); err != zcClient.ErrNotFound {
+		t.Errorf("Get of a missing key: got %v", err)
+	}
+	if _, err := benchClient.HSet(prefix+"hash", map[string][]byte{"f": []byte("v")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := benchClient.Get(prefix + "hash"); err == nil || !strings.Contains(err.Error(), "WRONGTYPE") {
+		t.Errorf("Get of a hash: got %v", err)
+	}
+}
+
+// BenchmarkE2EGetLarge reads values of several sizes with GET, which writes
+// values of 4KB and more straight from the cache with writev, and with GETV,
+// which still copies them into the connection's write buffer.
+func BenchmarkE2EGetLarge(b *testing.B) {
+	for _, size := range []int{1 << 10, 16 << 10, 64 << 10} {
+		key := fmt.Sprintf("getlarge_%d", size)
+		if err := benchClient.Set(key, generateValueBench(newRandSource(), size)); err != nil {
+			b.Fatal(err)
+		}
+		for _, cmd := range []string{"GET", "GETV"} {
+			b.Run(fmt.Sprintf("%s/%d", cmd, size), func(b *testing.B) {
+				b.SetBytes(int64(size))
+				b.RunParallel(func(pb *testing.PB) {
+					cli, err := zcClient.New(benchmarkServerAddr)
+					if err != nil {
+						b.Fatalf("Failed to create client: %v", err)
+					}
+					defer cli.Close()
+
+					for pb.Next() {
+						if cmd == "GET" {
+							_, err = cli.Get(key)
+						} else {
+							_, _, err = cli.GetWithVersion(key)
+						}
+						if err != nil {
+							b.Errorf("%s failed: %v", cmd, err)
+							return
+						}
+					}
+				})
+			})
+		}
+	}
+}
+
+const epollServerAddr = "127.0.0.1:6382"
+
+var (
+	epollServerOnce sync.Once
+	epollServerErr  error
+)
+
+// startEpollServer starts a second server, with the epoll engine, on
+// epollServerAddr. It fails where the engine is unavailable.
+func startEpollServer() error {
+	epollServerOnce.Do(func() {
+		srv := zcServer.New(zcCache.New())
+		if err := srv.AddNamespace("tenant_a", zcCache.New()); err != nil {
+			epollServerErr = err
+			return
+		}
+		errc := make(chan error, 1)
+		go func() { errc <- srv.ListenAndServeEpoll(epollServerAddr, 2) }()
+		for deadline := time.Now().Add(5 * time.Second); ; {
+			select {
+			case epollServerErr = <-errc:
+				return
+			default:
+			}
+			if conn, err := net.DialTimeout("tcp", epollServerAddr, 50*time.Millisecond); err == nil {
+				conn.Close()
+				return
+			} else if time.Now().After(deadline) {
+				epollServerErr = err
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+	return epollServerErr
+}
+
+func newEpollClient(tb testing.TB) *zcClient.Client {
+	tb.Helper()
+	if err := startEpollServer(); err != nil {
+		tb.Skipf("epoll engine unavailable: %v", err)
+	}
+	cli, err := zcClient.New(epollServerAddr)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return cli
+}
+
+func TestE2EEpoll(t *testing.T) {
+	cli := newEpollClient(t)
+	defer cli.Close()
+	prefix := fmt.Sprintf("epoll_%d_", time.Now().UnixNano())
+	r := newRandSource()
+
+	for _, size := range []int{0, 100, 20000, 64 << 10} {
+		key := prefix + strconv.Itoa(size)
+		value := generateValueBench(r, size)
+		if err := cli.Set(key, value); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := cli.Get(key); err != nil || !bytes.Equal(got, value) {
+			t.Fatalf("Get of %d bytes: got %d bytes, %v", size, len(got), err)
+		}
+	}
+
+	// Sessions live on with the connection between turns.
+	tx := cli.Multi()
+	tx.Set(prefix+"tx", []byte("10"))
+	tx.IncrBy(prefix+"tx", 5)
+	if replies, err := tx.Exec(); err != nil || len(replies) != 2 {
+		t.Fatalf("Exec: got %v, %v", replies, err)
+	}
+	if err := cli.Select("tenant_a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cli.Get(prefix + "tx"); err != zcClient.ErrNotFound {
+		t.Fatalf("Get in tenant_a: got %v, want ErrNotFound", err)
+	}
+	if err := cli.Set(prefix+"tx", []byte("tenant")); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Select("default"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := cli.Get(prefix + "tx"); err != nil || string(value) != "15" {
+		t.Fatalf("Get after Select: got %q, %v", value, err)
+	}
+
+	// Streams hand the connection over to a goroutine, which keeps serving it.
+	value := generateValueBench(r, 1<<20+7)
+	if err := cli.SetStream(prefix+"stream", bytes.NewReader(value)); err != nil {
+		t.Fatal(err)
+	}
+	stream, err := cli.GetStream(prefix + "stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(stream); err != nil || !bytes.Equal(got, value) {
+		t.Fatalf("GetStream: read %d bytes, %v", len(got), err)
+	}
+	stream.Close()
+	if value, err := cli.Get(prefix + "tx"); err != nil || string(value) != "15" {
+		t.Fatalf("Get after handover: got %q, %v", value, err)
+	}
+}
+
+func TestE2EEpollPipelined(t *testing.T) {
+	if err := startEpollServer(); err != nil {
+		t.Skipf("epoll engine unavailable: %v", err)
+	}
+	conn, err := net.Dial("tcp", epollServerAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Many commands in one write, the last one split across two, all get
+	// their responses in order.
+	key := fmt.Sprintf("pipelined_%d", time.Now().UnixNano())
+	var batch []byte
+	const n = 500
+	for i := range n {
+		batch = append(batch, rawFrame(zcProtocol.CmdSet, key+strconv.Itoa(i), []byte(strconv.Itoa(i)))...)
+	}
+	last := rawFrame(zcProtocol.CmdGet, key+"7", nil)
+	if _, err := conn.Write(append(batch, last[:5]...)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, err := conn.Write(last[5:]); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := bufio.NewReader(conn)
+	for i := range n + 1 {
+		respType, body, err := readRawResponse(reader)
+		if err != nil {
+			t.Fatalf("response %d: %v", i, err)
+		}
+		want := zcProtocol.RespOK
+		if i == n {
+			want = zcProtocol.RespValue
+			if string(body) != "7" {
+				t.Fatalf("GET: got %q", body)
+			}
+		}
+		if respType != want {
+			t.Fatalf("response %d: got type %d, want %d", i, respType, want)
+		}
+	}
+}
+
+// TestE2EEpollDrainBeforeClose pipelines more large values than the socket
+// buffers hold and ends its input, cleanly or with a malformed frame. Every
+// response must still arrive before the connection is closed.
+func TestE2EEpollDrainBeforeClose(t *testing.T) {
+	if err := startEpollServer(); err != nil {
+		t.Skipf("epoll engine unavailable: %v", err)
+	}
+	key := fmt.Sprintf("drain_%d", time.Now().UnixNano())
+	value := generateValueBench(newRandSource(), 60<<10)
+	setup := newEpollClient(t)
+	defer setup.Close()
+	if err := setup.Set(key, value); err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 200 // About 12MB of responses
+	for _, malformed := range []bool{false, true} {
+		conn, err := net.Dial("tcp", epollServerAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		var batch []byte
+		for range n {
+			batch = append(batch, rawFrame(zcProtocol.CmdGet, key, nil)...)
+		}
+		if malformed {
+			// A value length beyond any frame is rejected from the header.
+			batch = append(batch, zcProtocol.CmdGet, 0, 0, 0, 1, 0xff, 0xff, 0xff, 0xff)
+		}
+		if _, err := conn.Write(batch); err != nil {
+			t.Fatal(err)
+		}
+		// Let the server run everything before the end of input arrives.
+		time.Sleep(50 * time.Millisecond)
+		if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+			t.Fatal(err)
+		}
+
+		_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+		reader := bufio.NewReader(conn)
+		for i := range n {
+			respType, body, err := readRawResponse(reader)
+			if err != nil || respType != zcProtocol.RespValue || !bytes.Equal(body, value) {
+				t.Fatalf("malformed=%v, GET %d: got type %d with %d bytes, %v", malformed, i, respType, len(body), err)
+			}
+		}
+		if malformed {
+			respType, body, err := readRawResponse(reader)
+			if err != nil || respType != zcProtocol.RespError || !strings.Contains(string(body), "protocol error") {
+				t.Fatalf("malformed frame: got type %d %q, %v", respType, body, err)
+			}
+		}
+		if _, err := reader.ReadByte(); err != io.EOF {
+			t.Fatalf("malformed=%v: got %v after the responses, want EOF", malformed, err)
+		}
+	}
+}
+
+func TestE2EEpollHandover(t *testing.T) {
+	producer := newEpollClient(t)
+	defer producer.Close()
+	key := fmt.Sprintf("epoll_queue_%d", time.Now().UnixNano())
+	channel := key + ".news"
+
+	consumer := newEpollClient(t)
+	defer consumer.Close()
+	done := make(chan error)
+	go func() {
+		_, v, err := consumer.BLPop(5*time.Second, key)
+		if err == nil && string(v) != "job" {
+			err = fmt.Errorf("got %q", v)
+		}
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if _, err := producer.RPush(key, []byte("job")); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("BLPop: %v", err)
+	}
+	// The handed-over connection keeps its session and serves more commands,
+	// including values large enough for writev.
+	if _, err := consumer.Incr(key + "n"); err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []int{100, 20000, 64 << 10} {
+		value := generateValueBench(newRandSource(), size)
+		if err := producer.Set(key+"v", value); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := consumer.Get(key + "v"); err != nil || !bytes.Equal(got, value) {
+			t.Fatalf("Get of %d bytes after handover: got %d bytes, %v", size, len(got), err)
+		}
+	}
+
+	subscriber := newEpollClient(t)
+	sub, err := subscriber.Subscribe(channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	if n, err := producer.Publish(channel, []byte("hello")); err != nil || n != 1 {
+		t.Fatalf("Publish: got %d, %v", n, err)
+	}
+	if msg := <-sub.C; string(msg.Payload) != "hello" {
+		t.Fatalf("message: got %+v", msg)
+	}
+}
+
+func TestE2EEpollConcurrent(t *testing.T) {
+	if err := startEpollServer(); err != nil {
+		t.Skipf("epoll engine unavailable: %v", err)
+	}
+	prefix := fmt.Sprintf("epoll_conc_%d_", time.Now().UnixNano())
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cli, err := zcClient.New(epollServerAddr)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer cli.Close()
+			for j := range 100 {
+				key := prefix + strconv.Itoa(i)
+				if _, err := cli.Incr(key); err != nil {
+					errs <- err
+					return
+				}
+				if j%10 == 0 {
+					if _, err := cli.Get(key); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	cli := newEpollClient(t)
+	defer cli.Close()
+	for i := range 50 {
+		if v, err := cli.Get(prefix + strconv.Itoa(i)); err != nil || string(v) != "100" {
+			t.Fatalf("counter %d: got %q, %v", i, v, err)
+		}
+	}
+}
+
+// TestReadCommandConcurrentBuffers reads a frame too large for the server's
+// pooled read buffers, then two frames at once, each parked halfway through
+// its payload. The frame that grew must not have put its pooled buffer back
+// twice, which would hand both reads the same one.
+func TestReadCommandConcurrentBuffers(t *testing.T) {
+	// On a single P, a buffer put back twice is the next two handed out.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+
+	if _, err := zcServer.ReadCommand(bytes.NewReader(rawFrame(zcProtocol.CmdSet, "large", make([]byte, 4096)))); err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		cmd *zcServer.Command
+		err error
+	}
+	readers := make([]*parkingReader, 2)
+	results := make([]chan result, 2)
+	for i := range readers {
+		readers[i] = &parkingReader{
+			header:  rawFrame(zcProtocol.CmdSet, "small", make([]byte, 100))[:9],
+			fill:    byte('a' + i),
+			filled:  make(chan struct{}),
+			release: make(chan struct{}),
+		}
+		results[i] = make(chan result, 1)
+		go func() {
+			cmd, err := zcServer.ReadCommand(readers[i])
+			results[i] <- result{cmd, err}
+		}()
+		<-readers[i].filled
+	}
+	// The second read finishes first, putting its buffer back while the
+	// first still reads into its own.
+	close(readers[1].release)
+	<-results[1]
+	close(readers[0].release)
+	got := <-results[0]
+	if got.err != nil {
+		t.Fatal(got.err)
+	}
+	if want := bytes.Repeat([]byte{'a'}, 100); !bytes.Equal(got.cmd.Value, want) {
+		t.Fatalf("value read alongside another frame: got %q, want %q", got.cmd.Value, want)
+	}
+}
+
+// parkingReader returns a frame header, then fills the payload with one byte
+// and blocks until released.
+type parkingReader struct {
+	header  []byte
+	fill    byte
+	filled  chan struct{}
+	release chan struct{}
+}
+
+func (r *parkingReader) Read(p []byte) (int, error) {
+	if len(r.header) > 0 {
+		n := copy(p, r.header)
+		r.header = r.header[n:]
+		return n, nil
+	}
+	for i := range p {
+		p[i] = r.fill
+	}
+	close(r.filled)
+	<-r.release
+	return len(p), nil
+}
+
+// BenchmarkE2EIdleConns reports the memory each idle connection costs the
+// process with either engine, taking both sides of the connection together:
+// the client side is a bare socket, so the difference is the server's.
+func BenchmarkE2EIdleConns(b *testing.B) {
+	for _, engine := range []string{"goroutine", "epoll"} {
+		b.Run(engine, func(b *testing.B) {
+			addr := benchmarkServerAddr
+			if engine == "epoll" {
+				if err := startEpollServer(); err != nil {
+					b.Skipf("epoll engine unavailable: %v", err)
+				}
+				addr = epollServerAddr
+			}
+			const conns = 1000
+			for range b.N {
+				before := idleMemory()
+				open := make([]net.Conn, 0, conns)
+				for range conns {
+					conn, err := net.Dial("tcp", addr)
+					if err != nil {
+						b.Fatal(err)
+					}
+					// A round trip makes sure the server is serving it.
+					if _, err := conn.Write([]byte{zcProtocol.CmdGet, 0, 0, 0, 1, 0, 0, 0, 0, 'k'}); err != nil {
+						b.Fatal(err)
+					}
+					if _, err := io.ReadFull(conn, make([]byte, 5)); err != nil {
+						b.Fatal(err)
+					}
+					open = append(open, conn)
+				}
+				b.ReportMetric(float64(idleMemory()-before)/conns, "bytes/conn")
+				for _, conn := range open {
+					conn.Close()
+				}
+			}
+		})
+	}
+}
+
+// idleMemory returns the heap and stack memory in use after a collection.
+func idleMemory() int64 {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return int64(m.HeapInuse + m.StackInuse)
+}
+
+func BenchmarkE2EGetHitEpoll(b *testing.B) {
+	setup := newEpollClient(b)
+	defer setup.Close()
+	value := generateValueBench(newRandSource(), 100)
+	if err := setup.Set("epoll_hit", value); err != nil {
+		b.Fatal(err)
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		cli, err := zcClient.New(epollServerAddr)
+		if err != nil {
+			b.Fatalf("Failed to create client: %v", err)
+		}
+		defer cli.Close()
+		for pb.Next() {
+			if _, err := cli.Get("epoll_hit"); err != nil {
+				b.Errorf("Get failed: %v", err)
+				return
+			}
+		}
+	})
+}
@@ -4,8 +4,12 @@ import (
 	"bufio"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	zcClient "github.com/jasonrowsell/zerocache/pkg/client"
 )
@@ -13,8 +17,12 @@ import (
 var (
 	host = flag.String("h", "127.0.0.1", "Server host")
 	port = flag.String("p", "6380", "Server port")
+	ns   = flag.String("n", "", "Namespace to select after connecting")
 )
 
+// pendingTx holds the commands queued since MULTI in interactive mode.
+var pendingTx *zcClient.Tx
+
 func main() {
 	flag.Parse()
 
@@ -35,6 +43,7 @@ func runNonInteractiveMode(addr string, args []string) {
 		os.Exit(1)
 	}
 	defer cli.Close()
+	selectNamespace(cli)
 
 	output, err := executeCommand(cli, args)
 	if err != nil {
@@ -44,6 +53,17 @@ func runNonInteractiveMode(addr string, args []string) {
 	fmt.Println(output)
 }
 
+// selectNamespace switches to the namespace given with -n, if any.
+func selectNamespace(cli *zcClient.Client) {
+	if *ns == "" {
+		return
+	}
+	if err := cli.Select(*ns); err != nil {
+		fmt.Fprintf(os.Stderr, "Error selecting namespace %s: %v\n", *ns, err)
+		os.Exit(1)
+	}
+}
+
 // runInteractiveMode starts the Read-Eval-Print Loop.
 func runInteractiveMode(addr string) {
 	cli, err := zcClient.New(addr)
@@ -52,6 +72,8 @@ func runInteractiveMode(addr string) {
 		os.Exit(1)
 	}
 	defer cli.Close()
+	selectNamespace(cli)
+	namespace := *ns
 
 	fmt.Printf("Connected to ZeroCache at %s\n", addr)
 	fmt.Println("Type commands (e.g., SET key value, GET key, DEL key, QUIT)")
@@ -60,7 +82,11 @@ func runInteractiveMode(addr string) {
 
 	for {
 		// Prompt
-		fmt.Printf("%s> ", addr)
+		if namespace != "" {
+			fmt.Printf("%s[%s]> ", addr, namespace)
+		} else {
+			fmt.Printf("%s> ", addr)
+		}
 
 		// Read input line
 		input, err := reader.ReadString('\n')
@@ -81,7 +107,11 @@ func runInteractiveMode(addr string) {
 		}
 
 		// Split input into command and arguments
-		parts := strings.Fields(input)
+		parts, err := splitArgs(input)
+		if err != nil {
+			fmt.Printf("(error) %v\n", err)
+			continue
+		}
 		if len(parts) == 0 {
 			continue
 		}
@@ -101,6 +131,9 @@ func runInteractiveMode(addr string) {
 		if err != nil {
 			fmt.Printf("(error) %v\n", err)
 		} else {
+			if commandUpper == "SELECT" {
+				namespace = parts[1]
+			}
 			fmt.Println(output)
 		}
 	}
@@ -116,14 +149,26 @@ func executeCommand(cli *zcClient.Client, parts []string) (string, error) {
 	command := strings.ToUpper(parts[0])
 	args := parts[1:]
 
+	if pendingTx != nil && command != "EXEC" && command != "DISCARD" {
+		if err := queueCommand(pendingTx, command, args); err != nil {
+			return "", err
+		}
+		return "QUEUED", nil
+	}
+
 	switch command {
 	case "SET":
-		if len(args) != 2 {
-			return "", fmt.Errorf("ERR wrong number of arguments for 'SET' command (usage: SET key value)")
+		if len(args) != 2 && !(len(args) > 3 && strings.EqualFold(args[2], "TAGS")) {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'SET' command (usage: SET key value [TAGS tag [tag ...]])")
 		}
 		key := args[0]
 		value := []byte(args[1]) // Value is treated as raw string for now
-		err := cli.Set(key, value)
+		var err error
+		if len(args) > 2 {
+			err = cli.SetWithTags(key, value, args[3:]...)
+		} else {
+			err = cli.Set(key, value)
+		}
 		if err != nil {
 			return "", err
 		}
@@ -143,6 +188,45 @@ func executeCommand(cli *zcClient.Client, parts []string) (string, error) {
 		}
 		return fmt.Sprintf("%q", string(value)), nil
 
+	case "SETSTREAM":
+		if len(args) != 2 {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'SETSTREAM' command (usage: SETSTREAM key file)")
+		}
+		f, err := os.Open(args[1])
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		if err := cli.SetStream(args[0], f); err != nil {
+			return "", err
+		}
+		return "OK", nil
+
+	case "GETSTREAM":
+		if len(args) != 2 {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'GETSTREAM' command (usage: GETSTREAM key file)")
+		}
+		stream, err := cli.GetStream(args[0])
+		if err == zcClient.ErrNotFound {
+			return "(nil)", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		defer stream.Close()
+		f, err := os.Create(args[1])
+		if err != nil {
+			return "", err
+		}
+		n, err := io.Copy(f, stream)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(%d bytes written to %s)", n, args[1]), nil
+
 	case "DEL", "DELETE":
 		if len(args) != 1 {
 			return "", fmt.Errorf("ERR wrong number of arguments for 'DEL' command (usage: DEL key)")
@@ -154,6 +238,792 @@ func executeCommand(cli *zcClient.Client, parts []string) (string, error) {
 		}
 		return "OK", nil
 
+	case "GETV":
+		if len(args) != 1 {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'GETV' command (usage: GETV key)")
+		}
+		value, version, err := cli.GetWithVersion(args[0])
+		if err != nil {
+			if err == zcClient.ErrNotFound {
+				return "(nil)", nil
+			}
+			return "", err
+		}
+		return fmt.Sprintf("1) %q\n2) (version) %d", string(value), version), nil
+
+	case "CAS":
+		if len(args) != 3 {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'CAS' command (usage: CAS key value version)")
+		}
+		expected, err := strconv.ParseUint(args[2], 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("ERR version is not a valid unsigned integer")
+		}
+		version, err := cli.CompareAndSwap(args[0], []byte(args[1]), expected)
+		if err != nil {
+			if err == zcClient.ErrNotFound {
+				return "(nil)", nil
+			}
+			return "", err
+		}
+		return fmt.Sprintf("(version) %d", version), nil
+
+	case "SETNX", "SETXX":
+		if len(args) != 2 {
+			return "", fmt.Errorf("ERR wrong number of arguments for '%s' command (usage: %s key value)", command, command)
+		}
+		var stored bool
+		var err error
+		if command == "SETNX" {
+			stored, err = cli.SetNX(args[0], []byte(args[1]))
+		} else {
+			stored, err = cli.SetXX(args[0], []byte(args[1]))
+		}
+		if err != nil {
+			return "", err
+		}
+		if !stored {
+			return "(not stored)", nil
+		}
+		return "OK", nil
+
+	case "INCR", "DECR":
+		if len(args) != 1 {
+			return "", fmt.Errorf("ERR wrong number of arguments for '%s' command (usage: %s key)", command, command)
+		}
+		var result int64
+		var err error
+		if command == "INCR" {
+			result, err = cli.Incr(args[0])
+		} else {
+			result, err = cli.Decr(args[0])
+		}
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(integer) %d", result), nil
+
+	case "INCRBY":
+		if len(args) != 2 && len(args) != 3 {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'INCRBY' command (usage: INCRBY key delta [ttl-ms])")
+		}
+		delta, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("ERR delta is not an integer or out of range")
+		}
+		var result int64
+		if len(args) == 3 {
+			ttlMillis, err := strconv.ParseInt(args[2], 10, 64)
+			if err != nil || ttlMillis <= 0 {
+				return "", fmt.Errorf("ERR invalid TTL '%s'", args[2])
+			}
+			result, err = cli.IncrByTTL(args[0], delta, time.Duration(ttlMillis)*time.Millisecond)
+		} else {
+			result, err = cli.IncrBy(args[0], delta)
+		}
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(integer) %d", result), nil
+
+	case "HSET":
+		if len(args) < 3 || len(args)%2 != 1 {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'HSET' command (usage: HSET key field value [field value ...])")
+		}
+		fields := make(map[string][]byte, len(args)/2)
+		for i := 1; i < len(args); i += 2 {
+			fields[args[i]] = []byte(args[i+1])
+		}
+		added, err := cli.HSet(args[0], fields)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(integer) %d", added), nil
+
+	case "HGET":
+		if len(args) != 2 {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'HGET' command (usage: HGET key field)")
+		}
+		value, err := cli.HGet(args[0], args[1])
+		if err != nil {
+			if err == zcClient.ErrNotFound {
+				return "(nil)", nil
+			}
+			return "", err
+		}
+		return fmt.Sprintf("%q", string(value)), nil
+
+	case "HDEL":
+		if len(args) < 2 {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'HDEL' command (usage: HDEL key field [field ...])")
+		}
+		removed, err := cli.HDel(args[0], args[1:]...)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(integer) %d", removed), nil
+
+	case "HGETALL":
+		if len(args) != 1 {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'HGETALL' command (usage: HGETALL key)")
+		}
+		fields, err := cli.HGetAll(args[0])
+		if err != nil {
+			return "", err
+		}
+		names := make([]string, 0, len(fields))
+		for field := range fields {
+			names = append(names, field)
+		}
+		sort.Strings(names)
+		items := make([]string, 0, len(fields)*2)
+		for _, field := range names {
+			items = append(items, field, string(fields[field]))
+		}
+		return formatList(items), nil
+
+	case "HLEN":
+		if len(args) != 1 {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'HLEN' command (usage: HLEN key)")
+		}
+		n, err := cli.HLen(args[0])
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(integer) %d", n), nil
+
+	case "LPUSH", "RPUSH":
+		if len(args) < 2 {
+			return "", fmt.Errorf("ERR wrong number of arguments for '%s' command (usage: %s key value [value ...])", command, command)
+		}
+		values := make([][]byte, len(args)-1)
+		for i, arg := range args[1:] {
+			values[i] = []byte(arg)
+		}
+		var length int
+		var err error
+		if command == "LPUSH" {
+			length, err = cli.LPush(args[0], values...)
+		} else {
+			length, err = cli.RPush(args[0], values...)
+		}
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(integer) %d", length), nil
+
+	case "LPOP", "RPOP":
+		if len(args) != 1 {
+			return "", fmt.Errorf("ERR wrong number of arguments for '%s' command (usage: %s key)", command, command)
+		}
+		var value []byte
+		var err error
+		if command == "LPOP" {
+			value, err = cli.LPop(args[0])
+		} else {
+			value, err = cli.RPop(args[0])
+		}
+		if err != nil {
+			if err == zcClient.ErrNotFound {
+				return "(nil)", nil
+			}
+			return "", err
+		}
+		return fmt.Sprintf("%q", string(value)), nil
+
+	case "LRANGE":
+		if len(args) != 3 {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'LRANGE' command (usage: LRANGE key start stop)")
+		}
+		start, err1 := strconv.Atoi(args[1])
+		stop, err2 := strconv.Atoi(args[2])
+		if err1 != nil || err2 != nil {
+			return "", fmt.Errorf("ERR value is not an integer or out of range")
+		}
+		values, err := cli.LRange(args[0], start, stop)
+		if err != nil {
+			return "", err
+		}
+		return formatList(bytesToStrings(values)), nil
+
+	case "LLEN":
+		if len(args) != 1 {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'LLEN' command (usage: LLEN key)")
+		}
+		n, err := cli.LLen(args[0])
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(integer) %d", n), nil
+
+	case "BLPOP":
+		if len(args) < 2 {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'BLPOP' command (usage: BLPOP key [key ...] timeout)")
+		}
+		seconds, err := strconv.ParseFloat(args[len(args)-1], 64)
+		if err != nil || seconds < 0 {
+			return "", fmt.Errorf("ERR timeout is not a float or out of range")
+		}
+		key, value, err := cli.BLPop(time.Duration(seconds*float64(time.Second)), args[:len(args)-1]...)
+		if err != nil {
+			if err == zcClient.ErrNotFound {
+				return "(nil)", nil
+			}
+			return "", err
+		}
+		return formatList([]string{key, string(value)}), nil
+
+	case "ZADD":
+		if len(args) < 3 || len(args)%2 != 1 {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'ZADD' command (usage: ZADD key score member [score member ...])")
+		}
+		members := make([]zcClient.ZMember, 0, len(args)/2)
+		for i := 1; i < len(args); i += 2 {
+			score, err := strconv.ParseFloat(args[i], 64)
+			if err != nil {
+				return "", fmt.Errorf("ERR value is not a valid float")
+			}
+			members = append(members, zcClient.ZMember{Member: args[i+1], Score: score})
+		}
+		added, err := cli.ZAdd(args[0], members...)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(integer) %d", added), nil
+
+	case "ZREM":
+		if len(args) < 2 {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'ZREM' command (usage: ZREM key member [member ...])")
+		}
+		removed, err := cli.ZRem(args[0], args[1:]...)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(integer) %d", removed), nil
+
+	case "ZSCORE":
+		if len(args) != 2 {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'ZSCORE' command (usage: ZSCORE key member)")
+		}
+		score, err := cli.ZScore(args[0], args[1])
+		if err != nil {
+			if err == zcClient.ErrNotFound {
+				return "(nil)", nil
+			}
+			return "", err
+		}
+		return fmt.Sprintf("%q", strconv.FormatFloat(score, 'g', -1, 64)), nil
+
+	case "ZRANGE", "ZREVRANGE":
+		if len(args) != 3 && !(len(args) == 4 && strings.EqualFold(args[3], "WITHSCORES")) {
+			return "", fmt.Errorf("ERR wrong number of arguments for '%s' command (usage: %s key start stop [WITHSCORES])", command, command)
+		}
+		start, err1 := strconv.Atoi(args[1])
+		stop, err2 := strconv.Atoi(args[2])
+		if err1 != nil || err2 != nil {
+			return "", fmt.Errorf("ERR value is not an integer or out of range")
+		}
+		var members []zcClient.ZMember
+		var err error
+		if command == "ZRANGE" {
+			members, err = cli.ZRange(args[0], start, stop)
+		} else {
+			members, err = cli.ZRevRange(args[0], start, stop)
+		}
+		if err != nil {
+			return "", err
+		}
+		return formatList(zmembersToStrings(members, len(args) == 4)), nil
+
+	case "ZRANGEBYSCORE", "ZREVRANGEBYSCORE":
+		if len(args) != 3 && !(len(args) == 4 && strings.EqualFold(args[3], "WITHSCORES")) {
+			return "", fmt.Errorf("ERR wrong number of arguments for '%s' command (usage: %s key min max [WITHSCORES])", command, command)
+		}
+		from, err1 := strconv.ParseFloat(args[1], 64)
+		to, err2 := strconv.ParseFloat(args[2], 64)
+		if err1 != nil || err2 != nil {
+			return "", fmt.Errorf("ERR min or max is not a float")
+		}
+		var members []zcClient.ZMember
+		var err error
+		if command == "ZRANGEBYSCORE" {
+			members, err = cli.ZRangeByScore(args[0], from, to)
+		} else {
+			// Like redis-cli, the reverse variant takes max before min.
+			members, err = cli.ZRevRangeByScore(args[0], to, from)
+		}
+		if err != nil {
+			return "", err
+		}
+		return formatList(zmembersToStrings(members, len(args) == 4)), nil
+
+	case "ZRANK", "ZREVRANK":
+		if len(args) != 2 {
+			return "", fmt.Errorf("ERR wrong number of arguments for '%s' command (usage: %s key member)", command, command)
+		}
+		var rank int
+		var err error
+		if command == "ZRANK" {
+			rank, err = cli.ZRank(args[0], args[1])
+		} else {
+			rank, err = cli.ZRevRank(args[0], args[1])
+		}
+		if err != nil {
+			if err == zcClient.ErrNotFound {
+				return "(nil)", nil
+			}
+			return "", err
+		}
+		return fmt.Sprintf("(integer) %d", rank), nil
+
+	case "ZCARD":
+		if len(args) != 1 {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'ZCARD' command (usage: ZCARD key)")
+		}
+		n, err := cli.ZCard(args[0])
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(integer) %d", n), nil
+
+	case "SADD", "SREM":
+		if len(args) < 2 {
+			return "", fmt.Errorf("ERR wrong number of arguments for '%s' command (usage: %s key member [member ...])", command, command)
+		}
+		var n int
+		var err error
+		if command == "SADD" {
+			n, err = cli.SAdd(args[0], args[1:]...)
+		} else {
+			n, err = cli.SRem(args[0], args[1:]...)
+		}
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(integer) %d", n), nil
+
+	case "SISMEMBER":
+		if len(args) != 2 {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'SISMEMBER' command (usage: SISMEMBER key member)")
+		}
+		found, err := cli.SIsMember(args[0], args[1])
+		if err != nil {
+			return "", err
+		}
+		if found {
+			return "(integer) 1", nil
+		}
+		return "(integer) 0", nil
+
+	case "SMEMBERS":
+		if len(args) != 1 {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'SMEMBERS' command (usage: SMEMBERS key)")
+		}
+		members, err := cli.SMembers(args[0])
+		if err != nil {
+			return "", err
+		}
+		sort.Strings(members) // Sets are unordered; sort for stable output
+		return formatList(members), nil
+
+	case "SCARD":
+		if len(args) != 1 {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'SCARD' command (usage: SCARD key)")
+		}
+		n, err := cli.SCard(args[0])
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(integer) %d", n), nil
+
+	case "SRANDMEMBER":
+		if len(args) != 1 && len(args) != 2 {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'SRANDMEMBER' command (usage: SRANDMEMBER key [count])")
+		}
+		count := 1
+		if len(args) == 2 {
+			var err error
+			count, err = strconv.Atoi(args[1])
+			if err != nil {
+				return "", fmt.Errorf("ERR value is not an integer or out of range")
+			}
+		}
+		members, err := cli.SRandMember(args[0], count)
+		if err != nil {
+			return "", err
+		}
+		if len(args) == 1 {
+			// Without a count, reply with a single member like redis-cli.
+			if len(members) == 0 {
+				return "(nil)", nil
+			}
+			return fmt.Sprintf("%q", members[0]), nil
+		}
+		return formatList(members), nil
+
+	case "SINTER", "SUNION", "SDIFF":
+		if len(args) < 1 {
+			return "", fmt.Errorf("ERR wrong number of arguments for '%s' command (usage: %s key [key ...])", command, command)
+		}
+		var members []string
+		var err error
+		switch command {
+		case "SINTER":
+			members, err = cli.SInter(args...)
+		case "SUNION":
+			members, err = cli.SUnion(args...)
+		default:
+			members, err = cli.SDiff(args...)
+		}
+		if err != nil {
+			return "", err
+		}
+		sort.Strings(members)
+		return formatList(members), nil
+
+	case "PFADD":
+		if len(args) < 2 {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'PFADD' command (usage: PFADD key element [element ...])")
+		}
+		changed, err := cli.PFAdd(args[0], args[1:]...)
+		if err != nil {
+			return "", err
+		}
+		if changed {
+			return "(integer) 1", nil
+		}
+		return "(integer) 0", nil
+
+	case "PFCOUNT":
+		if len(args) < 1 {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'PFCOUNT' command (usage: PFCOUNT key [key ...])")
+		}
+		n, err := cli.PFCount(args...)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(integer) %d", n), nil
+
+	case "PFMERGE":
+		if len(args) < 1 {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'PFMERGE' command (usage: PFMERGE dest [source ...])")
+		}
+		if err := cli.PFMerge(args[0], args[1:]...); err != nil {
+			return "", err
+		}
+		return "OK", nil
+
+	case "BF.RESERVE":
+		if len(args) != 3 {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'BF.RESERVE' command (usage: BF.RESERVE key error_rate capacity)")
+		}
+		errorRate, err := strconv.ParseFloat(args[1], 64)
+		if err != nil {
+			return "", fmt.Errorf("ERR error rate is not a valid float")
+		}
+		capacity, err := strconv.Atoi(args[2])
+		if err != nil {
+			return "", fmt.Errorf("ERR value is not an integer or out of range")
+		}
+		ok, err := cli.BFReserve(args[0], errorRate, capacity)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "", fmt.Errorf("ERR item exists")
+		}
+		return "OK", nil
+
+	case "BF.ADD", "BF.EXISTS":
+		if len(args) != 2 {
+			return "", fmt.Errorf("ERR wrong number of arguments for '%s' command (usage: %s key element)", command, command)
+		}
+		var result bool
+		var err error
+		if command == "BF.ADD" {
+			result, err = cli.BFAdd(args[0], args[1])
+		} else {
+			result, err = cli.BFExists(args[0], args[1])
+		}
+		if err != nil {
+			return "", err
+		}
+		if result {
+			return "(integer) 1", nil
+		}
+		return "(integer) 0", nil
+
+	case "BF.MADD", "BF.MEXISTS":
+		if len(args) < 2 {
+			return "", fmt.Errorf("ERR wrong number of arguments for '%s' command (usage: %s key element [element ...])", command, command)
+		}
+		var results []bool
+		var err error
+		if command == "BF.MADD" {
+			results, err = cli.BFMAdd(args[0], args[1:]...)
+		} else {
+			results, err = cli.BFMExists(args[0], args[1:]...)
+		}
+		if err != nil {
+			return "", err
+		}
+		lines := make([]string, len(results))
+		for i, result := range results {
+			n := 0
+			if result {
+				n = 1
+			}
+			lines[i] = fmt.Sprintf("%d) (integer) %d", i+1, n)
+		}
+		return strings.Join(lines, "\n"), nil
+
+	case "SCAN":
+		if len(args) < 1 || len(args)%2 != 1 {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'SCAN' command (usage: SCAN cursor [MATCH pattern] [COUNT count])")
+		}
+		cursor, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("ERR invalid cursor")
+		}
+		var match string
+		var count int
+		for i := 1; i < len(args); i += 2 {
+			switch strings.ToUpper(args[i]) {
+			case "MATCH":
+				match = args[i+1]
+			case "COUNT":
+				count, err = strconv.Atoi(args[i+1])
+				if err != nil || count < 1 {
+					return "", fmt.Errorf("ERR value is not an integer or out of range")
+				}
+			default:
+				return "", fmt.Errorf("ERR syntax error")
+			}
+		}
+		keys, next, err := cli.Scan(cursor, match, count)
+		if err != nil {
+			return "", err
+		}
+		// Nest the key list under the cursor the way redis-cli does.
+		keyList := strings.ReplaceAll(formatList(keys), "\n", "\n   ")
+		return fmt.Sprintf("1) %q\n2) %s", strconv.FormatUint(next, 10), keyList), nil
+
+	case "DELPATTERN":
+		if len(args) != 1 {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'DELPATTERN' command (usage: DELPATTERN pattern)")
+		}
+		n, err := cli.DeletePattern(args[0])
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(integer) %d", n), nil
+
+	case "FLUSHALL":
+		if len(args) != 0 {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'FLUSHALL' command")
+		}
+		n, err := cli.FlushAll()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(integer) %d", n), nil
+
+	case "INVALIDATE":
+		if len(args) != 2 || !strings.EqualFold(args[0], "TAG") {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'INVALIDATE' command (usage: INVALIDATE TAG tag)")
+		}
+		n, err := cli.InvalidateTag(args[1])
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(integer) %d", n), nil
+
+	case "SELECT":
+		if len(args) != 1 {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'SELECT' command (usage: SELECT namespace)")
+		}
+		if err := cli.Select(args[0]); err != nil {
+			return "", err
+		}
+		return "OK", nil
+
+	case "STATS":
+		if len(args) != 0 {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'STATS' command")
+		}
+		stats, err := cli.Stats()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("namespace:%s\nkeys:%d\nbytes:%d\nhits:%d\nmisses:%d\nevictions:%d\nexpired:%d",
+			stats.Namespace, stats.Keys, stats.Bytes, stats.Hits, stats.Misses, stats.Evictions, stats.Expired), nil
+
+	case "PUBLISH":
+		if len(args) != 2 {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'PUBLISH' command (usage: PUBLISH channel message)")
+		}
+		n, err := cli.Publish(args[0], []byte(args[1]))
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(integer) %d", n), nil
+
+	case "SUBSCRIBE", "PSUBSCRIBE":
+		if len(args) == 0 {
+			return "", fmt.Errorf("ERR wrong number of arguments for '%s' command (usage: %s channel [channel ...])", command, command)
+		}
+		subscribe := cli.Subscribe
+		if command == "PSUBSCRIBE" {
+			subscribe = cli.PSubscribe
+		}
+		sub, err := subscribe(args...)
+		if err != nil {
+			return "", err
+		}
+		defer sub.Close()
+		fmt.Println("Reading messages... (press Ctrl-C to quit)")
+		for msg := range sub.C {
+			if msg.Pattern != "" {
+				fmt.Printf("%s (%s): %q\n", msg.Channel, msg.Pattern, msg.Payload)
+			} else {
+				fmt.Printf("%s: %q\n", msg.Channel, msg.Payload)
+			}
+		}
+		if err := sub.Err(); err != nil {
+			return "", err
+		}
+		return "(subscription closed)", nil
+
+	case "WATCH":
+		if len(args) == 0 {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'WATCH' command (usage: WATCH key [key ...])")
+		}
+		if err := cli.Watch(args...); err != nil {
+			return "", err
+		}
+		return "OK", nil
+
+	case "UNWATCH":
+		if err := cli.Unwatch(); err != nil {
+			return "", err
+		}
+		return "OK", nil
+
+	case "MULTI":
+		pendingTx = cli.Multi()
+		return "OK", nil
+
+	case "EXEC", "DISCARD":
+		if pendingTx == nil {
+			return "", fmt.Errorf("ERR %s without MULTI", command)
+		}
+		tx := pendingTx
+		pendingTx = nil
+		if command == "DISCARD" {
+			if err := tx.Discard(); err != nil {
+				return "", err
+			}
+			return "OK", nil
+		}
+		replies, err := tx.Exec()
+		if err == zcClient.ErrTxAborted {
+			return "(nil)", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		lines := make([]string, len(replies))
+		for i, reply := range replies {
+			lines[i] = fmt.Sprintf("%d) %s", i+1, formatReply(reply))
+		}
+		return strings.Join(lines, "\n"), nil
+
+	case "THROTTLE":
+		if len(args) != 3 && len(args) != 4 {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'THROTTLE' command (usage: THROTTLE key limit window-ms [cost])")
+		}
+		var params [3]int
+		params[2] = 1
+		for i, arg := range args[1:] {
+			n, err := strconv.Atoi(arg)
+			if err != nil {
+				return "", fmt.Errorf("ERR value is not an integer or out of range")
+			}
+			params[i] = n
+		}
+		result, err := cli.ThrottleN(args[0], params[0], time.Duration(params[1])*time.Millisecond, params[2])
+		if err != nil {
+			return "", err
+		}
+		allowed := 0
+		if result.Allowed {
+			allowed = 1
+		}
+		return fmt.Sprintf("allowed:%d\nremaining:%d\nretry_after_ms:%d\nreset_after_ms:%d",
+			allowed, result.Remaining, result.RetryAfter.Milliseconds(), result.ResetAfter.Milliseconds()), nil
+
+	case "LOCK", "EXTEND":
+		if len(args) != 3 {
+			return "", fmt.Errorf("ERR wrong number of arguments for '%s' command (usage: %s key owner ttl-ms)", command, command)
+		}
+		ttlMillis, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("ERR value is not an integer or out of range")
+		}
+		ttl := time.Duration(ttlMillis) * time.Millisecond
+		if command == "EXTEND" {
+			if err := cli.ExtendLock(args[0], args[1], ttl); err != nil {
+				return "", err
+			}
+			return "OK", nil
+		}
+		fence, err := cli.Lock(args[0], args[1], ttl)
+		if err == zcClient.ErrLockHeld {
+			return "(nil)", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(fence) %d", fence), nil
+
+	case "UNLOCK":
+		if len(args) != 2 {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'UNLOCK' command (usage: UNLOCK key owner)")
+		}
+		if err := cli.Unlock(args[0], args[1]); err != nil {
+			return "", err
+		}
+		return "OK", nil
+
+	case "EVAL", "EVALSHA":
+		if len(args) < 2 {
+			return "", fmt.Errorf("ERR wrong number of arguments for '%s' command (usage: %s script numkeys [key ...] [arg ...])", command, command)
+		}
+		numKeys, err := strconv.Atoi(args[1])
+		if err != nil || numKeys < 0 || numKeys > len(args)-2 {
+			return "", fmt.Errorf("ERR invalid number of keys")
+		}
+		keys, scriptArgs := args[2:2+numKeys], args[2+numKeys:]
+		eval := cli.Eval
+		if command == "EVALSHA" {
+			eval = cli.EvalSHA
+		}
+		reply, err := eval(args[0], keys, scriptArgs...)
+		if err != nil {
+			return "", err
+		}
+		return formatReply(reply), nil
+
+	case "SCRIPT":
+		if len(args) != 2 || !strings.EqualFold(args[0], "LOAD") {
+			return "", fmt.Errorf("ERR wrong number of arguments for 'SCRIPT' command (usage: SCRIPT LOAD script)")
+		}
+		sha, err := cli.ScriptLoad(args[1])
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%q", sha), nil
+
 	case "PING":
 		if len(args) > 1 {
 			return "", fmt.Errorf("ERR wrong number of arguments for 'PING' command")
@@ -168,12 +1038,231 @@ func executeCommand(cli *zcClient.Client, parts []string) (string, error) {
 	}
 }
 
+// queueCommand adds a command typed after MULTI to tx.
+func queueCommand(tx *zcClient.Tx, command string, args []string) error {
+	switch {
+	case command == "SET" && len(args) == 2:
+		tx.Set(args[0], []byte(args[1]))
+	case command == "GET" && len(args) == 1:
+		tx.Get(args[0])
+	case (command == "DEL" || command == "DELETE") && len(args) == 1:
+		tx.Delete(args[0])
+	case (command == "INCR" || command == "DECR") && len(args) == 1:
+		delta := int64(1)
+		if command == "DECR" {
+			delta = -1
+		}
+		tx.IncrBy(args[0], delta)
+	case command == "INCRBY" && len(args) == 2:
+		delta, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("ERR value is not an integer or out of range")
+		}
+		tx.IncrBy(args[0], delta)
+	case command == "HSET" && len(args) == 3:
+		tx.HSet(args[0], args[1], []byte(args[2]))
+	case command == "HDEL" && len(args) >= 2:
+		tx.HDel(args[0], args[1:]...)
+	case command == "SADD" && len(args) >= 2:
+		tx.SAdd(args[0], args[1:]...)
+	case command == "SREM" && len(args) >= 2:
+		tx.SRem(args[0], args[1:]...)
+	case command == "RPUSH" && len(args) >= 2:
+		values := make([][]byte, len(args)-1)
+		for i, arg := range args[1:] {
+			values[i] = []byte(arg)
+		}
+		tx.RPush(args[0], values...)
+	default:
+		return fmt.Errorf("ERR %s cannot be queued in a transaction (supported: SET, GET, DEL, INCR, DECR, INCRBY, HSET, HDEL, SADD, SREM, RPUSH)", command)
+	}
+	return nil
+}
+
+// formatReply renders one reply of EXEC or EVAL.
+func formatReply(reply zcClient.Reply) string {
+	if err := reply.Err(); err == zcClient.ErrNotFound {
+		return "(nil)"
+	} else if err != nil {
+		return fmt.Sprintf("(error) %v", err)
+	}
+	if n, err := reply.Int(); err == nil {
+		return fmt.Sprintf("(integer) %d", n)
+	}
+	if value, err := reply.Bytes(); err == nil {
+		return fmt.Sprintf("%q", string(value))
+	}
+	if items, err := reply.Strings(); err == nil {
+		return formatList(items)
+	}
+	return "OK"
+}
+
+// splitArgs splits an input line on whitespace. Single quotes group text
+// containing spaces, such as a script, into one argument.
+func splitArgs(input string) ([]string, error) {
+	var parts []string
+	var current strings.Builder
+	inArg, quoted := false, false
+	for _, r := range input {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+			inArg = true
+		case !quoted && (r == ' ' || r == '\t'):
+			if inArg {
+				parts = append(parts, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inArg {
+		parts = append(parts, current.String())
+	}
+	return parts, nil
+}
+
+// formatList renders a list of strings the way redis-cli prints multi-bulk replies.
+func formatList(items []string) string {
+	if len(items) == 0 {
+		return "(empty array)"
+	}
+	lines := make([]string, len(items))
+	for i, item := range items {
+		lines[i] = fmt.Sprintf("%d) %q", i+1, item)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// bytesToStrings converts raw values for display.
+func bytesToStrings(values [][]byte) []string {
+	items := make([]string, len(values))
+	for i, value := range values {
+		items[i] = string(value)
+	}
+	return items
+}
+
+// zmembersToStrings converts sorted set members for display, optionally interleaving scores.
+func zmembersToStrings(members []zcClient.ZMember, withScores bool) []string {
+	items := make([]string, 0, len(members)*2)
+	for _, m := range members {
+		items = append(items, m.Member)
+		if withScores {
+			items = append(items, strconv.FormatFloat(m.Score, 'g', -1, 64))
+		}
+	}
+	return items
+}
+
 // printHelp displays basic usage instructions.
 func printHelp() {
 	fmt.Println("ZeroCache CLI Help:")
-	fmt.Println("  SET <key> <value>   - Set key to hold the string value.")
+	fmt.Println("  SET <key> <value> [TAGS tag [tag ...]]")
+	fmt.Println("                      - Set key to hold the string value, optionally tagged.")
 	fmt.Println("  GET <key>           - Get the value of key.")
+	fmt.Println("  SETSTREAM <key> <file>")
+	fmt.Println("                      - Set key to the contents of a file of any size, sent in chunks.")
+	fmt.Println("  GETSTREAM <key> <file>")
+	fmt.Println("                      - Write the value of key to a file, received in chunks.")
 	fmt.Println("  DEL <key>           - Delete a key.")
+	fmt.Println("  GETV <key>          - Get the value of key and its version.")
+	fmt.Println("  CAS <key> <value> <version>")
+	fmt.Println("                      - Set key only if its version still matches.")
+	fmt.Println("  SETNX <key> <value> - Set key only if it does not exist.")
+	fmt.Println("  SETXX <key> <value> - Set key only if it already exists.")
+	fmt.Println("  INCR <key>          - Increment the integer value of key by one.")
+	fmt.Println("  DECR <key>          - Decrement the integer value of key by one.")
+	fmt.Println("  INCRBY <key> <delta> [ttl-ms]")
+	fmt.Println("                      - Add delta to key; a TTL applies if the key is created.")
+	fmt.Println("  HSET <key> <field> <value> [field value ...]")
+	fmt.Println("                      - Set fields of the hash stored at key.")
+	fmt.Println("  HGET <key> <field>  - Get a field of the hash stored at key.")
+	fmt.Println("  HDEL <key> <field> [field ...]")
+	fmt.Println("                      - Delete fields of the hash stored at key.")
+	fmt.Println("  HGETALL <key>       - Get all fields and values of a hash.")
+	fmt.Println("  HLEN <key>          - Get the number of fields in a hash.")
+	fmt.Println("  LPUSH/RPUSH <key> <value> [value ...]")
+	fmt.Println("                      - Prepend/append values to a list.")
+	fmt.Println("  LPOP/RPOP <key>     - Remove and get the first/last element of a list.")
+	fmt.Println("  LRANGE <key> <start> <stop>")
+	fmt.Println("                      - Get a range of elements from a list.")
+	fmt.Println("  LLEN <key>          - Get the length of a list.")
+	fmt.Println("  BLPOP <key> [key ...] <timeout>")
+	fmt.Println("                      - Pop the first element, waiting up to timeout seconds (0 = forever).")
+	fmt.Println("  ZADD <key> <score> <member> [score member ...]")
+	fmt.Println("                      - Add members to a sorted set or update their scores.")
+	fmt.Println("  ZREM <key> <member> [member ...]")
+	fmt.Println("                      - Remove members from a sorted set.")
+	fmt.Println("  ZSCORE <key> <member>")
+	fmt.Println("                      - Get the score of a member.")
+	fmt.Println("  ZRANGE/ZREVRANGE <key> <start> <stop> [WITHSCORES]")
+	fmt.Println("                      - Get members by rank, ascending/descending.")
+	fmt.Println("  ZRANGEBYSCORE <key> <min> <max> [WITHSCORES]")
+	fmt.Println("  ZREVRANGEBYSCORE <key> <max> <min> [WITHSCORES]")
+	fmt.Println("                      - Get members by score (-inf/+inf allowed).")
+	fmt.Println("  ZRANK/ZREVRANK <key> <member>")
+	fmt.Println("                      - Get the rank of a member, ascending/descending.")
+	fmt.Println("  ZCARD <key>         - Get the number of members in a sorted set.")
+	fmt.Println("  SADD/SREM <key> <member> [member ...]")
+	fmt.Println("                      - Add/remove members of a set.")
+	fmt.Println("  SISMEMBER <key> <member>")
+	fmt.Println("                      - Check whether a member belongs to a set.")
+	fmt.Println("  SMEMBERS <key>      - Get all members of a set.")
+	fmt.Println("  SCARD <key>         - Get the number of members in a set.")
+	fmt.Println("  SRANDMEMBER <key> [count]")
+	fmt.Println("                      - Get random members (negative count allows repeats).")
+	fmt.Println("  SINTER/SUNION/SDIFF <key> [key ...]")
+	fmt.Println("                      - Intersect, unite or subtract sets.")
+	fmt.Println("  PFADD <key> <element> [element ...]")
+	fmt.Println("                      - Add elements to a HyperLogLog.")
+	fmt.Println("  PFCOUNT <key> [key ...]")
+	fmt.Println("                      - Estimate the number of distinct elements added.")
+	fmt.Println("  PFMERGE <dest> [source ...]")
+	fmt.Println("                      - Store the union of HyperLogLogs at dest.")
+	fmt.Println("  BF.RESERVE <key> <error_rate> <capacity>")
+	fmt.Println("                      - Create a Bloom filter that grows beyond capacity.")
+	fmt.Println("  BF.ADD/BF.EXISTS <key> <element>")
+	fmt.Println("                      - Add an element to a Bloom filter, or check whether it may be in it.")
+	fmt.Println("  BF.MADD/BF.MEXISTS <key> <element> [element ...]")
+	fmt.Println("                      - Add or check several elements at once.")
+	fmt.Println("  SCAN <cursor> [MATCH pattern] [COUNT count]")
+	fmt.Println("                      - Incrementally iterate over keys, starting from cursor 0.")
+	fmt.Println("  DELPATTERN <pattern> - Delete every key matching a glob pattern.")
+	fmt.Println("  FLUSHALL            - Delete every key.")
+	fmt.Println("  INVALIDATE TAG <tag> - Delete every key carrying a tag.")
+	fmt.Println("  SELECT <namespace>  - Switch the connection to another namespace.")
+	fmt.Println("  STATS               - Show key count, memory and hit/miss/eviction counters of the namespace.")
+	fmt.Println("  PUBLISH <channel> <message>")
+	fmt.Println("                      - Send a message to the subscribers of a channel.")
+	fmt.Println("  SUBSCRIBE <channel> [channel ...]")
+	fmt.Println("                      - Print messages published to channels until interrupted.")
+	fmt.Println("  PSUBSCRIBE <pattern> [pattern ...]")
+	fmt.Println("                      - Print messages published to channels matching glob patterns.")
+	fmt.Println("  WATCH <key> [key ...] - Make the next EXEC fail if any of the keys changes first.")
+	fmt.Println("  UNWATCH             - Forget all watched keys.")
+	fmt.Println("  MULTI               - Start queuing commands for a transaction.")
+	fmt.Println("  EXEC                - Run the queued commands atomically.")
+	fmt.Println("  DISCARD             - Drop the queued commands.")
+	fmt.Println("  THROTTLE <key> <limit> <window-ms> [cost]")
+	fmt.Println("                      - Count a request against a rate limit of limit per window.")
+	fmt.Println("  LOCK <key> <owner> <ttl-ms>")
+	fmt.Println("                      - Acquire a lock for owner and print its fencing token.")
+	fmt.Println("  EXTEND <key> <owner> <ttl-ms>")
+	fmt.Println("                      - Reset the TTL of a lock held by owner.")
+	fmt.Println("  UNLOCK <key> <owner> - Release a lock held by owner.")
+	fmt.Println("  EVAL <script> <numkeys> [key ...] [arg ...]")
+	fmt.Println("                      - Run a script atomically on the given keys. Quote it in 'single quotes'.")
+	fmt.Println("  EVALSHA <sha1> <numkeys> [key ...] [arg ...]")
+	fmt.Println("                      - Run a script cached on the server.")
+	fmt.Println("  SCRIPT LOAD <script> - Cache a script on the server and print its SHA1.")
 	fmt.Println("  HELP                - Show this help message.")
 	fmt.Println("  QUIT / EXIT         - Disconnect and exit the CLI.")
 }
@@ -0,0 +1,56 @@
+package cache
+
+// Reads only take the shard's read lock, so a hit can neither move its entry
+// to the front of the LRU list nor set its CLOCK bit in the arena. Instead it
+// is recorded in a small batch taken from a per-shard sync.Pool, which keeps
+// one per P, and a full batch is applied in one go under the write lock, as
+// Caffeine and Ristretto do. If the write lock is busy the batch is dropped
+// rather than waited for: recency is approximate anyway, and a hot key turns
+// up again in the next batch. Batches the pool discards at a GC are lost too.
+
+// accessBatchSize is the number of hits recorded before they are applied.
+const accessBatchSize = 64
+
+type accessBatch struct {
+	keys [accessBatchSize]string
+	n    int
+}
+
+// recordAccess notes a hit on key. It never waits for s.mu, so the caller may
+// hold it, as transaction views do: the batch is then dropped, since TryLock
+// fails. Taking s.mu with Lock here would deadlock those callers.
+func (s *Shard) recordAccess(key string) {
+	b, _ := s.accesses.Get().(*accessBatch)
+	if b == nil {
+		b = new(accessBatch)
+	}
+	b.keys[b.n] = key
+	b.n++
+	if b.n == accessBatchSize {
+		if s.mu.TryLock() {
+			s.applyAccesses(b.keys[:])
+			s.mu.Unlock()
+		}
+		clear(b.keys[:]) // Let the keys be collected
+		b.n = 0
+	}
+	s.accesses.Put(b)
+}
+
+// applyAccesses marks keys as recently used: entries in the map move to the
+// front of the LRU list and records in the arena get their accessed bit.
+// Keys that are gone by now are skipped. The caller must hold s.mu.
+func (s *Shard) applyAccesses(keys []string) {
+	for _, key := range keys {
+		if entry, found := s.items[key]; found {
+			s.lruList.MoveToFront(entry.listElement)
+			continue
+		}
+		if s.arena == nil {
+			continue
+		}
+		if rec, found := s.arena.find(key, s.hasher.Hash(key)); found && rec.flags&arenaAccessed == 0 {
+			s.arena.setFlags(rec, rec.flags|arenaAccessed)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package cache
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+)
+
+// Engine selects how a cache stores plain byte values.
+type Engine uint8
+
+const (
+	// EngineMap keeps every key in a map of entries linked into an LRU list.
+	EngineMap Engine = iota
+	// EngineArena keeps plain byte values in a preallocated ring buffer per
+	// shard, indexed by key hash and evicted with CLOCK (see arena). Neither
+	// the buffer nor the index holds pointers, so the garbage collector does
+	// not scan them, however many keys they hold.
+	EngineArena
+)
+
+// defaultArenaBytesPerShard is the arena size used when Config.ArenaBytesPerShard is 0.
+const defaultArenaBytesPerShard = 1 << 20
+
+// String returns the engine's name as accepted by ParseEngine.
+func (e Engine) String() string {
+	switch e {
+	case EngineMap:
+		return "map"
+	case EngineArena:
+		return "arena"
+	}
+	return fmt.Sprintf("Engine(%d)", uint8(e))
+}
+
+// ParseEngine parses an engine name: map or arena.
+func ParseEngine(s string) (Engine, error) {
+	switch s {
+	case "map":
+		return EngineMap, nil
+	case "arena":
+		return EngineArena, nil
+	}
+	return 0, fmt.Errorf("unknown engine %q", s)
+}
+
+// An arena is a ring buffer of records, each holding one key and its value:
+//
+//	[flags:1][keyLen:2][valueLen:4][hash:8][version:8][expiresAt:8][key][value]
+//
+// Records are appended at the tail and never modified except for their flags
+// and, on Expire, their version and expiry. Overwriting or deleting a key only
+// drops it from the index; a record is live as long as the index maps its
+// hash to its offset. Two keys with the same hash cannot both be live, so
+// writing one evicts the other.
+//
+// When the tail runs into the head, the head advances over the oldest record:
+// a dead record is simply skipped, a live one that was read since the hand
+// last passed it gets a second chance and is moved to the tail with its
+// accessed bit cleared, and any other live one is evicted.
+//
+// Only plain byte values written by Set, SetNX, SetXX and CompareAndSwap live
+// in the arena. Structured values, tagged values and values too large for it
+// live in the map, and any other command on a key first moves it there (see
+// Shard.lookup). A key is never in both.
+type arena struct {
+	buf     []byte
+	head    int               // Offset of the oldest record
+	used    int               // Bytes from the head to the tail, dead records included
+	live    int               // Bytes held by live records
+	index   map[uint64]uint32 // Key hash to the offset of its live record
+	scratch []byte            // Holds records moved by the CLOCK hand
+}
+
+// Record header layout.
+const (
+	arenaFlagsOff     = 0
+	arenaKeyLenOff    = 1
+	arenaValueLenOff  = 3
+	arenaHashOff      = 7
+	arenaVersionOff   = 15
+	arenaExpiresAtOff = 23
+	arenaHeaderSize   = 31
+)
+
+// Record flags.
+const (
+	arenaAccessed   = 1 << iota // Read since the CLOCK hand last passed
+	arenaCompressed             // The value is compressed (see encodeValue)
+)
+
+// arenaRecord is a decoded record header together with its offset.
+type arenaRecord struct {
+	off       int
+	flags     uint8
+	keyLen    int
+	valueLen  int
+	hash      uint64
+	version   uint64
+	expiresAt int64
+}
+
+func (r *arenaRecord) size() int {
+	return arenaHeaderSize + r.keyLen + r.valueLen
+}
+
+func (r *arenaRecord) expired(now int64) bool {
+	return r.expiresAt != 0 && now >= r.expiresAt
+}
+
+func newArena(size int) *arena {
+	return &arena{
+		buf:   make([]byte, size),
+		index: make(map[uint64]uint32),
+	}
+}
+
+// fits reports whether a record for key and value may be stored. Records
+// larger than an eighth of the arena are refused, so that a single write never
+// evicts most of it.
+func (a *arena) fits(key string, value []byte) bool {
+	return len(key) <= math.MaxUint16 && arenaHeaderSize+len(key)+len(value) <= len(a.buf)/8
+}
+
+// wrap maps an offset past the end of the buffer back to its start.
+func (a *arena) wrap(off int) int {
+	if off >= len(a.buf) {
+		off -= len(a.buf)
+	}
+	return off
+}
+
+// read copies len(dst) bytes starting at off into dst, wrapping around the end
+// of the buffer.
+func (a *arena) read(off int, dst []byte) {
+	n := copy(dst, a.buf[off:])
+	copy(dst[n:], a.buf)
+}
+
+// write copies src to off, wrapping around the end of the buffer.
+func (a *arena) write(off int, src []byte) {
+	n := copy(a.buf[off:], src)
+	copy(a.buf, src[n:])
+}
+
+// record decodes the header of the record at off.
+func (a *arena) record(off int) arenaRecord {
+	var h [arenaHeaderSize]byte
+	a.read(off, h[:])
+	return arenaRecord{
+		off:       off,
+		flags:     h[arenaFlagsOff],
+		keyLen:    int(binary.LittleEndian.Uint16(h[arenaKeyLenOff:])),
+		valueLen:  int(binary.LittleEndian.Uint32(h[arenaValueLenOff:])),
+		hash:      binary.LittleEndian.Uint64(h[arenaHashOff:]),
+		version:   binary.LittleEndian.Uint64(h[arenaVersionOff:]),
+		expiresAt: int64(binary.LittleEndian.Uint64(h[arenaExpiresAtOff:])),
+	}
+}
+
+// find returns the live record for key, whose hash is h.
+func (a *arena) find(key string, h uint64) (arenaRecord, bool) {
+	off, found := a.index[h]
+	if !found {
+		return arenaRecord{}, false
+	}
+	rec := a.record(int(off))
+	if rec.keyLen != len(key) {
+		return arenaRecord{}, false
+	}
+	start := a.wrap(rec.off + arenaHeaderSize)
+	first := a.buf[start:min(start+len(key), len(a.buf))]
+	if string(first) != key[:len(first)] || string(a.buf[:len(key)-len(first)]) != key[len(first):] {
+		return arenaRecord{}, false
+	}
+	return rec, true
+}
+
+// key returns the key of rec.
+func (a *arena) key(rec arenaRecord) string {
+	key := make([]byte, rec.keyLen)
+	a.read(a.wrap(rec.off+arenaHeaderSize), key)
+	return string(key)
+}
+
+// value returns a copy of the value of rec.
+func (a *arena) value(rec arenaRecord) []byte {
+	value := make([]byte, rec.valueLen)
+	a.read(a.wrap(rec.off+arenaHeaderSize+rec.keyLen), value)
+	return value
+}
+
+// appendValue appends the value of rec to dst.
+func (a *arena) appendValue(dst []byte, rec arenaRecord) []byte {
+	n := len(dst)
+	dst = slices.Grow(dst, rec.valueLen)[:n+rec.valueLen]
+	a.read(a.wrap(rec.off+arenaHeaderSize+rec.keyLen), dst[n:])
+	return dst
+}
+
+// setFlags overwrites the flags of rec.
+func (a *arena) setFlags(rec arenaRecord, flags uint8) {
+	a.buf[rec.off] = flags
+}
+
+// setExpiry overwrites the version and expiry of rec.
+func (a *arena) setExpiry(rec arenaRecord, version uint64, expiresAt int64) {
+	var b [16]byte
+	binary.LittleEndian.PutUint64(b[:8], version)
+	binary.LittleEndian.PutUint64(b[8:], uint64(expiresAt))
+	a.write(a.wrap(rec.off+arenaVersionOff), b[:])
+}
+
+// drop unindexes rec, turning it into a dead record.
+func (a *arena) drop(rec arenaRecord) {
+	delete(a.index, rec.hash)
+	a.live -= rec.size()
+}
+
+// append writes a record without expiry at the tail and indexes it. The caller must have
+// made room for it.
+func (a *arena) append(key string, h uint64, value []byte, flags uint8, version uint64) {
+	var hdr [arenaHeaderSize]byte
+	hdr[arenaFlagsOff] = flags
+	binary.LittleEndian.PutUint16(hdr[arenaKeyLenOff:], uint16(len(key)))
+	binary.LittleEndian.PutUint32(hdr[arenaValueLenOff:], uint32(len(value)))
+	binary.LittleEndian.PutUint64(hdr[arenaHashOff:], h)
+	binary.LittleEndian.PutUint64(hdr[arenaVersionOff:], version)
+
+	tail := a.wrap(a.head + a.used)
+	a.write(tail, hdr[:])
+	keyOff := a.wrap(tail + arenaHeaderSize)
+	n := copy(a.buf[keyOff:], key)
+	copy(a.buf, key[n:])
+	a.write(a.wrap(keyOff+len(key)), value)
+
+	size := arenaHeaderSize + len(key) + len(value)
+	a.used += size
+	a.live += size
+	a.index[h] = uint32(tail)
+}
+
+// reset empties the arena, keeping its buffer.
+func (a *arena) reset() {
+	a.head, a.used, a.live = 0, 0, 0
+	a.index = make(map[uint64]uint32)
+}
+
+// arenaLookup returns the live record for key, dropping it first if it has
+// expired. The caller must hold s.mu for writing.
+func (s *Shard) arenaLookup(key string) (arenaRecord, bool) {
+	rec, found := s.arena.find(key, s.hasher.Hash(key))
+	if !found {
+		return arenaRecord{}, false
+	}
+	if rec.expired(time.Now().UnixNano()) {
+		s.arenaRemove(rec, EventExpired)
+		return arenaRecord{}, false
+	}
+	return rec, true
+}
+
+// arenaSet stores value under key in the arena, moving the key out of the map
+// if it is there, and returns its new version. It reports false, storing
+// nothing, if the value does not fit in the arena. The caller must hold s.mu.
+func (s *Shard) arenaSet(key string, value []byte, compressed bool) (uint64, bool) {
+	a := s.arena
+	if !a.fits(key, value) {
+		return 0, false
+	}
+	if entry, found := s.items[key]; found {
+		s.unlink(key, entry)
+	}
+	h := s.hasher.Hash(key)
+	if off, found := a.index[h]; found {
+		rec := a.record(int(off))
+		if rec.keyLen == len(key) && a.key(rec) == key {
+			a.drop(rec)
+		} else {
+			s.arenaRemove(rec, EventEvicted) // Hash collision
+		}
+	}
+
+	size := arenaHeaderSize + len(key) + len(value)
+	for len(a.buf)-a.used < size {
+		s.arenaAdvance()
+	}
+	var flags uint8
+	if compressed {
+		flags = arenaCompressed
+	}
+	s.version++
+	a.append(key, h, value, flags, s.version)
+	s.emit(EventSet, key)
+	return s.version, true
+}
+
+// arenaAdvance moves the arena's head past its oldest record, evicting it or
+// giving it a second chance as described on arena. The caller must hold s.mu.
+func (s *Shard) arenaAdvance() {
+	a := s.arena
+	rec := a.record(a.head)
+	size := rec.size()
+	live := a.index[rec.hash] == uint32(rec.off)
+	expired := rec.expired(time.Now().UnixNano())
+	if live && rec.flags&arenaAccessed != 0 && !expired {
+		if cap(a.scratch) < size {
+			a.scratch = make([]byte, size)
+		}
+		moved := a.scratch[:size]
+		a.read(rec.off, moved)
+		moved[arenaFlagsOff] &^= arenaAccessed
+
+		a.head = a.wrap(a.head + size)
+		tail := a.wrap(a.head + a.used - size)
+		a.write(tail, moved)
+		a.index[rec.hash] = uint32(tail)
+		return
+	}
+	if live && expired {
+		s.arenaRemove(rec, EventExpired)
+	} else if live {
+		s.arenaRemove(rec, EventEvicted)
+	}
+	a.head = a.wrap(a.head + size)
+	a.used -= size
+}
+
+// arenaRemove drops rec from the arena, counting and emitting the removal as
+// reason like remove. The caller must hold s.mu.
+func (s *Shard) arenaRemove(rec arenaRecord, reason EventType) {
+	s.arena.drop(rec)
+	switch reason {
+	case EventExpired:
+		s.stats.expired++
+	case EventEvicted:
+		s.stats.evictions++
+	}
+	if s.events != nil && s.events.types&reason != 0 {
+		s.emit(reason, s.arena.key(rec))
+	}
+}
+
+// promote moves key from the arena to the map and returns its new entry, with
+// its version and expiry kept. The caller must hold s.mu for writing.
+func (s *Shard) promote(key string) (*cacheEntry, bool) {
+	rec, found := s.arenaLookup(key)
+	if !found {
+		return nil, false
+	}
+	entry := &cacheEntry{
+		value:       s.arena.value(rec),
+		compressed:  rec.flags&arenaCompressed != 0,
+		version:     rec.version,
+		expiresAt:   rec.expiresAt,
+		listElement: s.lruList.PushFront(key),
+	}
+	s.arena.drop(rec)
+	s.items[key] = entry
+	s.resized(entry)
+	return entry, true
+}
+
+// arenaKeys appends the keys of the arena's records that are live at time now
+// to keys. The caller must hold s.mu.
+func (s *Shard) arenaKeys(keys []string, now int64) []string {
+	for _, off := range s.arena.index {
+		if rec := s.arena.record(int(off)); !rec.expired(now) {
+			keys = append(keys, s.arena.key(rec))
+		}
+	}
+	return keys
+}
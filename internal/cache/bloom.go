@@ -0,0 +1,213 @@
+package cache
+
+import (
+	"errors"
+	"math"
+)
+
+// Defaults for a Bloom filter created implicitly by BFAdd.
+const (
+	DefaultBloomErrorRate = 0.01
+	DefaultBloomCapacity  = 100
+)
+
+// maxBloomFilterBytes bounds the bit array of a single sub-filter. BFReserve
+// rejects larger filters, and growth stops scaling sub-filters up once the
+// next one would exceed it.
+const maxBloomFilterBytes = 64 << 20
+
+// ErrInvalidBloom is returned by BFReserve for an error rate outside (0, 1) or
+// a capacity that is not positive or needs more than 64MB.
+var ErrInvalidBloom = errors.New("bloom error rate must be between 0 and 1 and capacity positive, at most 64MB of bits")
+
+// bloomValue is a scalable Bloom filter: a series of sub-filters, each with
+// twice the capacity and half the error rate of the one before. Elements are
+// added to the last one, and a new one is started when it is full. The false
+// positive rates of the sub-filters sum to at most the requested one, so it
+// holds until a sub-filter reaches maxBloomFilterBytes. From then on each new
+// sub-filter is the same as the last, keeping memory proportional to the
+// elements added at the cost of a rising rate.
+type bloomValue struct {
+	filters []*bloomFilter
+}
+
+type bloomFilter struct {
+	bits      []uint64
+	hashes    int     // Bits set per element
+	capacity  int     // Elements before the next sub-filter is started
+	count     int     // Elements added
+	errorRate float64 // False positive rate once full
+}
+
+func (b *bloomValue) size() int {
+	size := 0
+	for _, f := range b.filters {
+		size += len(f.bits)*8 + 48
+	}
+	return size
+}
+
+// bloomBits returns the number of bits a filter of capacity needs for
+// errorRate, with the optimal number of hashes.
+func bloomBits(capacity int, errorRate float64) int {
+	return int(math.Ceil(-float64(capacity) * math.Log(errorRate) / (math.Ln2 * math.Ln2)))
+}
+
+// bloomFits reports whether a filter of capacity for errorRate can be
+// allocated: a rate that rounds to zero, such as half a denormal, needs
+// infinitely many bits, which convert to a negative int.
+func bloomFits(capacity int, errorRate float64) bool {
+	if errorRate <= 0 {
+		return false
+	}
+	bits := bloomBits(capacity, errorRate)
+	return bits > 0 && bits <= maxBloomFilterBytes*8
+}
+
+func newBloomFilter(capacity int, errorRate float64) *bloomFilter {
+	words := (bloomBits(capacity, errorRate) + 63) / 64
+	return &bloomFilter{
+		bits:      make([]uint64, words),
+		hashes:    max(int(math.Ceil(-math.Log2(errorRate))), 1),
+		capacity:  capacity,
+		errorRate: errorRate,
+	}
+}
+
+func newBloomValue(errorRate float64, capacity int) *bloomValue {
+	// The first sub-filter gets half the rate, so that the series sums to it.
+	return &bloomValue{filters: []*bloomFilter{newBloomFilter(capacity, errorRate/2)}}
+}
+
+// bloomHash returns the two hashes element's bit positions are derived from,
+// as h1 + i*h2 (Kirsch and Mitzenmacher). h2 is made odd so it is never zero,
+// which would put all of them on the same bit.
+func bloomHash(element string) (uint64, uint64) {
+	h1 := fmix64(fnv1a(element))
+	return h1, fmix64(h1+0x9e3779b97f4a7c15) | 1
+}
+
+func (f *bloomFilter) test(h1, h2 uint64) bool {
+	m := uint64(len(f.bits)) * 64
+	for i := range uint64(f.hashes) {
+		bit := (h1 + i*h2) % m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *bloomFilter) set(h1, h2 uint64) {
+	m := uint64(len(f.bits)) * 64
+	for i := range uint64(f.hashes) {
+		bit := (h1 + i*h2) % m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+	f.count++
+}
+
+// add adds element unless it may already be present, and reports whether it
+// did.
+func (b *bloomValue) add(element string) bool {
+	h1, h2 := bloomHash(element)
+	if b.contains(h1, h2) {
+		return false
+	}
+	last := b.filters[len(b.filters)-1]
+	if last.count >= last.capacity {
+		last = newBloomFilter(last.next())
+		b.filters = append(b.filters, last)
+	}
+	last.set(h1, h2)
+	return true
+}
+
+// next returns the capacity and error rate of the sub-filter to start once f
+// is full: twice the capacity and half the error rate, or the same as f if
+// that would exceed maxBloomFilterBytes.
+func (f *bloomFilter) next() (int, float64) {
+	capacity, errorRate := f.capacity*2, f.errorRate/2
+	if !bloomFits(capacity, errorRate) {
+		return f.capacity, f.errorRate
+	}
+	return capacity, errorRate
+}
+
+func (b *bloomValue) contains(h1, h2 uint64) bool {
+	for _, f := range b.filters {
+		if f.test(h1, h2) {
+			return true
+		}
+	}
+	return false
+}
+
+// BFReserve creates an empty Bloom filter at key that holds capacity elements
+// with a false positive rate of errorRate before it starts growing. It returns
+// false if the key already exists.
+func (c *Cache) BFReserve(key string, errorRate float64, capacity int) (bool, error) {
+	// Every element takes more than a bit, so checking capacity first keeps
+	// bloomBits from overflowing.
+	if !(errorRate > 0 && errorRate < 1) || capacity <= 0 || capacity > maxBloomFilterBytes*8 ||
+		!bloomFits(capacity, errorRate/2) {
+		return false, ErrInvalidBloom
+	}
+	shard := c.shards[c.getShardIndex(key)]
+
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	if _, found := shard.lookup(key); found {
+		return false, nil
+	}
+	shard.store(key, nil, newBloomValue(errorRate, capacity))
+	return true, nil
+}
+
+// BFAdd adds elements to the Bloom filter at key, creating one with
+// DefaultBloomErrorRate and DefaultBloomCapacity if needed, and reports for
+// each element whether it was added, i.e. was not already possibly present.
+// The filter grows as needed, so adding never fails for lack of room.
+func (c *Cache) BFAdd(key string, elements ...string) ([]bool, error) {
+	shard := c.shards[c.getShardIndex(key)]
+
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	entry, b, err := getOrCreateObject(shard, key, func() *bloomValue {
+		return newBloomValue(DefaultBloomErrorRate, DefaultBloomCapacity)
+	})
+	if err != nil {
+		return nil, err
+	}
+	added := make([]bool, len(elements))
+	for i, element := range elements {
+		added[i] = b.add(element)
+	}
+	shard.modified(entry)
+	return added, nil
+}
+
+// BFExists reports for each element whether it may have been added to the
+// Bloom filter at key. False means it certainly was not; true is wrong at most
+// at the filter's error rate. A missing key holds no elements.
+func (c *Cache) BFExists(key string, elements ...string) ([]bool, error) {
+	shard := c.shards[c.getShardIndex(key)]
+
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	_, b, err := getObject[*bloomValue](shard, key)
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+	found := make([]bool, len(elements))
+	if b == nil {
+		return found, nil
+	}
+	for i, element := range elements {
+		found[i] = b.contains(bloomHash(element))
+	}
+	return found, nil
+}
@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"time"
+
+	"github.com/jasonrowsell/zerocache/internal/glob"
+)
+
+// deleteBatchSize bounds how many keys a bulk delete removes per shard lock
+// acquisition, so other operations on the shard only ever wait for one batch.
+const deleteBatchSize = 256
+
+// DeletePattern removes every key matching the glob pattern and returns how
+// many were removed. It works through one shard at a time: the shard's keys are
+// copied under a read lock, matched without any lock held, and the matches are
+// deleted in small batches. Keys written while it runs may or may not be
+// removed. If ctx is done it stops early and returns the count so far along
+// with ctx.Err().
+func (c *Cache) DeletePattern(ctx context.Context, pattern string) (int, error) {
+	removed := 0
+	for _, shard := range c.shards {
+		c.rlock(shard)
+		keys := make([]string, 0, len(shard.items))
+		for key := range shard.items {
+			keys = append(keys, key)
+		}
+		if shard.arena != nil {
+			keys = shard.arenaKeys(keys, time.Now().UnixNano())
+		}
+		c.runlock(shard)
+
+		matches := keys[:0]
+		for _, key := range keys {
+			if glob.Match(pattern, key) {
+				matches = append(matches, key)
+			}
+		}
+
+		for len(matches) > 0 {
+			if err := ctx.Err(); err != nil {
+				return removed, err
+			}
+			batch := matches[:min(deleteBatchSize, len(matches))]
+			matches = matches[len(batch):]
+
+			c.lock(shard)
+			for _, key := range batch {
+				if shard.delete(key) {
+					removed++
+				}
+			}
+			c.unlock(shard)
+		}
+	}
+	return removed, nil
+}
+
+// FlushAll removes every key and returns how many were removed. Each shard is
+// emptied in constant time under its lock by swapping in fresh structures; the
+// old ones are left to the garbage collector. Clients blocked in BLPop keep
+// waiting.
+func (c *Cache) FlushAll() int {
+	removed := 0
+	for _, shard := range c.shards {
+		c.lock(shard)
+		removed += len(shard.items)
+		shard.items = make(map[string]*cacheEntry)
+		shard.lruList = list.New()
+		shard.used = 0
+		shard.tags = nil
+		if shard.arena != nil {
+			removed += len(shard.arena.index)
+			shard.arena.reset()
+		}
+		c.unlock(shard)
+	}
+	return removed
+}
@@ -2,8 +2,11 @@ package cache
 
 import (
 	"container/list"
-	"hash/fnv"
+	"errors"
+	"math"
+	"strconv"
 	"sync"
+	"time"
 )
 
 const (
@@ -11,30 +14,100 @@ const (
 	defaultMaxItemsPerShard = 1024
 )
 
+var (
+	ErrNotFound        = errors.New("key not found")
+	ErrVersionMismatch = errors.New("version mismatch")
+	ErrNotInteger      = errors.New("value is not an integer")
+	ErrOverflow        = errors.New("increment or decrement would overflow")
+	ErrWrongType       = errors.New("WRONGTYPE operation against a key holding the wrong kind of value")
+	ErrInvalidScore    = errors.New("score is not a valid float")
+
+	// errExpired is returned by Shard.peek for an expired key it could not drop.
+	errExpired = errors.New("key expired")
+)
+
+// entryOverhead approximates the bookkeeping cost of an entry (map slot,
+// cacheEntry and list element) for memory accounting.
+const entryOverhead = 128
+
+// object is a structured value (hash, list, ...) stored in place of a plain byte value.
+type object interface {
+	// size returns the approximate number of bytes held by the object.
+	size() int
+}
+
 // cacheEntry holds the value and a pointer to its corresponding element in the LRU list.
 type cacheEntry struct {
-	value       []byte
+	value       []byte        // Replaced on writes, never modified in place (see View)
+	compressed  bool          // value is compressed (see encodeValue)
+	obj         object        // Structured value; nil for plain byte values
+	size        int           // Bytes accounted against the shard for this entry
+	version     uint64        // CAS token, changes on every write to the entry
+	expiresAt   int64         // Unix nanoseconds after which the entry is gone, 0 for no expiry
+	tags        []string      // Tags for InvalidateTag, indexed in Shard.tags
 	listElement *list.Element // Pointer to the node in the list.List
 }
 
+// expired reports whether the entry's TTL has passed at time now (Unix nanoseconds).
+func (e *cacheEntry) expired(now int64) bool {
+	return e.expiresAt != 0 && now >= e.expiresAt
+}
+
+// expiryAfter returns the Unix nanoseconds ttl from now, saturating rather
+// than wrapping around to the past for a TTL beyond the year 2262.
+func expiryAfter(ttl time.Duration) int64 {
+	now := time.Now().UnixNano()
+	if int64(ttl) > math.MaxInt64-now {
+		return math.MaxInt64
+	}
+	return now + int64(ttl)
+}
+
 // Cache is a sharded key-value store.
 type Cache struct {
-	shards           []*Shard
-	shardMask        uint64
-	maxItemsPerShard int
+	shards            []*Shard
+	shardMask         uint64
+	maxItemsPerShard  int
+	compressThreshold int
+	engine            Engine
+	hasher            Hasher
+	tx                *txLocks // Set on transaction views only
 }
 
 // Shard represents a single partition of a cache.
 type Shard struct {
+	index    int // Position in Cache.shards
 	items    map[string]*cacheEntry
 	lruList  *list.List
 	mu       sync.RWMutex
 	maxItems int
+	maxBytes int
+	used     int                            // Bytes accounted for all entries in the shard
+	version  uint64                         // Last version handed out by this shard
+	waiters  map[string][]*listWaiter       // Clients blocked in BLPop, by key
+	tags     map[string]map[string]struct{} // Keys carrying each tag
+	stats    shardStats
+	events   *eventHook // Installed by Cache.Notify
+	arena    *arena     // Set with EngineArena
+	hasher   Hasher     // Same as Cache.hasher
+	accesses sync.Pool  // *accessBatch of hits not yet applied, see recordAccess
 }
 
 type Config struct {
 	ShardCount       int
 	MaxItemsPerShard int
+	MaxBytesPerShard int // Approximate memory limit per shard, 0 for unlimited
+	// CompressThreshold is the size from which byte values are stored
+	// compressed, if that makes them smaller (see encodeValue); 0 disables it.
+	CompressThreshold int
+	// Engine selects where plain byte values are stored. With EngineArena,
+	// MaxItemsPerShard and MaxBytesPerShard only limit the keys kept in the
+	// map; the arena is limited by its size, ArenaBytesPerShard (1MB if 0).
+	Engine             Engine
+	ArenaBytesPerShard int
+	// Hasher hashes keys; nil selects FNV1a. Use NewMapHasher for keys chosen
+	// by untrusted clients.
+	Hasher Hasher
 }
 
 // New creates a new Cache instance with the default number of shards.
@@ -53,92 +126,335 @@ func NewWithConfig(config Config) *Cache {
 	if config.MaxItemsPerShard < 0 {
 		config.MaxItemsPerShard = 0 // Unlimited
 	}
+	if config.MaxBytesPerShard < 0 {
+		config.MaxBytesPerShard = 0 // Unlimited
+	}
+	if config.ArenaBytesPerShard <= 0 {
+		config.ArenaBytesPerShard = defaultArenaBytesPerShard
+	}
+	// Record offsets are stored as uint32.
+	config.ArenaBytesPerShard = min(config.ArenaBytesPerShard, math.MaxUint32)
+	if config.Hasher == nil {
+		config.Hasher = FNV1a{}
+	}
 	c := &Cache{
-		shards:           make([]*Shard, config.ShardCount),
-		shardMask:        uint64(config.ShardCount - 1), // Precompute mask
-		maxItemsPerShard: config.MaxItemsPerShard,
+		shards:            make([]*Shard, config.ShardCount),
+		shardMask:         uint64(config.ShardCount - 1), // Precompute mask
+		maxItemsPerShard:  config.MaxItemsPerShard,
+		compressThreshold: config.CompressThreshold,
+		engine:            config.Engine,
+		hasher:            config.Hasher,
 	}
 	for i := 0; i < config.ShardCount; i++ {
 		c.shards[i] = &Shard{
+			index:    i,
 			items:    make(map[string]*cacheEntry),
 			lruList:  list.New(),
 			maxItems: config.MaxItemsPerShard,
+			maxBytes: config.MaxBytesPerShard,
+			hasher:   config.Hasher,
 			// mu implicity initialized
 		}
+		if config.Engine == EngineArena {
+			c.shards[i].arena = newArena(config.ArenaBytesPerShard)
+		}
 	}
 	return c
 }
 
 // getShardIndex returns the index of a shard for a given key.
 func (c *Cache) getShardIndex(key string) uint64 {
-	hasher := fnv.New64a()
-	hasher.Write([]byte(key))
-	return hasher.Sum64() & c.shardMask // Use bitwise AND as modulo
+	return c.hasher.Hash(key) & c.shardMask // Use bitwise AND as modulo
 }
 
 // Get retrieves a value from the cache.
+// Keys holding structured values (hashes, ...) are reported as missing;
+// use GetWithVersion to tell them apart.
 func (c *Cache) Get(key string) ([]byte, bool) {
+	value, _, err := c.GetWithVersion(key)
+	return value, err == nil
+}
+
+// GetWithVersion retrieves a value together with its current version.
+// The version can be passed to CompareAndSwap to update the value only if it
+// has not been modified in the meantime. It returns ErrNotFound for a missing
+// key and ErrWrongType if the key holds a structured value.
+//
+// It only takes the shard's read lock, unless the key has expired and must be
+// dropped, and records the hit for the LRU list or CLOCK later (see
+// recordAccess), so concurrent reads of a shard do not wait for each other.
+func (c *Cache) GetWithVersion(key string) ([]byte, uint64, error) {
+	v, err := c.read(key, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch {
+	case v.compressed:
+		return decodeValue(v.data), v.version, nil
+	case v.shared:
+		return copyValue(v.data), v.version, nil
+	}
+	return v.data, v.version, nil
+}
+
+// maxPooledViewBuffer is the capacity above which a buffer View grew is
+// dropped instead of going back to viewBuffers, so that one large value does
+// not pin memory.
+const maxPooledViewBuffer = 256 << 10
+
+// viewBuffers holds buffers that View copies arena values into.
+var viewBuffers = sync.Pool{
+	New: func() any {
+		return new([]byte)
+	},
+}
+
+// View calls fn with the byte value stored at key and its version, like
+// GetWithVersion but without copying the value, and returns fn's error. It
+// returns ErrNotFound or ErrWrongType without calling fn if GetWithVersion
+// would.
+//
+// Stored values are never modified in place, a write replaces them, so fn
+// gets the stored value itself and no lock is held while it runs: it may take
+// its time, e.g. to write the value to a socket. fn must neither modify value
+// nor use it once it returns. Compressed values are decompressed first, and
+// values in the arena, whose records are overwritten as it wraps around, are
+// copied into a pooled buffer.
+func (c *Cache) View(key string, fn func(value []byte, version uint64) error) error {
+	buf := viewBuffers.Get().(*[]byte)
+	defer viewBuffers.Put(buf)
+
+	v, err := c.read(key, (*buf)[:0])
+	if err != nil {
+		return err
+	}
+	if v.compressed {
+		return fn(decodeValue(v.data), v.version)
+	}
+	if !v.shared && cap(v.data) <= maxPooledViewBuffer {
+		*buf = v.data // Keep the buffer if it grew
+	}
+	return fn(v.data, v.version)
+}
+
+// peekedValue is a byte value found by Shard.peek.
+type peekedValue struct {
+	data       []byte
+	version    uint64
+	compressed bool // data is compressed (see encodeValue)
+	shared     bool // data is the stored value rather than a copy
+}
+
+// read looks key up for GetWithVersion and View, counting the hit or miss
+// and recording the access. Values in the arena are appended to buf.
+func (c *Cache) read(key string, buf []byte) (peekedValue, error) {
 	shard := c.shards[c.getShardIndex(key)]
 
-	shard.mu.Lock()
-	entry, found := shard.items[key]
-	if found {
-		shard.lruList.MoveToFront(entry.listElement)
-		valueCopy := make([]byte, len(entry.value))
+	c.rlock(shard)
+	v, err := shard.peek(key, time.Now().UnixNano(), buf)
+	c.runlock(shard)
+	if err == errExpired {
+		c.lock(shard)
+		shard.dropExpired(key)
+		v, err = shard.peek(key, time.Now().UnixNano(), buf)
+		c.unlock(shard)
+	}
 
-		copy(valueCopy, entry.value)
-		shard.mu.Unlock()
-		return valueCopy, true
+	switch err {
+	case nil:
+		shard.stats.hits.Add(1)
+		shard.recordAccess(key)
+	case ErrWrongType:
+		shard.stats.hits.Add(1)
+	default:
+		shard.stats.misses.Add(1)
+		err = ErrNotFound
 	}
+	return v, err
+}
 
-	shard.mu.Unlock()
-	return nil, false
+// peek returns the byte value at key without changing anything. A value in
+// the map is returned as it is, as stored values are never modified in place;
+// a value in the arena is appended to buf, as its record may be overwritten
+// once s.mu is released. For a key that has expired but is still held it
+// returns errExpired. The caller must hold s.mu for reading.
+func (s *Shard) peek(key string, now int64, buf []byte) (peekedValue, error) {
+	if entry, found := s.items[key]; found {
+		switch {
+		case entry.expired(now):
+			return peekedValue{}, errExpired
+		case entry.obj != nil:
+			return peekedValue{}, ErrWrongType
+		}
+		return peekedValue{data: entry.value, version: entry.version, compressed: entry.compressed, shared: true}, nil
+	}
+	if s.arena == nil {
+		return peekedValue{}, ErrNotFound
+	}
+	rec, found := s.arena.find(key, s.hasher.Hash(key))
+	if !found {
+		return peekedValue{}, ErrNotFound
+	}
+	if rec.expired(now) {
+		return peekedValue{}, errExpired
+	}
+	return peekedValue{
+		data:       s.arena.appendValue(buf, rec),
+		version:    rec.version,
+		compressed: rec.flags&arenaCompressed != 0,
+	}, nil
+}
+
+// dropExpired removes key if it has expired. The caller must hold s.mu.
+func (s *Shard) dropExpired(key string) {
+	if _, found := s.lookupMap(key); !found && s.arena != nil {
+		s.arenaLookup(key)
+	}
 }
 
 // Set adds or updates a value in the cache.
 func (c *Cache) Set(key string, value []byte) {
+	c.SetVersioned(key, value)
+}
+
+// SetVersioned adds or updates a value in the cache and returns its new version.
+func (c *Cache) SetVersioned(key string, value []byte) uint64 {
 	shard := c.shards[c.getShardIndex(key)]
+	valueCopy, compressed := c.encodeSetValue(value)
 
-	shard.mu.Lock()
-	defer shard.mu.Unlock()
+	c.lock(shard)
+	defer c.unlock(shard)
 
-	valueCopy := make([]byte, len(value))
-	copy(valueCopy, value)
+	return shard.set(key, valueCopy, compressed)
+}
+
+// SetNX stores the value only if the key does not exist yet.
+// It reports whether the value was stored.
+func (c *Cache) SetNX(key string, value []byte) bool {
+	shard := c.shards[c.getShardIndex(key)]
+	valueCopy, compressed := c.encodeSetValue(value)
+
+	c.lock(shard)
+	defer c.unlock(shard)
 
-	if entry, found := shard.items[key]; found {
-		entry.value = valueCopy
-		shard.lruList.MoveToFront(entry.listElement)
-		return
+	if _, found := shard.currentVersion(key); found {
+		return false
 	}
+	shard.set(key, valueCopy, compressed)
+	return true
+}
+
+// SetXX stores the value only if the key already exists.
+// It reports whether the value was stored.
+func (c *Cache) SetXX(key string, value []byte) bool {
+	shard := c.shards[c.getShardIndex(key)]
+	valueCopy, compressed := c.encodeSetValue(value)
+
+	c.lock(shard)
+	defer c.unlock(shard)
 
-	listElement := shard.lruList.PushFront(key)
-	newEntry := &cacheEntry{
-		value:       valueCopy,
-		listElement: listElement,
+	if _, found := shard.currentVersion(key); !found {
+		return false
 	}
-	shard.items[key] = newEntry
+	shard.set(key, valueCopy, compressed)
+	return true
+}
 
-	if shard.maxItems > 0 && shard.lruList.Len() > shard.maxItems {
-		lruElement := shard.lruList.Back()
-		if lruElement != nil {
-			lruKey := lruElement.Value.(string)
-			shard.lruList.Remove(lruElement)
-			delete(shard.items, lruKey)
-		}
+// CompareAndSwap stores the value only if the entry's version still equals version.
+// It returns the new version on success, ErrNotFound if the key does not exist and
+// ErrVersionMismatch if the entry was modified since version was read.
+func (c *Cache) CompareAndSwap(key string, value []byte, version uint64) (uint64, error) {
+	shard := c.shards[c.getShardIndex(key)]
+	valueCopy, compressed := c.encodeSetValue(value)
+
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	current, found := shard.currentVersion(key)
+	if !found {
+		return 0, ErrNotFound
 	}
+	if current != version {
+		return 0, ErrVersionMismatch
+	}
+	return shard.set(key, valueCopy, compressed), nil
 }
 
 // Delete removes a value from the cache.
 func (c *Cache) Delete(key string) {
 	shard := c.shards[c.getShardIndex(key)]
 
-	shard.mu.Lock()
-	defer shard.mu.Unlock()
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	shard.delete(key)
+}
+
+// IncrBy atomically adds delta to the integer stored at key and returns the result.
+// A missing key is created with value delta; if ttl is positive the new key expires
+// after ttl. The TTL of an existing key is left unchanged. It returns ErrNotInteger
+// if the stored value is not a base-10 64-bit integer and ErrOverflow if the result
+// would not fit in an int64, leaving the value untouched in both cases.
+func (c *Cache) IncrBy(key string, delta int64, ttl time.Duration) (int64, error) {
+	shard := c.shards[c.getShardIndex(key)]
+
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	entry, found := shard.lookup(key)
+	if !found {
+		entry = shard.store(key, strconv.AppendInt(nil, delta, 10), nil)
+		if ttl > 0 {
+			entry.expiresAt = expiryAfter(ttl)
+		}
+		return delta, nil
+	}
+	if entry.obj != nil {
+		return 0, ErrWrongType
+	}
+
+	current, err := strconv.ParseInt(string(entry.plainValue()), 10, 64)
+	if err != nil {
+		return 0, ErrNotInteger
+	}
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		return 0, ErrOverflow
+	}
+	result := current + delta
+
+	entry.value, entry.compressed = strconv.AppendInt(nil, result, 10), false
+	shard.modified(entry)
+	return result, nil
+}
+
+// Expire makes key expire after ttl, or removes its TTL if ttl is not positive,
+// and reports whether the key exists. It changes the key's version but emits
+// no event, as the value is unchanged.
+func (c *Cache) Expire(key string, ttl time.Duration) bool {
+	shard := c.shards[c.getShardIndex(key)]
+
+	c.lock(shard)
+	defer c.unlock(shard)
 
-	if entry, found := shard.items[key]; found {
-		shard.lruList.Remove(entry.listElement)
-		delete(shard.items, key)
+	var expiresAt int64
+	if ttl > 0 {
+		expiresAt = expiryAfter(ttl)
 	}
+	entry, found := shard.lookupMap(key)
+	if !found && shard.arena != nil {
+		rec, found := shard.arenaLookup(key)
+		if found {
+			shard.version++
+			shard.arena.setExpiry(rec, shard.version, expiresAt)
+		}
+		return found
+	}
+	if !found {
+		return false
+	}
+	entry.expiresAt = expiresAt
+	shard.bumpVersion(entry)
+	return true
 }
 
 // Len returns the total number of items in the cache across all shards.
@@ -146,9 +462,238 @@ func (c *Cache) Delete(key string) {
 func (c *Cache) Len() int {
 	totalLen := 0
 	for _, shard := range c.shards {
-		shard.mu.RLock()
+		c.rlock(shard)
 		totalLen += shard.lruList.Len()
-		shard.mu.RUnlock()
+		if shard.arena != nil {
+			totalLen += len(shard.arena.index)
+		}
+		c.runlock(shard)
 	}
 	return totalLen
 }
+
+// Bytes returns the approximate memory accounted across all shards.
+// Like Len, it locks every shard and is meant for info/metrics only.
+func (c *Cache) Bytes() int {
+	total := 0
+	for _, shard := range c.shards {
+		c.rlock(shard)
+		total += shard.used
+		if shard.arena != nil {
+			total += shard.arena.live
+		}
+		c.runlock(shard)
+	}
+	return total
+}
+
+// set stores value, compressed or not, under key and returns the entry's new
+// version. The caller must hold s.mu and must not retain value, which it got
+// from encodeSetValue.
+func (s *Shard) set(key string, value []byte, compressed bool) uint64 {
+	if s.arena != nil {
+		if version, ok := s.arenaSet(key, value, compressed); ok {
+			return version
+		}
+		if !compressed {
+			value = copyValue(value) // Borrowed, see encodeSetValue
+		}
+	}
+	entry := s.put(key, value, nil)
+	entry.compressed = compressed
+	s.modified(entry)
+	return entry.version
+}
+
+// store replaces whatever is held at key with either a byte value or an object,
+// clearing any TTL and tags, and evicts least recently used entries if the shard is now
+// over capacity. The caller must hold s.mu.
+func (s *Shard) store(key string, value []byte, obj object) *cacheEntry {
+	entry := s.put(key, value, obj)
+	s.modified(entry)
+	return entry
+}
+
+// put is store without recording the write: the caller must follow up with
+// modified, or remove the entry again. The caller must hold s.mu.
+func (s *Shard) put(key string, value []byte, obj object) *cacheEntry {
+	entry, found := s.items[key]
+	if found {
+		entry.value = value
+		entry.compressed = false
+		entry.obj = obj
+		entry.expiresAt = 0 // A plain SET clears any TTL
+		s.untag(key, entry)
+	} else {
+		if s.arena != nil {
+			if rec, found := s.arena.find(key, s.hasher.Hash(key)); found {
+				s.arena.drop(rec)
+			}
+		}
+		entry = &cacheEntry{
+			value:       value,
+			obj:         obj,
+			listElement: s.lruList.PushFront(key),
+		}
+		s.items[key] = entry
+	}
+	return entry
+}
+
+// modified records a write to entry: it becomes the most recently used entry,
+// gets a new version and has its size re-accounted, which may trigger eviction.
+// The caller must hold s.mu.
+func (s *Shard) modified(entry *cacheEntry) {
+	s.lruList.MoveToFront(entry.listElement)
+	s.bumpVersion(entry)
+	s.emit(EventSet, entry.listElement.Value.(string))
+	s.resized(entry)
+}
+
+// resized re-accounts the size of entry, which may trigger eviction.
+// The caller must hold s.mu.
+func (s *Shard) resized(entry *cacheEntry) {
+	size := entryOverhead + len(entry.listElement.Value.(string)) + len(entry.value)
+	if entry.obj != nil {
+		size += entry.obj.size()
+	}
+	for _, tag := range entry.tags {
+		size += tagOverhead + len(tag)
+	}
+	s.used += size - entry.size
+	entry.size = size
+
+	s.evict()
+}
+
+// evict removes least recently used entries until the shard is within its item
+// and byte limits. The most recently used entry is always kept, so a single entry
+// larger than the byte limit can still be stored. The caller must hold s.mu.
+func (s *Shard) evict() {
+	for s.lruList.Len() > 1 &&
+		((s.maxItems > 0 && s.lruList.Len() > s.maxItems) || (s.maxBytes > 0 && s.used > s.maxBytes)) {
+		lruKey := s.lruList.Back().Value.(string)
+		s.remove(lruKey, s.items[lruKey], EventEvicted)
+	}
+}
+
+// lookup returns the live entry for key, dropping it first if it has expired.
+// A key held in the arena is moved to the map first. The caller must hold s.mu
+// for writing.
+func (s *Shard) lookup(key string) (*cacheEntry, bool) {
+	entry, found := s.lookupMap(key)
+	if !found && s.arena != nil {
+		return s.promote(key)
+	}
+	return entry, found
+}
+
+// lookupMap is lookup for keys held in the map only.
+// The caller must hold s.mu for writing.
+func (s *Shard) lookupMap(key string) (*cacheEntry, bool) {
+	entry, found := s.items[key]
+	if !found {
+		return nil, false
+	}
+	if entry.expired(time.Now().UnixNano()) {
+		s.remove(key, entry, EventExpired)
+		return nil, false
+	}
+	return entry, true
+}
+
+// remove unlinks an entry from the shard, counting and emitting the removal
+// as reason: EventDel, EventExpired or EventEvicted. The caller must hold s.mu.
+func (s *Shard) remove(key string, entry *cacheEntry, reason EventType) {
+	s.unlink(key, entry)
+
+	switch reason {
+	case EventExpired:
+		s.stats.expired++
+	case EventEvicted:
+		s.stats.evictions++
+	}
+	s.emit(reason, key)
+}
+
+// unlink removes an entry from the map without counting or emitting anything.
+// The caller must hold s.mu.
+func (s *Shard) unlink(key string, entry *cacheEntry) {
+	s.lruList.Remove(entry.listElement)
+	delete(s.items, key)
+	s.used -= entry.size
+	s.untag(key, entry)
+}
+
+// delete removes key, wherever it is held, and reports whether it existed.
+// The caller must hold s.mu.
+func (s *Shard) delete(key string) bool {
+	if entry, found := s.lookupMap(key); found {
+		s.remove(key, entry, EventDel)
+		return true
+	}
+	if s.arena == nil {
+		return false
+	}
+	rec, found := s.arenaLookup(key)
+	if found {
+		s.arenaRemove(rec, EventDel)
+	}
+	return found
+}
+
+// currentVersion returns the version of key and whether it exists, without
+// moving it out of the arena. The caller must hold s.mu for writing.
+func (s *Shard) currentVersion(key string) (uint64, bool) {
+	if entry, found := s.lookupMap(key); found {
+		return entry.version, true
+	}
+	if s.arena == nil {
+		return 0, false
+	}
+	rec, found := s.arenaLookup(key)
+	return rec.version, found
+}
+
+// bumpVersion assigns the entry a fresh version. Versions increase monotonically
+// per shard, so a key never sees the same version twice, even across deletes.
+func (s *Shard) bumpVersion(entry *cacheEntry) uint64 {
+	s.version++
+	entry.version = s.version
+	return entry.version
+}
+
+// copyValue returns a copy of value owned by the cache.
+func copyValue(value []byte) []byte {
+	valueCopy := make([]byte, len(value))
+	copy(valueCopy, value)
+	return valueCopy
+}
+
+// getObject returns the live entry at key together with its object of type T.
+// It returns ErrNotFound if the key is missing and ErrWrongType if it holds a
+// different kind of value. The caller must hold s.mu for writing.
+func getObject[T object](s *Shard, key string) (*cacheEntry, T, error) {
+	var zero T
+	entry, found := s.lookup(key)
+	if !found {
+		return nil, zero, ErrNotFound
+	}
+	obj, ok := entry.obj.(T)
+	if !ok {
+		return nil, zero, ErrWrongType
+	}
+	return entry, obj, nil
+}
+
+// getOrCreateObject is like getObject, but puts a fresh object from newObj in
+// place when the key is missing. The caller must hold s.mu and, as with put,
+// must call modified or remove once done with the entry.
+func getOrCreateObject[T object](s *Shard, key string, newObj func() T) (*cacheEntry, T, error) {
+	entry, obj, err := getObject[T](s, key)
+	if err == ErrNotFound {
+		obj = newObj()
+		return s.put(key, nil, obj), obj, nil
+	}
+	return entry, obj, err
+}
@@ -1,7 +1,17 @@
 package cache
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
 	"math/rand"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -164,3 +174,1587 @@ func BenchmarkCacheGetSetParallelMixed(b *testing.B) {
 		}
 	})
 }
+
+// BenchmarkCacheGetContended reads a few keys of a single shard from many
+// goroutines, so all of them contend for the same shard lock.
+func BenchmarkCacheGetContended(b *testing.B) {
+	c := NewWithConfig(Config{ShardCount: 1, MaxItemsPerShard: 0})
+	keys := make([]string, 64)
+	for i := range keys {
+		keys[i] = generateKey(16)
+		c.Set(keys[i], generateValue(128))
+	}
+	b.SetParallelism(8)
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		keyIndex := rand.Intn(len(keys))
+		for pb.Next() {
+			_, _ = c.Get(keys[keyIndex%len(keys)])
+			keyIndex++
+		}
+	})
+}
+
+var engines = []Engine{EngineMap, EngineArena}
+
+// BenchmarkEngineGC measures a full garbage collection with a cache of each
+// engine holding many keys. Every map entry is a set of pointers the collector
+// has to trace; the arena holds none.
+func BenchmarkEngineGC(b *testing.B) {
+	const numItems = 300000
+	value := generateValue(64)
+	for _, engine := range engines {
+		b.Run(engine.String(), func(b *testing.B) {
+			c := NewWithConfig(Config{ShardCount: 256, MaxItemsPerShard: 0, Engine: engine, ArenaBytesPerShard: 256 << 10})
+			for i := range numItems {
+				c.Set("key:"+strconv.Itoa(i), value)
+			}
+			runtime.GC()
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+
+			b.ResetTimer()
+			for range b.N {
+				runtime.GC()
+			}
+			b.StopTimer()
+
+			runtime.ReadMemStats(&after)
+			b.ReportMetric(float64(after.PauseTotalNs-before.PauseTotalNs)/float64(after.NumGC-before.NumGC), "pause-ns/gc")
+			b.ReportMetric(float64(after.HeapObjects), "heap-objects")
+			runtime.KeepAlive(c)
+		})
+	}
+}
+
+func BenchmarkEngineSet(b *testing.B) {
+	value := generateValue(128)
+	keys := make([]string, 10000)
+	for i := range keys {
+		keys[i] = generateKey(16)
+	}
+	for _, engine := range engines {
+		b.Run(engine.String(), func(b *testing.B) {
+			c := NewWithConfig(Config{ShardCount: 256, MaxItemsPerShard: 0, Engine: engine})
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := range b.N {
+				c.Set(keys[i%len(keys)], value)
+			}
+		})
+	}
+}
+
+func BenchmarkEngineGetHit(b *testing.B) {
+	value := generateValue(128)
+	keys := make([]string, 10000)
+	for i := range keys {
+		keys[i] = generateKey(16)
+	}
+	for _, engine := range engines {
+		b.Run(engine.String(), func(b *testing.B) {
+			c := NewWithConfig(Config{ShardCount: 256, MaxItemsPerShard: 0, Engine: engine})
+			for _, key := range keys {
+				c.Set(key, value)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := range b.N {
+				c.Get(keys[i%len(keys)])
+			}
+		})
+	}
+}
+
+var hashers = []struct {
+	name   string
+	hasher Hasher
+}{
+	{"fnv1a", FNV1a{}},
+	{"maphash", NewMapHasher()},
+}
+
+func BenchmarkHasher(b *testing.B) {
+	for _, h := range hashers {
+		for _, keyLen := range []int{16, 128} {
+			key := generateKey(keyLen)
+			b.Run(fmt.Sprintf("%s/%d", h.name, keyLen), func(b *testing.B) {
+				b.ReportAllocs()
+				for range b.N {
+					h.hasher.Hash(key)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkHotPathAllocs reports the allocations of Get and Set with each
+// hasher. A Get allocates only the copy of the value it returns, and a Set
+// overwriting a key only the copy of the value it stores.
+func BenchmarkHotPathAllocs(b *testing.B) {
+	value := generateValue(128)
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = generateKey(16)
+	}
+	for _, h := range hashers {
+		c := NewWithConfig(Config{ShardCount: 256, MaxItemsPerShard: 0, Hasher: h.hasher})
+		for _, key := range keys {
+			c.Set(key, value)
+		}
+		b.Run(h.name+"/get", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := range b.N {
+				c.Get(keys[i%len(keys)])
+			}
+		})
+		b.Run(h.name+"/set", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := range b.N {
+				c.Set(keys[i%len(keys)], value)
+			}
+		})
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	c := New()
+
+	if _, err := c.CompareAndSwap("missing", []byte("v"), 1); err != ErrNotFound {
+		t.Fatalf("CompareAndSwap on missing key: got %v, want ErrNotFound", err)
+	}
+
+	c.Set("k", []byte("v1"))
+	value, version, err := c.GetWithVersion("k")
+	if err != nil || string(value) != "v1" {
+		t.Fatalf("GetWithVersion: got %q, %v", value, err)
+	}
+
+	newVersion, err := c.CompareAndSwap("k", []byte("v2"), version)
+	if err != nil {
+		t.Fatalf("CompareAndSwap with current version: %v", err)
+	}
+	if newVersion <= version {
+		t.Fatalf("version did not increase: %d -> %d", version, newVersion)
+	}
+
+	// The old token is stale now.
+	if _, err := c.CompareAndSwap("k", []byte("v3"), version); err != ErrVersionMismatch {
+		t.Fatalf("CompareAndSwap with stale version: got %v, want ErrVersionMismatch", err)
+	}
+	if value, _ := c.Get("k"); string(value) != "v2" {
+		t.Fatalf("value after failed CAS: got %q, want v2", value)
+	}
+
+	// A delete followed by a re-create must not reuse the old version.
+	c.Delete("k")
+	c.Set("k", []byte("v4"))
+	if _, err := c.CompareAndSwap("k", []byte("v5"), newVersion); err != ErrVersionMismatch {
+		t.Fatalf("CompareAndSwap across re-create: got %v, want ErrVersionMismatch", err)
+	}
+}
+
+func TestSetNXSetXX(t *testing.T) {
+	c := New()
+
+	if c.SetXX("k", []byte("v")) {
+		t.Fatal("SetXX stored a missing key")
+	}
+	if !c.SetNX("k", []byte("v1")) {
+		t.Fatal("SetNX did not store a missing key")
+	}
+	if c.SetNX("k", []byte("v2")) {
+		t.Fatal("SetNX overwrote an existing key")
+	}
+	if !c.SetXX("k", []byte("v3")) {
+		t.Fatal("SetXX did not replace an existing key")
+	}
+	if value, _ := c.Get("k"); string(value) != "v3" {
+		t.Fatalf("got %q, want v3", value)
+	}
+}
+
+func TestIncrBy(t *testing.T) {
+	c := New()
+
+	if v, err := c.IncrBy("n", 5, 0); err != nil || v != 5 {
+		t.Fatalf("IncrBy on missing key: got %d, %v", v, err)
+	}
+	if v, err := c.IncrBy("n", -7, 0); err != nil || v != -2 {
+		t.Fatalf("IncrBy: got %d, %v", v, err)
+	}
+	if value, _ := c.Get("n"); string(value) != "-2" {
+		t.Fatalf("stored value: got %q, want -2", value)
+	}
+
+	c.Set("s", []byte("abc"))
+	if _, err := c.IncrBy("s", 1, 0); err != ErrNotInteger {
+		t.Fatalf("IncrBy on non-numeric value: got %v, want ErrNotInteger", err)
+	}
+
+	c.IncrBy("max", math.MaxInt64, 0)
+	if _, err := c.IncrBy("max", 1, 0); err != ErrOverflow {
+		t.Fatalf("IncrBy past MaxInt64: got %v, want ErrOverflow", err)
+	}
+	c.IncrBy("min", math.MinInt64, 0)
+	if _, err := c.IncrBy("min", -1, 0); err != ErrOverflow {
+		t.Fatalf("IncrBy past MinInt64: got %v, want ErrOverflow", err)
+	}
+}
+
+func TestIncrByTTL(t *testing.T) {
+	c := New()
+
+	if _, err := c.IncrBy("window", 1, 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	// The TTL is only applied on creation, so further increments keep the deadline.
+	if v, _ := c.IncrBy("window", 1, time.Hour); v != 2 {
+		t.Fatalf("got %d, want 2", v)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, found := c.Get("window"); found {
+		t.Fatal("counter did not expire")
+	}
+	if v, _ := c.IncrBy("window", 1, 0); v != 1 {
+		t.Fatalf("IncrBy after expiry: got %d, want 1", v)
+	}
+
+	// A TTL reaching past the year 2262 does not wrap around to the past.
+	if _, err := c.IncrBy("centuries", 1, 250*365*24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := c.Get("centuries"); !found {
+		t.Fatal("counter with a 250-year TTL expired at once")
+	}
+}
+
+func TestHash(t *testing.T) {
+	c := New()
+
+	added, err := c.HSet("user:1", FieldValue{"name", []byte("ada")}, FieldValue{"lang", []byte("go")})
+	if err != nil || added != 2 {
+		t.Fatalf("HSet: got %d, %v", added, err)
+	}
+	if added, _ := c.HSet("user:1", FieldValue{"name", []byte("grace")}); added != 0 {
+		t.Fatalf("HSet on existing field reported %d new fields", added)
+	}
+	if value, err := c.HGet("user:1", "name"); err != nil || string(value) != "grace" {
+		t.Fatalf("HGet: got %q, %v", value, err)
+	}
+	if _, err := c.HGet("user:1", "missing"); err != ErrNotFound {
+		t.Fatalf("HGet on missing field: got %v, want ErrNotFound", err)
+	}
+	if n, _ := c.HLen("user:1"); n != 2 {
+		t.Fatalf("HLen: got %d, want 2", n)
+	}
+	if fields, _ := c.HGetAll("user:1"); len(fields) != 2 {
+		t.Fatalf("HGetAll: got %d fields, want 2", len(fields))
+	}
+
+	// Removing the last field removes the key.
+	if removed, _ := c.HDel("user:1", "name", "lang", "missing"); removed != 2 {
+		t.Fatalf("HDel: got %d, want 2", removed)
+	}
+	if c.Len() != 0 || c.Bytes() != 0 {
+		t.Fatalf("empty hash left behind: len=%d bytes=%d", c.Len(), c.Bytes())
+	}
+}
+
+func TestWrongType(t *testing.T) {
+	c := New()
+	c.Set("str", []byte("1"))
+	c.HSet("hash", FieldValue{"f", []byte("v")})
+
+	if _, err := c.HGet("str", "f"); err != ErrWrongType {
+		t.Fatalf("HGet on string: got %v, want ErrWrongType", err)
+	}
+	if _, _, err := c.GetWithVersion("hash"); err != ErrWrongType {
+		t.Fatalf("GetWithVersion on hash: got %v, want ErrWrongType", err)
+	}
+	if _, err := c.IncrBy("hash", 1, 0); err != ErrWrongType {
+		t.Fatalf("IncrBy on hash: got %v, want ErrWrongType", err)
+	}
+
+	// SET replaces a value of any type.
+	c.Set("hash", []byte("v"))
+	if value, found := c.Get("hash"); !found || string(value) != "v" {
+		t.Fatalf("Get after overwriting hash: got %q, %v", value, found)
+	}
+}
+
+func TestMaxBytesEviction(t *testing.T) {
+	c := NewWithConfig(Config{ShardCount: 1, MaxBytesPerShard: 4096})
+
+	c.Set("old", generateValue(1024))
+	// Growing a hash counts against the same limit as plain values.
+	for i := 0; i < 16; i++ {
+		c.HSet("big", FieldValue{Field: generateKey(8), Value: generateValue(256)})
+	}
+	if _, found := c.Get("old"); found {
+		t.Fatal("least recently used key survived exceeding the byte limit")
+	}
+	if n, _ := c.HLen("big"); n != 16 {
+		t.Fatalf("most recently used hash was evicted: HLen=%d", n)
+	}
+
+	c.Delete("big")
+	if c.Bytes() != 0 {
+		t.Fatalf("Bytes after deleting everything: got %d, want 0", c.Bytes())
+	}
+}
+
+func TestList(t *testing.T) {
+	c := New()
+
+	if n, err := c.RPush("q", []byte("b"), []byte("c")); err != nil || n != 2 {
+		t.Fatalf("RPush: got %d, %v", n, err)
+	}
+	if n, _ := c.LPush("q", []byte("a")); n != 3 {
+		t.Fatalf("LPush: got %d, want 3", n)
+	}
+	values, err := c.LRange("q", 0, -1)
+	if err != nil || len(values) != 3 || string(values[0]) != "a" || string(values[2]) != "c" {
+		t.Fatalf("LRange: got %q, %v", values, err)
+	}
+	if values, _ := c.LRange("q", -2, 100); len(values) != 2 || string(values[0]) != "b" {
+		t.Fatalf("LRange with negative start: got %q", values)
+	}
+	if value, _ := c.RPop("q"); string(value) != "c" {
+		t.Fatalf("RPop: got %q, want c", value)
+	}
+	c.LPop("q")
+	c.LPop("q")
+	if _, err := c.LPop("q"); err != ErrNotFound {
+		t.Fatalf("LPop on drained list: got %v, want ErrNotFound", err)
+	}
+	if c.Len() != 0 {
+		t.Fatal("empty list left behind")
+	}
+
+	// Grow well past the initial ring buffer and drain from both ends.
+	for i := 0; i < 1000; i++ {
+		c.RPush("big", []byte{byte(i)})
+	}
+	for i := 0; i < 500; i++ {
+		if value, _ := c.LPop("big"); value[0] != byte(i) {
+			t.Fatalf("LPop %d: got %d", i, value[0])
+		}
+	}
+	if n, _ := c.LLen("big"); n != 500 {
+		t.Fatalf("LLen: got %d, want 500", n)
+	}
+}
+
+func TestBLPop(t *testing.T) {
+	c := New()
+
+	// Data already present is popped without blocking, in key order.
+	c.RPush("b", []byte("x"))
+	key, value, err := c.BLPop(context.Background(), "a", "b")
+	if err != nil || key != "b" || string(value) != "x" {
+		t.Fatalf("BLPop on ready list: got %q %q %v", key, value, err)
+	}
+
+	// A push wakes a blocked caller and the element is handed over directly.
+	done := make(chan string)
+	go func() {
+		key, value, err := c.BLPop(context.Background(), "a", "b")
+		if err != nil {
+			done <- err.Error()
+			return
+		}
+		done <- key + "=" + string(value)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if n, _ := c.RPush("a", []byte("y")); n != 1 {
+		t.Fatalf("RPush: got length %d, want 1", n)
+	}
+	if got := <-done; got != "a=y" {
+		t.Fatalf("blocked BLPop: got %s, want a=y", got)
+	}
+	if n, _ := c.LLen("a"); n != 0 {
+		t.Fatalf("handed-over element still in list: LLen=%d", n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, _, err := c.BLPop(ctx, "a"); err != context.DeadlineExceeded {
+		t.Fatalf("BLPop timeout: got %v, want DeadlineExceeded", err)
+	}
+	// Timed-out waiters must not swallow later pushes.
+	c.RPush("a", []byte("z"))
+	if n, _ := c.LLen("a"); n != 1 {
+		t.Fatalf("push after timeout: LLen=%d, want 1", n)
+	}
+}
+
+func TestSortedSet(t *testing.T) {
+	c := New()
+
+	added, err := c.ZAdd("board", ScoredMember{"alice", 30}, ScoredMember{"bob", 10}, ScoredMember{"carol", 20})
+	if err != nil || added != 3 {
+		t.Fatalf("ZAdd: got %d, %v", added, err)
+	}
+	// Updating a score moves the member.
+	if added, _ := c.ZAdd("board", ScoredMember{"bob", 40}); added != 0 {
+		t.Fatalf("ZAdd update reported %d new members", added)
+	}
+
+	members, _ := c.ZRange("board", 0, -1, false)
+	if got := memberNames(members); got != "carol,alice,bob" {
+		t.Fatalf("ZRange: got %s", got)
+	}
+	members, _ = c.ZRange("board", 0, 1, true)
+	if got := memberNames(members); got != "bob,alice" {
+		t.Fatalf("ZRange reverse: got %s", got)
+	}
+	members, _ = c.ZRangeByScore("board", 20, 30, false)
+	if got := memberNames(members); got != "carol,alice" {
+		t.Fatalf("ZRangeByScore: got %s", got)
+	}
+	members, _ = c.ZRangeByScore("board", math.Inf(-1), math.Inf(1), true)
+	if got := memberNames(members); got != "bob,alice,carol" {
+		t.Fatalf("ZRangeByScore reverse: got %s", got)
+	}
+	if rank, _ := c.ZRank("board", "alice", false); rank != 1 {
+		t.Fatalf("ZRank: got %d, want 1", rank)
+	}
+	if rank, _ := c.ZRank("board", "carol", true); rank != 2 {
+		t.Fatalf("ZRank reverse: got %d, want 2", rank)
+	}
+	if score, _ := c.ZScore("board", "bob"); score != 40 {
+		t.Fatalf("ZScore: got %v, want 40", score)
+	}
+	if _, err := c.ZAdd("board", ScoredMember{"nan", math.NaN()}); err != ErrInvalidScore {
+		t.Fatalf("ZAdd with NaN: got %v, want ErrInvalidScore", err)
+	}
+	if removed, _ := c.ZRem("board", "alice", "bob", "carol"); removed != 3 || c.Len() != 0 {
+		t.Fatalf("ZRem: removed %d, %d keys left", removed, c.Len())
+	}
+}
+
+// TestSortedSetRandomized checks ranks and ranges against a sorted slice after
+// random inserts, updates and removals.
+func TestSortedSetRandomized(t *testing.T) {
+	c := New()
+	r := rand.New(rand.NewSource(1))
+	scores := make(map[string]float64)
+
+	for i := 0; i < 5000; i++ {
+		member := generateKey(3)
+		if r.Intn(4) == 0 {
+			c.ZRem("z", member)
+			delete(scores, member)
+			continue
+		}
+		score := float64(r.Intn(100))
+		c.ZAdd("z", ScoredMember{member, score})
+		scores[member] = score
+	}
+
+	expected := make([]ScoredMember, 0, len(scores))
+	for member, score := range scores {
+		expected = append(expected, ScoredMember{member, score})
+	}
+	sort.Slice(expected, func(i, j int) bool {
+		if expected[i].Score != expected[j].Score {
+			return expected[i].Score < expected[j].Score
+		}
+		return expected[i].Member < expected[j].Member
+	})
+
+	got, _ := c.ZRange("z", 0, -1, false)
+	if len(got) != len(expected) {
+		t.Fatalf("ZRange returned %d members, want %d", len(got), len(expected))
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("ZRange[%d]: got %v, want %v", i, got[i], expected[i])
+		}
+		if rank, _ := c.ZRank("z", expected[i].Member, false); rank != i {
+			t.Fatalf("ZRank(%s): got %d, want %d", expected[i].Member, rank, i)
+		}
+	}
+	if window, _ := c.ZRange("z", 10, 19, false); len(window) != 10 || window[0] != expected[10] {
+		t.Fatalf("ZRange window: got %v", window)
+	}
+}
+
+func TestSet(t *testing.T) {
+	c := New()
+
+	if added, err := c.SAdd("s", "a", "b", "a", "c"); err != nil || added != 3 {
+		t.Fatalf("SAdd: got %d, %v", added, err)
+	}
+	if found, _ := c.SIsMember("s", "b"); !found {
+		t.Fatal("SIsMember(b) = false, want true")
+	}
+	if n, _ := c.SCard("s"); n != 3 {
+		t.Fatalf("SCard: got %d, want 3", n)
+	}
+	members, _ := c.SMembers("s")
+	if got := sortedJoin(members); got != "a,b,c" {
+		t.Fatalf("SMembers: got %s", got)
+	}
+
+	if picked, _ := c.SRandMember("s", 2); len(picked) != 2 || picked[0] == picked[1] {
+		t.Fatalf("SRandMember(2): got %v, want 2 distinct members", picked)
+	}
+	if picked, _ := c.SRandMember("s", 10); len(picked) != 3 {
+		t.Fatalf("SRandMember(10): got %v, want the whole set", picked)
+	}
+	if picked, _ := c.SRandMember("s", -10); len(picked) != 10 {
+		t.Fatalf("SRandMember(-10): got %d members, want 10", len(picked))
+	}
+
+	c.Set("str", []byte("x"))
+	if _, err := c.SAdd("str", "a"); err != ErrWrongType {
+		t.Fatalf("SAdd on string: got %v, want ErrWrongType", err)
+	}
+	if removed, _ := c.SRem("s", "a", "b", "c", "d"); removed != 3 {
+		t.Fatalf("SRem: got %d, want 3", removed)
+	}
+	if _, found := c.Get("s"); found {
+		t.Fatal("empty set was not deleted")
+	}
+}
+
+func TestSetAlgebra(t *testing.T) {
+	c := New()
+	c.SAdd("x", "a", "b", "c", "d")
+	c.SAdd("y", "b", "c", "e")
+	c.SAdd("z", "c", "f")
+
+	inter, _ := c.SInter("x", "y", "z")
+	if got := sortedJoin(inter); got != "c" {
+		t.Fatalf("SInter: got %s", got)
+	}
+	if inter, _ := c.SInter("x", "missing"); len(inter) != 0 {
+		t.Fatalf("SInter with missing key: got %v", inter)
+	}
+	union, _ := c.SUnion("x", "y", "missing")
+	if got := sortedJoin(union); got != "a,b,c,d,e" {
+		t.Fatalf("SUnion: got %s", got)
+	}
+	diff, _ := c.SDiff("x", "y", "z")
+	if got := sortedJoin(diff); got != "a,d" {
+		t.Fatalf("SDiff: got %s", got)
+	}
+	if inter, _ := c.SInter("x", "x"); len(inter) != 4 {
+		t.Fatalf("SInter of a key with itself: got %v", inter)
+	}
+	c.Set("str", []byte("v"))
+	if _, err := c.SUnion("x", "str"); err != ErrWrongType {
+		t.Fatalf("SUnion with a string key: got %v, want ErrWrongType", err)
+	}
+
+	// Operations naming the same keys in opposite orders must not deadlock.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				if i%2 == 0 {
+					c.SInter("x", "y", "z")
+				} else {
+					c.SUnion("z", "y", "x")
+				}
+				c.SAdd("y", generateKey(2))
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// TestScan checks that keys present for the whole scan are all returned while
+// other keys churn, and that MATCH filters on the glob pattern.
+func TestScan(t *testing.T) {
+	c := NewWithConfig(Config{ShardCount: 8, MaxItemsPerShard: 0})
+	for i := 0; i < 1000; i++ {
+		c.Set(fmt.Sprintf("stable:%d", i), []byte("v"))
+	}
+
+	seen := make(map[string]bool)
+	var cursor uint64
+	for i := 0; ; i++ {
+		keys, next := c.Scan(cursor, "", 7)
+		for _, key := range keys {
+			seen[key] = true
+		}
+		// Churn between steps: add new keys and remove some of them again.
+		c.Set(fmt.Sprintf("churn:%d", i), []byte("v"))
+		if i%2 == 0 {
+			c.Delete(fmt.Sprintf("churn:%d", i/2))
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	for i := 0; i < 1000; i++ {
+		if key := fmt.Sprintf("stable:%d", i); !seen[key] {
+			t.Fatalf("Scan missed %s", key)
+		}
+	}
+
+	matched := 0
+	cursor = 0
+	for {
+		keys, next := c.Scan(cursor, "stable:1?", 100)
+		for _, key := range keys {
+			if !strings.HasPrefix(key, "stable:1") || len(key) != len("stable:1x") {
+				t.Fatalf("Scan MATCH returned %s", key)
+			}
+			matched++
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	if matched != 10 {
+		t.Fatalf("Scan MATCH returned %d keys, want 10", matched)
+	}
+}
+
+// scanTieHasher gives keys only a few distinct scan hashes, the high 32 bits,
+// so that Scan batches keep ending inside a run of equal hashes.
+type scanTieHasher struct{}
+
+func (scanTieHasher) Hash(key string) uint64 {
+	h := FNV1a{}.Hash(key)
+	return (h>>32)%3<<32 | h&0xffffffff
+}
+
+func TestScanBounded(t *testing.T) {
+	for _, engine := range []Engine{EngineMap, EngineArena} {
+		for _, hasher := range []Hasher{FNV1a{}, scanTieHasher{}} {
+			c := NewWithConfig(Config{ShardCount: 2, MaxItemsPerShard: 0, Engine: engine, Hasher: hasher})
+			for i := 0; i < 500; i++ {
+				c.Set(fmt.Sprintf("key:%d", i), []byte("v"))
+			}
+			c.HSet("key:hash", FieldValue{"f", []byte("v")}) // Always in the map
+
+			for _, count := range []int{1, 3, 50, 1000} {
+				seen := make(map[string]int)
+				var cursor uint64
+				for steps := 0; ; steps++ {
+					if steps > 2000 {
+						t.Fatalf("%s engine, count %d: Scan does not finish", engine, count)
+					}
+					keys, next := c.Scan(cursor, "", count)
+					for _, key := range keys {
+						seen[key]++
+					}
+					if next == 0 {
+						break
+					}
+					cursor = next
+				}
+				if len(seen) != 501 {
+					t.Fatalf("%s engine, count %d: Scan returned %d distinct keys, want 501", engine, count, len(seen))
+				}
+				for key, n := range seen {
+					if n != 1 {
+						t.Fatalf("%s engine, count %d: Scan returned %s %d times", engine, count, key, n)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestDeletePatternAndFlushAll(t *testing.T) {
+	c := NewWithConfig(Config{ShardCount: 4})
+	for i := 0; i < 1000; i++ {
+		c.Set(fmt.Sprintf("user:v3:%d", i), []byte("v"))
+		c.Set(fmt.Sprintf("user:v4:%d", i), []byte("v"))
+	}
+	c.HSet("user:v3:hash", FieldValue{"f", []byte("v")})
+
+	removed, err := c.DeletePattern(context.Background(), "user:v3:*")
+	if err != nil || removed != 1001 {
+		t.Fatalf("DeletePattern: removed %d, %v; want 1001", removed, err)
+	}
+	if c.Len() != 1000 {
+		t.Fatalf("DeletePattern left %d keys, want 1000", c.Len())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.DeletePattern(ctx, "*"); err != context.Canceled {
+		t.Fatalf("DeletePattern with cancelled context: got %v", err)
+	}
+
+	if removed := c.FlushAll(); removed != 1000 {
+		t.Fatalf("FlushAll: removed %d, want 1000", removed)
+	}
+	if c.Len() != 0 || c.Bytes() != 0 {
+		t.Fatalf("FlushAll left %d keys and %d bytes", c.Len(), c.Bytes())
+	}
+	c.Set("after", []byte("v"))
+	if _, found := c.Get("after"); !found {
+		t.Fatal("cache unusable after FlushAll")
+	}
+}
+
+func TestTags(t *testing.T) {
+	c := NewWithConfig(Config{ShardCount: 4})
+	c.SetWithTags("fragment:1", []byte("a"), "product:42", "user:9")
+	c.SetWithTags("fragment:2", []byte("b"), "product:42")
+	c.SetWithTags("fragment:3", []byte("c"), "user:9")
+	c.SetWithTags("fragment:4", []byte("d"), "product:42")
+	c.Set("fragment:4", []byte("d2")) // Overwriting without tags detaches them
+
+	if removed := c.InvalidateTag("product:42"); removed != 2 {
+		t.Fatalf("InvalidateTag: removed %d, want 2", removed)
+	}
+	for _, key := range []string{"fragment:1", "fragment:2"} {
+		if _, found := c.Get(key); found {
+			t.Fatalf("%s survived invalidation", key)
+		}
+	}
+	for _, key := range []string{"fragment:3", "fragment:4"} {
+		if _, found := c.Get(key); !found {
+			t.Fatalf("%s was wrongly invalidated", key)
+		}
+	}
+	if removed := c.InvalidateTag("product:42"); removed != 0 {
+		t.Fatalf("second InvalidateTag removed %d", removed)
+	}
+
+	// Evicted and deleted entries must leave the tag index.
+	small := NewWithConfig(Config{ShardCount: 1, MaxItemsPerShard: 10})
+	for i := 0; i < 100; i++ {
+		small.SetWithTags(fmt.Sprintf("k%d", i), []byte("v"), "t", fmt.Sprintf("only:%d", i))
+	}
+	small.Delete("k99")
+	shard := small.shards[0]
+	if n := len(shard.tags["t"]); n != 9 {
+		t.Fatalf("tag index holds %d keys after eviction and delete, want 9", n)
+	}
+	if n := len(shard.tags); n != 10 {
+		t.Fatalf("tag index holds %d tags, want 10", n)
+	}
+	if removed := small.InvalidateTag("t"); removed != 9 || small.Len() != 0 || small.Bytes() != 0 {
+		t.Fatalf("InvalidateTag: removed %d, %d keys and %d bytes left", removed, small.Len(), small.Bytes())
+	}
+}
+
+func TestStats(t *testing.T) {
+	c := NewWithConfig(Config{ShardCount: 1, MaxItemsPerShard: 2})
+	c.Set("a", []byte("1"))
+	c.Set("b", []byte("2"))
+	c.Set("c", []byte("3")) // Evicts a
+	c.Get("b")
+	c.Get("a")
+	c.IncrBy("ttl", 1, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	c.Get("ttl")
+
+	stats := c.Stats()
+	want := Stats{Keys: 1, Bytes: c.Bytes(), Hits: 1, Misses: 2, Evictions: 2, Expired: 1}
+	if stats != want {
+		t.Fatalf("Stats: got %+v, want %+v", stats, want)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	c := NewWithConfig(Config{ShardCount: 1, MaxItemsPerShard: 2})
+	var events []string
+	c.Notify(EventAll, func(e Event) {
+		events = append(events, e.Type.String()+" "+e.Key)
+	})
+
+	c.Set("a", []byte("1"))
+	c.Set("b", []byte("2"))
+	c.Set("c", []byte("3")) // Evicts a
+	c.Delete("b")
+	c.Delete("b") // No event for a missing key
+	c.RPush("list", []byte("x"))
+	c.LPop("list") // Emptying the list deletes the key
+	c.IncrBy("ttl", 1, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	c.Get("ttl")
+
+	want := "set a,set b,set c,evicted a,del b,set list,del list,set ttl,expired ttl"
+	if got := strings.Join(events, ","); got != want {
+		t.Fatalf("events:\n got %s\nwant %s", got, want)
+	}
+
+	// Only the requested types are reported, and a nil hook stops them.
+	events = nil
+	c.Notify(EventDel, func(e Event) {
+		events = append(events, e.Type.String()+" "+e.Key)
+	})
+	c.Set("d", []byte("4"))
+	c.Delete("d")
+	c.Notify(0, nil)
+	c.Set("e", []byte("5"))
+	c.Delete("e")
+	if got := strings.Join(events, ","); got != "del d" {
+		t.Fatalf("filtered events: got %s", got)
+	}
+
+	if types, err := ParseEventTypes("set, expired"); err != nil || types != EventSet|EventExpired {
+		t.Fatalf("ParseEventTypes: got %v, %v", types, err)
+	}
+	if types, err := ParseEventTypes("all"); err != nil || types != EventAll {
+		t.Fatalf("ParseEventTypes(all): got %v, %v", types, err)
+	}
+	if _, err := ParseEventTypes("set,bogus"); err == nil {
+		t.Fatal("ParseEventTypes(bogus): expected error")
+	}
+}
+
+func TestAtomically(t *testing.T) {
+	c := NewWithConfig(Config{ShardCount: 16})
+	keys := []string{"account:a", "account:b", "account:c"}
+	for _, key := range keys {
+		c.Set(key, []byte("100"))
+	}
+
+	// Concurrent transfers never let a transactional reader see money in flight.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			from, to := keys[i%3], keys[(i+1)%3]
+			for j := 0; j < 200; j++ {
+				c.Atomically([]string{from, to}, func(tx *Cache) {
+					tx.IncrBy(from, -1, 0)
+					tx.IncrBy(to, 1, 0)
+				})
+			}
+		}(i)
+	}
+	for j := 0; j < 200; j++ {
+		c.Atomically(keys, func(tx *Cache) {
+			total := 0
+			for _, key := range keys {
+				value, _ := tx.Get(key)
+				n, _ := strconv.Atoi(string(value))
+				total += n
+			}
+			if total != 300 {
+				t.Errorf("total: got %d, want 300", total)
+			}
+		})
+	}
+	wg.Wait()
+
+	var seen int
+	c.AtomicallyAll(func(tx *Cache) {
+		seen = tx.Len()
+	})
+	if seen != 3 {
+		t.Fatalf("Len in AtomicallyAll: got %d, want 3", seen)
+	}
+
+	var undeclared any
+	c.Atomically([]string{"account:a"}, func(tx *Cache) {
+		defer func() { undeclared = recover() }()
+		for i := 0; i < 64; i++ {
+			tx.Get(fmt.Sprintf("other:%d", i)) // Some land on other shards
+		}
+	})
+	if undeclared == nil {
+		t.Fatal("touching undeclared keys did not panic")
+	}
+}
+
+func TestVersion(t *testing.T) {
+	c := New()
+	if v := c.Version("k"); v != 0 {
+		t.Fatalf("Version of missing key: got %d", v)
+	}
+	c.Set("k", []byte("v"))
+	v1 := c.Version("k")
+	if v1 == 0 {
+		t.Fatal("Version after Set: got 0")
+	}
+	if v := c.Version("k"); v != v1 {
+		t.Fatalf("Version changed without a write: %d != %d", v, v1)
+	}
+	c.Set("k", []byte("w"))
+	if v := c.Version("k"); v == v1 {
+		t.Fatal("Version unchanged after Set")
+	}
+	c.Delete("k")
+	if v := c.Version("k"); v != 0 {
+		t.Fatalf("Version after Delete: got %d", v)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	c := New()
+	if c.Expire("missing", time.Second) {
+		t.Fatal("Expire of missing key: got true")
+	}
+	c.Set("k", []byte("v"))
+	v1 := c.Version("k")
+	if !c.Expire("k", 20*time.Millisecond) {
+		t.Fatal("Expire of existing key: got false")
+	}
+	if v := c.Version("k"); v == v1 {
+		t.Fatal("Version unchanged after Expire")
+	}
+	c.Set("p", []byte("v"))
+	c.Expire("p", 20*time.Millisecond)
+	c.Expire("p", 0) // Removes the TTL again
+	time.Sleep(30 * time.Millisecond)
+	if _, found := c.Get("k"); found {
+		t.Error("key with TTL still present after expiry")
+	}
+	if _, found := c.Get("p"); !found {
+		t.Error("key whose TTL was removed expired")
+	}
+
+	// A TTL reaching past the year 2262 does not wrap around to the past.
+	for _, c := range []*Cache{c, NewWithConfig(Config{Engine: EngineArena})} {
+		c.Set("centuries", []byte("v"))
+		c.Expire("centuries", 250*365*24*time.Hour)
+		if _, found := c.Get("centuries"); !found {
+			t.Errorf("key with a 250-year TTL expired at once")
+		}
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	c := New()
+	window := 100 * time.Millisecond
+
+	// A full burst is allowed, then requests are denied until quota returns.
+	for i := 0; i < 5; i++ {
+		r, err := c.Throttle("rl", 5, window, 1)
+		if err != nil || !r.Allowed || r.Remaining != 4-i {
+			t.Fatalf("request %d: got %+v, %v", i, r, err)
+		}
+	}
+	r, err := c.Throttle("rl", 5, window, 1)
+	if err != nil || r.Allowed || r.Remaining != 0 || r.RetryAfter <= 0 || r.RetryAfter > window/5 {
+		t.Fatalf("request over the limit: got %+v, %v", r, err)
+	}
+	time.Sleep(r.RetryAfter)
+	if r, _ := c.Throttle("rl", 5, window, 1); !r.Allowed {
+		t.Fatalf("request after RetryAfter: got %+v", r)
+	}
+
+	// Denied costly requests leave the quota untouched.
+	if r, _ := c.Throttle("batch", 5, window, 3); !r.Allowed || r.Remaining != 2 {
+		t.Fatalf("batch of 3: got %+v", r)
+	}
+	if r, _ := c.Throttle("batch", 5, window, 3); r.Allowed || r.Remaining != 2 {
+		t.Fatalf("second batch of 3: got %+v", r)
+	}
+	if r, _ := c.Throttle("batch", 5, window, 2); !r.Allowed || r.Remaining != 0 {
+		t.Fatalf("batch of 2: got %+v", r)
+	}
+
+	// The state expires once the quota is full again.
+	time.Sleep(window + 10*time.Millisecond)
+	if c.Version("batch") != 0 {
+		t.Error("throttle state still present after its quota was restored")
+	}
+
+	if _, err := c.Throttle("rl", 5, window, 6); err != ErrInvalidThrottle {
+		t.Errorf("cost above limit: got %v", err)
+	}
+	c.Set("plain", []byte("v"))
+	if _, err := c.Throttle("plain", 5, window, 1); err != ErrWrongType {
+		t.Errorf("throttle on a string: got %v", err)
+	}
+}
+
+func TestLock(t *testing.T) {
+	c := New()
+	fence, ok, err := c.Lock("l", "a", time.Minute)
+	if err != nil || !ok || fence == 0 {
+		t.Fatalf("Lock by a: got %d, %v, %v", fence, ok, err)
+	}
+	if _, ok, _ := c.Lock("l", "b", time.Minute); ok {
+		t.Fatal("Lock by b while a holds it: got true")
+	}
+	if again, ok, _ := c.Lock("l", "a", time.Minute); !ok || again != fence {
+		t.Fatalf("Lock by a again: got %d, %v, want %d", again, ok, fence)
+	}
+	if ok, _ := c.ExtendLock("l", "b", time.Minute); ok {
+		t.Fatal("ExtendLock by b: got true")
+	}
+	if ok, _ := c.Unlock("l", "b"); ok {
+		t.Fatal("Unlock by b: got true")
+	}
+	if ok, _ := c.Unlock("l", "a"); !ok {
+		t.Fatal("Unlock by a: got false")
+	}
+
+	// Fencing tokens keep growing across release and expiry.
+	next, ok, _ := c.Lock("l", "b", 20*time.Millisecond)
+	if !ok || next <= fence {
+		t.Fatalf("Lock by b after release: got %d, %v, want a token above %d", next, ok, fence)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if ok, _ := c.ExtendLock("l", "b", time.Minute); ok {
+		t.Fatal("ExtendLock of an expired lock: got true")
+	}
+	if last, ok, _ := c.Lock("l", "a", time.Minute); !ok || last <= next {
+		t.Fatalf("Lock by a after expiry: got %d, %v, want a token above %d", last, ok, next)
+	}
+
+	// The longest TTL holds the lock rather than wrapping around to the past.
+	if _, ok, _ := c.Lock("forever", "a", math.MaxInt64); !ok {
+		t.Fatal("Lock with the longest TTL: got false")
+	}
+	if _, ok, _ := c.Lock("forever", "b", time.Minute); ok {
+		t.Fatal("Lock by b of a lock held with the longest TTL: got true")
+	}
+	if ok, _ := c.ExtendLock("forever", "a", math.MaxInt64); !ok {
+		t.Fatal("ExtendLock with the longest TTL: got false")
+	}
+	if _, ok, _ := c.Lock("forever", "b", time.Minute); ok {
+		t.Fatal("Lock by b of a lock extended with the longest TTL: got true")
+	}
+
+	if _, _, err := c.Lock("l", "", time.Minute); err != ErrInvalidLock {
+		t.Errorf("Lock with empty owner: got %v", err)
+	}
+	c.Set("plain", []byte("v"))
+	if _, _, err := c.Lock("plain", "a", time.Minute); err != ErrWrongType {
+		t.Errorf("Lock on a string: got %v", err)
+	}
+}
+
+func TestHyperLogLog(t *testing.T) {
+	c := New()
+	if changed, err := c.PFAdd("hll", "a", "b", "c"); err != nil || !changed {
+		t.Fatalf("PFAdd: got %v, %v", changed, err)
+	}
+	if changed, _ := c.PFAdd("hll", "a", "b"); changed {
+		t.Fatal("PFAdd of seen elements: got changed")
+	}
+	if n, err := c.PFCount("hll"); err != nil || n != 3 {
+		t.Fatalf("PFCount: got %d, %v", n, err)
+	}
+	if n, _ := c.PFCount("missing"); n != 0 {
+		t.Fatalf("PFCount of missing key: got %d", n)
+	}
+
+	// Estimates stay within a few standard errors (0.8%) across the switch
+	// from the sparse to the dense encoding.
+	for _, total := range []int{500, 5000, 100000} {
+		key := "visitors" + strconv.Itoa(total)
+		for i := 0; i < total; i++ {
+			c.PFAdd(key, "user"+strconv.Itoa(i))
+		}
+		n, _ := c.PFCount(key)
+		if diff := math.Abs(float64(n)-float64(total)) / float64(total); diff > 0.03 {
+			t.Errorf("PFCount of %d elements: got %d", total, n)
+		}
+		value, _ := c.Get(key)
+		if dense := value[len(hllMagic)] == hllDense; dense != (total > 1000) {
+			t.Errorf("%d elements stored dense=%v", total, dense)
+		}
+	}
+
+	// Merging unions keys across shards; counting several keys does the same.
+	for i := 0; i < 3000; i++ {
+		c.PFAdd("left", "e"+strconv.Itoa(i))
+		c.PFAdd("right", "e"+strconv.Itoa(i+2000))
+	}
+	union, _ := c.PFCount("left", "right")
+	if err := c.PFMerge("both", "left", "right"); err != nil {
+		t.Fatal(err)
+	}
+	merged, _ := c.PFCount("both")
+	if merged != union || math.Abs(float64(merged)-5000) > 150 {
+		t.Errorf("PFMerge: got %d, PFCount of both keys %d, want about 5000", merged, union)
+	}
+
+	// A dump restored under another key counts the same.
+	dump, _ := c.Get("both")
+	c.Set("restored", dump)
+	if n, err := c.PFCount("restored"); err != nil || n != merged {
+		t.Errorf("PFCount of restored dump: got %d, %v, want %d", n, err, merged)
+	}
+
+	c.Set("plain", []byte("not an hll"))
+	if _, err := c.PFAdd("plain", "x"); err != ErrNotHyperLogLog {
+		t.Errorf("PFAdd on a string: got %v", err)
+	}
+	corrupt := dump[:len(dump)-1]
+	c.Set("corrupt", corrupt)
+	if _, err := c.PFCount("corrupt"); err != ErrNotHyperLogLog {
+		t.Errorf("PFCount of a corrupt dump: got %v", err)
+	}
+	// A sparse word indexing past the last register is rejected, not decoded.
+	c.Set("out-of-range", appendSparseWord([]byte(hllMagic+"\x00"), 20000, 1))
+	if _, err := c.PFCount("out-of-range"); err != ErrNotHyperLogLog {
+		t.Errorf("PFCount of a sparse register out of range: got %v", err)
+	}
+	if _, err := c.PFAdd("out-of-range", "x"); err != ErrNotHyperLogLog {
+		t.Errorf("PFAdd to a sparse register out of range: got %v", err)
+	}
+	if err := c.PFMerge("merged-out-of-range", "out-of-range"); err != ErrNotHyperLogLog {
+		t.Errorf("PFMerge of a sparse register out of range: got %v", err)
+	}
+
+	// A dense register above hllMaxRank is clamped by both PFCOUNT and
+	// PFMERGE, rather than trusted or rejected.
+	high := append([]byte(nil), dump...)
+	setDenseRegister(high[hllHeaderSize:], 7, 0x3f)
+	clamped := append([]byte(nil), dump...)
+	setDenseRegister(clamped[hllHeaderSize:], 7, hllMaxRank)
+	c.Set("high", high)
+	c.Set("clamped", clamped)
+	want, err := c.PFCount("clamped")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := c.PFCount("high"); err != nil || n != want {
+		t.Errorf("PFCount of a dump with a register above the maximum: got %d, %v, want %d", n, err, want)
+	}
+	if err := c.PFMerge("high-merged", "high"); err != nil {
+		t.Fatal(err)
+	}
+	if merged, _ := c.Get("high-merged"); denseRegister(merged[hllHeaderSize:], 7) != hllMaxRank {
+		t.Errorf("PFMerge of a register above the maximum: got %d, want %d", denseRegister(merged[hllHeaderSize:], 7), hllMaxRank)
+	}
+	c.SAdd("set", "x")
+	if err := c.PFMerge("set", "hll"); err != ErrWrongType {
+		t.Errorf("PFMerge into a set: got %v", err)
+	}
+}
+
+func TestBloom(t *testing.T) {
+	c := New()
+	if ok, err := c.BFReserve("ids", 0.01, 1000); err != nil || !ok {
+		t.Fatalf("BFReserve: got %v, %v", ok, err)
+	}
+	if ok, _ := c.BFReserve("ids", 0.01, 1000); ok {
+		t.Fatal("BFReserve of an existing key: got true")
+	}
+	for _, bad := range []struct {
+		rate     float64
+		capacity int
+	}{{0, 10}, {1, 10}, {0.01, 0}, {1e-9, 1 << 30}, {math.SmallestNonzeroFloat64, 10}} {
+		if _, err := c.BFReserve("bad", bad.rate, bad.capacity); err != ErrInvalidBloom {
+			t.Errorf("BFReserve(%v, %d): got %v", bad.rate, bad.capacity, err)
+		}
+	}
+
+	added, err := c.BFAdd("ids", "a", "b", "a")
+	if err != nil || !added[0] || !added[1] || added[2] {
+		t.Fatalf("BFAdd: got %v, %v", added, err)
+	}
+	found, _ := c.BFExists("ids", "a", "b", "c")
+	if !found[0] || !found[1] || found[2] {
+		t.Errorf("BFExists: got %v", found)
+	}
+	if found, err := c.BFExists("missing", "a"); err != nil || found[0] {
+		t.Errorf("BFExists of a missing key: got %v, %v", found, err)
+	}
+
+	// Growing well past the capacity keeps every element and the error rate.
+	statsBefore := c.Stats().Bytes
+	for i := 0; i < 20000; i++ {
+		c.BFAdd("ids", "id"+strconv.Itoa(i))
+	}
+	for i := 0; i < 20000; i += 97 {
+		if found, _ := c.BFExists("ids", "id"+strconv.Itoa(i)); !found[0] {
+			t.Fatalf("BFExists of added id%d: got false", i)
+		}
+	}
+	falsePositives := 0
+	for i := 0; i < 20000; i++ {
+		if found, _ := c.BFExists("ids", "other"+strconv.Itoa(i)); found[0] {
+			falsePositives++
+		}
+	}
+	if falsePositives > 200*3/2 {
+		t.Errorf("%d false positives in 20000, want about 1%% at most", falsePositives)
+	}
+	if grown := c.Stats().Bytes - statsBefore; grown < 20000 {
+		t.Errorf("memory grew by %d bytes, want the filter's bits accounted", grown)
+	}
+
+	// Sub-filters grow until they reach the size limit and then stay the
+	// same size, instead of each tightening the error rate and growing again.
+	f := &bloomFilter{capacity: DefaultBloomCapacity, errorRate: DefaultBloomErrorRate / 2}
+	var capped int
+	for range 200 {
+		capacity, errorRate := f.next()
+		bits := bloomBits(capacity, errorRate)
+		if bits > maxBloomFilterBytes*8 {
+			t.Fatalf("sub-filter of %d bits, want at most %d", bits, maxBloomFilterBytes*8)
+		}
+		if capacity == f.capacity {
+			if errorRate != f.errorRate {
+				t.Fatalf("sub-filter at the size limit: error rate %g after %g, want it unchanged", errorRate, f.errorRate)
+			}
+			capped++
+		}
+		f = &bloomFilter{capacity: capacity, errorRate: errorRate}
+	}
+	if capped == 0 {
+		t.Fatal("sub-filters never reached the size limit")
+	}
+	// Nor does growth halve the error rate down to zero.
+	tiny := &bloomFilter{capacity: 1, errorRate: math.SmallestNonzeroFloat64}
+	if capacity, errorRate := tiny.next(); capacity != 1 || errorRate != tiny.errorRate {
+		t.Errorf("next of a denormal error rate: got %d, %g", capacity, errorRate)
+	}
+
+	// A missing key gets a filter with the default parameters.
+	if added, _ := c.BFAdd("implicit", "x"); !added[0] {
+		t.Error("BFAdd to a missing key: got false")
+	}
+	c.Set("plain", []byte("v"))
+	if _, err := c.BFAdd("plain", "x"); err != ErrWrongType {
+		t.Errorf("BFAdd on a string: got %v", err)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	c := NewWithConfig(Config{ShardCount: 1, CompressThreshold: 1024})
+	raw := New()
+	doc := []byte(strings.Repeat(`{"id":12345,"name":"widget","tags":["a","b"]},`, 200))
+
+	c.Set("doc", doc)
+	raw.Set("doc", doc)
+	if got, _ := c.Get("doc"); !bytes.Equal(got, doc) {
+		t.Fatal("Get of a compressed value returned something else")
+	}
+	if c.Bytes() > raw.Bytes()/4 {
+		t.Errorf("compressed value accounts %d bytes, uncompressed %d", c.Bytes(), raw.Bytes())
+	}
+
+	// Small and incompressible values are stored as they are.
+	noise := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(noise)
+	c.Set("noise", noise)
+	c.Set("small", []byte("1"))
+	for _, key := range []string{"noise", "small"} {
+		if c.shards[0].items[key].compressed {
+			t.Errorf("%s was compressed", key)
+		}
+	}
+	if got, _ := c.Get("noise"); !bytes.Equal(got, noise) {
+		t.Fatal("Get of an incompressible value returned something else")
+	}
+
+	// Every way of storing a byte value compresses it, and every way of
+	// reading one decompresses it.
+	_, version, _ := c.GetWithVersion("doc")
+	if _, err := c.CompareAndSwap("doc", append(doc, ' '), version); err != nil {
+		t.Fatal(err)
+	}
+	c.SetNX("doc2", doc)
+	c.SetWithTags("doc3", doc, "docs")
+	for _, key := range []string{"doc", "doc2", "doc3"} {
+		if !c.shards[0].items[key].compressed {
+			t.Errorf("%s was not compressed", key)
+		}
+	}
+	if got, _ := c.Get("doc3"); !bytes.Equal(got, doc) {
+		t.Fatal("Get of a tagged compressed value returned something else")
+	}
+	if n := c.InvalidateTag("docs"); n != 1 {
+		t.Errorf("InvalidateTag: got %d", n)
+	}
+
+	number := []byte(strings.Repeat("0", 2000) + "41")
+	c.Set("counter", number)
+	if n, err := c.IncrBy("counter", 1, 0); err != nil || n != 42 {
+		t.Errorf("IncrBy of a compressed number: got %d, %v", n, err)
+	}
+
+	// A dense HyperLogLog dump compresses well and stays usable once restored.
+	for i := 0; i < 5000; i++ {
+		raw.PFAdd("hll", strconv.Itoa(i))
+	}
+	dump, _ := raw.Get("hll")
+	c.Set("hll", dump)
+	want, _ := raw.PFCount("hll")
+	if n, err := c.PFCount("hll"); err != nil || n != want {
+		t.Errorf("PFCount of a compressed dump: got %d, %v, want %d", n, err, want)
+	}
+	if _, err := c.PFAdd("hll", "new"); err != nil || c.shards[0].items["hll"].compressed {
+		t.Errorf("PFAdd to a compressed dump: got %v", err)
+	}
+}
+
+func TestArenaEngine(t *testing.T) {
+	c := NewWithConfig(Config{ShardCount: 1, Engine: EngineArena, ArenaBytesPerShard: 64 << 10})
+	var events []string
+	c.Notify(EventAll, func(e Event) { events = append(events, e.Type.String()+" "+e.Key) })
+	shard := c.shards[0]
+
+	v1 := c.SetVersioned("a", []byte("1"))
+	if got, v, err := c.GetWithVersion("a"); string(got) != "1" || v != v1 || err != nil {
+		t.Fatalf("GetWithVersion: got %q, %d, %v", got, v, err)
+	}
+	if len(shard.items) != 0 {
+		t.Fatal("plain value stored in the map")
+	}
+	c.Set("a", []byte("2"))
+	if c.SetNX("a", []byte("x")) || !c.SetXX("a", []byte("3")) || c.SetXX("missing", []byte("x")) {
+		t.Error("SetNX/SetXX disagree on which keys exist")
+	}
+	_, version, _ := c.GetWithVersion("a")
+	if _, err := c.CompareAndSwap("a", []byte("x"), version-1); err != ErrVersionMismatch {
+		t.Errorf("CompareAndSwap with a stale version: got %v", err)
+	}
+	if v, err := c.CompareAndSwap("a", []byte("4"), version); err != nil || c.Version("a") != v {
+		t.Errorf("CompareAndSwap: got %d, %v", v, err)
+	}
+
+	// Expire updates the record in place.
+	c.Set("ttl", []byte("x"))
+	if !c.Expire("ttl", time.Millisecond) || len(shard.items) != 0 {
+		t.Fatal("Expire moved the key out of the arena")
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, found := c.Get("ttl"); found {
+		t.Error("expired key still readable")
+	}
+
+	// Any other command moves the key to the map, keeping its version, and a
+	// plain write moves it back.
+	c.Set("n", []byte("41"))
+	version = c.Version("n")
+	if _, err := c.HSet("n", FieldValue{Field: "f", Value: []byte("v")}); err != ErrWrongType {
+		t.Errorf("HSet on a string: got %v", err)
+	}
+	if shard.items["n"] == nil || c.Version("n") != version {
+		t.Fatal("key not moved to the map with its version")
+	}
+	if n, err := c.IncrBy("n", 1, 0); err != nil || n != 42 {
+		t.Errorf("IncrBy: got %d, %v", n, err)
+	}
+	c.Set("n", []byte("1"))
+	if got, _ := c.Get("n"); string(got) != "1" || len(shard.items) != 0 {
+		t.Errorf("Set after IncrBy: got %q with %d keys in the map", got, len(shard.items))
+	}
+
+	keys, _ := c.Scan(0, "", 100)
+	if got := sortedJoin(keys); got != "a,n" || c.Len() != 2 {
+		t.Errorf("Scan: got %s, Len %d", got, c.Len())
+	}
+	c.Delete("a")
+	if _, found := c.Get("a"); found {
+		t.Error("deleted key still readable")
+	}
+	if n, _ := c.DeletePattern(context.Background(), "*"); n != 1 || c.Len() != 0 || c.Bytes() != 0 {
+		t.Errorf("DeletePattern: got %d, Len %d, Bytes %d", n, c.Len(), c.Bytes())
+	}
+	want := []string{"set a", "set a", "set a", "set a", "set ttl", "expired ttl", "set n", "set n", "set n", "del a", "del n"}
+	if strings.Join(events, ",") != strings.Join(want, ",") {
+		t.Errorf("events: got %v, want %v", events, want)
+	}
+}
+
+func TestArenaEviction(t *testing.T) {
+	c := NewWithConfig(Config{ShardCount: 1, Engine: EngineArena, ArenaBytesPerShard: 16 << 10})
+	value := generateValue(100)
+
+	// A key read between writes keeps getting a second chance. Reads are
+	// applied in batches, so apply them directly.
+	c.Set("hot", value)
+	for i := range 1000 {
+		if _, found := c.Get("hot"); !found {
+			t.Fatalf("hot key evicted after %d writes", i)
+		}
+		c.shards[0].applyAccesses([]string{"hot"})
+		c.Set("key:"+strconv.Itoa(i), value)
+	}
+	stats := c.Stats()
+	if stats.Evictions == 0 || stats.Keys+int(stats.Evictions) != 1001 || stats.Bytes > 16<<10 {
+		t.Errorf("stats after filling the arena: %+v", stats)
+	}
+	if _, found := c.Get("key:999"); !found {
+		t.Error("most recent key evicted")
+	}
+
+	// Values too large for the arena are kept in the map.
+	big := generateValue(4 << 10)
+	c.Set("big", big)
+	if got, _ := c.Get("big"); !bytes.Equal(got, big) || c.shards[0].items["big"] == nil {
+		t.Error("large value not stored in the map")
+	}
+	if n := c.FlushAll(); n != stats.Keys+1 || c.Len() != 0 {
+		t.Errorf("FlushAll: got %d, Len %d", n, c.Len())
+	}
+}
+
+func TestHasher(t *testing.T) {
+	// FNV1a matches hash/fnv, so keys stay in the same shards as before.
+	ref := fnv.New64a()
+	ref.Write([]byte("user:42"))
+	if got := (FNV1a{}).Hash("user:42"); got != ref.Sum64() {
+		t.Errorf("FNV1a: got %x, want %x", got, ref.Sum64())
+	}
+
+	for _, h := range hashers {
+		if allocs := testing.AllocsPerRun(100, func() { h.hasher.Hash("user:42") }); allocs != 0 {
+			t.Errorf("%s: %v allocations per hash", h.name, allocs)
+		}
+
+		c := NewWithConfig(Config{ShardCount: 16, Hasher: h.hasher})
+		for i := range 100 {
+			c.Set("key:"+strconv.Itoa(i), []byte(strconv.Itoa(i)))
+		}
+		value := []byte("7")
+		if allocs := testing.AllocsPerRun(100, func() { c.Set("key:7", value) }); allocs != 1 {
+			t.Errorf("%s: %v allocations per Set, want 1 for the value", h.name, allocs)
+		}
+		if got, _ := c.Get("key:7"); string(got) != "7" {
+			t.Errorf("%s: Get: got %q", h.name, got)
+		}
+		var scanned []string
+		for cursor := uint64(0); ; {
+			var keys []string
+			keys, cursor = c.Scan(cursor, "", 10)
+			scanned = append(scanned, keys...)
+			if cursor == 0 {
+				break
+			}
+		}
+		if len(scanned) != 100 {
+			t.Errorf("%s: Scan returned %d keys, want 100", h.name, len(scanned))
+		}
+	}
+
+	// Different map hashers are seeded differently.
+	if NewMapHasher().Hash("user:42") == NewMapHasher().Hash("user:42") {
+		t.Error("map hashers share a seed")
+	}
+}
+
+func TestConcurrentReads(t *testing.T) {
+	for _, engine := range engines {
+		c := NewWithConfig(Config{ShardCount: 2, MaxItemsPerShard: 8, Engine: engine, ArenaBytesPerShard: 4 << 10})
+		var wg sync.WaitGroup
+		for w := range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range 2000 {
+					key := "key:" + strconv.Itoa(i%16)
+					switch w {
+					case 0:
+						c.Set(key, []byte(key))
+					case 1:
+						c.Expire(key, time.Microsecond)
+						c.Delete("key:" + strconv.Itoa((i+7)%16))
+					default:
+						if value, found := c.Get(key); found && string(value) != key {
+							t.Errorf("%s: Get(%s) returned %q", engine, key, value)
+							return
+						}
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		if stats := c.Stats(); stats.Hits+stats.Misses != 4000 {
+			t.Errorf("%s: %d hits and %d misses for 4000 reads", engine, stats.Hits, stats.Misses)
+		}
+
+		// Reading an expired key drops it.
+		c.Set("ttl", []byte("x"))
+		c.Expire("ttl", time.Millisecond)
+		time.Sleep(2 * time.Millisecond)
+		before := c.Len()
+		if _, found := c.Get("ttl"); found || c.Len() != before-1 {
+			t.Errorf("%s: expired key not dropped by Get", engine)
+		}
+	}
+}
+
+func TestView(t *testing.T) {
+	for _, engine := range engines {
+		c := NewWithConfig(Config{ShardCount: 1, Engine: engine, CompressThreshold: 1024})
+		doc := []byte(strings.Repeat("compressible ", 200))
+		c.Set("a", []byte("1"))
+		c.Set("doc", doc)
+		c.HSet("h", FieldValue{Field: "f", Value: []byte("v")})
+
+		for key, want := range map[string][]byte{"a": []byte("1"), "doc": doc} {
+			wantVersion := c.Version(key)
+			err := c.View(key, func(value []byte, version uint64) error {
+				if !bytes.Equal(value, want) || version != wantVersion {
+					t.Errorf("%s: View(%s): got %d bytes at version %d", engine, key, len(value), version)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Errorf("%s: View(%s): %v", engine, key, err)
+			}
+		}
+		called := false
+		fn := func([]byte, uint64) error { called = true; return nil }
+		if err := c.View("missing", fn); err != ErrNotFound {
+			t.Errorf("%s: View of a missing key: got %v", engine, err)
+		}
+		if err := c.View("h", fn); err != ErrWrongType || called {
+			t.Errorf("%s: View of a hash: got %v, called %v", engine, err, called)
+		}
+		if err := c.View("a", func([]byte, uint64) error { return ErrOverflow }); err != ErrOverflow {
+			t.Errorf("%s: View did not return fn's error: got %v", engine, err)
+		}
+		if stats := c.Stats(); stats.Hits != 4 || stats.Misses != 1 {
+			t.Errorf("%s: View counted %d hits and %d misses", engine, stats.Hits, stats.Misses)
+		}
+	}
+
+	// A lent value stays unchanged after the key is written to.
+	c := New()
+	c.PFAdd("hll", "a")
+	var lent []byte
+	c.View("hll", func(value []byte, _ uint64) error {
+		lent = value
+		return nil
+	})
+	before := bytes.Clone(lent)
+	c.PFAdd("hll", "b", "c", "d")
+	if !bytes.Equal(lent, before) {
+		t.Error("PFAdd modified a lent value in place")
+	}
+
+	// Copying a large arena value does not leave its buffer in the pool.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1)) // View's Put is the next Get
+	c = NewWithConfig(Config{ShardCount: 1, Engine: EngineArena, ArenaBytesPerShard: 16 * maxPooledViewBuffer})
+	large := bytes.Repeat([]byte("x"), maxPooledViewBuffer+1)
+	c.Set("large", large)
+	c.View("large", func(value []byte, _ uint64) error {
+		if !bytes.Equal(value, large) {
+			t.Errorf("View of a large arena value: got %d bytes", len(value))
+		}
+		return nil
+	})
+	buf := viewBuffers.Get().(*[]byte)
+	if cap(*buf) > maxPooledViewBuffer {
+		t.Errorf("View pooled a buffer of %d bytes", cap(*buf))
+	}
+	viewBuffers.Put(buf)
+}
+
+func sortedJoin(members []string) string {
+	sort.Strings(members)
+	return strings.Join(members, ",")
+}
+
+func memberNames(members []ScoredMember) string {
+	names := ""
+	for i, m := range members {
+		if i > 0 {
+			names += ","
+		}
+		names += m.Member
+	}
+	return names
+}
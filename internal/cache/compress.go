@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"sync"
+)
+
+// Byte values of at least Config.CompressThreshold bytes are stored compressed
+// with DEFLATE at its fastest level, if that saves at least an eighth of their
+// size; JSON and HTML typically shrink several times over. Compression happens
+// before the shard lock is taken and decompression after it is released, and
+// the entry's size, and so the shard's memory limit, counts the compressed
+// bytes. A compressed value starts with the uvarint length of the original.
+//
+// Hash fields, list elements and other structured values are never compressed,
+// and neither are counters and HyperLogLogs: each update stores a new,
+// uncompressed value in place of the old one, which is never modified, so a
+// value lent out by View stays intact.
+
+var flateWriters = sync.Pool{
+	New: func() any {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	},
+}
+
+var flateReaders = sync.Pool{
+	New: func() any {
+		return flate.NewReader(nil)
+	},
+}
+
+// encodeValue returns a copy of value owned by the cache, compressed if the
+// cache is configured to and it pays off, and whether it was compressed.
+func (c *Cache) encodeValue(value []byte) ([]byte, bool) {
+	if c.compressThreshold <= 0 || len(value) < c.compressThreshold {
+		return copyValue(value), false
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(binary.MaxVarintLen64 + len(value)/2)
+	var header [binary.MaxVarintLen64]byte
+	buf.Write(binary.AppendUvarint(header[:0], uint64(len(value))))
+	w := flateWriters.Get().(*flate.Writer)
+	w.Reset(&buf)
+	_, _ = w.Write(value) // Writes to a bytes.Buffer do not fail
+	_ = w.Close()
+	flateWriters.Put(w)
+
+	if buf.Len() > len(value)-len(value)/8 {
+		return copyValue(value), false
+	}
+	return copyValue(buf.Bytes()), true // Trimmed to size, as it is accounted
+}
+
+// encodeSetValue is encodeValue for values passed to Shard.set. With the arena
+// engine a value that is not compressed is returned as is, as the arena copies
+// it; set copies it itself if it ends up in the map.
+func (c *Cache) encodeSetValue(value []byte) ([]byte, bool) {
+	if c.engine == EngineArena && (c.compressThreshold <= 0 || len(value) < c.compressThreshold) {
+		return value, false
+	}
+	return c.encodeValue(value)
+}
+
+// decodeValue returns the original of a value compressed by encodeValue.
+func decodeValue(data []byte) []byte {
+	n, headerLen := binary.Uvarint(data)
+	value := make([]byte, n)
+	r := flateReaders.Get().(io.ReadCloser)
+	_ = r.(flate.Resetter).Reset(bytes.NewReader(data[headerLen:]), nil)
+	// The data was produced by encodeValue and is never modified, so this
+	// cannot fail.
+	_, _ = io.ReadFull(r, value)
+	flateReaders.Put(r)
+	return value
+}
+
+// plainValue returns the byte value of entry, decompressed if needed. It
+// aliases entry.value if that was not compressed. The caller must hold the
+// entry's shard lock.
+func (e *cacheEntry) plainValue() []byte {
+	if e.compressed {
+		return decodeValue(e.value)
+	}
+	return e.value
+}
@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EventType identifies a kind of keyspace event. Types are bit flags, so a
+// set of them can be passed to Notify.
+type EventType uint8
+
+const (
+	EventSet     EventType = 1 << iota // A key was created or written to
+	EventDel                           // A key was deleted, explicitly or by emptying its hash, list, set or sorted set
+	EventExpired                       // A key was dropped because its TTL passed
+	EventEvicted                       // A key was dropped to stay within the shard's limits
+
+	EventAll = EventSet | EventDel | EventExpired | EventEvicted
+)
+
+var eventNames = []struct {
+	typ  EventType
+	name string
+}{
+	{EventSet, "set"},
+	{EventDel, "del"},
+	{EventExpired, "expired"},
+	{EventEvicted, "evicted"},
+}
+
+// String returns the event names in t joined by commas, e.g. "set,del".
+func (t EventType) String() string {
+	var names []string
+	for _, e := range eventNames {
+		if t&e.typ != 0 {
+			names = append(names, e.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseEventTypes parses a comma-separated list of event names (set, del,
+// expired, evicted) or "all". An empty string yields no events.
+func ParseEventTypes(s string) (EventType, error) {
+	var types EventType
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		switch name {
+		case "":
+			continue
+		case "all":
+			types |= EventAll
+			continue
+		}
+		found := false
+		for _, e := range eventNames {
+			if e.name == name {
+				types |= e.typ
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown event type %q", name)
+		}
+	}
+	return types, nil
+}
+
+// Event is a change to a single key.
+type Event struct {
+	Type EventType
+	Key  string
+}
+
+// eventHook is the subscriber installed by Notify.
+type eventHook struct {
+	types EventType
+	fn    func(Event)
+}
+
+// Notify makes the cache call fn for every event whose type is in types,
+// replacing any previous hook; a nil fn or empty types removes it. FlushAll
+// does not emit per-key events.
+//
+// fn is called synchronously with the key's shard locked, in the order the
+// changes happen, so it must be fast and must not call back into the cache.
+func (c *Cache) Notify(types EventType, fn func(Event)) {
+	var hook *eventHook
+	if fn != nil && types != 0 {
+		hook = &eventHook{types: types, fn: fn}
+	}
+	for _, shard := range c.shards {
+		c.lock(shard)
+		shard.events = hook
+		c.unlock(shard)
+	}
+}
+
+// emit reports an event to the hook, if any. The caller must hold s.mu.
+func (s *Shard) emit(typ EventType, key string) {
+	if s.events != nil && s.events.types&typ != 0 {
+		s.events.fn(Event{Type: typ, Key: key})
+	}
+}
@@ -0,0 +1,147 @@
+package cache
+
+// hashFieldOverhead approximates the per-field bookkeeping cost of a hash (map slot and headers).
+const hashFieldOverhead = 48
+
+// FieldValue is a single field of a hash.
+type FieldValue struct {
+	Field string
+	Value []byte
+}
+
+// hashValue is a map of fields stored under a single key.
+type hashValue struct {
+	fields map[string][]byte
+	bytes  int // Sum of field and value lengths
+}
+
+func newHashValue() *hashValue {
+	return &hashValue{fields: make(map[string][]byte)}
+}
+
+func (h *hashValue) size() int {
+	return h.bytes + len(h.fields)*hashFieldOverhead
+}
+
+// HSet sets the given fields of the hash stored at key, creating the hash if needed.
+// It returns the number of fields that were newly added.
+func (c *Cache) HSet(key string, fields ...FieldValue) (int, error) {
+	if len(fields) == 0 {
+		return 0, nil
+	}
+	shard := c.shards[c.getShardIndex(key)]
+
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	entry, h, err := getOrCreateObject(shard, key, newHashValue)
+	if err != nil {
+		return 0, err
+	}
+
+	added := 0
+	for _, fv := range fields {
+		if old, found := h.fields[fv.Field]; found {
+			h.bytes -= len(fv.Field) + len(old)
+		} else {
+			added++
+		}
+		h.fields[fv.Field] = copyValue(fv.Value)
+		h.bytes += len(fv.Field) + len(fv.Value)
+	}
+	shard.modified(entry)
+	return added, nil
+}
+
+// HGet returns the value of a field in the hash stored at key.
+// It returns ErrNotFound if either the key or the field does not exist.
+func (c *Cache) HGet(key string, field string) ([]byte, error) {
+	shard := c.shards[c.getShardIndex(key)]
+
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	entry, h, err := getObject[*hashValue](shard, key)
+	if err != nil {
+		return nil, err
+	}
+	value, found := h.fields[field]
+	if !found {
+		return nil, ErrNotFound
+	}
+	shard.lruList.MoveToFront(entry.listElement)
+	return copyValue(value), nil
+}
+
+// HDel removes fields from the hash stored at key and returns how many were removed.
+// The key itself is deleted once its last field is gone.
+func (c *Cache) HDel(key string, fields ...string) (int, error) {
+	shard := c.shards[c.getShardIndex(key)]
+
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	entry, h, err := getObject[*hashValue](shard, key)
+	if err == ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, field := range fields {
+		if value, found := h.fields[field]; found {
+			h.bytes -= len(field) + len(value)
+			delete(h.fields, field)
+			removed++
+		}
+	}
+	if len(h.fields) == 0 {
+		shard.remove(key, entry, EventDel)
+	} else if removed > 0 {
+		shard.modified(entry)
+	}
+	return removed, nil
+}
+
+// HGetAll returns every field of the hash stored at key, in no particular order.
+// A missing key yields an empty result.
+func (c *Cache) HGetAll(key string) ([]FieldValue, error) {
+	shard := c.shards[c.getShardIndex(key)]
+
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	entry, h, err := getObject[*hashValue](shard, key)
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make([]FieldValue, 0, len(h.fields))
+	for field, value := range h.fields {
+		fields = append(fields, FieldValue{Field: field, Value: copyValue(value)})
+	}
+	shard.lruList.MoveToFront(entry.listElement)
+	return fields, nil
+}
+
+// HLen returns the number of fields in the hash stored at key.
+func (c *Cache) HLen(key string) (int, error) {
+	shard := c.shards[c.getShardIndex(key)]
+
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	_, h, err := getObject[*hashValue](shard, key)
+	if err == ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(h.fields), nil
+}
@@ -0,0 +1,51 @@
+package cache
+
+import "hash/maphash"
+
+// Hasher hashes keys. The low bits of a key's hash select its shard, the high
+// 32 bits order the keys of a shard for Scan and the arena engine indexes keys
+// by the whole hash, so all 64 bits must be well mixed. Hash is called on
+// every operation: it must not allocate and must be safe for concurrent use.
+type Hasher interface {
+	Hash(key string) uint64
+}
+
+// FNV1a is the 64-bit FNV-1a hash, computed inline over the key's bytes. It is
+// the default hasher; keys hash the same in every process.
+type FNV1a struct{}
+
+func (FNV1a) Hash(key string) uint64 {
+	return fnv1a(key)
+}
+
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// fnv1a returns the 64-bit FNV-1a hash of s, the same as hash/fnv's New64a
+// but without allocating.
+func fnv1a(s string) uint64 {
+	h := uint64(fnvOffset64)
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= fnvPrime64
+	}
+	return h
+}
+
+// NewMapHasher returns a Hasher using hash/maphash with a seed chosen at random
+// when it is called. Keys hash differently in every process, so clients cannot
+// craft keys that all land in the same shard (hash flooding). It is also
+// faster than FNV1a on long keys.
+func NewMapHasher() Hasher {
+	return mapHasher{seed: maphash.MakeSeed()}
+}
+
+type mapHasher struct {
+	seed maphash.Seed
+}
+
+func (h mapHasher) Hash(key string) uint64 {
+	return maphash.String(h.seed, key)
+}
@@ -0,0 +1,383 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"math/bits"
+)
+
+// HyperLogLogs are stored as plain byte values, so GET dumps one and SET
+// restores it, also on another server or under another key. Every PF command
+// validates the value first. The layout is a header of hllMagic and an
+// encoding byte, followed by either:
+//
+//   - sparse: the non-zero registers as 3-byte big-endian words of
+//     index<<6 | value, in ascending index order, or
+//   - dense: all registers, 6 bits each, packed little-endian.
+//
+// New HyperLogLogs start sparse, which costs 3 bytes per non-zero register,
+// and switch to the fixed 12KB dense form once the sparse body would exceed
+// hllSparseMaxBytes.
+const (
+	hllPrecision      = 14
+	hllRegisters      = 1 << hllPrecision
+	hllMaxRank        = 64 - hllPrecision + 1 // Largest register value
+	hllHeaderSize     = len(hllMagic) + 1
+	hllDenseSize      = hllHeaderSize + hllRegisters*6/8
+	hllSparseMaxBytes = 3000 // Largest sparse body before switching to dense
+
+	hllSparse byte = 0
+	hllDense  byte = 1
+)
+
+const hllMagic = "ZHLL"
+
+// ErrNotHyperLogLog is returned by the PF methods for a key holding a byte
+// value that is not a valid HyperLogLog.
+var ErrNotHyperLogLog = errors.New("WRONGTYPE key holds a value that is not a valid HyperLogLog")
+
+// hllHash hashes an element. It must never change: dumped HyperLogLogs are
+// only comparable if built with the same hash. FNV-1a alone mixes poorly, so
+// its result goes through the MurmurHash3 finalizer.
+func hllHash(element string) uint64 {
+	return fmix64(fnv1a(element))
+}
+
+// fmix64 is the 64-bit finalizer of MurmurHash3, which spreads every input bit
+// over the whole output.
+func fmix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// hllPosition returns the register an element hash falls into and the value
+// it proposes for it: the position of the lowest set bit among the rest.
+func hllPosition(hash uint64) (int, uint8) {
+	index := int(hash & (hllRegisters - 1))
+	rest := hash>>hllPrecision | 1<<(64-hllPrecision) // Sentinel bounds the rank
+	return index, uint8(bits.TrailingZeros64(rest) + 1)
+}
+
+// hllValid reports whether b is a well-formed HyperLogLog.
+func hllValid(b []byte) bool {
+	if len(b) < hllHeaderSize || string(b[:len(hllMagic)]) != hllMagic {
+		return false
+	}
+	body := b[hllHeaderSize:]
+	switch b[len(hllMagic)] {
+	case hllDense:
+		// Registers above hllMaxRank are clamped by hllDecode rather than
+		// checked here, which would cost a pass over all of them per command.
+		return len(b) == hllDenseSize
+	case hllSparse:
+		if len(body)%3 != 0 {
+			return false
+		}
+		prev := -1
+		for off := 0; off < len(body); off += 3 {
+			index, value := sparseWord(body[off:])
+			if index <= prev || index >= hllRegisters || value == 0 || value > hllMaxRank {
+				return false
+			}
+			prev = index
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+func denseRegister(body []byte, i int) uint8 {
+	bit := i * 6
+	word := uint16(body[bit/8])
+	if bit/8+1 < len(body) {
+		word |= uint16(body[bit/8+1]) << 8
+	}
+	return uint8(word>>(bit%8)) & 0x3f
+}
+
+func setDenseRegister(body []byte, i int, value uint8) {
+	bit := i * 6
+	word := uint16(body[bit/8])
+	if bit/8+1 < len(body) {
+		word |= uint16(body[bit/8+1]) << 8
+	}
+	word = word&^(0x3f<<(bit%8)) | uint16(value)<<(bit%8)
+	body[bit/8] = byte(word)
+	if bit/8+1 < len(body) {
+		body[bit/8+1] = byte(word >> 8)
+	}
+}
+
+func sparseWord(b []byte) (int, uint8) {
+	word := uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+	return int(word >> 6), uint8(word & 0x3f)
+}
+
+func appendSparseWord(b []byte, index int, value uint8) []byte {
+	word := uint32(index)<<6 | uint32(value)
+	return append(b, byte(word>>16), byte(word>>8), byte(word))
+}
+
+// hllNew returns an empty HyperLogLog.
+func hllNew() []byte {
+	return append([]byte(hllMagic), hllSparse)
+}
+
+// hllAdd raises the register hash falls into, and returns the possibly
+// reallocated value and whether it changed. b must be valid and owned by the
+// caller, as it is updated in place where possible; PFAdd passes a copy of
+// the stored value.
+func hllAdd(b []byte, hash uint64) ([]byte, bool) {
+	index, value := hllPosition(hash)
+	body := b[hllHeaderSize:]
+
+	if b[len(hllMagic)] == hllDense {
+		if denseRegister(body, index) >= value {
+			return b, false
+		}
+		setDenseRegister(body, index, value)
+		return b, true
+	}
+
+	// Binary search the sparse words for index.
+	n := len(body) / 3
+	lo, hi := 0, n
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if i, _ := sparseWord(body[mid*3:]); i < index {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo < n {
+		if i, old := sparseWord(body[lo*3:]); i == index {
+			if old >= value {
+				return b, false
+			}
+			body[lo*3+2] = body[lo*3+2]&^0x3f | value
+			return b, true
+		}
+	}
+	if len(body)+3 > hllSparseMaxBytes {
+		regs := hllDecode(b)
+		regs[index] = value
+		return hllEncode(regs, true), true
+	}
+	out := make([]byte, 0, len(b)+3)
+	out = append(out, b[:hllHeaderSize+lo*3]...)
+	out = appendSparseWord(out, index, value)
+	out = append(out, b[hllHeaderSize+lo*3:]...)
+	return out, true
+}
+
+// hllDecode expands a valid HyperLogLog to one byte per register.
+func hllDecode(b []byte) []uint8 {
+	regs := make([]uint8, hllRegisters)
+	body := b[hllHeaderSize:]
+	if b[len(hllMagic)] == hllDense {
+		for i := range regs {
+			regs[i] = min(denseRegister(body, i), hllMaxRank)
+		}
+		return regs
+	}
+	for off := 0; off < len(body); off += 3 {
+		index, value := sparseWord(body[off:])
+		regs[index] = value
+	}
+	return regs
+}
+
+// hllEncode packs registers, sparse if they fit unless dense is forced.
+func hllEncode(regs []uint8, dense bool) []byte {
+	nonZero := 0
+	for _, r := range regs {
+		if r != 0 {
+			nonZero++
+		}
+	}
+	if !dense && nonZero*3 <= hllSparseMaxBytes {
+		b := make([]byte, 0, hllHeaderSize+nonZero*3)
+		b = append(append(b, hllMagic...), hllSparse)
+		for i, r := range regs {
+			if r != 0 {
+				b = appendSparseWord(b, i, r)
+			}
+		}
+		return b
+	}
+	b := make([]byte, hllDenseSize)
+	copy(b, hllMagic)
+	b[len(hllMagic)] = hllDense
+	for i, r := range regs {
+		setDenseRegister(b[hllHeaderSize:], i, r)
+	}
+	return b
+}
+
+// hllEstimate estimates the cardinality of registers with Otmar Ertl's
+// improved estimator, which is accurate from zero to billions without the
+// empirical bias corrections of the original algorithm.
+func hllEstimate(regs []uint8) uint64 {
+	var hist [hllMaxRank + 1]int
+	for _, r := range regs {
+		hist[r]++
+	}
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(hist[hllMaxRank]))/m)
+	for k := hllMaxRank - 1; k >= 1; k-- {
+		z = 0.5 * (z + float64(hist[k]))
+	}
+	z += m * hllSigma(float64(hist[0])/m)
+	return uint64(math.Round(m * m / (2 * math.Ln2) / z))
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		prev := z
+		z += x * y
+		y += y
+		if z == prev {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		prev := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if z == prev {
+			return z / 3
+		}
+	}
+}
+
+// lockedHLL returns the HyperLogLog stored at key, nil if the key is missing.
+// The caller must hold the key's shard lock.
+func lockedHLL(s *Shard, key string) (*cacheEntry, []byte, error) {
+	entry, found := s.lookup(key)
+	if !found {
+		return nil, nil, nil
+	}
+	if entry.obj != nil {
+		return nil, nil, ErrWrongType
+	}
+	// A dump restored with SET may have been compressed.
+	value := entry.plainValue()
+	if !hllValid(value) {
+		return nil, nil, ErrNotHyperLogLog
+	}
+	return entry, value, nil
+}
+
+// PFAdd adds elements to the HyperLogLog stored at key, creating it if
+// needed, and reports whether its estimate may have changed, which includes
+// creating it. An existing key keeps its TTL.
+func (c *Cache) PFAdd(key string, elements ...string) (bool, error) {
+	shard := c.shards[c.getShardIndex(key)]
+
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	entry, b, err := lockedHLL(shard, key)
+	if err != nil {
+		return false, err
+	}
+	changed := entry == nil
+	if b == nil {
+		b = hllNew()
+	} else if !entry.compressed {
+		// b is the stored value, which View may be lending out.
+		b = bytes.Clone(b)
+	}
+	for _, element := range elements {
+		var added bool
+		b, added = hllAdd(b, hllHash(element))
+		changed = changed || added
+	}
+	if !changed {
+		return false, nil
+	}
+	if entry == nil {
+		shard.store(key, b, nil)
+	} else {
+		entry.value, entry.compressed = b, false
+		shard.modified(entry)
+	}
+	return true, nil
+}
+
+// PFCount returns the estimated number of distinct elements added to the
+// HyperLogLogs stored at keys, taken together. Missing keys count as empty.
+// The standard error is about 0.8%.
+func (c *Cache) PFCount(keys ...string) (uint64, error) {
+	unlock := c.lockShards(keys)
+	defer unlock()
+
+	regs, err := c.lockedUnion(keys)
+	if err != nil {
+		return 0, err
+	}
+	return hllEstimate(regs), nil
+}
+
+// PFMerge stores at dest the union of the HyperLogLogs stored at dest and
+// sources, so that it counts every element added to any of them. The keys may
+// live in different shards; they are locked together, so the merge is atomic.
+func (c *Cache) PFMerge(dest string, sources ...string) error {
+	keys := append([]string{dest}, sources...)
+	unlock := c.lockShards(keys)
+	defer unlock()
+
+	regs, err := c.lockedUnion(keys)
+	if err != nil {
+		return err
+	}
+	shard := c.shards[c.getShardIndex(dest)]
+	merged := hllEncode(regs, false)
+	if entry, found := shard.items[dest]; found {
+		// Checked by lockedUnion; keep the TTL like PFAdd.
+		entry.value, entry.compressed = merged, false
+		shard.modified(entry)
+		return nil
+	}
+	shard.store(dest, merged, nil)
+	return nil
+}
+
+// lockedUnion returns the register-wise maximum of the HyperLogLogs stored at
+// keys. The caller must hold the locks of all shards involved.
+func (c *Cache) lockedUnion(keys []string) ([]uint8, error) {
+	union := make([]uint8, hllRegisters)
+	for _, key := range keys {
+		_, b, err := lockedHLL(c.shards[c.getShardIndex(key)], key)
+		if err != nil {
+			return nil, err
+		}
+		if b == nil {
+			continue
+		}
+		for i, r := range hllDecode(b) {
+			union[i] = max(union[i], r)
+		}
+	}
+	return union, nil
+}
@@ -0,0 +1,355 @@
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// listElemOverhead approximates the per-element bookkeeping cost of a list (slice header).
+const listElemOverhead = 24
+
+// listValue is a double-ended queue of values stored under a single key,
+// implemented as a ring buffer so pushes and pops at either end are O(1).
+type listValue struct {
+	buf   [][]byte
+	head  int // Index of the first element in buf
+	n     int // Number of elements
+	bytes int // Sum of element lengths
+}
+
+func newListValue() *listValue {
+	return &listValue{}
+}
+
+func (l *listValue) size() int {
+	return l.bytes + len(l.buf)*listElemOverhead
+}
+
+func (l *listValue) len() int {
+	return l.n
+}
+
+// at returns the i-th element from the front.
+func (l *listValue) at(i int) []byte {
+	return l.buf[(l.head+i)%len(l.buf)]
+}
+
+func (l *listValue) pushFront(value []byte) {
+	if l.n == len(l.buf) {
+		l.resize(max(8, 2*len(l.buf)))
+	}
+	l.head = (l.head - 1 + len(l.buf)) % len(l.buf)
+	l.buf[l.head] = value
+	l.n++
+	l.bytes += len(value)
+}
+
+func (l *listValue) pushBack(value []byte) {
+	if l.n == len(l.buf) {
+		l.resize(max(8, 2*len(l.buf)))
+	}
+	l.buf[(l.head+l.n)%len(l.buf)] = value
+	l.n++
+	l.bytes += len(value)
+}
+
+func (l *listValue) popFront() []byte {
+	value := l.buf[l.head]
+	l.buf[l.head] = nil
+	l.head = (l.head + 1) % len(l.buf)
+	l.n--
+	l.bytes -= len(value)
+	l.maybeShrink()
+	return value
+}
+
+func (l *listValue) popBack() []byte {
+	i := (l.head + l.n - 1) % len(l.buf)
+	value := l.buf[i]
+	l.buf[i] = nil
+	l.n--
+	l.bytes -= len(value)
+	l.maybeShrink()
+	return value
+}
+
+// maybeShrink releases buffer space once the list has drained to a quarter of its capacity.
+func (l *listValue) maybeShrink() {
+	if len(l.buf) > 64 && l.n < len(l.buf)/4 {
+		l.resize(len(l.buf) / 2)
+	}
+}
+
+func (l *listValue) resize(capacity int) {
+	buf := make([][]byte, capacity)
+	for i := 0; i < l.n; i++ {
+		buf[i] = l.at(i)
+	}
+	l.buf = buf
+	l.head = 0
+}
+
+// listWaiter is a client parked in BLPop. It may be registered on several keys
+// (possibly in different shards); whichever side claims it first, a pusher
+// handing over an element or the waiter giving up, wins.
+type listWaiter struct {
+	claimed atomic.Bool
+	result  chan listPop // Buffered; receives exactly one element once claimed by a pusher
+}
+
+type listPop struct {
+	key   string
+	value []byte
+}
+
+// LPush prepends values to the list stored at key, creating it if needed, and
+// returns the resulting length. Values are pushed one after another, so the last
+// one ends up at the head. Clients blocked in BLPop on key are served first.
+func (c *Cache) LPush(key string, values ...[]byte) (int, error) {
+	return c.push(key, values, true)
+}
+
+// RPush appends values to the list stored at key, creating it if needed, and
+// returns the resulting length. Clients blocked in BLPop on key are served first.
+func (c *Cache) RPush(key string, values ...[]byte) (int, error) {
+	return c.push(key, values, false)
+}
+
+func (c *Cache) push(key string, values [][]byte, front bool) (int, error) {
+	if len(values) == 0 {
+		return 0, nil
+	}
+	shard := c.shards[c.getShardIndex(key)]
+
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	entry, l, err := getOrCreateObject(shard, key, newListValue)
+	if err != nil {
+		return 0, err
+	}
+	for _, value := range values {
+		if front {
+			l.pushFront(copyValue(value))
+		} else {
+			l.pushBack(copyValue(value))
+		}
+	}
+	length := l.len()
+
+	shard.serveWaiters(key, l)
+	if l.len() == 0 {
+		// Waiters took everything: the key was written and emptied at once.
+		shard.emit(EventSet, key)
+		shard.remove(key, entry, EventDel)
+	} else {
+		shard.modified(entry)
+	}
+	return length, nil
+}
+
+// LPop removes and returns the first element of the list stored at key.
+// It returns ErrNotFound if the key does not exist.
+func (c *Cache) LPop(key string) ([]byte, error) {
+	return c.pop(key, true)
+}
+
+// RPop removes and returns the last element of the list stored at key.
+// It returns ErrNotFound if the key does not exist.
+func (c *Cache) RPop(key string) ([]byte, error) {
+	return c.pop(key, false)
+}
+
+func (c *Cache) pop(key string, front bool) ([]byte, error) {
+	shard := c.shards[c.getShardIndex(key)]
+
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	entry, l, err := getObject[*listValue](shard, key)
+	if err != nil {
+		return nil, err
+	}
+	return shard.popFrom(key, entry, l, front), nil
+}
+
+// LRange returns the elements between start and stop, both inclusive. Negative
+// indexes count from the end of the list (-1 is the last element), and out of
+// range indexes are clamped. A missing key yields an empty result.
+func (c *Cache) LRange(key string, start, stop int) ([][]byte, error) {
+	shard := c.shards[c.getShardIndex(key)]
+
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	entry, l, err := getObject[*listValue](shard, key)
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	start, stop, ok := clampRange(start, stop, l.len())
+	if !ok {
+		return nil, nil
+	}
+	values := make([][]byte, 0, stop-start+1)
+	for i := start; i <= stop; i++ {
+		values = append(values, copyValue(l.at(i)))
+	}
+	shard.lruList.MoveToFront(entry.listElement)
+	return values, nil
+}
+
+// LLen returns the length of the list stored at key.
+func (c *Cache) LLen(key string) (int, error) {
+	shard := c.shards[c.getShardIndex(key)]
+
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	_, l, err := getObject[*listValue](shard, key)
+	if err == ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return l.len(), nil
+}
+
+// BLPop pops the first element of the first non-empty list among keys. If all
+// of them are empty it blocks until another caller pushes to one of the keys or
+// ctx is done, in which case ctx.Err() is returned. Blocked callers are served
+// in arrival order, and an element pushed for a waiting caller is handed over
+// directly so it is never observed by anyone else.
+func (c *Cache) BLPop(ctx context.Context, keys ...string) (string, []byte, error) {
+	w := &listWaiter{result: make(chan listPop, 1)}
+	defer c.unregisterWaiter(w, keys)
+
+	// Register on every key, popping right away if one of them has data.
+	for _, key := range keys {
+		shard := c.shards[c.getShardIndex(key)]
+
+		c.lock(shard)
+		entry, l, err := getObject[*listValue](shard, key)
+		switch err {
+		case nil:
+			if !w.claimed.CompareAndSwap(false, true) {
+				// A pusher already served us through a key registered earlier.
+				c.unlock(shard)
+				r := <-w.result
+				return r.key, r.value, nil
+			}
+			value := shard.popFrom(key, entry, l, true)
+			c.unlock(shard)
+			return key, value, nil
+		case ErrNotFound:
+			if shard.waiters == nil {
+				shard.waiters = make(map[string][]*listWaiter)
+			}
+			shard.waiters[key] = append(shard.waiters[key], w)
+			c.unlock(shard)
+		default:
+			c.unlock(shard)
+			if !w.claimed.CompareAndSwap(false, true) {
+				r := <-w.result
+				return r.key, r.value, nil
+			}
+			return "", nil, err
+		}
+	}
+
+	select {
+	case r := <-w.result:
+		return r.key, r.value, nil
+	case <-ctx.Done():
+		if !w.claimed.CompareAndSwap(false, true) {
+			// Lost the race against a pusher; the element is already ours.
+			r := <-w.result
+			return r.key, r.value, nil
+		}
+		return "", nil, ctx.Err()
+	}
+}
+
+// unregisterWaiter removes w from the waiter queues of keys.
+func (c *Cache) unregisterWaiter(w *listWaiter, keys []string) {
+	for _, key := range keys {
+		shard := c.shards[c.getShardIndex(key)]
+
+		c.lock(shard)
+		queue := shard.waiters[key]
+		for i, queued := range queue {
+			if queued == w {
+				queue = append(queue[:i], queue[i+1:]...)
+				break
+			}
+		}
+		if len(queue) == 0 {
+			delete(shard.waiters, key)
+		} else {
+			shard.waiters[key] = queue
+		}
+		c.unlock(shard)
+	}
+}
+
+// serveWaiters hands elements from the front of l to clients blocked on key,
+// oldest first. Waiters that were already claimed elsewhere are dropped.
+// The caller must hold s.mu.
+func (s *Shard) serveWaiters(key string, l *listValue) {
+	queue := s.waiters[key]
+	for len(queue) > 0 && l.len() > 0 {
+		w := queue[0]
+		queue = queue[1:]
+		if w.claimed.CompareAndSwap(false, true) {
+			w.result <- listPop{key: key, value: l.popFront()}
+		}
+	}
+	if len(queue) == 0 {
+		delete(s.waiters, key)
+	} else {
+		s.waiters[key] = queue
+	}
+}
+
+// popFrom pops one element off a non-empty list and deletes the key once the
+// list is empty. The caller must hold s.mu.
+func (s *Shard) popFrom(key string, entry *cacheEntry, l *listValue, front bool) []byte {
+	var value []byte
+	if front {
+		value = l.popFront()
+	} else {
+		value = l.popBack()
+	}
+	if l.len() == 0 {
+		s.remove(key, entry, EventDel)
+	} else {
+		s.modified(entry)
+	}
+	return value
+}
+
+// clampRange resolves Redis-style inclusive start/stop indexes, where negative
+// values count from the end, against a sequence of length n. It reports false
+// if the range is empty.
+func clampRange(start, stop, n int) (int, int, bool) {
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop || start >= n {
+		return 0, 0, false
+	}
+	return start, stop, true
+}
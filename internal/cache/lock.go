@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrInvalidLock is returned by the lock methods for an empty owner or a
+// non-positive TTL.
+var ErrInvalidLock = errors.New("lock owner must not be empty and TTL must be positive")
+
+// lockValue is a held lock: the token of its owner and the fencing token it
+// was acquired with.
+type lockValue struct {
+	owner string
+	fence uint64
+}
+
+func (l *lockValue) size() int {
+	return len(l.owner) + 8
+}
+
+// Lock acquires the lock at key for owner, an opaque token identifying the
+// holder, for ttl. If the lock is free it returns a new fencing token and
+// true; if owner already holds it, its TTL is reset and its token returned
+// again, so a retried Lock is harmless; otherwise it returns false.
+//
+// Fencing tokens come from the shard's version counter: each acquisition gets
+// a larger one than any before it on the key, even after the lock expired, was
+// released or was evicted. A resource guarded by the lock should reject writes
+// carrying a lower token than one it has seen, which keeps it safe from a
+// holder that paused past its TTL.
+func (c *Cache) Lock(key, owner string, ttl time.Duration) (uint64, bool, error) {
+	if owner == "" || ttl <= 0 {
+		return 0, false, ErrInvalidLock
+	}
+	shard := c.shards[c.getShardIndex(key)]
+
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	entry, l, err := getObject[*lockValue](shard, key)
+	switch {
+	case err == ErrNotFound:
+		l = &lockValue{owner: owner}
+		entry = shard.put(key, nil, l)
+	case err != nil:
+		return 0, false, err
+	case l.owner != owner:
+		return 0, false, nil
+	}
+
+	entry.expiresAt = expiryAfter(ttl)
+	shard.modified(entry)
+	if l.fence == 0 {
+		l.fence = entry.version
+	}
+	return l.fence, true, nil
+}
+
+// Unlock releases the lock at key if owner holds it, and reports whether it
+// did. A lock that expired or was taken over is left alone.
+func (c *Cache) Unlock(key, owner string) (bool, error) {
+	shard := c.shards[c.getShardIndex(key)]
+
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	entry, l, err := getObject[*lockValue](shard, key)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	if err != nil || l.owner != owner {
+		return false, err
+	}
+	shard.remove(key, entry, EventDel)
+	return true, nil
+}
+
+// ExtendLock resets the TTL of the lock at key to ttl if owner holds it, and
+// reports whether it did. The fencing token does not change.
+func (c *Cache) ExtendLock(key, owner string, ttl time.Duration) (bool, error) {
+	if owner == "" || ttl <= 0 {
+		return false, ErrInvalidLock
+	}
+	shard := c.shards[c.getShardIndex(key)]
+
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	entry, l, err := getObject[*lockValue](shard, key)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	if err != nil || l.owner != owner {
+		return false, err
+	}
+	entry.expiresAt = expiryAfter(ttl)
+	shard.modified(entry)
+	return true, nil
+}
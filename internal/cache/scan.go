@@ -0,0 +1,178 @@
+package cache
+
+import (
+	"cmp"
+	"container/heap"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/jasonrowsell/zerocache/internal/glob"
+)
+
+// defaultScanCount is the number of keys Scan examines when no count is given.
+const defaultScanCount = 10
+
+// Scan walks the keyspace incrementally. Start with cursor 0 and pass the
+// returned cursor to the next call until it comes back as 0. Each call examines
+// roughly count keys and returns those matching the glob pattern match (all of
+// them if match is empty), so a call may return fewer keys than count, or none,
+// before the scan is over.
+//
+// The cursor is (shard index << 32) | h, where h is a lower bound on the high 32
+// bits of the key hash. Within a shard keys are visited in hash order and a
+// call only ever stops between two different hashes, so the position does not
+// depend on what else is in the shard: every key present for the whole scan is
+// returned at least once, however many keys are added, removed or evicted in
+// the meantime. Keys added or removed during the scan may or may not be seen.
+// Only one shard is read-locked at a time, for a pass over its keys that keeps
+// just the next count of them.
+func (c *Cache) Scan(cursor uint64, match string, count int) ([]string, uint64) {
+	if count <= 0 {
+		count = defaultScanCount
+	}
+	shardIdx, bound := cursor>>32, uint32(cursor)
+
+	var keys []string
+	examined := 0
+	for shardIdx < uint64(len(c.shards)) && examined < count {
+		batch, next, more := c.scanFrom(c.shards[shardIdx], bound, count-examined)
+		examined += len(batch)
+		for _, key := range batch {
+			if match == "" || glob.Match(match, key) {
+				keys = append(keys, key)
+			}
+		}
+		if more {
+			bound = next
+		} else {
+			shardIdx++
+			bound = 0
+		}
+	}
+
+	if shardIdx >= uint64(len(c.shards)) {
+		return keys, 0
+	}
+	return keys, shardIdx<<32 | uint64(bound)
+}
+
+// scanHash orders keys within a shard for Scan. The shard index is taken from
+// the low bits of the hash, so the high bits still spread keys evenly.
+func (c *Cache) scanHash(key string) uint32 {
+	return uint32(c.hasher.Hash(key) >> 32)
+}
+
+// scanFrom returns about limit live keys of the shard whose scan hash is at
+// least bound, in hash order. It never splits keys sharing a hash across calls,
+// so the batch may exceed limit. If more keys remain, it returns the bound to
+// resume from and true.
+//
+// The shard is read-locked for one pass over its keys that keeps the limit
+// candidates with the smallest hashes in a max-heap, so it is neither copied
+// nor sorted and only the keys returned are allocated.
+func (c *Cache) scanFrom(s *Shard, bound uint32, limit int) ([]string, uint32, bool) {
+	c.rlock(s)
+	defer c.runlock(s)
+	now := time.Now().UnixNano()
+
+	candidates := make(scanHeap, 0, limit)
+	skipped := false
+	s.scanKeys(c, now, func(cand scanCandidate) {
+		switch {
+		case cand.hash < bound:
+		case len(candidates) < limit:
+			heap.Push(&candidates, cand)
+		case cand.hash < candidates[0].hash:
+			candidates[0] = cand
+			heap.Fix(&candidates, 0)
+			skipped = true
+		default:
+			skipped = true
+		}
+	})
+	if !skipped {
+		return s.scanBatch(candidates), 0, false
+	}
+
+	// Keys sharing the largest hash kept may have been split between kept
+	// and skipped, so they are left to the next call. If they are all that
+	// was kept, they are collected in full instead.
+	top := candidates[0].hash
+	batch := candidates[:0]
+	for _, cand := range candidates {
+		if cand.hash < top {
+			batch = append(batch, cand)
+		}
+	}
+	if len(batch) > 0 {
+		return s.scanBatch(batch), top, true
+	}
+	s.scanKeys(c, now, func(cand scanCandidate) {
+		if cand.hash == top {
+			batch = append(batch, cand)
+		}
+	})
+	if top == math.MaxUint32 {
+		return s.scanBatch(batch), 0, false
+	}
+	return s.scanBatch(batch), top + 1, true
+}
+
+// scanKeys calls fn with every live key of the shard. Arena keys are passed
+// by offset, as they must be copied out of the ring. The caller must hold
+// s.mu.
+func (s *Shard) scanKeys(c *Cache, now int64, fn func(scanCandidate)) {
+	for key, entry := range s.items {
+		if !entry.expired(now) {
+			fn(scanCandidate{hash: c.scanHash(key), key: key, arenaOff: -1})
+		}
+	}
+	if s.arena == nil {
+		return
+	}
+	// The arena is indexed by the same full hash scanHash is taken from.
+	for h, off := range s.arena.index {
+		if rec := s.arena.record(int(off)); !rec.expired(now) {
+			fn(scanCandidate{hash: uint32(h >> 32), arenaOff: int(off)})
+		}
+	}
+}
+
+// scanBatch returns the keys of cands in hash order, ties broken by key. The
+// caller must hold s.mu.
+func (s *Shard) scanBatch(cands []scanCandidate) []string {
+	for i := range cands {
+		if cands[i].arenaOff >= 0 {
+			cands[i].key = s.arena.key(s.arena.record(cands[i].arenaOff))
+		}
+	}
+	slices.SortFunc(cands, func(a, b scanCandidate) int {
+		return cmp.Or(cmp.Compare(a.hash, b.hash), cmp.Compare(a.key, b.key))
+	})
+	keys := make([]string, len(cands))
+	for i, cand := range cands {
+		keys[i] = cand.key
+	}
+	return keys
+}
+
+type scanCandidate struct {
+	hash     uint32
+	key      string
+	arenaOff int // Offset of the arena record holding the key, or -1
+}
+
+// scanHeap is a max-heap of scan candidates by hash.
+type scanHeap []scanCandidate
+
+func (h scanHeap) Len() int           { return len(h) }
+func (h scanHeap) Less(i, j int) bool { return h[i].hash > h[j].hash }
+func (h scanHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *scanHeap) Push(x any)        { *h = append(*h, x.(scanCandidate)) }
+func (h *scanHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
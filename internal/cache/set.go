@@ -0,0 +1,324 @@
+package cache
+
+import (
+	"math/rand/v2"
+	"slices"
+)
+
+// setMemberOverhead approximates the per-member bookkeeping cost of a set (map slot and string header).
+const setMemberOverhead = 32
+
+// setValue is an unordered collection of unique members stored under a single key.
+type setValue struct {
+	members map[string]struct{}
+	bytes   int // Sum of member lengths
+}
+
+func newSetValue() *setValue {
+	return &setValue{members: make(map[string]struct{})}
+}
+
+func (s *setValue) size() int {
+	return s.bytes + len(s.members)*setMemberOverhead
+}
+
+// SAdd adds members to the set stored at key, creating it if needed, and
+// returns the number of members that were not already present.
+func (c *Cache) SAdd(key string, members ...string) (int, error) {
+	if len(members) == 0 {
+		return 0, nil
+	}
+	shard := c.shards[c.getShardIndex(key)]
+
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	entry, set, err := getOrCreateObject(shard, key, newSetValue)
+	if err != nil {
+		return 0, err
+	}
+
+	added := 0
+	for _, member := range members {
+		if _, found := set.members[member]; !found {
+			set.members[member] = struct{}{}
+			set.bytes += len(member)
+			added++
+		}
+	}
+	shard.modified(entry)
+	return added, nil
+}
+
+// SRem removes members from the set stored at key and returns how many were
+// removed. The key is deleted once its last member is gone.
+func (c *Cache) SRem(key string, members ...string) (int, error) {
+	shard := c.shards[c.getShardIndex(key)]
+
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	entry, set, err := getObject[*setValue](shard, key)
+	if err == ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, member := range members {
+		if _, found := set.members[member]; found {
+			delete(set.members, member)
+			set.bytes -= len(member)
+			removed++
+		}
+	}
+	if len(set.members) == 0 {
+		shard.remove(key, entry, EventDel)
+	} else if removed > 0 {
+		shard.modified(entry)
+	}
+	return removed, nil
+}
+
+// SIsMember reports whether member belongs to the set stored at key.
+func (c *Cache) SIsMember(key string, member string) (bool, error) {
+	shard := c.shards[c.getShardIndex(key)]
+
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	_, set, err := getObject[*setValue](shard, key)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	_, found := set.members[member]
+	return found, nil
+}
+
+// SMembers returns all members of the set stored at key, in no particular order.
+func (c *Cache) SMembers(key string) ([]string, error) {
+	shard := c.shards[c.getShardIndex(key)]
+
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	entry, set, err := getObject[*setValue](shard, key)
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	shard.lruList.MoveToFront(entry.listElement)
+	return setMembers(set), nil
+}
+
+// SCard returns the number of members in the set stored at key.
+func (c *Cache) SCard(key string) (int, error) {
+	shard := c.shards[c.getShardIndex(key)]
+
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	_, set, err := getObject[*setValue](shard, key)
+	if err == ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(set.members), nil
+}
+
+// SRandMember returns random members of the set stored at key without removing
+// them. A positive count returns up to count distinct members; a negative count
+// returns exactly -count members, possibly repeating some.
+func (c *Cache) SRandMember(key string, count int) ([]string, error) {
+	shard := c.shards[c.getShardIndex(key)]
+
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	_, set, err := getObject[*setValue](shard, key)
+	if err == ErrNotFound || count == 0 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if count < 0 {
+		all := setMembers(set)
+		picked := make([]string, -count)
+		for i := range picked {
+			picked[i] = all[rand.IntN(len(all))]
+		}
+		return picked, nil
+	}
+
+	if count >= len(set.members) {
+		return setMembers(set), nil
+	}
+	// Reservoir sampling keeps the pick uniform regardless of map iteration order.
+	picked := make([]string, 0, count)
+	seen := 0
+	for member := range set.members {
+		if len(picked) < count {
+			picked = append(picked, member)
+		} else if j := rand.IntN(seen + 1); j < count {
+			picked[j] = member
+		}
+		seen++
+	}
+	return picked, nil
+}
+
+// SInter returns the members present in every set stored at keys.
+// A missing key counts as an empty set.
+func (c *Cache) SInter(keys ...string) ([]string, error) {
+	unlock := c.lockShards(keys)
+	defer unlock()
+
+	sets, err := c.lockedSets(keys)
+	if err != nil {
+		return nil, err
+	}
+	for _, set := range sets {
+		if set == nil {
+			return nil, nil
+		}
+	}
+
+	// Iterate the smallest set and probe the others.
+	smallest := 0
+	for i, set := range sets {
+		if len(set.members) < len(sets[smallest].members) {
+			smallest = i
+		}
+	}
+	var result []string
+outer:
+	for member := range sets[smallest].members {
+		for i, set := range sets {
+			if i == smallest {
+				continue
+			}
+			if _, found := set.members[member]; !found {
+				continue outer
+			}
+		}
+		result = append(result, member)
+	}
+	return result, nil
+}
+
+// SUnion returns the members present in any of the sets stored at keys.
+func (c *Cache) SUnion(keys ...string) ([]string, error) {
+	unlock := c.lockShards(keys)
+	defer unlock()
+
+	sets, err := c.lockedSets(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	union := make(map[string]struct{})
+	for _, set := range sets {
+		if set == nil {
+			continue
+		}
+		for member := range set.members {
+			union[member] = struct{}{}
+		}
+	}
+	result := make([]string, 0, len(union))
+	for member := range union {
+		result = append(result, member)
+	}
+	return result, nil
+}
+
+// SDiff returns the members of the set stored at the first key that are not
+// present in any of the sets stored at the remaining keys.
+func (c *Cache) SDiff(keys ...string) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	unlock := c.lockShards(keys)
+	defer unlock()
+
+	sets, err := c.lockedSets(keys)
+	if err != nil {
+		return nil, err
+	}
+	if sets[0] == nil {
+		return nil, nil
+	}
+
+	var result []string
+outer:
+	for member := range sets[0].members {
+		for _, set := range sets[1:] {
+			if set == nil {
+				continue
+			}
+			if _, found := set.members[member]; found {
+				continue outer
+			}
+		}
+		result = append(result, member)
+	}
+	return result, nil
+}
+
+// lockedSets fetches the sets stored at keys, with nil for missing keys.
+// The caller must hold the locks of all shards involved (see lockShards).
+func (c *Cache) lockedSets(keys []string) ([]*setValue, error) {
+	sets := make([]*setValue, len(keys))
+	for i, key := range keys {
+		_, set, err := getObject[*setValue](c.shards[c.getShardIndex(key)], key)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = set
+	}
+	return sets, nil
+}
+
+// lockShards write-locks every distinct shard owning one of keys and returns a
+// function releasing them. Shards are always locked in ascending index order,
+// which gives all multi-key operations a single global lock order and so rules
+// out deadlocks between them.
+func (c *Cache) lockShards(keys []string) (unlock func()) {
+	indexes := make([]uint64, 0, len(keys))
+	for _, key := range keys {
+		indexes = append(indexes, c.getShardIndex(key))
+	}
+	slices.Sort(indexes)
+	indexes = slices.Compact(indexes)
+
+	for _, i := range indexes {
+		c.lock(c.shards[i])
+	}
+	return func() {
+		for j := len(indexes) - 1; j >= 0; j-- {
+			c.unlock(c.shards[indexes[j]])
+		}
+	}
+}
+
+func setMembers(set *setValue) []string {
+	members := make([]string, 0, len(set.members))
+	for member := range set.members {
+		members = append(members, member)
+	}
+	return members
+}
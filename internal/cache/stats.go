@@ -0,0 +1,43 @@
+package cache
+
+import "sync/atomic"
+
+// Stats is a point-in-time snapshot of a cache's size and counters.
+type Stats struct {
+	Keys      int
+	Bytes     int    // Approximate memory accounted, as reported by Bytes
+	Hits      uint64 // GET lookups that found the key
+	Misses    uint64 // GET lookups that did not
+	Evictions uint64 // Entries dropped to stay within the item or byte limits
+	Expired   uint64 // Entries dropped because their TTL passed
+}
+
+// shardStats holds a shard's counters. Hits and misses are counted under the
+// read lock and so atomically, the others under the write lock.
+type shardStats struct {
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions uint64
+	expired   uint64
+}
+
+// Stats sums the counters of all shards. Like Len, it locks every shard in
+// turn and is meant for info/metrics only.
+func (c *Cache) Stats() Stats {
+	var stats Stats
+	for _, shard := range c.shards {
+		c.rlock(shard)
+		stats.Keys += shard.lruList.Len()
+		stats.Bytes += shard.used
+		if shard.arena != nil {
+			stats.Keys += len(shard.arena.index)
+			stats.Bytes += shard.arena.live
+		}
+		stats.Hits += shard.stats.hits.Load()
+		stats.Misses += shard.stats.misses.Load()
+		stats.Evictions += shard.stats.evictions
+		stats.Expired += shard.stats.expired
+		c.runlock(shard)
+	}
+	return stats
+}
@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"slices"
+	"time"
+)
+
+// tagOverhead approximates the per-tag bookkeeping cost of an entry (slice slot
+// plus its slot in the shard's tag index).
+const tagOverhead = 48
+
+// SetWithTags stores value under key like Set and attaches tags to the entry,
+// so that InvalidateTag can later drop it. The tags replace any the entry had;
+// overwriting the key without tags (e.g. a plain Set) removes them. It returns
+// the entry's new version.
+func (c *Cache) SetWithTags(key string, value []byte, tags ...string) uint64 {
+	shard := c.shards[c.getShardIndex(key)]
+	valueCopy, compressed := c.encodeValue(value)
+	tags = slices.Compact(slices.Sorted(slices.Values(tags)))
+
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	entry := shard.store(key, valueCopy, nil)
+	entry.compressed = compressed
+	shard.tag(key, entry, tags)
+	return entry.version
+}
+
+// InvalidateTag deletes every entry carrying tag and returns how many were
+// removed. Shards are processed one at a time.
+func (c *Cache) InvalidateTag(tag string) int {
+	removed := 0
+	for _, shard := range c.shards {
+		c.lock(shard)
+		now := time.Now().UnixNano()
+		// remove unindexes each key, which is safe while ranging over the map.
+		for key := range shard.tags[tag] {
+			entry := shard.items[key]
+			if entry.expired(now) {
+				shard.remove(key, entry, EventExpired)
+				continue
+			}
+			shard.remove(key, entry, EventDel)
+			removed++
+		}
+		c.unlock(shard)
+	}
+	return removed
+}
+
+// tag attaches tags to an untagged entry and indexes them.
+// The caller must hold s.mu.
+func (s *Shard) tag(key string, entry *cacheEntry, tags []string) {
+	if len(tags) == 0 {
+		return
+	}
+	if s.tags == nil {
+		s.tags = make(map[string]map[string]struct{})
+	}
+	for _, tag := range tags {
+		keys := s.tags[tag]
+		if keys == nil {
+			keys = make(map[string]struct{})
+			s.tags[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+	entry.tags = tags
+	s.resized(entry)
+}
+
+// untag detaches all tags from entry and drops them from the index. It does
+// not re-account the entry's size. The caller must hold s.mu.
+func (s *Shard) untag(key string, entry *cacheEntry) {
+	for _, tag := range entry.tags {
+		keys := s.tags[tag]
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(s.tags, tag)
+		}
+	}
+	entry.tags = nil
+}
@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrInvalidThrottle is returned by Throttle for a non-positive limit, window
+// or cost, a cost above the limit, which could never be allowed, or a window
+// longer than maxThrottleWindow.
+var ErrInvalidThrottle = errors.New("throttle limit, window and cost must be positive, with cost at most limit and window at most 100 years")
+
+// maxThrottleWindow keeps arrival times, in Unix nanoseconds, within an int64.
+const maxThrottleWindow = 100 * 365 * 24 * time.Hour
+
+// throttleValue is the state of a rate limiter: the theoretical arrival time
+// (TAT) of GCRA, in Unix nanoseconds. A TAT in the past means a full quota.
+type throttleValue struct {
+	tat int64
+}
+
+func (t *throttleValue) size() int {
+	return 8
+}
+
+// ThrottleResult is the outcome of a call to Throttle.
+type ThrottleResult struct {
+	Allowed    bool
+	Remaining  int           // Requests of cost 1 that would be allowed right now
+	RetryAfter time.Duration // Until the request would be allowed; 0 if it was
+	ResetAfter time.Duration // Until the full quota is available again
+}
+
+// Throttle applies a rate limit of limit requests per window to key using the
+// generic cell rate algorithm: requests are allowed in bursts of up to limit,
+// after which quota comes back smoothly, one request every window/limit. A
+// request counts cost times. Denied requests do not use up quota.
+//
+// The limiter state lives in the cache like any other value, expires once the
+// quota is full again and is updated under the shard lock, so concurrent
+// callers cannot exceed the limit. It returns ErrWrongType if key holds
+// anything else.
+func (c *Cache) Throttle(key string, limit int, window time.Duration, cost int) (ThrottleResult, error) {
+	if limit <= 0 || window <= 0 || window > maxThrottleWindow || cost <= 0 || cost > limit {
+		return ThrottleResult{}, ErrInvalidThrottle
+	}
+	interval := max(int64(window)/int64(limit), 1) // Emission interval per request
+	shard := c.shards[c.getShardIndex(key)]
+
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	now := time.Now().UnixNano()
+	tat := now
+	entry, t, err := getObject[*throttleValue](shard, key)
+	switch {
+	case err == nil:
+		tat = max(t.tat, now)
+	case err != ErrNotFound:
+		return ThrottleResult{}, err
+	}
+
+	newTAT := tat + int64(cost)*interval
+	if allowAt := newTAT - int64(window); now < allowAt {
+		return ThrottleResult{
+			Remaining:  int((now + int64(window) - tat) / interval),
+			RetryAfter: time.Duration(allowAt - now),
+			ResetAfter: time.Duration(tat - now),
+		}, nil
+	}
+
+	if entry == nil {
+		t = &throttleValue{}
+		entry = shard.put(key, nil, t)
+	}
+	t.tat = newTAT
+	entry.expiresAt = newTAT
+	shard.modified(entry)
+	return ThrottleResult{
+		Allowed:    true,
+		Remaining:  int((now + int64(window) - newTAT) / interval),
+		ResetAfter: time.Duration(newTAT - now),
+	}, nil
+}
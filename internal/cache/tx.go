@@ -0,0 +1,123 @@
+package cache
+
+import "slices"
+
+// txLocks marks a transaction view of a cache (see Atomically) and records
+// the shards it holds. Views skip locking and only check that a shard is held.
+type txLocks struct {
+	held  []bool // By shard index
+	ended bool
+}
+
+// Atomically runs fn with the shards owning keys write-locked, so that fn's
+// operations on those keys take effect at once as far as any other caller can
+// tell. Shards are locked in ascending index order, like every multi-key
+// operation, which rules out deadlocks.
+//
+// fn receives a transaction view of c. The view supports every Cache method
+// except BLPop, which would wait forever, as long as it only touches keys
+// passed to Atomically; anything else panics, as does using the view after fn
+// returns. Methods that visit every shard (Scan, FlushAll, Stats, ...) need
+// AtomicallyAll.
+func (c *Cache) Atomically(keys []string, fn func(tx *Cache)) {
+	indexes := make([]int, 0, len(keys))
+	for _, key := range keys {
+		indexes = append(indexes, int(c.getShardIndex(key)))
+	}
+	slices.Sort(indexes)
+	c.atomically(slices.Compact(indexes), fn)
+}
+
+// AtomicallyAll is Atomically with every shard locked, for transactions that
+// cannot name their keys up front. It stalls the whole cache while fn runs.
+func (c *Cache) AtomicallyAll(fn func(tx *Cache)) {
+	indexes := make([]int, len(c.shards))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	c.atomically(indexes, fn)
+}
+
+// atomically locks the shards at indexes, which must be sorted and distinct,
+// and runs fn on a view holding them.
+func (c *Cache) atomically(indexes []int, fn func(tx *Cache)) {
+	if c.tx != nil {
+		// Nested in another transaction: its locks must already cover these.
+		for _, i := range indexes {
+			c.tx.check(c.shards[i])
+		}
+		fn(c)
+		return
+	}
+
+	tx := &txLocks{held: make([]bool, len(c.shards))}
+	for _, i := range indexes {
+		c.shards[i].mu.Lock()
+		tx.held[i] = true
+	}
+	defer func() {
+		tx.ended = true
+		for j := len(indexes) - 1; j >= 0; j-- {
+			c.shards[indexes[j]].mu.Unlock()
+		}
+	}()
+
+	view := *c
+	view.tx = tx
+	fn(&view)
+}
+
+// check panics unless the transaction is live and holds s.
+func (tx *txLocks) check(s *Shard) {
+	if tx.ended {
+		panic("cache: transaction used after it ended")
+	}
+	if !tx.held[s.index] {
+		panic("cache: transaction touched a shard it did not lock")
+	}
+}
+
+// lock write-locks s, or checks that a transaction view holds it.
+func (c *Cache) lock(s *Shard) {
+	if c.tx != nil {
+		c.tx.check(s)
+		return
+	}
+	s.mu.Lock()
+}
+
+func (c *Cache) unlock(s *Shard) {
+	if c.tx == nil {
+		s.mu.Unlock()
+	}
+}
+
+// rlock read-locks s, or checks that a transaction view holds it.
+func (c *Cache) rlock(s *Shard) {
+	if c.tx != nil {
+		c.tx.check(s)
+		return
+	}
+	s.mu.RLock()
+}
+
+func (c *Cache) runlock(s *Shard) {
+	if c.tx == nil {
+		s.mu.RUnlock()
+	}
+}
+
+// Version returns the current version of key, or 0 if it does not exist.
+// Any write to the key changes its version, so comparing versions tells
+// whether a key was modified, e.g. to implement optimistic WATCH. Unlike
+// GetWithVersion it works for every kind of value and neither counts as a
+// hit nor refreshes the key's LRU position.
+func (c *Cache) Version(key string) uint64 {
+	shard := c.shards[c.getShardIndex(key)]
+
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	version, _ := shard.currentVersion(key)
+	return version
+}
@@ -0,0 +1,425 @@
+package cache
+
+import (
+	"math"
+	"math/rand/v2"
+)
+
+const (
+	zskiplistMaxLevel = 32
+	zskiplistP        = 0.25 // Probability of a node being promoted to the next level
+
+	// zsetMemberOverhead approximates the per-member bookkeeping cost of a sorted
+	// set (map slot plus skiplist node with its average level array).
+	zsetMemberOverhead = 96
+)
+
+// ScoredMember is a member of a sorted set together with its score.
+type ScoredMember struct {
+	Member string
+	Score  float64
+}
+
+// zsetValue is a sorted set: a map for O(1) score lookups plus a skiplist
+// ordered by (score, member) for range and rank queries.
+type zsetValue struct {
+	scores map[string]float64
+	zsl    *zskiplist
+	bytes  int // Sum of member lengths
+}
+
+func newZSetValue() *zsetValue {
+	return &zsetValue{
+		scores: make(map[string]float64),
+		zsl:    newZskiplist(),
+	}
+}
+
+func (z *zsetValue) size() int {
+	// Members are held by both the map and the skiplist.
+	return 2*z.bytes + len(z.scores)*zsetMemberOverhead
+}
+
+// zskiplist is a skiplist whose links carry spans (the number of level-0 nodes
+// they skip), which makes rank lookups logarithmic. It follows the design of
+// the Redis zskiplist.
+type zskiplist struct {
+	head   *zskipNode
+	tail   *zskipNode
+	length int
+	level  int
+}
+
+type zskipNode struct {
+	member   string
+	score    float64
+	backward *zskipNode
+	level    []zskipLevel
+}
+
+type zskipLevel struct {
+	forward *zskipNode
+	span    int
+}
+
+func newZskiplist() *zskiplist {
+	return &zskiplist{
+		head:  &zskipNode{level: make([]zskipLevel, zskiplistMaxLevel)},
+		level: 1,
+	}
+}
+
+func zslRandomLevel() int {
+	level := 1
+	for level < zskiplistMaxLevel && rand.Float64() < zskiplistP {
+		level++
+	}
+	return level
+}
+
+// before reports whether n orders strictly before (score, member).
+func (n *zskipNode) before(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+// insert adds a node; the member must not already be present.
+func (zsl *zskiplist) insert(score float64, member string) {
+	var update [zskiplistMaxLevel]*zskipNode
+	var rank [zskiplistMaxLevel]int
+
+	x := zsl.head
+	for i := zsl.level - 1; i >= 0; i-- {
+		if i < zsl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := zslRandomLevel()
+	if level > zsl.level {
+		for i := zsl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = zsl.head
+			update[i].level[i].span = zsl.length
+		}
+		zsl.level = level
+	}
+
+	x = &zskipNode{member: member, score: score, level: make([]zskipLevel, level)}
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < zsl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != zsl.head {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		zsl.tail = x
+	}
+	zsl.length++
+}
+
+// delete removes the node for (score, member) and reports whether it was found.
+func (zsl *zskiplist) delete(score float64, member string) bool {
+	var update [zskiplistMaxLevel]*zskipNode
+
+	x := zsl.head
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	x = x.level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+
+	for i := 0; i < zsl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		zsl.tail = x.backward
+	}
+	for zsl.level > 1 && zsl.head.level[zsl.level-1].forward == nil {
+		zsl.level--
+	}
+	zsl.length--
+	return true
+}
+
+// rank returns the 1-based rank of (score, member), or 0 if it is not present.
+func (zsl *zskiplist) rank(score float64, member string) int {
+	rank := 0
+	x := zsl.head
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil &&
+			(x.level[i].forward.before(score, member) || (x.level[i].forward.score == score && x.level[i].forward.member == member)) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+		if x != zsl.head && x.score == score && x.member == member {
+			return rank
+		}
+	}
+	return 0
+}
+
+// byRank returns the node at the given 1-based rank, or nil if out of range.
+func (zsl *zskiplist) byRank(rank int) *zskipNode {
+	traversed := 0
+	x := zsl.head
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
+
+// firstGE returns the first node with a score of at least min, or nil.
+func (zsl *zskiplist) firstGE(min float64) *zskipNode {
+	x := zsl.head
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.score < min {
+			x = x.level[i].forward
+		}
+	}
+	return x.level[0].forward
+}
+
+// lastLE returns the last node with a score of at most max, or nil.
+func (zsl *zskiplist) lastLE(max float64) *zskipNode {
+	x := zsl.head
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.score <= max {
+			x = x.level[i].forward
+		}
+	}
+	if x == zsl.head {
+		return nil
+	}
+	return x
+}
+
+// ZAdd adds members to the sorted set stored at key, or updates the scores of
+// members already present, and returns the number of members newly added.
+func (c *Cache) ZAdd(key string, members ...ScoredMember) (int, error) {
+	if len(members) == 0 {
+		return 0, nil
+	}
+	for _, m := range members {
+		if math.IsNaN(m.Score) {
+			return 0, ErrInvalidScore
+		}
+	}
+	shard := c.shards[c.getShardIndex(key)]
+
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	entry, z, err := getOrCreateObject(shard, key, newZSetValue)
+	if err != nil {
+		return 0, err
+	}
+
+	added := 0
+	for _, m := range members {
+		if old, found := z.scores[m.Member]; found {
+			if old == m.Score {
+				continue
+			}
+			z.zsl.delete(old, m.Member)
+		} else {
+			z.bytes += len(m.Member)
+			added++
+		}
+		z.scores[m.Member] = m.Score
+		z.zsl.insert(m.Score, m.Member)
+	}
+	shard.modified(entry)
+	return added, nil
+}
+
+// ZRem removes members from the sorted set stored at key and returns how many
+// were removed. The key is deleted once its last member is gone.
+func (c *Cache) ZRem(key string, members ...string) (int, error) {
+	shard := c.shards[c.getShardIndex(key)]
+
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	entry, z, err := getObject[*zsetValue](shard, key)
+	if err == ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, member := range members {
+		if score, found := z.scores[member]; found {
+			z.zsl.delete(score, member)
+			delete(z.scores, member)
+			z.bytes -= len(member)
+			removed++
+		}
+	}
+	if len(z.scores) == 0 {
+		shard.remove(key, entry, EventDel)
+	} else if removed > 0 {
+		shard.modified(entry)
+	}
+	return removed, nil
+}
+
+// ZScore returns the score of member in the sorted set stored at key.
+// It returns ErrNotFound if either the key or the member does not exist.
+func (c *Cache) ZScore(key string, member string) (float64, error) {
+	shard := c.shards[c.getShardIndex(key)]
+
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	_, z, err := getObject[*zsetValue](shard, key)
+	if err != nil {
+		return 0, err
+	}
+	score, found := z.scores[member]
+	if !found {
+		return 0, ErrNotFound
+	}
+	return score, nil
+}
+
+// ZRank returns the 0-based rank of member ordered by ascending score, or by
+// descending score if reverse is set. It returns ErrNotFound if either the key
+// or the member does not exist.
+func (c *Cache) ZRank(key string, member string, reverse bool) (int, error) {
+	shard := c.shards[c.getShardIndex(key)]
+
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	_, z, err := getObject[*zsetValue](shard, key)
+	if err != nil {
+		return 0, err
+	}
+	score, found := z.scores[member]
+	if !found {
+		return 0, ErrNotFound
+	}
+	rank := z.zsl.rank(score, member)
+	if reverse {
+		return z.zsl.length - rank, nil
+	}
+	return rank - 1, nil
+}
+
+// ZRange returns members by rank between start and stop, both inclusive, in
+// ascending score order or descending if reverse is set. Indexes follow LRange:
+// negative values count from the end.
+func (c *Cache) ZRange(key string, start, stop int, reverse bool) ([]ScoredMember, error) {
+	shard := c.shards[c.getShardIndex(key)]
+
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	entry, z, err := getObject[*zsetValue](shard, key)
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	start, stop, ok := clampRange(start, stop, z.zsl.length)
+	if !ok {
+		return nil, nil
+	}
+	members := make([]ScoredMember, 0, stop-start+1)
+	if reverse {
+		for x := z.zsl.byRank(z.zsl.length - start); x != nil && len(members) < cap(members); x = x.backward {
+			members = append(members, ScoredMember{Member: x.member, Score: x.score})
+		}
+	} else {
+		for x := z.zsl.byRank(start + 1); x != nil && len(members) < cap(members); x = x.level[0].forward {
+			members = append(members, ScoredMember{Member: x.member, Score: x.score})
+		}
+	}
+	shard.lruList.MoveToFront(entry.listElement)
+	return members, nil
+}
+
+// ZRangeByScore returns members whose score lies between min and max, both
+// inclusive, in ascending score order or descending if reverse is set. Use
+// math.Inf for open-ended ranges.
+func (c *Cache) ZRangeByScore(key string, min, max float64, reverse bool) ([]ScoredMember, error) {
+	shard := c.shards[c.getShardIndex(key)]
+
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	entry, z, err := getObject[*zsetValue](shard, key)
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var members []ScoredMember
+	if reverse {
+		for x := z.zsl.lastLE(max); x != nil && x.score >= min; x = x.backward {
+			members = append(members, ScoredMember{Member: x.member, Score: x.score})
+		}
+	} else {
+		for x := z.zsl.firstGE(min); x != nil && x.score <= max; x = x.level[0].forward {
+			members = append(members, ScoredMember{Member: x.member, Score: x.score})
+		}
+	}
+	shard.lruList.MoveToFront(entry.listElement)
+	return members, nil
+}
+
+// ZCard returns the number of members in the sorted set stored at key.
+func (c *Cache) ZCard(key string) (int, error) {
+	shard := c.shards[c.getShardIndex(key)]
+
+	c.lock(shard)
+	defer c.unlock(shard)
+
+	_, z, err := getObject[*zsetValue](shard, key)
+	if err == ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(z.scores), nil
+}
@@ -0,0 +1,141 @@
+// Package glob implements the Redis-style glob patterns used to select keys.
+//
+// Supported syntax:
+//   - '*' matches any sequence of bytes, including the empty one
+//   - '?' matches exactly one byte
+//   - "[abc]" matches one of the listed bytes; ranges such as "[a-z]" are allowed
+//   - "[^abc]" matches any byte not listed ("[!abc]" is accepted too)
+//   - a backslash matches the following byte literally
+//
+// Matching works on bytes, not runes, the same way keys are compared everywhere else.
+package glob
+
+import "strings"
+
+// Match reports whether s matches pattern. A malformed class (an unterminated
+// '[') matches the '[' literally.
+func Match(pattern, s string) bool {
+	p, i := 0, 0
+	// Position to resume from when the most recent '*' has to absorb one more byte.
+	starP, starI := -1, 0
+
+	for i < len(s) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				// Collapse consecutive stars and remember where to backtrack to.
+				for p < len(pattern) && pattern[p] == '*' {
+					p++
+				}
+				if p == len(pattern) {
+					return true
+				}
+				starP, starI = p, i
+				continue
+			case '?':
+				p++
+				i++
+				continue
+			case '[':
+				if matched, next, ok := matchClass(pattern, p, s[i]); ok {
+					if matched {
+						p = next
+						i++
+						continue
+					}
+				} else if s[i] == '[' {
+					p++
+					i++
+					continue
+				}
+			case '\\':
+				if p+1 < len(pattern) {
+					if pattern[p+1] == s[i] {
+						p += 2
+						i++
+						continue
+					}
+				} else if s[i] == '\\' {
+					p++
+					i++
+					continue
+				}
+			default:
+				if pattern[p] == s[i] {
+					p++
+					i++
+					continue
+				}
+			}
+		}
+		// Mismatch: let the last star swallow one more byte, if there is one.
+		if starP < 0 {
+			return false
+		}
+		starI++
+		p, i = starP, starI
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchClass matches c against the class starting at pattern[start] == '['. It
+// returns whether c matched, the index just past the closing ']', and false if
+// the class is not terminated.
+func matchClass(pattern string, start int, c byte) (matched bool, next int, ok bool) {
+	p := start + 1
+	negate := false
+	if p < len(pattern) && (pattern[p] == '^' || pattern[p] == '!') {
+		negate = true
+		p++
+	}
+
+	first := true
+	for p < len(pattern) {
+		// A ']' right after the opening bracket is a literal member of the class.
+		if pattern[p] == ']' && !first {
+			return matched != negate, p + 1, true
+		}
+		first = false
+
+		lo := pattern[p]
+		if lo == '\\' && p+1 < len(pattern) {
+			p++
+			lo = pattern[p]
+		}
+		p++
+
+		hi := lo
+		if p+1 < len(pattern) && pattern[p] == '-' && pattern[p+1] != ']' {
+			hi = pattern[p+1]
+			if hi == '\\' && p+2 < len(pattern) {
+				p++
+				hi = pattern[p+1]
+			}
+			p += 2
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+		}
+		if lo <= c && c <= hi {
+			matched = true
+		}
+	}
+	return false, 0, false
+}
+
+// Escape returns a pattern that matches s literally.
+func Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
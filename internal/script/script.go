@@ -0,0 +1,759 @@
+// Package script implements the small Lisp that EVAL runs on the server.
+//
+// A script is a sequence of expressions; its result is the value of the last
+// one. Values are nil, booleans, 64-bit integers, byte strings and lists.
+// Only nil and false are falsy.
+//
+// Special forms:
+//   - (if cond then [else])
+//   - (let ((name expr) ...) body ...) binds names in order, then runs body
+//   - (set name expr) assigns to a bound name; other forms of set call the
+//     host's set function, if any
+//   - (do expr ...), (and expr ...), (or expr ...)
+//   - (while cond body ...) loops and yields nil
+//
+// Builtins are + - * / % (integers), = != (any values), < <= > >= (two
+// integers or two strings), not, nil?, concat, str, int, list, len, nth,
+// append and error. The host adds its own functions and variables, such as
+// the cache operations and KEYS and ARGV on the server.
+//
+// Scripts are sandboxed: they can only call the functions they are given,
+// and every run is bounded by a step and time limit, as well as limits on
+// nesting depth and the size of strings and lists.
+package script
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Value is a script value: nil, bool, int64, string or []Value.
+type Value = any
+
+// Func is a function callable from scripts. It receives evaluated arguments.
+type Func func(args []Value) (Value, error)
+
+// Limits bound a single run. Zero fields mean no limit.
+type Limits struct {
+	MaxSteps int           // Expressions evaluated
+	Timeout  time.Duration // Wall-clock time
+}
+
+var (
+	ErrStepLimit = errors.New("script exceeded its step limit")
+	ErrTimeout   = errors.New("script exceeded its time limit")
+)
+
+const (
+	maxDepth     = 100     // Nesting of expressions, bounding stack use
+	maxStringLen = 1 << 20 // Bytes in a string built by a script
+	maxListLen   = 1 << 16 // Elements in a list built by a script
+
+	// timeCheckInterval is how many steps run between deadline checks.
+	timeCheckInterval = 256
+)
+
+// Error is a script error, located at a line of the source.
+type Error struct {
+	Line int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("script error at line %d: %s", e.Line, e.Msg)
+}
+
+// Program is a compiled script. It is immutable and safe for concurrent use.
+type Program struct {
+	forms []*node
+}
+
+type nodeKind uint8
+
+const (
+	nodeInt nodeKind = iota
+	nodeString
+	nodeSymbol
+	nodeList
+)
+
+type node struct {
+	kind nodeKind
+	line int
+	num  int64
+	str  string // String literal or symbol name
+	list []*node
+}
+
+// Compile parses src into a Program.
+func Compile(src string) (*Program, error) {
+	p := &parser{src: src, line: 1}
+	var forms []*node
+	for {
+		p.skipSpace()
+		if p.pos == len(p.src) {
+			break
+		}
+		n, err := p.parse(0)
+		if err != nil {
+			return nil, err
+		}
+		forms = append(forms, n)
+	}
+	if len(forms) == 0 {
+		return nil, &Error{Line: 1, Msg: "empty script"}
+	}
+	return &Program{forms: forms}, nil
+}
+
+type parser struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return &Error{Line: p.line, Msg: fmt.Sprintf(format, args...)}
+}
+
+// skipSpace skips whitespace and ; comments.
+func (p *parser) skipSpace() {
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; {
+		case c == '\n':
+			p.line++
+			p.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == ';':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *parser) parse(depth int) (*node, error) {
+	if depth > maxDepth {
+		return nil, p.errorf("expressions nested deeper than %d", maxDepth)
+	}
+	p.skipSpace()
+	if p.pos == len(p.src) {
+		return nil, p.errorf("unexpected end of script")
+	}
+
+	switch p.src[p.pos] {
+	case '(':
+		n := &node{kind: nodeList, line: p.line}
+		p.pos++
+		for {
+			p.skipSpace()
+			if p.pos == len(p.src) {
+				return nil, &Error{Line: n.line, Msg: "unclosed parenthesis"}
+			}
+			if p.src[p.pos] == ')' {
+				p.pos++
+				return n, nil
+			}
+			elem, err := p.parse(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			n.list = append(n.list, elem)
+		}
+	case ')':
+		return nil, p.errorf("unexpected )")
+	case '"':
+		return p.parseString()
+	}
+
+	start := p.pos
+	for p.pos < len(p.src) && !strings.ContainsRune(" \t\r\n();\"", rune(p.src[p.pos])) {
+		p.pos++
+	}
+	atom := p.src[start:p.pos]
+	if c := atom[0]; c >= '0' && c <= '9' || (c == '-' && len(atom) > 1 && atom[1] >= '0' && atom[1] <= '9') {
+		num, err := strconv.ParseInt(atom, 10, 64)
+		if err != nil {
+			return nil, p.errorf("invalid integer %q", atom)
+		}
+		return &node{kind: nodeInt, line: p.line, num: num}, nil
+	}
+	return &node{kind: nodeSymbol, line: p.line, str: atom}, nil
+}
+
+func (p *parser) parseString() (*node, error) {
+	n := &node{kind: nodeString, line: p.line}
+	var b strings.Builder
+	p.pos++ // Opening quote
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		p.pos++
+		switch c {
+		case '"':
+			n.str = b.String()
+			return n, nil
+		case '\n':
+			p.line++
+		case '\\':
+			if p.pos == len(p.src) {
+				break
+			}
+			switch esc := p.src[p.pos]; esc {
+			case 'n':
+				c = '\n'
+			case 't':
+				c = '\t'
+			case '"', '\\':
+				c = esc
+			default:
+				return nil, p.errorf("invalid escape \\%c", esc)
+			}
+			p.pos++
+		}
+		b.WriteByte(c)
+	}
+	return nil, &Error{Line: n.line, Msg: "unterminated string"}
+}
+
+// Run evaluates the program with vars bound as global variables and funcs
+// callable next to the builtins, and returns the value of its last expression.
+func (p *Program) Run(vars map[string]Value, funcs map[string]Func, limits Limits) (Value, error) {
+	in := &interp{
+		funcs:    funcs,
+		scopes:   []map[string]Value{vars},
+		maxSteps: limits.MaxSteps,
+	}
+	if in.scopes[0] == nil {
+		in.scopes[0] = make(map[string]Value)
+	}
+	if limits.Timeout > 0 {
+		in.deadline = time.Now().Add(limits.Timeout)
+	}
+
+	var result Value
+	for _, form := range p.forms {
+		var err error
+		if result, err = in.eval(form); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+type interp struct {
+	funcs    map[string]Func
+	scopes   []map[string]Value // Innermost last
+	steps    int
+	maxSteps int
+	deadline time.Time
+}
+
+// located attaches n's line to err unless it already carries one.
+func located(n *node, err error) error {
+	var scriptErr *Error
+	if err == nil || errors.As(err, &scriptErr) || err == ErrStepLimit || err == ErrTimeout {
+		return err
+	}
+	return &Error{Line: n.line, Msg: err.Error()}
+}
+
+func (in *interp) eval(n *node) (Value, error) {
+	in.steps++
+	if in.maxSteps > 0 && in.steps > in.maxSteps {
+		return nil, ErrStepLimit
+	}
+	if in.steps%timeCheckInterval == 0 && !in.deadline.IsZero() && time.Now().After(in.deadline) {
+		return nil, ErrTimeout
+	}
+
+	switch n.kind {
+	case nodeInt:
+		return n.num, nil
+	case nodeString:
+		return n.str, nil
+	case nodeSymbol:
+		v, err := in.lookup(n.str)
+		return v, located(n, err)
+	}
+
+	if len(n.list) == 0 {
+		return nil, &Error{Line: n.line, Msg: "empty expression ()"}
+	}
+	head := n.list[0]
+	if head.kind != nodeSymbol {
+		return nil, &Error{Line: n.line, Msg: "expression must start with a name"}
+	}
+	if form, ok := specialForms[head.str]; ok {
+		v, err := form(in, n)
+		return v, located(n, err)
+	}
+
+	fn, ok := in.funcs[head.str]
+	if !ok {
+		if fn, ok = builtins[head.str]; !ok {
+			return nil, &Error{Line: n.line, Msg: fmt.Sprintf("unknown function %q", head.str)}
+		}
+	}
+	return in.call(n, fn)
+}
+
+// call evaluates the arguments of n and passes them to fn.
+func (in *interp) call(n *node, fn Func) (Value, error) {
+	args := make([]Value, len(n.list)-1)
+	for i, arg := range n.list[1:] {
+		v, err := in.eval(arg)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	v, err := fn(args)
+	return v, located(n, err)
+}
+
+func (in *interp) lookup(name string) (Value, error) {
+	switch name {
+	case "nil":
+		return nil, nil
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	for i := len(in.scopes) - 1; i >= 0; i-- {
+		if v, ok := in.scopes[i][name]; ok {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("undefined variable %q", name)
+}
+
+// evalBody evaluates forms in order and returns the last value.
+func (in *interp) evalBody(forms []*node) (Value, error) {
+	var result Value
+	for _, form := range forms {
+		var err error
+		if result, err = in.eval(form); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func truthy(v Value) bool {
+	return v != nil && v != false
+}
+
+var specialForms map[string]func(in *interp, n *node) (Value, error)
+
+func init() {
+	// Assigned in init because the forms call back into eval.
+	specialForms = map[string]func(in *interp, n *node) (Value, error){
+		"if":    evalIf,
+		"let":   evalLet,
+		"set":   evalSet,
+		"do":    func(in *interp, n *node) (Value, error) { return in.evalBody(n.list[1:]) },
+		"and":   evalAnd,
+		"or":    evalOr,
+		"while": evalWhile,
+	}
+}
+
+func evalIf(in *interp, n *node) (Value, error) {
+	if len(n.list) != 3 && len(n.list) != 4 {
+		return nil, errors.New("if expects a condition, a then branch and an optional else branch")
+	}
+	cond, err := in.eval(n.list[1])
+	if err != nil {
+		return nil, err
+	}
+	if truthy(cond) {
+		return in.eval(n.list[2])
+	}
+	if len(n.list) == 4 {
+		return in.eval(n.list[3])
+	}
+	return nil, nil
+}
+
+func evalLet(in *interp, n *node) (Value, error) {
+	if len(n.list) < 2 || n.list[1].kind != nodeList {
+		return nil, errors.New("let expects a list of (name value) bindings")
+	}
+	scope := make(map[string]Value)
+	in.scopes = append(in.scopes, scope)
+	defer func() { in.scopes = in.scopes[:len(in.scopes)-1] }()
+
+	for _, binding := range n.list[1].list {
+		if binding.kind != nodeList || len(binding.list) != 2 || binding.list[0].kind != nodeSymbol {
+			return nil, errors.New("let expects a list of (name value) bindings")
+		}
+		v, err := in.eval(binding.list[1])
+		if err != nil {
+			return nil, err
+		}
+		scope[binding.list[0].str] = v
+	}
+	return in.evalBody(n.list[2:])
+}
+
+// evalSet assigns to a variable. Any other use of set, such as (set "key"
+// value ttl), calls the host's set function if it has one, so hosts can offer
+// a set of their own.
+func evalSet(in *interp, n *node) (Value, error) {
+	if len(n.list) != 3 || n.list[1].kind != nodeSymbol {
+		if fn, ok := in.funcs["set"]; ok {
+			return in.call(n, fn)
+		}
+		return nil, errors.New("set expects a name and a value")
+	}
+	name := n.list[1].str
+	v, err := in.eval(n.list[2])
+	if err != nil {
+		return nil, err
+	}
+	for i := len(in.scopes) - 1; i >= 0; i-- {
+		if _, ok := in.scopes[i][name]; ok {
+			in.scopes[i][name] = v
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("set of undefined variable %q", name)
+}
+
+func evalAnd(in *interp, n *node) (Value, error) {
+	var v Value = true
+	for _, arg := range n.list[1:] {
+		var err error
+		if v, err = in.eval(arg); err != nil || !truthy(v) {
+			return v, err
+		}
+	}
+	return v, nil
+}
+
+func evalOr(in *interp, n *node) (Value, error) {
+	var v Value
+	for _, arg := range n.list[1:] {
+		var err error
+		if v, err = in.eval(arg); err != nil || truthy(v) {
+			return v, err
+		}
+	}
+	return v, nil
+}
+
+func evalWhile(in *interp, n *node) (Value, error) {
+	if len(n.list) < 2 {
+		return nil, errors.New("while expects a condition")
+	}
+	for {
+		cond, err := in.eval(n.list[1])
+		if err != nil {
+			return nil, err
+		}
+		if !truthy(cond) {
+			return nil, nil
+		}
+		if _, err := in.evalBody(n.list[2:]); err != nil {
+			return nil, err
+		}
+	}
+}
+
+var builtins = map[string]Func{
+	"+":      arith("+", func(a, b int64) (int64, bool) { r := a + b; return r, (r > a) == (b > 0) }),
+	"*":      arith("*", mulChecked),
+	"-":      sub,
+	"/":      divmod("/"),
+	"%":      divmod("%"),
+	"=":      func(args []Value) (Value, error) { return compareEqual(args) },
+	"!=":     func(args []Value) (Value, error) { eq, err := compareEqual(args); return !eq, err },
+	"<":      order("<", func(c int) bool { return c < 0 }),
+	"<=":     order("<=", func(c int) bool { return c <= 0 }),
+	">":      order(">", func(c int) bool { return c > 0 }),
+	">=":     order(">=", func(c int) bool { return c >= 0 }),
+	"not":    unary("not", func(v Value) (Value, error) { return !truthy(v), nil }),
+	"nil?":   unary("nil?", func(v Value) (Value, error) { return v == nil, nil }),
+	"str":    unary("str", func(v Value) (Value, error) { return ToString(v) }),
+	"int":    unary("int", toInt),
+	"len":    unary("len", length),
+	"concat": concat,
+	"list":   newList,
+	"nth":    nth,
+	"append": appendList,
+	"error":  raise,
+}
+
+func arith(name string, op func(a, b int64) (int64, bool)) Func {
+	return func(args []Value) (Value, error) {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("%s expects at least one argument", name)
+		}
+		acc, err := intArg(name, args[0])
+		if err != nil {
+			return nil, err
+		}
+		for _, arg := range args[1:] {
+			n, err := intArg(name, arg)
+			if err != nil {
+				return nil, err
+			}
+			var ok bool
+			if acc, ok = op(acc, n); !ok && n != 0 {
+				return nil, fmt.Errorf("integer overflow in %s", name)
+			}
+		}
+		return acc, nil
+	}
+}
+
+func mulChecked(a, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	r := a * b
+	return r, r/b == a && !(a == -1 && b == math.MinInt64) && !(b == -1 && a == math.MinInt64)
+}
+
+func sub(args []Value) (Value, error) {
+	if len(args) == 1 {
+		n, err := intArg("-", args[0])
+		if err != nil {
+			return nil, err
+		}
+		if n == math.MinInt64 {
+			return nil, errors.New("integer overflow in -")
+		}
+		return -n, nil
+	}
+	return arith("-", func(a, b int64) (int64, bool) { r := a - b; return r, (r < a) == (b > 0) })(args)
+}
+
+func divmod(name string) Func {
+	return func(args []Value) (Value, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("%s expects two arguments", name)
+		}
+		a, err := intArg(name, args[0])
+		if err != nil {
+			return nil, err
+		}
+		b, err := intArg(name, args[1])
+		if err != nil {
+			return nil, err
+		}
+		if b == 0 {
+			return nil, errors.New("division by zero")
+		}
+		if a == math.MinInt64 && b == -1 {
+			return nil, fmt.Errorf("integer overflow in %s", name)
+		}
+		if name == "/" {
+			return a / b, nil
+		}
+		return a % b, nil
+	}
+}
+
+func compareEqual(args []Value) (bool, error) {
+	if len(args) != 2 {
+		return false, errors.New("= and != expect two arguments")
+	}
+	return equal(args[0], args[1]), nil
+}
+
+func equal(a, b Value) bool {
+	la, aList := a.([]Value)
+	lb, bList := b.([]Value)
+	if aList || bList {
+		if !aList || !bList || len(la) != len(lb) {
+			return false
+		}
+		for i := range la {
+			if !equal(la[i], lb[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+func order(name string, ok func(c int) bool) Func {
+	return func(args []Value) (Value, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("%s expects two arguments", name)
+		}
+		switch a := args[0].(type) {
+		case int64:
+			if b, isInt := args[1].(int64); isInt {
+				switch {
+				case a < b:
+					return ok(-1), nil
+				case a > b:
+					return ok(1), nil
+				}
+				return ok(0), nil
+			}
+		case string:
+			if b, isString := args[1].(string); isString {
+				return ok(strings.Compare(a, b)), nil
+			}
+		}
+		return nil, fmt.Errorf("%s expects two integers or two strings", name)
+	}
+}
+
+func unary(name string, fn func(v Value) (Value, error)) Func {
+	return func(args []Value) (Value, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s expects one argument", name)
+		}
+		return fn(args[0])
+	}
+}
+
+func intArg(name string, v Value) (int64, error) {
+	n, ok := v.(int64)
+	if !ok {
+		return 0, fmt.Errorf("%s expects integers, got %s", name, typeName(v))
+	}
+	return n, nil
+}
+
+// ToString converts a string, integer or boolean to its string form.
+func ToString(v Value) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case bool:
+		if v {
+			return "true", nil
+		}
+		return "false", nil
+	default:
+		return "", fmt.Errorf("cannot convert %s to a string", typeName(v))
+	}
+}
+
+func toInt(v Value) (Value, error) {
+	switch v := v.(type) {
+	case int64:
+		return v, nil
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an integer", v)
+		}
+		return n, nil
+	default:
+		return nil, fmt.Errorf("cannot convert %s to an integer", typeName(v))
+	}
+}
+
+func length(v Value) (Value, error) {
+	switch v := v.(type) {
+	case string:
+		return int64(len(v)), nil
+	case []Value:
+		return int64(len(v)), nil
+	case nil:
+		return int64(0), nil
+	default:
+		return nil, fmt.Errorf("len expects a string or a list, got %s", typeName(v))
+	}
+}
+
+func concat(args []Value) (Value, error) {
+	var b strings.Builder
+	for _, arg := range args {
+		s, err := ToString(arg)
+		if err != nil {
+			return nil, err
+		}
+		if b.Len()+len(s) > maxStringLen {
+			return nil, fmt.Errorf("string longer than %d bytes", maxStringLen)
+		}
+		b.WriteString(s)
+	}
+	return b.String(), nil
+}
+
+func newList(args []Value) (Value, error) {
+	if len(args) > maxListLen {
+		return nil, fmt.Errorf("list longer than %d elements", maxListLen)
+	}
+	return append([]Value(nil), args...), nil
+}
+
+func nth(args []Value) (Value, error) {
+	if len(args) != 2 {
+		return nil, errors.New("nth expects a list and an index")
+	}
+	list, ok := args[0].([]Value)
+	if !ok && args[0] != nil {
+		return nil, fmt.Errorf("nth expects a list, got %s", typeName(args[0]))
+	}
+	i, err := intArg("nth", args[1])
+	if err != nil {
+		return nil, err
+	}
+	if i < 0 || i >= int64(len(list)) {
+		return nil, nil
+	}
+	return list[i], nil
+}
+
+func appendList(args []Value) (Value, error) {
+	if len(args) == 0 {
+		return nil, errors.New("append expects a list")
+	}
+	list, ok := args[0].([]Value)
+	if !ok && args[0] != nil {
+		return nil, fmt.Errorf("append expects a list, got %s", typeName(args[0]))
+	}
+	if len(list)+len(args)-1 > maxListLen {
+		return nil, fmt.Errorf("list longer than %d elements", maxListLen)
+	}
+	// Copy, so lists behave as values.
+	return append(append([]Value(nil), list...), args[1:]...), nil
+}
+
+func raise(args []Value) (Value, error) {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		s, err := ToString(arg)
+		if err != nil {
+			s = typeName(arg)
+		}
+		parts[i] = s
+	}
+	return nil, errors.New(strings.Join(parts, " "))
+}
+
+func typeName(v Value) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "boolean"
+	case int64:
+		return "integer"
+	case string:
+		return "string"
+	case []Value:
+		return "list"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
@@ -0,0 +1,185 @@
+package script
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		src  string
+		want Value
+	}{
+		{`42`, int64(42)},
+		{`-7`, int64(-7)},
+		{`"a\"b\n"`, "a\"b\n"},
+		{`(+ 1 2 3)`, int64(6)},
+		{`(- 10 4 1)`, int64(5)},
+		{`(- 3)`, int64(-3)},
+		{`(* 6 7)`, int64(42)},
+		{`(/ 7 2)`, int64(3)},
+		{`(% 7 2)`, int64(1)},
+		{`(= "a" "a")`, true},
+		{`(= 1 "1")`, false},
+		{`(= (list 1 "x") (list 1 "x"))`, true},
+		{`(!= 1 2)`, true},
+		{`(< 1 2)`, true},
+		{`(>= "b" "a")`, true},
+		{`(not nil)`, true},
+		{`(nil? nil)`, true},
+		{`(if 0 "yes" "no")`, "yes"}, // Only nil and false are falsy
+		{`(if false "yes")`, nil},
+		{`(and 1 nil 2)`, nil},
+		{`(and 1 2)`, int64(2)},
+		{`(or nil false 3)`, int64(3)},
+		{`(concat "n=" 5 " " true)`, "n=5 true"},
+		{`(int "-12")`, int64(-12)},
+		{`(str 12)`, "12"},
+		{`(len "abc")`, int64(3)},
+		{`(len (list 1 2))`, int64(2)},
+		{`(nth (list 1 2) 1)`, int64(2)},
+		{`(nth (list 1 2) 5)`, nil},
+		{`(append (list 1) 2 3)`, []Value{int64(1), int64(2), int64(3)}},
+		{`(let ((a 1) (b (+ a 1))) (* a b))`, int64(2)},
+		{`(let ((a 1)) (let ((a 2)) a))`, int64(2)},
+		{`(let ((a 1)) (let ((b 2)) (set a b)) a)`, int64(2)},
+		{`(let ((i 0) (sum 0)) (while (< i 5) (set sum (+ sum i)) (set i (+ i 1))) sum)`, int64(10)},
+		{"; comment\n(do 1 2) ; trailing\n3", int64(3)},
+		{`(nth ARGV 0)`, "arg"},
+	}
+	for _, tt := range tests {
+		prog, err := Compile(tt.src)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", tt.src, err)
+			continue
+		}
+		got, err := prog.Run(map[string]Value{"ARGV": []Value{"arg"}}, nil, Limits{})
+		if err != nil {
+			t.Errorf("Run(%q): %v", tt.src, err)
+			continue
+		}
+		if !equal(got, tt.want) {
+			t.Errorf("Run(%q) = %#v, want %#v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		src     string
+		compile bool // Fails to compile rather than to run
+		want    string
+	}{
+		{``, true, "empty script"},
+		{`(+ 1`, true, "unclosed parenthesis"},
+		{`)`, true, "unexpected )"},
+		{`"abc`, true, "unterminated string"},
+		{`"\q"`, true, "invalid escape"},
+		{`99999999999999999999`, true, "invalid integer"},
+		{strings.Repeat("(", maxDepth+2), true, "nested deeper"},
+		{`()`, false, "empty expression"},
+		{`(1 2)`, false, "must start with a name"},
+		{`(nope)`, false, `unknown function "nope"`},
+		{`x`, false, `undefined variable "x"`},
+		{`(set x 1)`, false, `undefined variable "x"`},
+		{`(+ 1 "2")`, false, "expects integers"},
+		{`(/ 1 0)`, false, "division by zero"},
+		{`(+ 9223372036854775807 1)`, false, "overflow"},
+		{`(* 9223372036854775807 2)`, false, "overflow"},
+		{`(- -9223372036854775807 2)`, false, "overflow"},
+		{`(< 1 "a")`, false, "two integers or two strings"},
+		{`(int "x")`, false, "not an integer"},
+		{"1\n\n(error \"boom\" 42)", false, "line 3: boom 42"},
+	}
+	for _, tt := range tests {
+		prog, err := Compile(tt.src)
+		if err == nil && !tt.compile {
+			_, err = prog.Run(nil, nil, Limits{})
+		}
+		if err == nil || (prog != nil) == tt.compile {
+			t.Errorf("%q: got error %v, want a %s error containing %q", tt.src, err, phase(tt.compile), tt.want)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: got error %q, want it to contain %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+func phase(compile bool) string {
+	if compile {
+		return "compile"
+	}
+	return "run"
+}
+
+func TestFuncs(t *testing.T) {
+	var calls []string
+	funcs := map[string]Func{
+		"record": func(args []Value) (Value, error) {
+			calls = append(calls, fmt.Sprint(args))
+			return int64(len(args)), nil
+		},
+		"fail": func(args []Value) (Value, error) {
+			return nil, errors.New("host failure")
+		},
+	}
+
+	prog, err := Compile(`(record "a" 1) (record)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := prog.Run(nil, funcs, Limits{})
+	if err != nil || got != int64(0) {
+		t.Fatalf("Run = %v, %v; want 0, nil", got, err)
+	}
+	if len(calls) != 2 || calls[0] != "[a 1]" {
+		t.Errorf("calls = %q", calls)
+	}
+
+	// set calls the host's set unless it assigns to a variable.
+	funcs["set"] = funcs["record"]
+	calls = nil
+	prog, _ = Compile(`(let ((a 1)) (set a 2) (set "k" a 3))`)
+	if got, err := prog.Run(nil, funcs, Limits{}); err != nil || got != int64(3) {
+		t.Fatalf("Run(set) = %v, %v; want 3, nil", got, err)
+	}
+	if len(calls) != 1 || calls[0] != "[k 2 3]" {
+		t.Errorf("calls = %q", calls)
+	}
+
+	prog, _ = Compile("\n(fail)")
+	_, err = prog.Run(nil, funcs, Limits{})
+	var scriptErr *Error
+	if !errors.As(err, &scriptErr) || scriptErr.Line != 2 || scriptErr.Msg != "host failure" {
+		t.Errorf("Run(fail) error = %v, want host failure at line 2", err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	loop, err := Compile(`(while true 1)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := loop.Run(nil, nil, Limits{MaxSteps: 1000}); err != ErrStepLimit {
+		t.Errorf("step-limited loop: got %v, want ErrStepLimit", err)
+	}
+
+	start := time.Now()
+	if _, err := loop.Run(nil, nil, Limits{Timeout: 20 * time.Millisecond}); err != ErrTimeout {
+		t.Errorf("timed loop: got %v, want ErrTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("timed loop ran for %v", elapsed)
+	}
+
+	// Doubling a string is stopped by the size limit, not memory.
+	grow, _ := Compile(`(let ((s "x")) (while true (set s (concat s s))))`)
+	if _, err := grow.Run(nil, nil, Limits{}); err == nil || !strings.Contains(err.Error(), "longer than") {
+		t.Errorf("growing string: got %v, want a size error", err)
+	}
+}
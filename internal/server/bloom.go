@@ -0,0 +1,62 @@
+package server
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/jasonrowsell/zerocache/internal/cache"
+	"github.com/jasonrowsell/zerocache/pkg/protocol"
+)
+
+// executeBloom handles BF.RESERVE, BF.ADD and BF.EXISTS.
+func (s *Server) executeBloom(c *cache.Cache, cmd *Command) (*Response, error) {
+	switch cmd.Type {
+	case protocol.CmdBFReserve:
+		if len(cmd.Args) != 2 {
+			return nil, fmt.Errorf("wrong number of arguments for BF.RESERVE (expected error rate and capacity)")
+		}
+		errorRate, err := protocol.DecodeFloat64(cmd.Args[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid BF.RESERVE error rate: %w", err)
+		}
+		capacity, err := protocol.DecodeUint64(cmd.Args[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid BF.RESERVE capacity: %w", err)
+		}
+		ok, err := c.BFReserve(cmd.Key, errorRate, int(min(capacity, math.MaxInt32)))
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return &Response{Type: protocol.RespNotStored}, nil
+		}
+		return &Response{Type: protocol.RespOK}, nil
+
+	case protocol.CmdBFAdd, protocol.CmdBFExists:
+		if len(cmd.Args) == 0 {
+			return nil, fmt.Errorf("wrong number of arguments for %s (expected at least one element)", cmd.Name())
+		}
+		var results []bool
+		var err error
+		if cmd.Type == protocol.CmdBFAdd {
+			results, err = c.BFAdd(cmd.Key, argStrings(cmd.Args)...)
+		} else {
+			results, err = c.BFExists(cmd.Key, argStrings(cmd.Args)...)
+		}
+		if err != nil {
+			return nil, err
+		}
+		var payload []byte
+		for _, result := range results {
+			n := int64(0)
+			if result {
+				n = 1
+			}
+			payload = protocol.AppendArg(payload, protocol.EncodeInt64(n))
+		}
+		return &Response{Type: protocol.RespArray, Value: payload}, nil
+
+	default:
+		return nil, fmt.Errorf("internal error: unknown bloom filter command type %d", cmd.Type)
+	}
+}
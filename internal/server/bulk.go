@@ -0,0 +1,22 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jasonrowsell/zerocache/internal/cache"
+)
+
+// executeDelPattern handles DELPATTERN. It runs as a blocking command so that
+// the deletion stops if the client disconnects or the server shuts down; other
+// connections keep being served throughout.
+func (s *Server) executeDelPattern(ctx context.Context, c *cache.Cache, cmd *Command) (*Response, error) {
+	if len(cmd.Args) != 1 || len(cmd.Args[0]) == 0 {
+		return nil, fmt.Errorf("wrong number of arguments for DELPATTERN (expected pattern)")
+	}
+	removed, err := c.DeletePattern(ctx, string(cmd.Args[0]))
+	if err != nil {
+		return nil, fmt.Errorf("DELPATTERN interrupted after removing %d keys: %w", removed, err)
+	}
+	return intResponse(int64(removed)), nil
+}
@@ -0,0 +1,519 @@
+//go:build linux
+
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"runtime"
+	"slices"
+	"sync"
+	"syscall"
+
+	"github.com/jasonrowsell/zerocache/pkg/protocol"
+)
+
+// The epoll engine serves connections from a small pool of worker goroutines
+// instead of a goroutine with its own reader and writer per connection, so an
+// idle connection costs little more than its socket. One goroutine waits in
+// epoll_wait and hands each ready connection to a worker. Connections are
+// registered with EPOLLONESHOT, so a connection is served by one worker at a
+// time and reports nothing more until the worker re-arms it.
+//
+// In one turn a worker writes any output left over from the last one, reads
+// what the socket has, runs every complete command and writes all responses
+// with a single write. Input and output buffers come from pools and go back
+// once empty. While output is pending the connection is only armed for
+// writing, so a client that does not read its responses stops being read.
+// A connection that ends its input, or sends a malformed frame, is only
+// closed once all its responses have been written.
+//
+// Commands that read or write the connection beyond their own frame, such as
+// SUBSCRIBE, BLPOP and streams (see needsConn), are not run by the workers:
+// the connection is handed over to a goroutine of its own, as in the default
+// engine, together with its session and the input read so far, and stays
+// there.
+
+// epollReadSize is the least free space offered to each read.
+const epollReadSize = 16 << 10
+
+// epollMaxPooledBuffer is the capacity above which a buffer is dropped instead
+// of going back to its pool, so that one large value does not pin memory.
+const epollMaxPooledBuffer = 256 << 10
+
+// epollMaxFrame is the size of the largest frame ReadCommand accepts.
+const epollMaxFrame = 9 + protocol.MaxKeySize + protocol.MaxPayloadSize + 9 + protocol.MaxKeySize
+
+var (
+	epollInputs = sync.Pool{
+		New: func() any {
+			b := make([]byte, 0, epollReadSize)
+			return &b
+		},
+	}
+	epollOutputs = sync.Pool{
+		New: func() any {
+			return new(bytes.Buffer)
+		},
+	}
+)
+
+// epollConn is a connection served by the epoll engine.
+type epollConn struct {
+	// mu is held for each turn. EPOLLONESHOT already keeps turns apart, but
+	// through the kernel, where the race detector cannot see it.
+	mu sync.Mutex
+
+	fd   int
+	addr net.Addr
+	sess *session
+	in   *[]byte       // Input not yet run, nil while there is none
+	out  *bytes.Buffer // Output not yet written, nil while there is none
+
+	closing bool // Close once out has been written
+}
+
+type epollEngine struct {
+	s     *Server
+	epfd  int
+	ready chan *epollConn
+
+	mu     sync.Mutex
+	conns  map[int32]*epollConn // By file descriptor
+	closed bool
+}
+
+// ListenAndServeEpoll is ListenAndServe with the epoll engine and the given
+// number of workers, GOMAXPROCS if not positive. It is only available on
+// Linux.
+func (s *Server) ListenAndServeEpoll(addr string, workers int) error {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	defer listener.Close()
+
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return fmt.Errorf("epoll_create1: %w", err)
+	}
+	// Shutdown wakes the poller through a pipe.
+	var wake [2]int
+	if err := syscall.Pipe2(wake[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		syscall.Close(epfd)
+		return fmt.Errorf("pipe2: %w", err)
+	}
+	err = syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, wake[0], &syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(wake[0])})
+	if err != nil {
+		syscall.Close(epfd)
+		syscall.Close(wake[0])
+		syscall.Close(wake[1])
+		return fmt.Errorf("epoll_ctl: %w", err)
+	}
+	log.Printf("ZeroCache server listening on %s (epoll engine, %d workers)", addr, workers)
+
+	e := &epollEngine{
+		s:     s,
+		epfd:  epfd,
+		ready: make(chan *epollConn, 1024),
+		conns: make(map[int32]*epollConn),
+	}
+	var workersDone sync.WaitGroup
+	s.wg.Add(workers + 1)
+	workersDone.Add(workers)
+	for range workers {
+		go func() {
+			defer s.wg.Done()
+			defer workersDone.Done()
+			var r bytes.Reader
+			for c := range e.ready {
+				e.serve(c, &r)
+			}
+		}()
+	}
+	go func() {
+		defer s.wg.Done()
+		e.poll(wake[0])
+		close(e.ready)
+		workersDone.Wait()
+		e.closeAll()
+		syscall.Close(wake[0])
+		syscall.Close(wake[1])
+	}()
+	go func() {
+		<-s.shutdown
+		listener.Close()
+		_, _ = syscall.Write(wake[1], []byte{0})
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-s.shutdown:
+				return nil // Graceful shutdown initiated
+			default:
+			}
+
+			if _, ok := err.(net.Error); ok {
+				log.Printf("Temporary accept error: %v; retrying...", err)
+				continue
+			}
+			log.Printf("Permanent accept error: %v; stopping listener", err)
+			return err
+		}
+		if err := e.add(conn); err != nil {
+			log.Printf("Error registering connection from %s: %v", conn.RemoteAddr(), err)
+		}
+	}
+}
+
+// add takes over the socket of conn, which it closes, and registers it.
+func (e *epollEngine) add(conn net.Conn) error {
+	defer conn.Close()
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return errors.New("not a TCP connection")
+	}
+	_ = tcpConn.SetNoDelay(true)
+	raw, err := tcpConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	// The duplicate shares the original's non-blocking mode and survives
+	// conn being closed.
+	fd, dupErr := -1, error(nil)
+	err = raw.Control(func(orig uintptr) {
+		var r uintptr
+		var errno syscall.Errno
+		r, _, errno = syscall.Syscall(syscall.SYS_FCNTL, orig, syscall.F_DUPFD_CLOEXEC, 0)
+		if errno != 0 {
+			dupErr = errno
+			return
+		}
+		fd = int(r)
+	})
+	if err == nil {
+		err = dupErr
+	}
+	if err != nil {
+		return err
+	}
+
+	defaultCache, _ := e.s.namespace(DefaultNamespace)
+	c := &epollConn{
+		fd:   fd,
+		addr: conn.RemoteAddr(),
+		sess: &session{namespace: DefaultNamespace, cache: defaultCache},
+	}
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		syscall.Close(fd)
+		return errors.New("server is shutting down")
+	}
+	e.conns[int32(fd)] = c
+	e.mu.Unlock()
+
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT, Fd: int32(fd)}
+	if err := syscall.EpollCtl(e.epfd, syscall.EPOLL_CTL_ADD, fd, &ev); err != nil {
+		e.close(c)
+		return fmt.Errorf("epoll_ctl: %w", err)
+	}
+	return nil
+}
+
+// poll hands ready connections to the workers until wakeFd becomes readable.
+func (e *epollEngine) poll(wakeFd int) {
+	events := make([]syscall.EpollEvent, 256)
+	for {
+		n, err := syscall.EpollWait(e.epfd, events, -1)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			log.Printf("epoll_wait: %v; stopping the epoll engine", err)
+			return
+		}
+		for _, ev := range events[:n] {
+			if ev.Fd == int32(wakeFd) {
+				return
+			}
+			e.mu.Lock()
+			c := e.conns[ev.Fd]
+			e.mu.Unlock()
+			if c != nil {
+				e.ready <- c
+			}
+		}
+	}
+}
+
+// serve gives c one turn on a worker, as described above, and re-arms it
+// unless it was closed or handed over. r is the worker's reader for
+// ReadCommand.
+func (e *epollEngine) serve(c *epollConn, r *bytes.Reader) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.out != nil {
+		if err := e.flush(c); err != nil {
+			log.Printf("Error writing response to %s: %v", c.addr, err)
+			e.close(c)
+			return
+		}
+		if c.out != nil {
+			e.arm(c)
+			return
+		}
+		if c.closing {
+			e.close(c)
+			return
+		}
+	}
+
+	eof, err := e.read(c)
+	if err != nil {
+		log.Printf("Error reading command from %s: %v", c.addr, err)
+		e.close(c)
+		return
+	}
+	handedOver, err := e.run(c, r)
+	if handedOver {
+		return
+	}
+	if flushErr := e.flush(c); flushErr != nil {
+		log.Printf("Error writing response to %s: %v", c.addr, flushErr)
+		e.close(c)
+		return
+	}
+	if err != nil || eof {
+		if eof {
+			log.Printf("Connection closed by %s (EOF)", c.addr)
+		}
+		if c.out == nil {
+			e.close(c)
+			return
+		}
+		c.closing = true
+	}
+	if c.in != nil && (len(*c.in) == 0 || c.closing) {
+		e.releaseInput(c)
+	}
+	e.arm(c)
+}
+
+// read reads what the socket has into c.in and reports whether the client
+// closed the connection.
+func (e *epollEngine) read(c *epollConn) (bool, error) {
+	if c.in == nil {
+		c.in = epollInputs.Get().(*[]byte)
+	}
+	in := slices.Grow(*c.in, epollReadSize)
+	for {
+		n, err := syscall.Read(c.fd, in[len(in):cap(in)])
+		switch {
+		case err == syscall.EINTR:
+			continue
+		case err == syscall.EAGAIN:
+			return false, nil
+		case err != nil:
+			return false, err
+		case n == 0:
+			return true, nil
+		}
+		*c.in = in[:len(in)+n]
+		return false, nil
+	}
+}
+
+// run runs the complete commands in c.in, appending their responses to c.out,
+// and keeps the rest of the input. It reports whether it handed c over to a
+// goroutine, in which case c must no longer be used. An error means the
+// input could not be parsed; its response has been appended.
+func (e *epollEngine) run(c *epollConn, r *bytes.Reader) (bool, error) {
+	buf := *c.in
+	in, need := buf, 0
+	defer func() {
+		if c.in == nil {
+			return
+		}
+		// Move the rest to the front, making room for an incomplete frame
+		// so that it can be read at once.
+		*c.in = slices.Grow(buf[:copy(buf, in)], need)
+	}()
+	for len(in) >= 9 {
+		total := 9 + int(binary.BigEndian.Uint32(in[1:5])) + int(binary.BigEndian.Uint32(in[5:9]))
+		if total <= epollMaxFrame && len(in) < total {
+			need = total - len(in)
+			break
+		}
+		// An oversized frame is rejected by ReadCommand from its header alone.
+		r.Reset(in[:min(total, len(in))])
+		cmd, err := ReadCommand(r)
+		if err != nil {
+			log.Printf("Error reading command from %s: %v", c.addr, err)
+			e.writeError(c, fmt.Sprintf("protocol error: %v", err))
+			return false, err
+		}
+		if needsConn(cmd) {
+			e.handOver(c, in)
+			return true, nil
+		}
+		in = in[total:]
+
+		response, err := e.s.execute(c.sess, cmd, nil)
+		if err != nil {
+			log.Printf("Error executing command (%s) from %s: %v", cmd.Name(), c.addr, err)
+			e.writeError(c, err.Error())
+			continue
+		}
+		if c.out == nil {
+			c.out = epollOutputs.Get().(*bytes.Buffer)
+		}
+		if err := WriteResponse(c.out, response); err != nil {
+			log.Printf("Error writing response to %s: %v", c.addr, err)
+		}
+	}
+	return false, nil
+}
+
+func (e *epollEngine) writeError(c *epollConn, msg string) {
+	if c.out == nil {
+		c.out = epollOutputs.Get().(*bytes.Buffer)
+	}
+	_ = WriteError(c.out, msg)
+}
+
+// flush writes as much of c.out as the socket takes, releasing it once it is
+// all written.
+func (e *epollEngine) flush(c *epollConn) error {
+	if c.out == nil {
+		return nil
+	}
+	for c.out.Len() > 0 {
+		n, err := syscall.Write(c.fd, c.out.Bytes())
+		if n > 0 {
+			c.out.Next(n)
+		}
+		switch {
+		case err == syscall.EINTR:
+			continue
+		case err == syscall.EAGAIN:
+			return nil
+		case err != nil:
+			return err
+		}
+	}
+	e.releaseOutput(c)
+	return nil
+}
+
+// arm re-enables events for c: writability while output is pending, input
+// otherwise. EPOLLRDHUP is left out while writing, as it would keep reporting
+// a client that has ended its input before its responses can be written.
+func (e *epollEngine) arm(c *epollConn) {
+	events := uint32(syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT)
+	if c.out != nil {
+		events = syscall.EPOLLOUT | syscall.EPOLLONESHOT
+	}
+	if err := syscall.EpollCtl(e.epfd, syscall.EPOLL_CTL_MOD, c.fd, &syscall.EpollEvent{Events: events, Fd: int32(c.fd)}); err != nil {
+		log.Printf("Error re-arming connection from %s: %v", c.addr, err)
+		e.close(c)
+	}
+}
+
+// handOver moves c to a goroutine of its own, running serveConn on it with
+// in, the unconsumed input starting at the command that needs it, read
+// first. Pending output is written before anything else. serveConn gets the
+// *net.TCPConn itself rather than a wrapper, which would hide the writev
+// support net.Buffers looks for.
+func (e *epollEngine) handOver(c *epollConn, in []byte) {
+	e.unregister(c)
+	_ = syscall.EpollCtl(e.epfd, syscall.EPOLL_CTL_DEL, c.fd, nil)
+	prefix := bytes.Clone(in)
+	var pending []byte
+	if c.out != nil {
+		pending = bytes.Clone(c.out.Bytes())
+	}
+	e.releaseInput(c)
+	e.releaseOutput(c)
+
+	f := os.NewFile(uintptr(c.fd), "")
+	conn, err := net.FileConn(f) // Duplicates the descriptor
+	f.Close()
+	if err != nil {
+		log.Printf("Error handing over connection from %s: %v", c.addr, err)
+		return
+	}
+
+	e.s.wg.Add(1)
+	go func() {
+		defer e.s.wg.Done()
+		if _, err := conn.Write(pending); err != nil {
+			log.Printf("Error writing response to %s: %v", c.addr, err)
+			conn.Close()
+			return
+		}
+		e.s.serveConn(conn, io.MultiReader(bytes.NewReader(prefix), conn), c.sess)
+	}()
+}
+
+// close closes c, which must not be used afterwards.
+func (e *epollEngine) close(c *epollConn) {
+	e.unregister(c)
+	syscall.Close(c.fd) // Also removes it from the epoll set
+	e.releaseInput(c)
+	e.releaseOutput(c)
+}
+
+func (e *epollEngine) unregister(c *epollConn) {
+	e.mu.Lock()
+	delete(e.conns, int32(c.fd))
+	e.mu.Unlock()
+}
+
+// closeAll closes every connection and the epoll set once the workers have
+// stopped.
+func (e *epollEngine) closeAll() {
+	e.mu.Lock()
+	e.closed = true
+	conns := e.conns
+	e.conns = nil
+	e.mu.Unlock()
+	for _, c := range conns {
+		syscall.Close(c.fd)
+	}
+	syscall.Close(e.epfd)
+}
+
+func (e *epollEngine) releaseInput(c *epollConn) {
+	if c.in == nil {
+		return
+	}
+	if cap(*c.in) <= epollMaxPooledBuffer {
+		*c.in = (*c.in)[:0]
+		epollInputs.Put(c.in)
+	}
+	c.in = nil
+}
+
+func (e *epollEngine) releaseOutput(c *epollConn) {
+	if c.out == nil {
+		return
+	}
+	if c.out.Cap() <= epollMaxPooledBuffer {
+		c.out.Reset()
+		epollOutputs.Put(c.out)
+	}
+	c.out = nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package server
+
+import "errors"
+
+// ListenAndServeEpoll is ListenAndServe with the epoll engine, which is only
+// available on Linux.
+func (s *Server) ListenAndServeEpoll(addr string, workers int) error {
+	return errors.New("the epoll engine is only available on Linux")
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/jasonrowsell/zerocache/internal/cache"
+	"github.com/jasonrowsell/zerocache/pkg/protocol"
+)
+
+// executeHash handles the HSET, HGET, HDEL, HGETALL and HLEN commands.
+func (s *Server) executeHash(c *cache.Cache, cmd *Command) (*Response, error) {
+	switch cmd.Type {
+	case protocol.CmdHSet:
+		if len(cmd.Args) == 0 || len(cmd.Args)%2 != 0 {
+			return nil, fmt.Errorf("wrong number of arguments for HSET (expected field/value pairs)")
+		}
+		fields := make([]cache.FieldValue, 0, len(cmd.Args)/2)
+		for i := 0; i < len(cmd.Args); i += 2 {
+			fields = append(fields, cache.FieldValue{Field: string(cmd.Args[i]), Value: cmd.Args[i+1]})
+		}
+		added, err := c.HSet(cmd.Key, fields...)
+		if err != nil {
+			return nil, err
+		}
+		return intResponse(int64(added)), nil
+
+	case protocol.CmdHGet:
+		if len(cmd.Args) != 1 {
+			return nil, fmt.Errorf("wrong number of arguments for HGET (expected field)")
+		}
+		value, err := c.HGet(cmd.Key, string(cmd.Args[0]))
+		if err != nil {
+			return notFoundOr(err)
+		}
+		return &Response{Type: protocol.RespValue, Value: value}, nil
+
+	case protocol.CmdHDel:
+		if len(cmd.Args) == 0 {
+			return nil, fmt.Errorf("wrong number of arguments for HDEL (expected at least one field)")
+		}
+		fields := make([]string, len(cmd.Args))
+		for i, arg := range cmd.Args {
+			fields[i] = string(arg)
+		}
+		removed, err := c.HDel(cmd.Key, fields...)
+		if err != nil {
+			return nil, err
+		}
+		return intResponse(int64(removed)), nil
+
+	case protocol.CmdHGetAll:
+		fields, err := c.HGetAll(cmd.Key)
+		if err != nil {
+			return nil, err
+		}
+		var payload []byte
+		for _, fv := range fields {
+			payload = protocol.AppendArg(payload, []byte(fv.Field))
+			payload = protocol.AppendArg(payload, fv.Value)
+		}
+		return &Response{Type: protocol.RespArray, Value: payload}, nil
+
+	case protocol.CmdHLen:
+		n, err := c.HLen(cmd.Key)
+		if err != nil {
+			return nil, err
+		}
+		return intResponse(int64(n)), nil
+
+	default:
+		return nil, fmt.Errorf("internal error: unknown hash command type %d", cmd.Type)
+	}
+}
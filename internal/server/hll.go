@@ -0,0 +1,51 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/jasonrowsell/zerocache/internal/cache"
+	"github.com/jasonrowsell/zerocache/pkg/protocol"
+)
+
+// executeHLL handles PFADD, PFCOUNT and PFMERGE.
+func (s *Server) executeHLL(c *cache.Cache, cmd *Command) (*Response, error) {
+	switch cmd.Type {
+	case protocol.CmdPFAdd:
+		if len(cmd.Args) == 0 {
+			return nil, fmt.Errorf("wrong number of arguments for PFADD (expected at least one element)")
+		}
+		changed, err := c.PFAdd(cmd.Key, argStrings(cmd.Args)...)
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			return intResponse(1), nil
+		}
+		return intResponse(0), nil
+
+	case protocol.CmdPFCount, protocol.CmdPFMerge:
+		if cmd.Type == protocol.CmdPFCount && len(cmd.Args) == 0 {
+			return nil, fmt.Errorf("wrong number of arguments for PFCOUNT (expected at least one key)")
+		}
+		for _, arg := range cmd.Args {
+			if len(arg) == 0 || len(arg) > protocol.MaxKeySize {
+				return nil, fmt.Errorf("invalid key length: %d, (max %d)", len(arg), protocol.MaxKeySize)
+			}
+		}
+		keys := argStrings(cmd.Args)
+		if cmd.Type == protocol.CmdPFMerge {
+			if err := c.PFMerge(cmd.Key, keys...); err != nil {
+				return nil, err
+			}
+			return &Response{Type: protocol.RespOK}, nil
+		}
+		count, err := c.PFCount(keys...)
+		if err != nil {
+			return nil, err
+		}
+		return &Response{Type: protocol.RespInt, Value: protocol.EncodeUint64(count)}, nil
+
+	default:
+		return nil, fmt.Errorf("internal error: unknown HyperLogLog command type %d", cmd.Type)
+	}
+}
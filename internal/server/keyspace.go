@@ -0,0 +1,30 @@
+package server
+
+import (
+	"github.com/jasonrowsell/zerocache/internal/cache"
+	"github.com/jasonrowsell/zerocache/pkg/protocol"
+)
+
+// NotifyKeyspaceEvents publishes the given types of keyspace events of every
+// namespace, including ones added later, over pub/sub on the channels
+// described by protocol.KeyEventChannel. Zero turns notifications off.
+func (s *Server) NotifyKeyspaceEvents(types cache.EventType) {
+	s.nsMu.Lock()
+	defer s.nsMu.Unlock()
+
+	s.keyspaceEvents = types
+	for name, c := range s.namespaces {
+		c.Notify(types, s.keyspaceHook(name))
+	}
+}
+
+// keyspaceHook returns the cache event hook publishing a namespace's events.
+// It runs under a shard lock, which is fine as publishing never blocks.
+func (s *Server) keyspaceHook(namespace string) func(cache.Event) {
+	return func(e cache.Event) {
+		if s.broker.idle() {
+			return // Skip building the channel name
+		}
+		s.broker.publish(protocol.KeyEventChannel(namespace, e.Type.String(), e.Key), []byte(e.Key))
+	}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/jasonrowsell/zerocache/internal/cache"
+	"github.com/jasonrowsell/zerocache/pkg/protocol"
+)
+
+// executeList handles the non-blocking list commands.
+func (s *Server) executeList(c *cache.Cache, cmd *Command) (*Response, error) {
+	switch cmd.Type {
+	case protocol.CmdLPush, protocol.CmdRPush:
+		if len(cmd.Args) == 0 {
+			return nil, fmt.Errorf("wrong number of arguments for %s (expected at least one value)", cmd.Name())
+		}
+		for _, value := range cmd.Args {
+			if len(value) > protocol.MaxValueSize {
+				return nil, fmt.Errorf("invalid value length: %d, (max %d)", len(value), protocol.MaxValueSize)
+			}
+		}
+		var length int
+		var err error
+		if cmd.Type == protocol.CmdLPush {
+			length, err = c.LPush(cmd.Key, cmd.Args...)
+		} else {
+			length, err = c.RPush(cmd.Key, cmd.Args...)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return intResponse(int64(length)), nil
+
+	case protocol.CmdLPop, protocol.CmdRPop:
+		var value []byte
+		var err error
+		if cmd.Type == protocol.CmdLPop {
+			value, err = c.LPop(cmd.Key)
+		} else {
+			value, err = c.RPop(cmd.Key)
+		}
+		if err != nil {
+			return notFoundOr(err)
+		}
+		return &Response{Type: protocol.RespValue, Value: value}, nil
+
+	case protocol.CmdLRange:
+		if len(cmd.Args) != 2 {
+			return nil, fmt.Errorf("wrong number of arguments for LRANGE (expected start and stop)")
+		}
+		start, err := protocol.DecodeInt64(cmd.Args[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid LRANGE start: %w", err)
+		}
+		stop, err := protocol.DecodeInt64(cmd.Args[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid LRANGE stop: %w", err)
+		}
+		values, err := c.LRange(cmd.Key, int(start), int(stop))
+		if err != nil {
+			return nil, err
+		}
+		return &Response{Type: protocol.RespArray, Value: protocol.EncodeArgs(values...)}, nil
+
+	case protocol.CmdLLen:
+		n, err := c.LLen(cmd.Key)
+		if err != nil {
+			return nil, err
+		}
+		return intResponse(int64(n)), nil
+
+	default:
+		return nil, fmt.Errorf("internal error: unknown list command type %d", cmd.Type)
+	}
+}
+
+// executeBLPop handles BLPOP, parking the connection until an element is
+// available, the timeout passes or ctx is cancelled. A timeout is reported as
+// NotFound; otherwise the response is a [key, value] array.
+func (s *Server) executeBLPop(ctx context.Context, c *cache.Cache, cmd *Command) (*Response, error) {
+	if len(cmd.Args) < 2 {
+		return nil, fmt.Errorf("wrong number of arguments for BLPOP (expected timeout and at least one key)")
+	}
+	timeoutMillis, err := protocol.DecodeUint64(cmd.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid BLPOP timeout: %w", err)
+	}
+	keys := make([]string, len(cmd.Args)-1)
+	for i, arg := range cmd.Args[1:] {
+		if len(arg) == 0 || len(arg) > protocol.MaxKeySize {
+			return nil, fmt.Errorf("invalid key length: %d, (max %d)", len(arg), protocol.MaxKeySize)
+		}
+		keys[i] = string(arg)
+	}
+
+	if timeoutMillis > 0 {
+		// Timeouts too large for a time.Duration are clamped, waiting as good
+		// as forever rather than wrapping around to the past.
+		timeout := time.Duration(min(timeoutMillis, math.MaxInt64/uint64(time.Millisecond))) * time.Millisecond
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	key, value, err := c.BLPop(ctx, keys...)
+	if err == context.DeadlineExceeded {
+		return &Response{Type: protocol.RespNotFound}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Response{Type: protocol.RespArray, Value: protocol.EncodeArgs([]byte(key), value)}, nil
+}
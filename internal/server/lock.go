@@ -0,0 +1,59 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/jasonrowsell/zerocache/internal/cache"
+	"github.com/jasonrowsell/zerocache/pkg/protocol"
+)
+
+// executeLock handles LOCK, UNLOCK and EXTEND.
+func (s *Server) executeLock(c *cache.Cache, cmd *Command) (*Response, error) {
+	wantArgs := 2
+	if cmd.Type == protocol.CmdUnlock {
+		wantArgs = 1
+	}
+	if len(cmd.Args) != wantArgs {
+		if wantArgs == 1 {
+			return nil, fmt.Errorf("wrong number of arguments for UNLOCK (expected owner)")
+		}
+		return nil, fmt.Errorf("wrong number of arguments for %s (expected owner and TTL)", cmd.Name())
+	}
+	owner := string(cmd.Args[0])
+	if len(owner) > protocol.MaxKeySize {
+		return nil, fmt.Errorf("invalid lock owner length: %d, (max %d)", len(owner), protocol.MaxKeySize)
+	}
+
+	var ttl time.Duration
+	if wantArgs == 2 {
+		ttlMillis, err := protocol.DecodeUint64(cmd.Args[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s TTL: %w", cmd.Name(), err)
+		}
+		ttl = time.Duration(min(ttlMillis, math.MaxInt64/uint64(time.Millisecond))) * time.Millisecond
+	}
+
+	var ok bool
+	var fence uint64
+	var err error
+	switch cmd.Type {
+	case protocol.CmdLock:
+		fence, ok, err = c.Lock(cmd.Key, owner, ttl)
+	case protocol.CmdUnlock:
+		ok, err = c.Unlock(cmd.Key, owner)
+	default:
+		ok, err = c.ExtendLock(cmd.Key, owner, ttl)
+	}
+	switch {
+	case err != nil:
+		return nil, err
+	case !ok:
+		return &Response{Type: protocol.RespNotStored}, nil
+	case cmd.Type == protocol.CmdLock:
+		return &Response{Type: protocol.RespInt, Value: protocol.EncodeUint64(fence)}, nil
+	default:
+		return &Response{Type: protocol.RespOK}, nil
+	}
+}
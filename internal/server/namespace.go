@@ -0,0 +1,106 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/jasonrowsell/zerocache/internal/cache"
+	"github.com/jasonrowsell/zerocache/pkg/protocol"
+)
+
+// DefaultNamespace is the namespace connections start in. It is backed by the
+// cache passed to New.
+const DefaultNamespace = "default"
+
+var errUnknownNamespace = errors.New("unknown namespace")
+
+// session is the state of a single client connection.
+type session struct {
+	namespace string
+	cache     *cache.Cache
+	multi     *transaction // Set between MULTI and EXEC or DISCARD
+	watched   []watchedKey
+}
+
+// AddNamespace registers a namespace backed by its own cache. Keys, LRU order,
+// limits and stats are all per cache, so one namespace's churn never evicts
+// another's keys. Clients switch with SELECT or address a single command to a
+// namespace with the IN envelope.
+func (s *Server) AddNamespace(name string, c *cache.Cache) error {
+	if name == "" || len(name) > protocol.MaxKeySize {
+		return fmt.Errorf("invalid namespace name %q", name)
+	}
+	s.nsMu.Lock()
+	defer s.nsMu.Unlock()
+
+	if _, exists := s.namespaces[name]; exists {
+		return fmt.Errorf("namespace %q already exists", name)
+	}
+	s.namespaces[name] = c
+	if s.keyspaceEvents != 0 {
+		c.Notify(s.keyspaceEvents, s.keyspaceHook(name))
+	}
+	return nil
+}
+
+// namespace returns the cache backing a namespace.
+func (s *Server) namespace(name string) (*cache.Cache, bool) {
+	s.nsMu.RLock()
+	defer s.nsMu.RUnlock()
+
+	c, found := s.namespaces[name]
+	return c, found
+}
+
+// resolve returns the cache a command operates on: the namespace named by its
+// IN envelope if it had one, otherwise the session's current namespace.
+func (s *Server) resolve(sess *session, cmd *Command) (string, *cache.Cache, error) {
+	if cmd.Namespace == "" {
+		return sess.namespace, sess.cache, nil
+	}
+	c, found := s.namespace(cmd.Namespace)
+	if !found {
+		return "", nil, errUnknownNamespace
+	}
+	return cmd.Namespace, c, nil
+}
+
+// executeSelect handles SELECT, switching the connection's namespace.
+func (s *Server) executeSelect(sess *session, cmd *Command) (*Response, error) {
+	if len(cmd.Args) != 1 {
+		return nil, fmt.Errorf("wrong number of arguments for SELECT (expected namespace)")
+	}
+	name := string(cmd.Args[0])
+	c, found := s.namespace(name)
+	if !found {
+		return nil, errUnknownNamespace
+	}
+	sess.namespace, sess.cache = name, c
+	return &Response{Type: protocol.RespOK}, nil
+}
+
+// statsResponse encodes the stats of a namespace as an array of alternating
+// names and decimal values.
+func statsResponse(name string, c *cache.Cache) *Response {
+	stats := c.Stats()
+	fields := []struct {
+		name  string
+		value uint64
+	}{
+		{"keys", uint64(stats.Keys)},
+		{"bytes", uint64(stats.Bytes)},
+		{"hits", stats.Hits},
+		{"misses", stats.Misses},
+		{"evictions", stats.Evictions},
+		{"expired", stats.Expired},
+	}
+
+	payload := protocol.AppendArg(nil, []byte("namespace"))
+	payload = protocol.AppendArg(payload, []byte(name))
+	for _, f := range fields {
+		payload = protocol.AppendArg(payload, []byte(f.name))
+		payload = protocol.AppendArg(payload, strconv.AppendUint(nil, f.value, 10))
+	}
+	return &Response{Type: protocol.RespArray, Value: payload}
+}
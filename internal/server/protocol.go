@@ -1,9 +1,12 @@
 package server
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"net"
 	"sync"
 
 	"github.com/jasonrowsell/zerocache/pkg/protocol"
@@ -12,21 +15,116 @@ import (
 type Command struct {
 	Type  uint8
 	Key   string
-	Value []byte // Only used for SET
+	Value []byte   // Raw value for SET-like commands
+	Args  [][]byte // Decoded arguments for commands with framed payloads
+	// Namespace is set when the command arrived wrapped in an IN envelope.
+	Namespace string
+}
+
+// commandSpec describes how a command's payload is laid out on the wire.
+type commandSpec struct {
+	name string
+	// payload is how the bytes following the key are interpreted.
+	payload payloadKind
+	// keyless commands send an empty key and carry any keys in their arguments.
+	keyless bool
+	// blocking commands may park the connection until data arrives (e.g. BLPOP)
+	// or run long enough that they should stop if the client goes away (e.g. DELPATTERN).
+	blocking bool
+}
+
+type payloadKind uint8
+
+const (
+	payloadNone    payloadKind = iota // No payload allowed
+	payloadValue                      // Single opaque value, stored in Command.Value
+	payloadArgs                       // Length-prefixed arguments, stored in Command.Args
+	payloadCommand                    // A complete inner command frame (IN envelope)
+)
+
+var commandSpecs = map[uint8]commandSpec{
+	protocol.CmdSet:              {name: "SET", payload: payloadValue},
+	protocol.CmdGet:              {name: "GET", payload: payloadNone},
+	protocol.CmdDel:              {name: "DELETE", payload: payloadNone},
+	protocol.CmdGetV:             {name: "GETV", payload: payloadNone},
+	protocol.CmdCAS:              {name: "CAS", payload: payloadArgs},
+	protocol.CmdSetNX:            {name: "SETNX", payload: payloadValue},
+	protocol.CmdSetXX:            {name: "SETXX", payload: payloadValue},
+	protocol.CmdIncr:             {name: "INCR", payload: payloadArgs},
+	protocol.CmdDecr:             {name: "DECR", payload: payloadArgs},
+	protocol.CmdIncrBy:           {name: "INCRBY", payload: payloadArgs},
+	protocol.CmdHSet:             {name: "HSET", payload: payloadArgs},
+	protocol.CmdHGet:             {name: "HGET", payload: payloadArgs},
+	protocol.CmdHDel:             {name: "HDEL", payload: payloadArgs},
+	protocol.CmdHGetAll:          {name: "HGETALL", payload: payloadNone},
+	protocol.CmdHLen:             {name: "HLEN", payload: payloadNone},
+	protocol.CmdLPush:            {name: "LPUSH", payload: payloadArgs},
+	protocol.CmdRPush:            {name: "RPUSH", payload: payloadArgs},
+	protocol.CmdLPop:             {name: "LPOP", payload: payloadNone},
+	protocol.CmdRPop:             {name: "RPOP", payload: payloadNone},
+	protocol.CmdLRange:           {name: "LRANGE", payload: payloadArgs},
+	protocol.CmdLLen:             {name: "LLEN", payload: payloadNone},
+	protocol.CmdBLPop:            {name: "BLPOP", payload: payloadArgs, keyless: true, blocking: true},
+	protocol.CmdZAdd:             {name: "ZADD", payload: payloadArgs},
+	protocol.CmdZRem:             {name: "ZREM", payload: payloadArgs},
+	protocol.CmdZScore:           {name: "ZSCORE", payload: payloadArgs},
+	protocol.CmdZRange:           {name: "ZRANGE", payload: payloadArgs},
+	protocol.CmdZRevRange:        {name: "ZREVRANGE", payload: payloadArgs},
+	protocol.CmdZRangeByScore:    {name: "ZRANGEBYSCORE", payload: payloadArgs},
+	protocol.CmdZRevRangeByScore: {name: "ZREVRANGEBYSCORE", payload: payloadArgs},
+	protocol.CmdZRank:            {name: "ZRANK", payload: payloadArgs},
+	protocol.CmdZRevRank:         {name: "ZREVRANK", payload: payloadArgs},
+	protocol.CmdZCard:            {name: "ZCARD", payload: payloadNone},
+	protocol.CmdSAdd:             {name: "SADD", payload: payloadArgs},
+	protocol.CmdSRem:             {name: "SREM", payload: payloadArgs},
+	protocol.CmdSIsMember:        {name: "SISMEMBER", payload: payloadArgs},
+	protocol.CmdSMembers:         {name: "SMEMBERS", payload: payloadNone},
+	protocol.CmdSCard:            {name: "SCARD", payload: payloadNone},
+	protocol.CmdSRandMember:      {name: "SRANDMEMBER", payload: payloadArgs},
+	protocol.CmdSInter:           {name: "SINTER", payload: payloadArgs, keyless: true},
+	protocol.CmdSUnion:           {name: "SUNION", payload: payloadArgs, keyless: true},
+	protocol.CmdSDiff:            {name: "SDIFF", payload: payloadArgs, keyless: true},
+	protocol.CmdScan:             {name: "SCAN", payload: payloadArgs, keyless: true},
+	protocol.CmdDelPattern:       {name: "DELPATTERN", payload: payloadArgs, keyless: true, blocking: true},
+	protocol.CmdFlushAll:         {name: "FLUSHALL", payload: payloadNone, keyless: true},
+	protocol.CmdSetTags:          {name: "SETTAGS", payload: payloadArgs},
+	protocol.CmdInvalidateTag:    {name: "INVALIDATE", payload: payloadArgs, keyless: true},
+	protocol.CmdSelect:           {name: "SELECT", payload: payloadArgs, keyless: true},
+	protocol.CmdIn:               {name: "IN", payload: payloadCommand},
+	protocol.CmdStats:            {name: "STATS", payload: payloadNone, keyless: true},
+	protocol.CmdSubscribe:        {name: "SUBSCRIBE", payload: payloadArgs, keyless: true},
+	protocol.CmdPSubscribe:       {name: "PSUBSCRIBE", payload: payloadArgs, keyless: true},
+	protocol.CmdUnsubscribe:      {name: "UNSUBSCRIBE", payload: payloadArgs, keyless: true},
+	protocol.CmdPUnsubscribe:     {name: "PUNSUBSCRIBE", payload: payloadArgs, keyless: true},
+	protocol.CmdPublish:          {name: "PUBLISH", payload: payloadArgs, keyless: true},
+	protocol.CmdMulti:            {name: "MULTI", payload: payloadNone, keyless: true},
+	protocol.CmdExec:             {name: "EXEC", payload: payloadNone, keyless: true},
+	protocol.CmdDiscard:          {name: "DISCARD", payload: payloadNone, keyless: true},
+	protocol.CmdWatch:            {name: "WATCH", payload: payloadArgs, keyless: true},
+	protocol.CmdUnwatch:          {name: "UNWATCH", payload: payloadNone, keyless: true},
+	protocol.CmdEval:             {name: "EVAL", payload: payloadArgs, keyless: true},
+	protocol.CmdEvalSHA:          {name: "EVALSHA", payload: payloadArgs, keyless: true},
+	protocol.CmdScriptLoad:       {name: "SCRIPT LOAD", payload: payloadArgs, keyless: true},
+	protocol.CmdThrottle:         {name: "THROTTLE", payload: payloadArgs},
+	protocol.CmdLock:             {name: "LOCK", payload: payloadArgs},
+	protocol.CmdUnlock:           {name: "UNLOCK", payload: payloadArgs},
+	protocol.CmdExtend:           {name: "EXTEND", payload: payloadArgs},
+	protocol.CmdPFAdd:            {name: "PFADD", payload: payloadArgs},
+	protocol.CmdPFCount:          {name: "PFCOUNT", payload: payloadArgs, keyless: true},
+	protocol.CmdPFMerge:          {name: "PFMERGE", payload: payloadArgs},
+	protocol.CmdBFReserve:        {name: "BF.RESERVE", payload: payloadArgs},
+	protocol.CmdBFAdd:            {name: "BF.ADD", payload: payloadArgs},
+	protocol.CmdBFExists:         {name: "BF.EXISTS", payload: payloadArgs},
+	protocol.CmdSetStream:        {name: "SETSTREAM", payload: payloadNone},
+	protocol.CmdGetStream:        {name: "GETSTREAM", payload: payloadNone},
 }
 
 // Name returns human-readable name for the command type.
 func (c *Command) Name() string {
-	switch c.Type {
-	case protocol.CmdSet:
-		return "SET"
-	case protocol.CmdGet:
-		return "GET"
-	case protocol.CmdDel:
-		return "DELETE"
-	default:
-		return "UNKNOWN"
+	if spec, ok := commandSpecs[c.Type]; ok {
+		return spec.name
 	}
+	return "UNKNOWN"
 }
 
 type Response struct {
@@ -59,14 +157,29 @@ func ReadCommand(r io.Reader) (*Command, error) {
 	keyLen := binary.BigEndian.Uint32(header[1:5])
 	valLen := binary.BigEndian.Uint32(header[5:9])
 
-	if keyLen == 0 || keyLen > protocol.MaxKeySize {
+	spec, known := commandSpecs[cmdType]
+	if !known {
+		return nil, fmt.Errorf("unknown command type: %d", cmdType)
+	}
+	if spec.keyless {
+		if keyLen != 0 {
+			return nil, fmt.Errorf("protocol violation: key sent for keyless %s command", spec.name)
+		}
+	} else if keyLen == 0 || keyLen > protocol.MaxKeySize {
 		return nil, fmt.Errorf("invalid key length: %d, (max %d)", keyLen, protocol.MaxKeySize)
 	}
-	if valLen > protocol.MaxValueSize {
+	if spec.payload == payloadValue && valLen > protocol.MaxValueSize {
 		return nil, fmt.Errorf("invalid value length: %d, (max %d)", valLen, protocol.MaxValueSize)
 	}
-	if valLen > 0 && (cmdType == protocol.CmdGet || cmdType == protocol.CmdDel) {
-		return nil, fmt.Errorf("protocol violation: value data sent for non-SET command (type %d)", cmdType)
+	maxPayload := uint32(protocol.MaxPayloadSize)
+	if spec.payload == payloadCommand {
+		maxPayload += 9 + protocol.MaxKeySize // Room for the inner command's header and key
+	}
+	if valLen > maxPayload {
+		return nil, fmt.Errorf("invalid payload length: %d, (max %d)", valLen, maxPayload)
+	}
+	if valLen > 0 && spec.payload == payloadNone {
+		return nil, fmt.Errorf("protocol violation: value data sent for %s command (type %d)", spec.name, cmdType)
 	}
 
 	cmd := &Command{Type: cmdType}
@@ -77,23 +190,17 @@ func ReadCommand(r io.Reader) (*Command, error) {
 
 	// Get buffer from pool
 	payloadBufPtr = bufferPool.Get().(*[]byte)
-	// Only need buffer size for key if not SET, else key+value
-	neededSize := int(keyLen)
-	if cmdType == protocol.CmdSet {
-		neededSize = int(totalPayloadLen)
-	}
+	neededSize := int(totalPayloadLen)
 
 	if cap(*payloadBufPtr) < neededSize {
 		bufferPool.Put(payloadBufPtr) // Put back small one
 		payloadBuf = make([]byte, neededSize)
 	} else {
-		// Use buffer from pool, slice it to the needed length
+		// Use buffer from pool, slice it to the needed length, and put it
+		// back when done
 		payloadBuf = (*payloadBufPtr)[:neededSize]
+		defer bufferPool.Put(payloadBufPtr)
 	}
-	// Ensure buffer is put back when done
-	defer func() {
-		bufferPool.Put(payloadBufPtr)
-	}()
 
 	if neededSize > 0 {
 		if _, err := io.ReadFull(r, payloadBuf); err != nil {
@@ -106,20 +213,46 @@ func ReadCommand(r io.Reader) (*Command, error) {
 
 	// Extract key and value from the buffer
 	cmd.Key = string(payloadBuf[:keyLen])
-	if cmdType == protocol.CmdSet {
+	switch spec.payload {
+	case payloadValue:
 		// Copy value from buffer into the command struct
 		// Cache needs to own its copy
 		cmd.Value = make([]byte, valLen)
 		copy(cmd.Value, payloadBuf[keyLen:totalPayloadLen])
+	case payloadArgs:
+		// Arguments alias a private copy, never the pooled buffer
+		payload := make([]byte, valLen)
+		copy(payload, payloadBuf[keyLen:totalPayloadLen])
+		args, err := protocol.DecodeArgs(payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s arguments: %w", spec.name, err)
+		}
+		cmd.Args = args
+	case payloadCommand:
+		return readEnvelope(cmd.Key, payloadBuf[keyLen:totalPayloadLen])
 	}
 
-	switch cmdType {
-	case protocol.CmdSet, protocol.CmdGet, protocol.CmdDel:
-		// Valid
-	default:
-		return nil, fmt.Errorf("unknown command type: %d", cmdType)
-	}
+	return cmd, nil
+}
 
+// readEnvelope parses the command wrapped in an IN envelope and tags it with
+// the namespace it must run in. The inner command is fully copied out of frame.
+func readEnvelope(namespace string, frame []byte) (*Command, error) {
+	r := bytes.NewReader(frame)
+	cmd, err := ReadCommand(r)
+	if err == io.EOF {
+		return nil, fmt.Errorf("truncated command in IN envelope")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("protocol violation: %d trailing bytes in IN envelope", r.Len())
+	}
+	if cmd.Namespace != "" || cmd.Type == protocol.CmdSelect {
+		return nil, fmt.Errorf("protocol violation: %s cannot be sent in an IN envelope", cmd.Name())
+	}
+	cmd.Namespace = namespace
 	return cmd, nil
 }
 
@@ -128,7 +261,7 @@ func WriteResponse(w io.Writer, resp *Response) error {
 	valLen := uint32(len(resp.Value))
 	headerLen := 5
 
-	if valLen > protocol.MaxValueSize {
+	if valLen > protocol.MaxPayloadSize {
 		errMsg := "internal: response value exceeds maximum size"
 		if len(errMsg) > protocol.MaxValueSize {
 			errMsg = errMsg[:protocol.MaxValueSize] // Truncate
@@ -148,7 +281,10 @@ func WriteResponse(w io.Writer, resp *Response) error {
 		}
 		return fmt.Errorf("original response value exceeds maximum size")
 	}
-	if (resp.Type == protocol.RespOK || resp.Type == protocol.RespNotFound) && valLen != 0 {
+	if resp.Type == protocol.RespFloat && valLen != 8 {
+		return fmt.Errorf("internal server error: float response must carry 8 bytes, got %d", valLen)
+	}
+	if (resp.Type == protocol.RespOK || resp.Type == protocol.RespNotFound || resp.Type == protocol.RespNotStored) && valLen != 0 {
 		errMsg := fmt.Sprintf("internal: unexpected value data with response type %d", resp.Type)
 		errResp := &Response{Type: protocol.RespError, Value: []byte(errMsg)}
 		totalLen := headerLen + len(errResp.Value)
@@ -159,7 +295,7 @@ func WriteResponse(w io.Writer, resp *Response) error {
 		if _, writeErr := w.Write(buf); writeErr != nil {
 			return fmt.Errorf("failed to write internal protocol error response: %w", writeErr)
 		}
-		return fmt.Errorf("internal server error: tried to send data with OK/NotFound")
+		return fmt.Errorf("internal server error: tried to send data with OK/NotFound/NotStored")
 	}
 
 	totalLen := headerLen + int(valLen)
@@ -191,6 +327,34 @@ func WriteResponse(w io.Writer, resp *Response) error {
 	return nil
 }
 
+// writevMinSize is the value size from which WriteValue hands the value to
+// the kernel with writev rather than copying it into the write buffer; smaller
+// values are cheaper to copy than to send with a system call of their own.
+const writevMinSize = 4 << 10
+
+// WriteValue writes a RespValue response carrying value. Large values are not
+// copied: anything buffered in w is flushed and the header and value go out
+// together in a single writev on conn. value must not change until it returns.
+func WriteValue(w *bufio.Writer, conn net.Conn, value []byte) error {
+	var header [5]byte
+	header[0] = protocol.RespValue
+	binary.BigEndian.PutUint32(header[1:], uint32(len(value)))
+	if len(value) < writevMinSize {
+		_, _ = w.Write(header[:])
+		_, err := w.Write(value)
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to flush before writing value: %w", err)
+	}
+	buffers := net.Buffers{header[:], value}
+	if _, err := buffers.WriteTo(conn); err != nil {
+		return fmt.Errorf("failed to write value: %w", err)
+	}
+	return nil
+}
+
 // WriteError is a helper function to write an error response.
 func WriteError(w io.Writer, errMsg string) error {
 	if len(errMsg) > protocol.MaxValueSize {
@@ -0,0 +1,317 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/jasonrowsell/zerocache/internal/glob"
+	"github.com/jasonrowsell/zerocache/pkg/protocol"
+)
+
+// subscriberQueueSize bounds the pushes waiting to be written to a subscriber.
+// A subscriber that falls this far behind is disconnected, so one slow consumer
+// can neither stall publishers nor make the server buffer without limit.
+const subscriberQueueSize = 1024
+
+// broker routes published messages to subscribed connections. Channels are
+// global: they are not scoped to namespaces.
+type broker struct {
+	mu       sync.RWMutex
+	channels map[string]map[*subscriber]struct{}
+	patterns map[string]map[*subscriber]struct{}
+}
+
+func newBroker() *broker {
+	return &broker{
+		channels: make(map[string]map[*subscriber]struct{}),
+		patterns: make(map[string]map[*subscriber]struct{}),
+	}
+}
+
+// subscriber is a connection in push mode. Everything sent to it, message or
+// reply, goes through out in order and is written by a single goroutine.
+type subscriber struct {
+	out      chan *Response
+	conn     io.Closer
+	dropped  chan struct{}
+	dropOnce sync.Once
+
+	// Guarded by broker.mu.
+	channels map[string]struct{}
+	patterns map[string]struct{}
+}
+
+func newSubscriber(conn io.Closer) *subscriber {
+	return &subscriber{
+		out:      make(chan *Response, subscriberQueueSize),
+		conn:     conn,
+		dropped:  make(chan struct{}),
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+	}
+}
+
+// send queues a push without blocking. If the queue is full the subscriber is
+// dropped and its connection closed.
+func (sub *subscriber) send(resp *Response) bool {
+	select {
+	case <-sub.dropped:
+		return false
+	default:
+	}
+	select {
+	case sub.out <- resp:
+		return true
+	default:
+		sub.drop()
+		return false
+	}
+}
+
+// drop disconnects the subscriber. Closing the connection also unblocks a
+// writer stuck on a client that stopped reading.
+func (sub *subscriber) drop() {
+	sub.dropOnce.Do(func() {
+		close(sub.dropped)
+		_ = sub.conn.Close()
+	})
+}
+
+// count returns the number of channels and patterns sub is subscribed to.
+// The caller must hold b.mu.
+func (sub *subscriber) count() int {
+	return len(sub.channels) + len(sub.patterns)
+}
+
+// subscribe adds sub to channels (or patterns), queues one confirmation per
+// name and returns the number of subscriptions sub now has. Confirmations are
+// queued under the lock, so they always precede any message published to the
+// new subscription.
+func (b *broker) subscribe(sub *subscriber, names []string, pattern bool) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	index, own, kind := b.channels, sub.channels, "subscribe"
+	if pattern {
+		index, own, kind = b.patterns, sub.patterns, "psubscribe"
+	}
+	for _, name := range names {
+		subs := index[name]
+		if subs == nil {
+			subs = make(map[*subscriber]struct{})
+			index[name] = subs
+		}
+		subs[sub] = struct{}{}
+		own[name] = struct{}{}
+		sub.send(pushResponse([]byte(kind), []byte(name), protocol.EncodeInt64(int64(sub.count()))))
+	}
+	return sub.count()
+}
+
+// unsubscribe removes sub from channels (or patterns), or from all of them if
+// names is empty, queues a confirmation per name and returns the number of
+// subscriptions left.
+func (b *broker) unsubscribe(sub *subscriber, names []string, pattern bool) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	index, own, kind := b.channels, sub.channels, "unsubscribe"
+	if pattern {
+		index, own, kind = b.patterns, sub.patterns, "punsubscribe"
+	}
+	if len(names) == 0 {
+		for name := range own {
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		if subs := index[name]; subs != nil {
+			delete(subs, sub)
+			if len(subs) == 0 {
+				delete(index, name)
+			}
+		}
+		delete(own, name)
+		sub.send(pushResponse([]byte(kind), []byte(name), protocol.EncodeInt64(int64(sub.count()))))
+	}
+	return sub.count()
+}
+
+// subscriptions returns the number of channels and patterns sub is subscribed to.
+func (b *broker) subscriptions(sub *subscriber) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return sub.count()
+}
+
+// unsubscribeAll silently removes every subscription of sub.
+func (b *broker) unsubscribeAll(sub *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for name := range sub.channels {
+		delete(b.channels[name], sub)
+		if len(b.channels[name]) == 0 {
+			delete(b.channels, name)
+		}
+	}
+	for name := range sub.patterns {
+		delete(b.patterns[name], sub)
+		if len(b.patterns[name]) == 0 {
+			delete(b.patterns, name)
+		}
+	}
+	clear(sub.channels)
+	clear(sub.patterns)
+}
+
+// publish delivers payload to every subscriber of channel and of a pattern
+// matching it, and returns how many deliveries were queued.
+func (b *broker) publish(channel string, payload []byte) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	received := 0
+	if subs := b.channels[channel]; len(subs) > 0 {
+		// All subscribers share the same encoded frame.
+		msg := pushResponse([]byte("message"), []byte(channel), payload)
+		for sub := range subs {
+			if sub.send(msg) {
+				received++
+			}
+		}
+	}
+	for pattern, subs := range b.patterns {
+		if !glob.Match(pattern, channel) {
+			continue
+		}
+		msg := pushResponse([]byte("pmessage"), []byte(pattern), []byte(channel), payload)
+		for sub := range subs {
+			if sub.send(msg) {
+				received++
+			}
+		}
+	}
+	return received
+}
+
+// idle reports whether nothing at all is subscribed.
+func (b *broker) idle() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.channels) == 0 && len(b.patterns) == 0
+}
+
+// pushResponse encodes an out-of-band push frame.
+func pushResponse(elems ...[]byte) *Response {
+	return &Response{Type: protocol.RespPush, Value: protocol.EncodeArgs(elems...)}
+}
+
+// executePublish handles PUBLISH.
+func (s *Server) executePublish(cmd *Command) (*Response, error) {
+	if len(cmd.Args) != 2 {
+		return nil, fmt.Errorf("wrong number of arguments for PUBLISH (expected channel and message)")
+	}
+	return intResponse(int64(s.broker.publish(string(cmd.Args[0]), cmd.Args[1]))), nil
+}
+
+// runSubscriber serves a connection in push mode, starting with the SUBSCRIBE
+// or PSUBSCRIBE command cmd. A dedicated goroutine writes queued pushes while
+// this one keeps reading subscription commands. It returns nil once the client
+// has unsubscribed from everything, so the connection can go back to regular
+// commands, or an error if the connection is done for.
+func (s *Server) runSubscriber(conn net.Conn, reader *bufio.Reader, writer *bufio.Writer, cmd *Command) error {
+	sub := newSubscriber(conn)
+	defer s.broker.unsubscribeAll(sub)
+
+	stop := make(chan struct{})
+	writerDone := make(chan error, 1)
+	go func() {
+		writerDone <- writePushes(sub, writer, stop)
+	}()
+	finish := func() error {
+		close(stop)
+		return <-writerDone
+	}
+
+	for {
+		remaining, err := s.executeSubscription(sub, cmd)
+		if err != nil {
+			sub.send(&Response{Type: protocol.RespError, Value: []byte(err.Error())})
+		}
+		if remaining == 0 {
+			// Everything queued so far is written before push mode ends.
+			return finish()
+		}
+
+		cmd, err = ReadCommand(reader)
+		if err == nil && cmd.Type == protocol.CmdSetStream {
+			// Not allowed here either, but its value must be skipped.
+			_, _, err = readChunks(reader, 0)
+		}
+		if err != nil {
+			sub.drop()
+			_ = finish()
+			return err
+		}
+	}
+}
+
+// executeSubscription runs a command received in push mode and returns the
+// number of subscriptions left. Replies are queued on sub rather than returned.
+func (s *Server) executeSubscription(sub *subscriber, cmd *Command) (int, error) {
+	names := argStrings(cmd.Args)
+	switch cmd.Type {
+	case protocol.CmdSubscribe, protocol.CmdPSubscribe:
+		if len(names) == 0 {
+			return s.broker.subscriptions(sub), fmt.Errorf("wrong number of arguments for %s (expected at least one channel)", cmd.Name())
+		}
+		return s.broker.subscribe(sub, names, cmd.Type == protocol.CmdPSubscribe), nil
+	case protocol.CmdUnsubscribe, protocol.CmdPUnsubscribe:
+		return s.broker.unsubscribe(sub, names, cmd.Type == protocol.CmdPUnsubscribe), nil
+	default:
+		return s.broker.subscriptions(sub), fmt.Errorf("only (P)SUBSCRIBE and (P)UNSUBSCRIBE are allowed while subscribed, got %s", cmd.Name())
+	}
+}
+
+// writePushes writes queued pushes until stop is closed, then drains what is
+// left. It flushes whenever the queue runs empty, batching bursts.
+func writePushes(sub *subscriber, writer *bufio.Writer, stop <-chan struct{}) error {
+	write := func(resp *Response) error {
+		if err := WriteResponse(writer, resp); err != nil {
+			return err
+		}
+		if len(sub.out) == 0 {
+			return writer.Flush()
+		}
+		return nil
+	}
+
+	for {
+		select {
+		case resp := <-sub.out:
+			if err := write(resp); err != nil {
+				log.Printf("Error writing push to subscriber: %v", err)
+				sub.drop()
+				return err
+			}
+		case <-sub.dropped:
+			return fmt.Errorf("subscriber dropped: too slow or disconnected")
+		case <-stop:
+			for {
+				select {
+				case resp := <-sub.out:
+					if err := write(resp); err != nil {
+						return err
+					}
+				default:
+					return writer.Flush()
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/jasonrowsell/zerocache/internal/cache"
+	"github.com/jasonrowsell/zerocache/pkg/protocol"
+)
+
+// maxScanCount caps the keys examined per SCAN call so that a batch of
+// maximum-length keys still fits in a single response.
+const maxScanCount = protocol.MaxPayloadSize / (protocol.MaxKeySize + 4) / 2
+
+// executeScan handles SCAN. The response carries the next cursor first,
+// followed by the matching keys.
+func (s *Server) executeScan(c *cache.Cache, cmd *Command) (*Response, error) {
+	if len(cmd.Args) != 2 && len(cmd.Args) != 3 {
+		return nil, fmt.Errorf("wrong number of arguments for SCAN (expected cursor, count and optional pattern)")
+	}
+	cursor, err := protocol.DecodeUint64(cmd.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid SCAN cursor: %w", err)
+	}
+	count, err := protocol.DecodeUint64(cmd.Args[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid SCAN count: %w", err)
+	}
+	var match string
+	if len(cmd.Args) == 3 {
+		match = string(cmd.Args[2])
+	}
+
+	keys, next := c.Scan(cursor, match, int(min(count, maxScanCount)))
+	payload := protocol.AppendArg(nil, protocol.EncodeUint64(next))
+	for _, key := range keys {
+		payload = protocol.AppendArg(payload, []byte(key))
+	}
+	return &Response{Type: protocol.RespArray, Value: payload}, nil
+}
@@ -0,0 +1,530 @@
+package server
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jasonrowsell/zerocache/internal/cache"
+	"github.com/jasonrowsell/zerocache/internal/script"
+	"github.com/jasonrowsell/zerocache/pkg/protocol"
+)
+
+// Default limits of a script run. A script holds the locks of its keys'
+// shards for as long as it runs, so these are kept tight.
+const (
+	DefaultScriptMaxSteps = 100_000
+	DefaultScriptTimeout  = 50 * time.Millisecond
+)
+
+// maxCachedScripts bounds the script cache. When it is full, loading a script
+// evicts an arbitrary other one; clients fall back to EVAL on NOSCRIPT.
+const maxCachedScripts = 4096
+
+var errNoScript = errors.New("NOSCRIPT no script with this SHA1, use SCRIPT LOAD or EVAL")
+
+// scriptCache holds compiled scripts by the hex SHA1 of their source.
+type scriptCache struct {
+	mu       sync.RWMutex
+	programs map[string]*script.Program
+	limits   script.Limits
+}
+
+func newScriptCache() *scriptCache {
+	return &scriptCache{
+		programs: make(map[string]*script.Program),
+		limits:   script.Limits{MaxSteps: DefaultScriptMaxSteps, Timeout: DefaultScriptTimeout},
+	}
+}
+
+// load compiles and caches src, returning its SHA1 and program.
+func (sc *scriptCache) load(src string) (string, *script.Program, error) {
+	sum := sha1.Sum([]byte(src))
+	sha := hex.EncodeToString(sum[:])
+	if prog, ok := sc.get(sha); ok {
+		return sha, prog, nil
+	}
+
+	prog, err := script.Compile(src)
+	if err != nil {
+		return "", nil, err
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if len(sc.programs) >= maxCachedScripts {
+		for other := range sc.programs {
+			delete(sc.programs, other)
+			break
+		}
+	}
+	sc.programs[sha] = prog
+	return sha, prog, nil
+}
+
+func (sc *scriptCache) get(sha string) (*script.Program, bool) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	prog, ok := sc.programs[sha]
+	return prog, ok
+}
+
+// SetScriptLimits sets the step and time limits of EVAL and EVALSHA. Zero
+// fields mean no limit.
+func (s *Server) SetScriptLimits(limits script.Limits) {
+	s.scripts.mu.Lock()
+	defer s.scripts.mu.Unlock()
+	s.scripts.limits = limits
+}
+
+// executeScript handles EVAL, EVALSHA and SCRIPT LOAD. A script runs with the
+// shards of the keys it declares locked, so it is atomic like a transaction,
+// and may only touch those keys. Like a transaction, a script failing halfway
+// keeps the writes it made.
+func (s *Server) executeScript(c *cache.Cache, cmd *Command) (*Response, error) {
+	if cmd.Type == protocol.CmdScriptLoad {
+		if len(cmd.Args) != 1 {
+			return nil, fmt.Errorf("wrong number of arguments for SCRIPT LOAD (expected script)")
+		}
+		sha, _, err := s.scripts.load(string(cmd.Args[0]))
+		if err != nil {
+			return nil, err
+		}
+		return &Response{Type: protocol.RespValue, Value: []byte(sha)}, nil
+	}
+
+	keys, args, err := scriptArgs(cmd)
+	if err != nil {
+		return nil, err
+	}
+	var prog *script.Program
+	if cmd.Type == protocol.CmdEval {
+		if _, prog, err = s.scripts.load(string(cmd.Args[0])); err != nil {
+			return nil, err
+		}
+	} else {
+		var ok bool
+		if prog, ok = s.scripts.get(string(cmd.Args[0])); !ok {
+			return nil, errNoScript
+		}
+	}
+
+	s.scripts.mu.RLock()
+	limits := s.scripts.limits
+	s.scripts.mu.RUnlock()
+
+	vars := map[string]script.Value{
+		"KEYS": stringValues(keys),
+		"ARGV": stringValues(args),
+	}
+	declared := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		declared[key] = true
+	}
+
+	var result script.Value
+	c.Atomically(keys, func(tx *cache.Cache) {
+		result, err = prog.Run(vars, scriptFuncs(tx, declared), limits)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return scriptResponse(result)
+}
+
+// scriptArgs splits the arguments of EVAL and EVALSHA: script or SHA1, key
+// count, keys, then the script's arguments.
+func scriptArgs(cmd *Command) (keys, args []string, err error) {
+	if len(cmd.Args) < 2 {
+		return nil, nil, fmt.Errorf("wrong number of arguments for %s (expected script, key count, keys and arguments)", cmd.Name())
+	}
+	numKeys, err := protocol.DecodeUint64(cmd.Args[1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid %s key count: %w", cmd.Name(), err)
+	}
+	rest := cmd.Args[2:]
+	if numKeys > uint64(len(rest)) {
+		return nil, nil, fmt.Errorf("%s key count %d exceeds the number of arguments", cmd.Name(), numKeys)
+	}
+	for _, key := range rest[:numKeys] {
+		if len(key) == 0 || len(key) > protocol.MaxKeySize {
+			return nil, nil, fmt.Errorf("invalid key length: %d, (max %d)", len(key), protocol.MaxKeySize)
+		}
+	}
+	return argStrings(rest[:numKeys]), argStrings(rest[numKeys:]), nil
+}
+
+func stringValues(strs []string) []script.Value {
+	values := make([]script.Value, len(strs))
+	for i, s := range strs {
+		values[i] = s
+	}
+	return values
+}
+
+// scriptResponse encodes a script's result: nil as RespNotFound, integers and
+// booleans as RespInt, strings as RespValue and lists as RespArray of their
+// elements' string forms.
+func scriptResponse(v script.Value) (*Response, error) {
+	switch v := v.(type) {
+	case nil:
+		return &Response{Type: protocol.RespNotFound}, nil
+	case int64:
+		return intResponse(v), nil
+	case bool:
+		if v {
+			return intResponse(1), nil
+		}
+		return intResponse(0), nil
+	case string:
+		return &Response{Type: protocol.RespValue, Value: []byte(v)}, nil
+	case []script.Value:
+		elems := make([][]byte, len(v))
+		for i, elem := range v {
+			s, err := script.ToString(elem)
+			if err != nil {
+				return nil, fmt.Errorf("invalid script result: %w", err)
+			}
+			elems[i] = []byte(s)
+		}
+		payload := protocol.EncodeArgs(elems...)
+		if len(payload) > protocol.MaxPayloadSize {
+			return nil, errors.New("script result exceeds the maximum response size")
+		}
+		return &Response{Type: protocol.RespArray, Value: payload}, nil
+	default:
+		return nil, fmt.Errorf("script returned an unsupported %T", v)
+	}
+}
+
+// scriptFuncs returns the cache operations available to a script running on
+// tx. Each refuses keys the script did not declare.
+func scriptFuncs(tx *cache.Cache, declared map[string]bool) map[string]script.Func {
+	h := &scriptHost{c: tx, declared: declared}
+	return map[string]script.Func{
+		"get":           h.get,
+		"set":           h.set,
+		"del":           h.del,
+		"exists":        h.exists,
+		"incrby":        h.incrBy,
+		"expire":        h.expire,
+		"hget":          h.hget,
+		"hset":          h.hset,
+		"sadd":          h.sadd,
+		"srem":          h.srem,
+		"sismember":     h.sismember,
+		"zadd":          h.zadd,
+		"zrem":          h.zrem,
+		"zcard":         h.zcard,
+		"zrangebyscore": h.zrangeByScore,
+		"lpush":         h.push(false),
+		"rpush":         h.push(true),
+		"lpop":          h.lpop,
+		"llen":          h.llen,
+		"now":           now,
+	}
+}
+
+type scriptHost struct {
+	c        *cache.Cache
+	declared map[string]bool
+}
+
+// argc checks the number of arguments of the named function.
+func argc(name string, args []script.Value, min, max int) error {
+	if len(args) < min || (max >= 0 && len(args) > max) {
+		return fmt.Errorf("wrong number of arguments for %s", name)
+	}
+	return nil
+}
+
+// key returns args[0] as a key the script declared.
+func (h *scriptHost) key(args []script.Value) (string, error) {
+	key, ok := args[0].(string)
+	if !ok {
+		return "", errors.New("keys must be strings")
+	}
+	if !h.declared[key] {
+		return "", fmt.Errorf("script accessed key %q it did not declare", key)
+	}
+	return key, nil
+}
+
+func intArg(v script.Value) (int64, error) {
+	switch v := v.(type) {
+	case int64:
+		return v, nil
+	case string:
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("expected an integer, got %v", v)
+}
+
+// keyAnd validates a call taking a key and between min and max more arguments
+// (max < 0 for no limit) and returns the key and those arguments as strings.
+func (h *scriptHost) keyAnd(name string, args []script.Value, min, max int) (string, []string, error) {
+	if max >= 0 {
+		max++
+	}
+	if err := argc(name, args, min+1, max); err != nil {
+		return "", nil, err
+	}
+	key, err := h.key(args)
+	if err != nil {
+		return "", nil, err
+	}
+	rest := make([]string, len(args)-1)
+	for i, arg := range args[1:] {
+		if rest[i], err = script.ToString(arg); err != nil {
+			return "", nil, err
+		}
+	}
+	return key, rest, nil
+}
+
+func bytesOrNil(value []byte, err error) (script.Value, error) {
+	if err == cache.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return string(value), nil
+}
+
+func (h *scriptHost) get(args []script.Value) (script.Value, error) {
+	key, _, err := h.keyAnd("get", args, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	value, _, err := h.c.GetWithVersion(key)
+	return bytesOrNil(value, err)
+}
+
+// set stores a value, with a TTL in milliseconds if given.
+func (h *scriptHost) set(args []script.Value) (script.Value, error) {
+	key, rest, err := h.keyAnd("set", args, 1, 2)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest[0]) > protocol.MaxValueSize {
+		return nil, fmt.Errorf("invalid value length: %d, (max %d)", len(rest[0]), protocol.MaxValueSize)
+	}
+	var ttl int64
+	if len(rest) == 2 {
+		if ttl, err = intArg(args[2]); err != nil || ttl <= 0 {
+			return nil, errors.New("set TTL must be a positive number of milliseconds")
+		}
+	}
+	h.c.Set(key, []byte(rest[0]))
+	if ttl > 0 {
+		h.c.Expire(key, millis(ttl))
+	}
+	return true, nil
+}
+
+func (h *scriptHost) del(args []script.Value) (script.Value, error) {
+	key, _, err := h.keyAnd("del", args, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	existed := h.c.Version(key) != 0
+	h.c.Delete(key)
+	return existed, nil
+}
+
+func (h *scriptHost) exists(args []script.Value) (script.Value, error) {
+	key, _, err := h.keyAnd("exists", args, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	return h.c.Version(key) != 0, nil
+}
+
+func (h *scriptHost) incrBy(args []script.Value) (script.Value, error) {
+	key, _, err := h.keyAnd("incrby", args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	delta, err := intArg(args[1])
+	if err != nil {
+		return nil, err
+	}
+	return h.c.IncrBy(key, delta, 0)
+}
+
+// expire sets a TTL in milliseconds; zero or less removes it.
+func (h *scriptHost) expire(args []script.Value) (script.Value, error) {
+	key, _, err := h.keyAnd("expire", args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	ms, err := intArg(args[1])
+	if err != nil {
+		return nil, err
+	}
+	return h.c.Expire(key, millis(ms)), nil
+}
+
+// millis converts a TTL in milliseconds to a time.Duration, clamping ones it
+// cannot hold. The cache saturates expiry times, so they last as good as
+// forever.
+func millis(ms int64) time.Duration {
+	return time.Duration(min(ms, math.MaxInt64/int64(time.Millisecond))) * time.Millisecond
+}
+
+func (h *scriptHost) hget(args []script.Value) (script.Value, error) {
+	key, rest, err := h.keyAnd("hget", args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	return bytesOrNil(h.c.HGet(key, rest[0]))
+}
+
+func (h *scriptHost) hset(args []script.Value) (script.Value, error) {
+	key, rest, err := h.keyAnd("hset", args, 2, 2)
+	if err != nil {
+		return nil, err
+	}
+	added, err := h.c.HSet(key, cache.FieldValue{Field: rest[0], Value: []byte(rest[1])})
+	return int64(added), err
+}
+
+func (h *scriptHost) sadd(args []script.Value) (script.Value, error) {
+	key, members, err := h.keyAnd("sadd", args, 1, -1)
+	if err != nil {
+		return nil, err
+	}
+	added, err := h.c.SAdd(key, members...)
+	return int64(added), err
+}
+
+func (h *scriptHost) srem(args []script.Value) (script.Value, error) {
+	key, members, err := h.keyAnd("srem", args, 1, -1)
+	if err != nil {
+		return nil, err
+	}
+	removed, err := h.c.SRem(key, members...)
+	return int64(removed), err
+}
+
+func (h *scriptHost) sismember(args []script.Value) (script.Value, error) {
+	key, rest, err := h.keyAnd("sismember", args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	return h.c.SIsMember(key, rest[0])
+}
+
+// zadd adds a single member with an integer score.
+func (h *scriptHost) zadd(args []script.Value) (script.Value, error) {
+	key, rest, err := h.keyAnd("zadd", args, 2, 2)
+	if err != nil {
+		return nil, err
+	}
+	score, err := intArg(args[1])
+	if err != nil {
+		return nil, err
+	}
+	added, err := h.c.ZAdd(key, cache.ScoredMember{Member: rest[1], Score: float64(score)})
+	return int64(added), err
+}
+
+func (h *scriptHost) zrem(args []script.Value) (script.Value, error) {
+	key, members, err := h.keyAnd("zrem", args, 1, -1)
+	if err != nil {
+		return nil, err
+	}
+	removed, err := h.c.ZRem(key, members...)
+	return int64(removed), err
+}
+
+func (h *scriptHost) zcard(args []script.Value) (script.Value, error) {
+	key, _, err := h.keyAnd("zcard", args, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	n, err := h.c.ZCard(key)
+	return int64(n), err
+}
+
+// zrangeByScore returns the members scored between min and max inclusive.
+func (h *scriptHost) zrangeByScore(args []script.Value) (script.Value, error) {
+	key, _, err := h.keyAnd("zrangebyscore", args, 2, 2)
+	if err != nil {
+		return nil, err
+	}
+	min, err := intArg(args[1])
+	if err != nil {
+		return nil, err
+	}
+	max, err := intArg(args[2])
+	if err != nil {
+		return nil, err
+	}
+	members, err := h.c.ZRangeByScore(key, float64(min), float64(max), false)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]script.Value, len(members))
+	for i, m := range members {
+		list[i] = m.Member
+	}
+	return list, nil
+}
+
+func (h *scriptHost) push(back bool) script.Func {
+	name := "lpush"
+	if back {
+		name = "rpush"
+	}
+	return func(args []script.Value) (script.Value, error) {
+		key, rest, err := h.keyAnd(name, args, 1, -1)
+		if err != nil {
+			return nil, err
+		}
+		values := make([][]byte, len(rest))
+		for i, v := range rest {
+			values[i] = []byte(v)
+		}
+		var n int
+		if back {
+			n, err = h.c.RPush(key, values...)
+		} else {
+			n, err = h.c.LPush(key, values...)
+		}
+		return int64(n), err
+	}
+}
+
+func (h *scriptHost) lpop(args []script.Value) (script.Value, error) {
+	key, _, err := h.keyAnd("lpop", args, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	return bytesOrNil(h.c.LPop(key))
+}
+
+func (h *scriptHost) llen(args []script.Value) (script.Value, error) {
+	key, _, err := h.keyAnd("llen", args, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	n, err := h.c.LLen(key)
+	return int64(n), err
+}
+
+// now returns the server's time in Unix milliseconds.
+func now(args []script.Value) (script.Value, error) {
+	if err := argc("now", args, 0, 0); err != nil {
+		return nil, err
+	}
+	return time.Now().UnixMilli(), nil
+}
@@ -2,11 +2,17 @@ package server
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net"
+	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/jasonrowsell/zerocache/internal/cache"
 	"github.com/jasonrowsell/zerocache/pkg/protocol"
@@ -14,16 +20,37 @@ import (
 
 // Server holds the dependencies for the ZeroCache server.
 type Server struct {
-	cache    *cache.Cache
-	wg       sync.WaitGroup
-	shutdown chan struct{}
+	// namespaces maps namespace names to the caches backing them.
+	namespaces map[string]*cache.Cache
+	nsMu       sync.RWMutex
+	broker     *broker
+	scripts    *scriptCache
+	wg         sync.WaitGroup
+	shutdown   chan struct{}
+	// ctx is cancelled on shutdown to release connections parked in blocking commands.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// keyspaceEvents are the cache events published over pub/sub, guarded by nsMu.
+	keyspaceEvents cache.EventType
+	// maxStreamValue is the largest value SETSTREAM accepts.
+	maxStreamValue atomic.Int64
 }
 
+// New creates a server whose DefaultNamespace is backed by c.
+// Further namespaces can be registered with AddNamespace.
 func New(c *cache.Cache) *Server {
-	return &Server{
-		cache:    c,
-		shutdown: make(chan struct{}),
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		namespaces: map[string]*cache.Cache{DefaultNamespace: c},
+		broker:     newBroker(),
+		scripts:    newScriptCache(),
+		shutdown:   make(chan struct{}),
+		ctx:        ctx,
+		cancel:     cancel,
 	}
+	s.maxStreamValue.Store(DefaultMaxStreamValueSize)
+	return s
 }
 
 // ListenAndServe starts the TCP server and listens for incoming connections.
@@ -67,17 +94,28 @@ func (s *Server) ListenAndServe(addr string) error {
 
 func (s *Server) Shutdown() {
 	close(s.shutdown) // Signal listener to stop accepting
+	s.cancel()        // Wake connections parked in blocking commands
 
 	s.wg.Wait() // Wait for all active connections to finish
 	log.Println("Server connections closed.")
 }
 
 func (s *Server) handleConnection(conn net.Conn) {
+	defaultCache, _ := s.namespace(DefaultNamespace)
+	s.serveConn(conn, conn, &session{namespace: DefaultNamespace, cache: defaultCache})
+}
+
+// serveConn serves conn until it is closed, picking up the connection's
+// session where it stands, and closes it. Commands are read from input, which
+// is conn itself unless some input was already read off it. Responses are
+// written to conn directly, so that WriteValue can use writev.
+func (s *Server) serveConn(conn net.Conn, input io.Reader, sess *session) {
 	defer conn.Close()
 
 	// Use bufio for potentially better performance with buffered I/O
-	reader := bufio.NewReader(conn)
+	reader := bufio.NewReader(input)
 	writer := bufio.NewWriter(conn)
+	cio := &connIO{conn: conn, reader: reader, writer: writer}
 
 	for {
 		// 1. Read and Parse Command (using our custom protocol)
@@ -95,12 +133,46 @@ func (s *Server) handleConnection(conn net.Conn) {
 			return // Close connection on err or EOF
 		}
 
+		// The value of SETSTREAM follows its frame. It is read in full even if
+		// the command is rejected, to keep the connection in sync.
+		var rejected error
+		if cmd.Type == protocol.CmdSetStream {
+			cmd.Value, rejected, err = readChunks(reader, int(s.maxStreamValue.Load()))
+			if err != nil {
+				log.Printf("Error reading SETSTREAM value from %s: %v", conn.RemoteAddr(), err)
+				return
+			}
+		}
+
+		// Subscribing hands the connection over to push mode until the client
+		// unsubscribes from everything.
+		if sess.multi == nil && (cmd.Type == protocol.CmdSubscribe || cmd.Type == protocol.CmdPSubscribe) {
+			if err := s.runSubscriber(conn, reader, writer, cmd); err != nil {
+				if err != io.EOF {
+					log.Printf("Subscriber %s disconnected: %v", conn.RemoteAddr(), err)
+				}
+				return
+			}
+			continue
+		}
+
 		// 2. Execute command
-		response, err := s.executeCommand(cmd)
+		var response *Response
+		if rejected != nil {
+			_, _, err = s.resolve(sess, cmd)
+			if err == nil {
+				err = rejected
+			}
+			if sess.multi != nil {
+				sess.multi.failed = true // As for any command rejected while queuing
+			}
+		} else {
+			response, err = s.execute(sess, cmd, cio)
+		}
 		if err != nil {
 			log.Printf("Error executing command (%s) from %s: %v", cmd.Name(), conn.RemoteAddr(), err)
 			_ = WriteError(writer, err.Error()) // Send error response
-		} else {
+		} else if response != nil { // GET and GETSTREAM write their own response
 			// 3. Write response
 			err = WriteResponse(writer, response)
 			if err != nil {
@@ -119,21 +191,273 @@ func (s *Server) handleConnection(conn net.Conn) {
 
 }
 
-func (s *Server) executeCommand(cmd *Command) (*Response, error) {
+// connIO is a connection served by a goroutine of its own, for the commands
+// that write their response themselves or wait on the connection.
+type connIO struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+// execute runs cmd in the connection's session. cio is nil in the epoll
+// engine, which writes every response itself and hands connections over to
+// a goroutine before running the commands that need one (see needsConn).
+func (s *Server) execute(sess *session, cmd *Command, cio *connIO) (*Response, error) {
+	namespace, c, err := s.resolve(sess, cmd)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case sess.multi != nil && cmd.Type != protocol.CmdExec && cmd.Type != protocol.CmdDiscard:
+		return s.queueCommand(sess, cmd)
+	case cmd.Type == protocol.CmdMulti:
+		return s.executeMulti(sess, namespace, c)
+	case cmd.Type == protocol.CmdExec:
+		return s.executeExec(sess)
+	case cmd.Type == protocol.CmdDiscard:
+		return s.executeDiscard(sess)
+	case cmd.Type == protocol.CmdWatch:
+		return s.executeWatch(sess, c, cmd)
+	case cmd.Type == protocol.CmdUnwatch:
+		return s.executeUnwatch(sess)
+	case cmd.Type == protocol.CmdSelect:
+		return s.executeSelect(sess, cmd)
+	case cmd.Type == protocol.CmdStats:
+		return statsResponse(namespace, c), nil
+	case cmd.Type == protocol.CmdPublish:
+		return s.executePublish(cmd)
+	case cmd.Type == protocol.CmdUnsubscribe || cmd.Type == protocol.CmdPUnsubscribe:
+		return nil, fmt.Errorf("%s is only allowed while subscribed", cmd.Name())
+	case cio == nil:
+		if needsConn(cmd) {
+			return nil, fmt.Errorf("internal: %s needs a connection of its own", cmd.Name())
+		}
+	case cmd.Type == protocol.CmdGet:
+		return s.executeGet(cio.conn, cio.writer, c, cmd)
+	case cmd.Type == protocol.CmdGetStream:
+		return s.executeGetStream(cio.writer, c, cmd)
+	case commandSpecs[cmd.Type].blocking:
+		return s.executeBlocking(cio.conn, cio.reader, c, cmd)
+	}
+	return s.executeCommand(c, cmd)
+}
+
+// needsConn reports whether cmd reads from or writes to the connection beyond
+// its own frame and response: streams, subscriptions and blocking commands.
+func needsConn(cmd *Command) bool {
+	switch cmd.Type {
+	case protocol.CmdSetStream, protocol.CmdGetStream, protocol.CmdSubscribe, protocol.CmdPSubscribe:
+		return true
+	}
+	return commandSpecs[cmd.Type].blocking
+}
+
+// executeGet answers GET with the value lent by the cache, written out by
+// WriteValue without copying it, and returns a nil response. Within MULTI and
+// scripts GET goes through executeCommand instead.
+func (s *Server) executeGet(conn net.Conn, w *bufio.Writer, c *cache.Cache, cmd *Command) (*Response, error) {
+	var writeErr error
+	err := c.View(cmd.Key, func(value []byte, _ uint64) error {
+		if len(value) > protocol.MaxPayloadSize {
+			return fmt.Errorf("value of %d bytes is too large for GET (max %d), use GETSTREAM", len(value), protocol.MaxPayloadSize)
+		}
+		writeErr = WriteValue(w, conn, value)
+		return nil
+	})
+	if err != nil {
+		return notFoundOr(err)
+	}
+	return nil, writeErr
+}
+
+// executeBlocking runs a command that may park the connection until data is
+// available, or otherwise take a while. Meanwhile, a watcher peeks at the
+// connection so that a client disconnecting cancels the command, rather than
+// leaving an element to be popped on behalf of a client that is gone.
+func (s *Server) executeBlocking(conn net.Conn, reader *bufio.Reader, c *cache.Cache, cmd *Command) (*Response, error) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+
+	watchDone := make(chan struct{})
+	go func() {
+		defer close(watchDone)
+		if _, err := reader.Peek(1); err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			cancel() // Client went away
+		}
+	}()
+
+	var response *Response
+	var err error
+	switch cmd.Type {
+	case protocol.CmdBLPop:
+		response, err = s.executeBLPop(ctx, c, cmd)
+	case protocol.CmdDelPattern:
+		response, err = s.executeDelPattern(ctx, c, cmd)
+	default:
+		err = fmt.Errorf("internal error: command type %d is not blocking", cmd.Type)
+	}
+
+	// Wake the watcher and wait for it, so it never races with the next ReadCommand.
+	_ = conn.SetReadDeadline(time.Now())
+	<-watchDone
+	_ = conn.SetReadDeadline(time.Time{})
+
+	return response, err
+}
+
+func (s *Server) executeCommand(c *cache.Cache, cmd *Command) (*Response, error) {
 	switch cmd.Type {
-	case protocol.CmdSet:
-		s.cache.Set(cmd.Key, cmd.Value)
+	case protocol.CmdSet, protocol.CmdSetStream:
+		c.Set(cmd.Key, cmd.Value)
 		return &Response{Type: protocol.RespOK}, nil
 	case protocol.CmdGet:
-		value, found := s.cache.Get(cmd.Key)
-		if !found {
-			return &Response{Type: protocol.RespNotFound}, nil
+		value, _, err := c.GetWithVersion(cmd.Key)
+		if err != nil {
+			return notFoundOr(err)
+		}
+		if len(value) > protocol.MaxPayloadSize {
+			return nil, fmt.Errorf("value of %d bytes is too large for GET (max %d), use GETSTREAM", len(value), protocol.MaxPayloadSize)
 		}
 		return &Response{Type: protocol.RespValue, Value: value}, nil
 	case protocol.CmdDel:
-		s.cache.Delete(cmd.Key)
+		c.Delete(cmd.Key)
+		return &Response{Type: protocol.RespOK}, nil
+	case protocol.CmdGetV:
+		value, version, err := c.GetWithVersion(cmd.Key)
+		if err != nil {
+			return notFoundOr(err)
+		}
+		return &Response{Type: protocol.RespArray, Value: protocol.EncodeArgs(value, protocol.EncodeUint64(version))}, nil
+	case protocol.CmdCAS:
+		if len(cmd.Args) != 2 {
+			return nil, fmt.Errorf("wrong number of arguments for CAS (expected value and version)")
+		}
+		if len(cmd.Args[0]) > protocol.MaxValueSize {
+			return nil, fmt.Errorf("invalid value length: %d, (max %d)", len(cmd.Args[0]), protocol.MaxValueSize)
+		}
+		expected, err := protocol.DecodeUint64(cmd.Args[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid CAS version: %w", err)
+		}
+		version, err := c.CompareAndSwap(cmd.Key, cmd.Args[0], expected)
+		switch err {
+		case nil:
+			return &Response{Type: protocol.RespInt, Value: protocol.EncodeUint64(version)}, nil
+		case cache.ErrNotFound:
+			return &Response{Type: protocol.RespNotFound}, nil
+		case cache.ErrVersionMismatch:
+			return &Response{Type: protocol.RespNotStored}, nil
+		default:
+			return nil, err
+		}
+	case protocol.CmdSetNX, protocol.CmdSetXX:
+		var stored bool
+		if cmd.Type == protocol.CmdSetNX {
+			stored = c.SetNX(cmd.Key, cmd.Value)
+		} else {
+			stored = c.SetXX(cmd.Key, cmd.Value)
+		}
+		if !stored {
+			return &Response{Type: protocol.RespNotStored}, nil
+		}
+		return &Response{Type: protocol.RespOK}, nil
+	case protocol.CmdIncr, protocol.CmdDecr, protocol.CmdIncrBy:
+		return s.executeCounter(c, cmd)
+	case protocol.CmdHSet, protocol.CmdHGet, protocol.CmdHDel, protocol.CmdHGetAll, protocol.CmdHLen:
+		return s.executeHash(c, cmd)
+	case protocol.CmdLPush, protocol.CmdRPush, protocol.CmdLPop, protocol.CmdRPop, protocol.CmdLRange, protocol.CmdLLen:
+		return s.executeList(c, cmd)
+	case protocol.CmdZAdd, protocol.CmdZRem, protocol.CmdZScore, protocol.CmdZRange, protocol.CmdZRevRange,
+		protocol.CmdZRangeByScore, protocol.CmdZRevRangeByScore, protocol.CmdZRank, protocol.CmdZRevRank, protocol.CmdZCard:
+		return s.executeZSet(c, cmd)
+	case protocol.CmdSAdd, protocol.CmdSRem, protocol.CmdSIsMember, protocol.CmdSMembers, protocol.CmdSCard,
+		protocol.CmdSRandMember, protocol.CmdSInter, protocol.CmdSUnion, protocol.CmdSDiff:
+		return s.executeSet(c, cmd)
+	case protocol.CmdScan:
+		return s.executeScan(c, cmd)
+	case protocol.CmdEval, protocol.CmdEvalSHA, protocol.CmdScriptLoad:
+		return s.executeScript(c, cmd)
+	case protocol.CmdThrottle:
+		return s.executeThrottle(c, cmd)
+	case protocol.CmdLock, protocol.CmdUnlock, protocol.CmdExtend:
+		return s.executeLock(c, cmd)
+	case protocol.CmdPFAdd, protocol.CmdPFCount, protocol.CmdPFMerge:
+		return s.executeHLL(c, cmd)
+	case protocol.CmdBFReserve, protocol.CmdBFAdd, protocol.CmdBFExists:
+		return s.executeBloom(c, cmd)
+	case protocol.CmdFlushAll:
+		return intResponse(int64(c.FlushAll())), nil
+	case protocol.CmdSetTags:
+		if len(cmd.Args) < 2 {
+			return nil, fmt.Errorf("wrong number of arguments for SETTAGS (expected value and at least one tag)")
+		}
+		if len(cmd.Args[0]) > protocol.MaxValueSize {
+			return nil, fmt.Errorf("invalid value length: %d, (max %d)", len(cmd.Args[0]), protocol.MaxValueSize)
+		}
+		tags := argStrings(cmd.Args[1:])
+		c.SetWithTags(cmd.Key, cmd.Args[0], tags...)
 		return &Response{Type: protocol.RespOK}, nil
+	case protocol.CmdInvalidateTag:
+		if len(cmd.Args) != 1 {
+			return nil, fmt.Errorf("wrong number of arguments for INVALIDATE (expected tag)")
+		}
+		return intResponse(int64(c.InvalidateTag(string(cmd.Args[0])))), nil
 	default:
 		return nil, fmt.Errorf("internal error: unknown command type %d reached execution", cmd.Type)
 	}
 }
+
+// notFoundOr turns cache.ErrNotFound into a NotFound response and passes any other error through.
+func notFoundOr(err error) (*Response, error) {
+	if err == cache.ErrNotFound {
+		return &Response{Type: protocol.RespNotFound}, nil
+	}
+	return nil, err
+}
+
+// intResponse builds an integer response.
+func intResponse(n int64) *Response {
+	return &Response{Type: protocol.RespInt, Value: protocol.EncodeInt64(n)}
+}
+
+// executeCounter handles INCR, DECR and INCRBY. INCRBY takes the delta as its
+// first argument; all three accept a trailing TTL in milliseconds that applies
+// only when the key is created.
+func (s *Server) executeCounter(c *cache.Cache, cmd *Command) (*Response, error) {
+	args := cmd.Args
+	delta := int64(1)
+	switch cmd.Type {
+	case protocol.CmdDecr:
+		delta = -1
+	case protocol.CmdIncrBy:
+		if len(args) == 0 {
+			return nil, fmt.Errorf("wrong number of arguments for %s (expected delta)", cmd.Name())
+		}
+		var err error
+		if delta, err = protocol.DecodeInt64(args[0]); err != nil {
+			return nil, fmt.Errorf("invalid %s delta: %w", cmd.Name(), err)
+		}
+		args = args[1:]
+	}
+
+	var ttl time.Duration
+	switch len(args) {
+	case 0:
+	case 1:
+		ttlMillis, err := protocol.DecodeUint64(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s TTL: %w", cmd.Name(), err)
+		}
+		// The cache saturates expiry times, so clamping to what a
+		// time.Duration holds makes such TTLs last as good as forever.
+		ttl = time.Duration(min(ttlMillis, math.MaxInt64/uint64(time.Millisecond))) * time.Millisecond
+	default:
+		return nil, fmt.Errorf("wrong number of arguments for %s", cmd.Name())
+	}
+
+	result, err := c.IncrBy(cmd.Key, delta, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return intResponse(result), nil
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/jasonrowsell/zerocache/internal/cache"
+	"github.com/jasonrowsell/zerocache/pkg/protocol"
+)
+
+// executeSet handles the set commands, including the keyless SINTER, SUNION
+// and SDIFF whose keys travel as arguments.
+func (s *Server) executeSet(c *cache.Cache, cmd *Command) (*Response, error) {
+	switch cmd.Type {
+	case protocol.CmdSAdd, protocol.CmdSRem:
+		if len(cmd.Args) == 0 {
+			return nil, fmt.Errorf("wrong number of arguments for %s (expected at least one member)", cmd.Name())
+		}
+		members := argStrings(cmd.Args)
+		var n int
+		var err error
+		if cmd.Type == protocol.CmdSAdd {
+			n, err = c.SAdd(cmd.Key, members...)
+		} else {
+			n, err = c.SRem(cmd.Key, members...)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return intResponse(int64(n)), nil
+
+	case protocol.CmdSIsMember:
+		if len(cmd.Args) != 1 {
+			return nil, fmt.Errorf("wrong number of arguments for SISMEMBER (expected member)")
+		}
+		found, err := c.SIsMember(cmd.Key, string(cmd.Args[0]))
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			return intResponse(1), nil
+		}
+		return intResponse(0), nil
+
+	case protocol.CmdSMembers:
+		members, err := c.SMembers(cmd.Key)
+		if err != nil {
+			return nil, err
+		}
+		return membersResponse(members), nil
+
+	case protocol.CmdSCard:
+		n, err := c.SCard(cmd.Key)
+		if err != nil {
+			return nil, err
+		}
+		return intResponse(int64(n)), nil
+
+	case protocol.CmdSRandMember:
+		if len(cmd.Args) != 1 {
+			return nil, fmt.Errorf("wrong number of arguments for SRANDMEMBER (expected count)")
+		}
+		count, err := protocol.DecodeInt64(cmd.Args[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid SRANDMEMBER count: %w", err)
+		}
+		// Each returned member costs at least its length prefix, so larger
+		// counts could never fit in a response.
+		if count < -protocol.MaxPayloadSize || count > protocol.MaxPayloadSize {
+			return nil, fmt.Errorf("SRANDMEMBER count out of range: %d", count)
+		}
+		members, err := c.SRandMember(cmd.Key, int(count))
+		if err != nil {
+			return nil, err
+		}
+		return membersResponse(members), nil
+
+	case protocol.CmdSInter, protocol.CmdSUnion, protocol.CmdSDiff:
+		if len(cmd.Args) == 0 {
+			return nil, fmt.Errorf("wrong number of arguments for %s (expected at least one key)", cmd.Name())
+		}
+		keys := make([]string, len(cmd.Args))
+		for i, arg := range cmd.Args {
+			if len(arg) == 0 || len(arg) > protocol.MaxKeySize {
+				return nil, fmt.Errorf("invalid key length: %d, (max %d)", len(arg), protocol.MaxKeySize)
+			}
+			keys[i] = string(arg)
+		}
+		var members []string
+		var err error
+		switch cmd.Type {
+		case protocol.CmdSInter:
+			members, err = c.SInter(keys...)
+		case protocol.CmdSUnion:
+			members, err = c.SUnion(keys...)
+		default:
+			members, err = c.SDiff(keys...)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return membersResponse(members), nil
+
+	default:
+		return nil, fmt.Errorf("internal error: unknown set command type %d", cmd.Type)
+	}
+}
+
+// membersResponse encodes members as an array response.
+func membersResponse(members []string) *Response {
+	var payload []byte
+	for _, member := range members {
+		payload = protocol.AppendArg(payload, []byte(member))
+	}
+	return &Response{Type: protocol.RespArray, Value: payload}
+}
+
+// argStrings converts raw arguments to strings.
+func argStrings(args [][]byte) []string {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		strs[i] = string(arg)
+	}
+	return strs
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/jasonrowsell/zerocache/internal/cache"
+	"github.com/jasonrowsell/zerocache/pkg/protocol"
+)
+
+// DefaultMaxStreamValueSize is the default limit on values stored with
+// SETSTREAM.
+const DefaultMaxStreamValueSize = 16 << 20
+
+var errStreamAborted = errors.New("SETSTREAM aborted by the client")
+
+// SetMaxStreamValueSize sets the largest value SETSTREAM accepts. Larger
+// values are read off the connection and discarded, and the command fails.
+func (s *Server) SetMaxStreamValueSize(n int) {
+	s.maxStreamValue.Store(int64(n))
+}
+
+// readChunks reads a chunk stream into a value of its own, growing it chunk
+// by chunk rather than reading it into a pooled buffer. The stream is always
+// consumed to its end: if it was aborted or the value exceeds limit, the
+// value is discarded and rejected says why. err is set if the stream could
+// not be read, after which the connection is out of sync.
+func readChunks(r io.Reader, limit int) (value []byte, rejected error, err error) {
+	var header [4]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, nil, fmt.Errorf("failed to read chunk header: %w", err)
+		}
+		n := binary.BigEndian.Uint32(header[:])
+		switch {
+		case n == 0:
+			if rejected != nil {
+				return nil, rejected, nil
+			}
+			if value == nil {
+				value = []byte{} // An empty value is still a value
+			}
+			return value, nil, nil
+		case n == protocol.StreamAbort:
+			return nil, errStreamAborted, nil
+		case n > protocol.MaxChunkSize:
+			return nil, nil, fmt.Errorf("invalid chunk length: %d, (max %d)", n, protocol.MaxChunkSize)
+		}
+
+		if rejected == nil && len(value)+int(n) > limit {
+			rejected = fmt.Errorf("stream value exceeds the server limit of %d bytes", limit)
+			value = nil
+		}
+		if rejected != nil {
+			if _, err := io.CopyN(io.Discard, r, int64(n)); err != nil {
+				return nil, nil, fmt.Errorf("failed to read chunk: %w", err)
+			}
+			continue
+		}
+		start := len(value)
+		value = slices.Grow(value, int(n))[:start+int(n)]
+		if _, err := io.ReadFull(r, value[start:]); err != nil {
+			return nil, nil, fmt.Errorf("failed to read chunk: %w", err)
+		}
+	}
+}
+
+// executeGetStream handles GETSTREAM. A found value is written straight to w
+// as a RespStream response, in chunks sliced from the value the cache lends
+// (see cache.View), and a nil response is returned. Write errors stick to w
+// and surface when it is flushed.
+func (s *Server) executeGetStream(w *bufio.Writer, c *cache.Cache, cmd *Command) (*Response, error) {
+	err := c.View(cmd.Key, func(value []byte, _ uint64) error {
+		var header [5]byte
+		header[0] = protocol.RespStream
+		_, _ = w.Write(header[:])
+		for len(value) > 0 {
+			chunk := value[:min(len(value), protocol.StreamChunkSize)]
+			value = value[len(chunk):]
+			_, _ = w.Write(binary.BigEndian.AppendUint32(header[:0], uint32(len(chunk))))
+			_, _ = w.Write(chunk)
+		}
+		_, _ = w.Write(binary.BigEndian.AppendUint32(header[:0], 0))
+		return nil
+	})
+	if err != nil {
+		return notFoundOr(err)
+	}
+	return nil, nil
+}
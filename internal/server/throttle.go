@@ -0,0 +1,51 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/jasonrowsell/zerocache/internal/cache"
+	"github.com/jasonrowsell/zerocache/pkg/protocol"
+)
+
+// executeThrottle handles THROTTLE: limit, window in milliseconds and an
+// optional cost, defaulting to 1.
+func (s *Server) executeThrottle(c *cache.Cache, cmd *Command) (*Response, error) {
+	if len(cmd.Args) != 2 && len(cmd.Args) != 3 {
+		return nil, fmt.Errorf("wrong number of arguments for THROTTLE (expected limit, window and optional cost)")
+	}
+	// Values too large to convert are clamped: such limits and costs behave the
+	// same, and such windows are rejected by the cache.
+	maxParams := [3]uint64{math.MaxInt32, math.MaxInt64 / uint64(time.Millisecond), math.MaxInt32}
+	params := [3]uint64{0, 0, 1}
+	for i, arg := range cmd.Args {
+		n, err := protocol.DecodeUint64(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid THROTTLE argument: %w", err)
+		}
+		params[i] = min(n, maxParams[i])
+	}
+	limit, window, cost := int(params[0]), time.Duration(params[1])*time.Millisecond, int(params[2])
+
+	result, err := c.Throttle(cmd.Key, limit, window, cost)
+	if err != nil {
+		return nil, err
+	}
+	allowed := int64(0)
+	if result.Allowed {
+		allowed = 1
+	}
+	return &Response{Type: protocol.RespArray, Value: protocol.EncodeArgs(
+		protocol.EncodeInt64(allowed),
+		protocol.EncodeInt64(int64(result.Remaining)),
+		protocol.EncodeInt64(ceilMillis(result.RetryAfter)),
+		protocol.EncodeInt64(ceilMillis(result.ResetAfter)),
+	)}, nil
+}
+
+// ceilMillis rounds d up to whole milliseconds, so that a client waiting for
+// the reported time is never early.
+func ceilMillis(d time.Duration) int64 {
+	return int64((d + time.Millisecond - 1) / time.Millisecond)
+}
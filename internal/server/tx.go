@@ -0,0 +1,200 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jasonrowsell/zerocache/internal/cache"
+	"github.com/jasonrowsell/zerocache/pkg/protocol"
+)
+
+// maxQueuedCommands bounds the length of a transaction.
+const maxQueuedCommands = 1024
+
+var (
+	errExecAborted = errors.New("EXECABORT transaction discarded because of previous errors")
+	errNoMulti     = errors.New("not in a transaction")
+)
+
+// transaction holds the commands queued since MULTI.
+type transaction struct {
+	namespace string
+	cache     *cache.Cache
+	queued    []*Command
+	failed    bool // A command was rejected while queuing
+}
+
+// watchedKey is a key registered with WATCH and the version it had then.
+type watchedKey struct {
+	cache   *cache.Cache
+	key     string
+	version uint64
+}
+
+// executeMulti handles MULTI. The transaction runs in the namespace MULTI was
+// sent to.
+func (s *Server) executeMulti(sess *session, namespace string, c *cache.Cache) (*Response, error) {
+	if sess.multi != nil {
+		return nil, errors.New("MULTI calls can not be nested")
+	}
+	sess.multi = &transaction{namespace: namespace, cache: c}
+	return &Response{Type: protocol.RespOK}, nil
+}
+
+// executeWatch handles WATCH, recording the current version of each key.
+func (s *Server) executeWatch(sess *session, c *cache.Cache, cmd *Command) (*Response, error) {
+	if sess.multi != nil {
+		return nil, errors.New("WATCH inside MULTI is not allowed")
+	}
+	if len(cmd.Args) == 0 {
+		return nil, fmt.Errorf("wrong number of arguments for WATCH (expected at least one key)")
+	}
+	for _, arg := range cmd.Args {
+		if len(arg) == 0 || len(arg) > protocol.MaxKeySize {
+			return nil, fmt.Errorf("invalid key length: %d, (max %d)", len(arg), protocol.MaxKeySize)
+		}
+	}
+	for _, arg := range cmd.Args {
+		key := string(arg)
+		sess.watched = append(sess.watched, watchedKey{cache: c, key: key, version: c.Version(key)})
+	}
+	return &Response{Type: protocol.RespOK}, nil
+}
+
+// queueCommand queues cmd in the session's transaction. A command that could
+// never run inside one is rejected, which makes the whole transaction fail.
+func (s *Server) queueCommand(sess *session, cmd *Command) (*Response, error) {
+	tx := sess.multi
+	_, c, err := s.resolve(sess, cmd)
+	switch {
+	case err != nil:
+	case c != tx.cache:
+		err = errors.New("all commands of a transaction must run in the namespace of its MULTI")
+	case cmd.Type == protocol.CmdMulti:
+		err = errors.New("MULTI calls can not be nested")
+	case cmd.Type == protocol.CmdWatch:
+		err = errors.New("WATCH inside MULTI is not allowed")
+	case cmd.Type == protocol.CmdUnwatch, cmd.Type == protocol.CmdBLPop, cmd.Type == protocol.CmdSelect,
+		cmd.Type == protocol.CmdSubscribe, cmd.Type == protocol.CmdPSubscribe,
+		cmd.Type == protocol.CmdUnsubscribe, cmd.Type == protocol.CmdPUnsubscribe, cmd.Type == protocol.CmdGetStream:
+		err = fmt.Errorf("%s is not allowed in a transaction", cmd.Name())
+	case len(tx.queued) >= maxQueuedCommands:
+		err = fmt.Errorf("too many commands in transaction (max %d)", maxQueuedCommands)
+	}
+	if err != nil {
+		tx.failed = true
+		return nil, err
+	}
+	tx.queued = append(tx.queued, cmd)
+	return &Response{Type: protocol.RespOK}, nil
+}
+
+// executeDiscard handles DISCARD.
+func (s *Server) executeDiscard(sess *session) (*Response, error) {
+	if sess.multi == nil {
+		return nil, errNoMulti
+	}
+	sess.multi, sess.watched = nil, nil
+	return &Response{Type: protocol.RespOK}, nil
+}
+
+// executeUnwatch handles UNWATCH.
+func (s *Server) executeUnwatch(sess *session) (*Response, error) {
+	sess.watched = nil
+	return &Response{Type: protocol.RespOK}, nil
+}
+
+// executeExec handles EXEC: with the shards of every key involved locked, it
+// checks the watched keys and runs the queued commands. Errors of individual
+// commands become their responses and do not stop the rest, as nothing is
+// rolled back.
+func (s *Server) executeExec(sess *session) (*Response, error) {
+	tx, watched := sess.multi, sess.watched
+	sess.multi, sess.watched = nil, nil
+	if tx == nil {
+		return nil, errNoMulti
+	}
+	if tx.failed {
+		return nil, errExecAborted
+	}
+
+	keys, all := transactionKeys(tx.queued)
+	for _, w := range watched {
+		if w.cache != tx.cache {
+			// Checking them atomically would mean locking two caches at once.
+			return nil, errors.New("EXEC with keys watched in another namespace")
+		}
+		keys = append(keys, w.key)
+	}
+
+	var payload []byte
+	aborted := false
+	run := func(c *cache.Cache) {
+		for _, w := range watched {
+			if c.Version(w.key) != w.version {
+				aborted = true
+				return
+			}
+		}
+		for _, cmd := range tx.queued {
+			resp, err := s.executeQueued(c, tx.namespace, cmd)
+			if err != nil {
+				resp = &Response{Type: protocol.RespError, Value: []byte(err.Error())}
+			}
+			payload = protocol.AppendArg(payload, append([]byte{resp.Type}, resp.Value...))
+		}
+	}
+	if all {
+		tx.cache.AtomicallyAll(run)
+	} else {
+		tx.cache.Atomically(keys, run)
+	}
+
+	if aborted {
+		return &Response{Type: protocol.RespNotStored}, nil
+	}
+	if len(payload) > protocol.MaxPayloadSize {
+		return nil, errors.New("transaction ran, but its responses exceed the maximum response size")
+	}
+	return &Response{Type: protocol.RespArray, Value: payload}, nil
+}
+
+// executeQueued runs a queued command inside a transaction.
+func (s *Server) executeQueued(c *cache.Cache, namespace string, cmd *Command) (*Response, error) {
+	switch cmd.Type {
+	case protocol.CmdStats:
+		return statsResponse(namespace, c), nil
+	case protocol.CmdPublish:
+		return s.executePublish(cmd)
+	case protocol.CmdDelPattern:
+		return s.executeDelPattern(context.Background(), c, cmd)
+	default:
+		return s.executeCommand(c, cmd)
+	}
+}
+
+// transactionKeys returns the keys cmds touch, or all=true if one of them
+// may touch any key.
+func transactionKeys(cmds []*Command) (keys []string, all bool) {
+	for _, cmd := range cmds {
+		switch cmd.Type {
+		case protocol.CmdSInter, protocol.CmdSUnion, protocol.CmdSDiff, protocol.CmdPFCount:
+			keys = append(keys, argStrings(cmd.Args)...)
+		case protocol.CmdPFMerge:
+			keys = append(append(keys, cmd.Key), argStrings(cmd.Args)...)
+		case protocol.CmdEval, protocol.CmdEvalSHA:
+			// Malformed ones fail without touching any key.
+			scriptKeys, _, _ := scriptArgs(cmd)
+			keys = append(keys, scriptKeys...)
+		case protocol.CmdScan, protocol.CmdDelPattern, protocol.CmdFlushAll,
+			protocol.CmdInvalidateTag, protocol.CmdStats:
+			return nil, true
+		default:
+			if !commandSpecs[cmd.Type].keyless {
+				keys = append(keys, cmd.Key)
+			}
+		}
+	}
+	return keys, false
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/jasonrowsell/zerocache/internal/cache"
+	"github.com/jasonrowsell/zerocache/pkg/protocol"
+)
+
+// executeZSet handles the sorted set commands.
+func (s *Server) executeZSet(c *cache.Cache, cmd *Command) (*Response, error) {
+	switch cmd.Type {
+	case protocol.CmdZAdd:
+		if len(cmd.Args) == 0 || len(cmd.Args)%2 != 0 {
+			return nil, fmt.Errorf("wrong number of arguments for ZADD (expected score/member pairs)")
+		}
+		members := make([]cache.ScoredMember, 0, len(cmd.Args)/2)
+		for i := 0; i < len(cmd.Args); i += 2 {
+			score, err := protocol.DecodeFloat64(cmd.Args[i])
+			if err != nil {
+				return nil, fmt.Errorf("invalid ZADD score: %w", err)
+			}
+			members = append(members, cache.ScoredMember{Member: string(cmd.Args[i+1]), Score: score})
+		}
+		added, err := c.ZAdd(cmd.Key, members...)
+		if err != nil {
+			return nil, err
+		}
+		return intResponse(int64(added)), nil
+
+	case protocol.CmdZRem:
+		if len(cmd.Args) == 0 {
+			return nil, fmt.Errorf("wrong number of arguments for ZREM (expected at least one member)")
+		}
+		members := make([]string, len(cmd.Args))
+		for i, arg := range cmd.Args {
+			members[i] = string(arg)
+		}
+		removed, err := c.ZRem(cmd.Key, members...)
+		if err != nil {
+			return nil, err
+		}
+		return intResponse(int64(removed)), nil
+
+	case protocol.CmdZScore:
+		if len(cmd.Args) != 1 {
+			return nil, fmt.Errorf("wrong number of arguments for ZSCORE (expected member)")
+		}
+		score, err := c.ZScore(cmd.Key, string(cmd.Args[0]))
+		if err != nil {
+			return notFoundOr(err)
+		}
+		return &Response{Type: protocol.RespFloat, Value: protocol.EncodeFloat64(score)}, nil
+
+	case protocol.CmdZRange, protocol.CmdZRevRange:
+		if len(cmd.Args) != 2 {
+			return nil, fmt.Errorf("wrong number of arguments for %s (expected start and stop)", cmd.Name())
+		}
+		start, err := protocol.DecodeInt64(cmd.Args[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s start: %w", cmd.Name(), err)
+		}
+		stop, err := protocol.DecodeInt64(cmd.Args[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s stop: %w", cmd.Name(), err)
+		}
+		members, err := c.ZRange(cmd.Key, int(start), int(stop), cmd.Type == protocol.CmdZRevRange)
+		if err != nil {
+			return nil, err
+		}
+		return scoredMembersResponse(members), nil
+
+	case protocol.CmdZRangeByScore, protocol.CmdZRevRangeByScore:
+		if len(cmd.Args) != 2 {
+			return nil, fmt.Errorf("wrong number of arguments for %s (expected min and max)", cmd.Name())
+		}
+		min, err := protocol.DecodeFloat64(cmd.Args[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s min: %w", cmd.Name(), err)
+		}
+		max, err := protocol.DecodeFloat64(cmd.Args[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s max: %w", cmd.Name(), err)
+		}
+		members, err := c.ZRangeByScore(cmd.Key, min, max, cmd.Type == protocol.CmdZRevRangeByScore)
+		if err != nil {
+			return nil, err
+		}
+		return scoredMembersResponse(members), nil
+
+	case protocol.CmdZRank, protocol.CmdZRevRank:
+		if len(cmd.Args) != 1 {
+			return nil, fmt.Errorf("wrong number of arguments for %s (expected member)", cmd.Name())
+		}
+		rank, err := c.ZRank(cmd.Key, string(cmd.Args[0]), cmd.Type == protocol.CmdZRevRank)
+		if err != nil {
+			return notFoundOr(err)
+		}
+		return intResponse(int64(rank)), nil
+
+	case protocol.CmdZCard:
+		n, err := c.ZCard(cmd.Key)
+		if err != nil {
+			return nil, err
+		}
+		return intResponse(int64(n)), nil
+
+	default:
+		return nil, fmt.Errorf("internal error: unknown sorted set command type %d", cmd.Type)
+	}
+}
+
+// scoredMembersResponse encodes members as an array of alternating members and scores.
+func scoredMembersResponse(members []cache.ScoredMember) *Response {
+	var payload []byte
+	for _, m := range members {
+		payload = protocol.AppendArg(payload, []byte(m.Member))
+		payload = protocol.AppendArg(payload, protocol.EncodeFloat64(m.Score))
+	}
+	return &Response{Type: protocol.RespArray, Value: payload}
+}
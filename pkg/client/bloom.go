@@ -0,0 +1,95 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/jasonrowsell/zerocache/pkg/protocol"
+)
+
+// BFReserve creates an empty Bloom filter at key that holds capacity elements
+// with a false positive rate of errorRate. Beyond capacity the filter grows,
+// keeping the error rate. It returns false if the key already exists.
+func (c *Client) BFReserve(key string, errorRate float64, capacity int) (bool, error) {
+	if err := checkKey(key); err != nil {
+		return false, err
+	}
+	if !(errorRate > 0 && errorRate < 1) || capacity <= 0 {
+		return false, fmt.Errorf("invalid bloom filter: error rate must be between 0 and 1 and capacity positive")
+	}
+	payload := protocol.EncodeArgs(protocol.EncodeFloat64(errorRate), protocol.EncodeUint64(uint64(capacity)))
+	respType, respValue, err := c.roundTrip(protocol.CmdBFReserve, key, payload)
+	if err != nil {
+		return false, err
+	}
+
+	switch respType {
+	case protocol.RespOK:
+		return true, nil
+	case protocol.RespNotStored:
+		return false, nil
+	case protocol.RespError:
+		return false, Error(respValue)
+	default:
+		return false, c.protocolError("BF.RESERVE", respType)
+	}
+}
+
+// BFAdd adds element to the Bloom filter at key, creating one with the
+// server's default capacity and error rate if needed. It reports whether the
+// element was added, i.e. was not possibly present already.
+func (c *Client) BFAdd(key, element string) (bool, error) {
+	added, err := c.BFMAdd(key, element)
+	if err != nil {
+		return false, err
+	}
+	return added[0], nil
+}
+
+// BFMAdd is BFAdd for several elements in one round trip.
+func (c *Client) BFMAdd(key string, elements ...string) ([]bool, error) {
+	return c.bloomCommand(protocol.CmdBFAdd, "BF.ADD", key, elements)
+}
+
+// BFExists reports whether element may have been added to the Bloom filter at
+// key. False is certain; true is wrong at most at the filter's error rate.
+func (c *Client) BFExists(key, element string) (bool, error) {
+	found, err := c.BFMExists(key, element)
+	if err != nil {
+		return false, err
+	}
+	return found[0], nil
+}
+
+// BFMExists is BFExists for several elements in one round trip.
+func (c *Client) BFMExists(key string, elements ...string) ([]bool, error) {
+	return c.bloomCommand(protocol.CmdBFExists, "BF.EXISTS", key, elements)
+}
+
+func (c *Client) bloomCommand(cmdType uint8, name string, key string, elements []string) ([]bool, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	if len(elements) == 0 {
+		return nil, fmt.Errorf("no elements given")
+	}
+	var payload []byte
+	for _, element := range elements {
+		payload = protocol.AppendArg(payload, []byte(element))
+	}
+	elems, err := c.arrayCommand(cmdType, name, key, payload)
+	if err != nil {
+		return nil, err
+	}
+	if len(elems) != len(elements) {
+		return nil, c.protocolError(name, protocol.RespArray)
+	}
+	results := make([]bool, len(elems))
+	for i, elem := range elems {
+		n, err := protocol.DecodeInt64(elem)
+		if err != nil {
+			return nil, c.protocolError(name, protocol.RespArray)
+		}
+		results[i] = n == 1
+	}
+	return results, nil
+}
@@ -0,0 +1,24 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/jasonrowsell/zerocache/pkg/protocol"
+)
+
+// DeletePattern removes every key matching the glob pattern, e.g. "user:v3:*",
+// and returns how many were removed. The server deletes in small batches, so
+// other clients are served meanwhile, but this call returns only once it is done.
+func (c *Client) DeletePattern(pattern string) (int, error) {
+	if pattern == "" {
+		return 0, fmt.Errorf("empty pattern")
+	}
+	n, err := c.intCommand(protocol.CmdDelPattern, "DELPATTERN", "", protocol.EncodeArgs([]byte(pattern)))
+	return int(n), err
+}
+
+// FlushAll removes every key and returns how many were removed.
+func (c *Client) FlushAll() (int, error) {
+	n, err := c.intCommand(protocol.CmdFlushAll, "FLUSHALL", "", nil)
+	return int(n), err
+}
@@ -19,9 +19,25 @@ func (e Error) Error() string {
 	return string(e)
 }
 
-var ErrNotFound = Error("key not found")
+var (
+	ErrNotFound         = Error("key not found")
+	ErrVersionMismatch  = Error("version mismatch")
+	ErrNotInteger       = Error("value is not an integer")
+	ErrOverflow         = Error("increment or decrement would overflow")
+	ErrWrongType        = Error("WRONGTYPE operation against a key holding the wrong kind of value")
+	ErrInvalidScore     = Error("score is not a valid float")
+	ErrUnknownNamespace = Error("unknown namespace")
+	ErrNoScript         = Error("NOSCRIPT no script with this SHA1, use SCRIPT LOAD or EVAL")
+)
 
 type Client struct {
+	*clientConn
+	// namespace, if set, wraps every command in an IN envelope (see Namespace).
+	namespace string
+}
+
+// clientConn is the connection state, shared between a Client and its namespace views.
+type clientConn struct {
 	conn   net.Conn
 	reader *bufio.Reader
 	writer *bufio.Writer
@@ -66,12 +82,12 @@ func NewWithConn(conn net.Conn) (*Client, error) {
 		addrStr = remoteAddr.String()
 	}
 
-	return &Client{
+	return &Client{clientConn: &clientConn{
 		conn:   conn,
 		reader: bufio.NewReader(conn),
 		writer: bufio.NewWriter(conn),
 		addr:   addrStr,
-	}, nil
+	}}, nil
 }
 
 func (c *Client) Close() error {
@@ -99,8 +115,8 @@ func (c *Client) sendCommand(cmdType uint8, key string, value []byte) error {
 		buf = make([]byte, bufSize)
 	} else {
 		buf = (*bufPtr)[:bufSize]
+		defer clientBufferPool.Put(bufPtr) // Put back when done
 	}
-	defer clientBufferPool.Put(bufPtr) // Put back when done
 
 	buf[0] = cmdType
 	binary.BigEndian.PutUint32(buf[1:5], uint32(keyLen))
@@ -110,6 +126,17 @@ func (c *Client) sendCommand(cmdType uint8, key string, value []byte) error {
 		copy(buf[9+keyLen:], value)
 	}
 
+	if c.namespace != "" {
+		// Wrap the frame in an IN envelope. bufio.Writer errors are sticky, so
+		// a failure here is reported by the Write below.
+		var envelope [9]byte
+		envelope[0] = protocol.CmdIn
+		binary.BigEndian.PutUint32(envelope[1:5], uint32(len(c.namespace)))
+		binary.BigEndian.PutUint32(envelope[5:9], uint32(bufSize))
+		_, _ = c.writer.Write(envelope[:])
+		_, _ = c.writer.WriteString(c.namespace)
+	}
+
 	// Write the entire command
 	if _, err := c.writer.Write(buf); err != nil {
 		c.closeConnOnError(err)
@@ -228,6 +255,238 @@ func (c *Client) Delete(key string) error {
 	}
 }
 
+// GetWithVersion sends a GETV command and returns the value with its current version.
+// The version can be passed to CompareAndSwap.
+func (c *Client) GetWithVersion(key string) ([]byte, uint64, error) {
+	if err := checkKey(key); err != nil {
+		return nil, 0, err
+	}
+
+	respType, respValue, err := c.roundTrip(protocol.CmdGetV, key, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch respType {
+	case protocol.RespArray:
+		elems, err := protocol.DecodeArgs(respValue)
+		if err != nil || len(elems) != 2 {
+			return nil, 0, c.protocolError("GETV", respType)
+		}
+		version, err := protocol.DecodeUint64(elems[1])
+		if err != nil {
+			return nil, 0, c.protocolError("GETV", respType)
+		}
+		return elems[0], version, nil
+	case protocol.RespNotFound:
+		return nil, 0, ErrNotFound
+	case protocol.RespError:
+		return nil, 0, Error(respValue)
+	default:
+		return nil, 0, c.protocolError("GETV", respType)
+	}
+}
+
+// CompareAndSwap stores value only if the key's version still equals version.
+// It returns the new version, ErrNotFound if the key does not exist, or
+// ErrVersionMismatch if the key was modified since version was read.
+func (c *Client) CompareAndSwap(key string, value []byte, version uint64) (uint64, error) {
+	if err := checkKey(key); err != nil {
+		return 0, err
+	}
+	if len(value) > protocol.MaxValueSize {
+		return 0, fmt.Errorf("invalid value length")
+	}
+
+	respType, respValue, err := c.roundTrip(protocol.CmdCAS, key, protocol.EncodeArgs(value, protocol.EncodeUint64(version)))
+	if err != nil {
+		return 0, err
+	}
+
+	switch respType {
+	case protocol.RespInt:
+		newVersion, err := protocol.DecodeUint64(respValue)
+		if err != nil {
+			return 0, c.protocolError("CAS", respType)
+		}
+		return newVersion, nil
+	case protocol.RespNotFound:
+		return 0, ErrNotFound
+	case protocol.RespNotStored:
+		return 0, ErrVersionMismatch
+	case protocol.RespError:
+		return 0, Error(respValue)
+	default:
+		return 0, c.protocolError("CAS", respType)
+	}
+}
+
+// SetNX stores value only if the key does not exist yet and reports whether it was stored.
+func (c *Client) SetNX(key string, value []byte) (bool, error) {
+	return c.setConditional(protocol.CmdSetNX, "SETNX", key, value)
+}
+
+// SetXX stores value only if the key already exists and reports whether it was stored.
+func (c *Client) SetXX(key string, value []byte) (bool, error) {
+	return c.setConditional(protocol.CmdSetXX, "SETXX", key, value)
+}
+
+func (c *Client) setConditional(cmdType uint8, name string, key string, value []byte) (bool, error) {
+	if err := checkKey(key); err != nil {
+		return false, err
+	}
+	if len(value) > protocol.MaxValueSize {
+		return false, fmt.Errorf("invalid value length")
+	}
+
+	respType, respValue, err := c.roundTrip(cmdType, key, value)
+	if err != nil {
+		return false, err
+	}
+
+	switch respType {
+	case protocol.RespOK:
+		return true, nil
+	case protocol.RespNotStored:
+		return false, nil
+	case protocol.RespError:
+		return false, Error(respValue)
+	default:
+		return false, c.protocolError(name, respType)
+	}
+}
+
+// Incr atomically increments the integer stored at key by one and returns the new value.
+// A missing key is created with value 1.
+func (c *Client) Incr(key string) (int64, error) {
+	return c.counter(protocol.CmdIncr, "INCR", key, nil)
+}
+
+// Decr atomically decrements the integer stored at key by one and returns the new value.
+// A missing key is created with value -1.
+func (c *Client) Decr(key string) (int64, error) {
+	return c.counter(protocol.CmdDecr, "DECR", key, nil)
+}
+
+// IncrBy atomically adds delta to the integer stored at key and returns the new value.
+// A missing key is created with value delta.
+func (c *Client) IncrBy(key string, delta int64) (int64, error) {
+	return c.counter(protocol.CmdIncrBy, "INCRBY", key, [][]byte{protocol.EncodeInt64(delta)})
+}
+
+// IncrByTTL behaves like IncrBy, but a key created by this call expires after ttl.
+// The TTL of an existing key is not changed, which makes it suitable for fixed-window counters.
+func (c *Client) IncrByTTL(key string, delta int64, ttl time.Duration) (int64, error) {
+	if ttl <= 0 {
+		return 0, fmt.Errorf("invalid TTL: %v", ttl)
+	}
+	args := [][]byte{protocol.EncodeInt64(delta), protocol.EncodeUint64(uint64(ttl.Milliseconds()))}
+	return c.counter(protocol.CmdIncrBy, "INCRBY", key, args)
+}
+
+func (c *Client) counter(cmdType uint8, name string, key string, args [][]byte) (int64, error) {
+	if err := checkKey(key); err != nil {
+		return 0, err
+	}
+	return c.intCommand(cmdType, name, key, protocol.EncodeArgs(args...))
+}
+
+// intCommand runs a command that responds with an integer.
+func (c *Client) intCommand(cmdType uint8, name string, key string, payload []byte) (int64, error) {
+	respType, respValue, err := c.roundTrip(cmdType, key, payload)
+	if err != nil {
+		return 0, err
+	}
+
+	switch respType {
+	case protocol.RespInt:
+		result, err := protocol.DecodeInt64(respValue)
+		if err != nil {
+			return 0, c.protocolError(name, respType)
+		}
+		return result, nil
+	case protocol.RespError:
+		return 0, Error(respValue)
+	default:
+		return 0, c.protocolError(name, respType)
+	}
+}
+
+// arrayCommand runs a command that responds with an array. A NotFound
+// response is treated as an empty array.
+func (c *Client) arrayCommand(cmdType uint8, name string, key string, payload []byte) ([][]byte, error) {
+	respType, respValue, err := c.roundTrip(cmdType, key, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	switch respType {
+	case protocol.RespArray:
+		elems, err := protocol.DecodeArgs(respValue)
+		if err != nil {
+			return nil, c.protocolError(name, respType)
+		}
+		return elems, nil
+	case protocol.RespNotFound:
+		return nil, nil
+	case protocol.RespError:
+		return nil, Error(respValue)
+	default:
+		return nil, c.protocolError(name, respType)
+	}
+}
+
+// valueCommand runs a command that responds with a single value or NotFound.
+func (c *Client) valueCommand(cmdType uint8, name string, key string, payload []byte) ([]byte, error) {
+	respType, respValue, err := c.roundTrip(cmdType, key, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	switch respType {
+	case protocol.RespValue:
+		return respValue, nil
+	case protocol.RespNotFound:
+		return nil, ErrNotFound
+	case protocol.RespError:
+		return nil, Error(respValue)
+	default:
+		return nil, c.protocolError(name, respType)
+	}
+}
+
+// roundTrip sends a command and reads its response while holding the client lock.
+func (c *Client) roundTrip(cmdType uint8, key string, value []byte) (uint8, []byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return 0, nil, fmt.Errorf("client closed")
+	}
+	if err := c.sendCommand(cmdType, key, value); err != nil {
+		return 0, nil, err
+	}
+	return c.readResponse()
+}
+
+// protocolError reports an unexpected response type and closes the connection,
+// since the stream can no longer be trusted to be in sync.
+func (c *Client) protocolError(name string, respType uint8) error {
+	err := fmt.Errorf("protocol error: unexpected response type %d for %s", respType, name)
+	c.mu.Lock()
+	c.closeConnOnError(err)
+	c.mu.Unlock()
+	return err
+}
+
+// checkKey validates a key before it is sent to the server.
+func checkKey(key string) error {
+	if len(key) == 0 || len(key) > protocol.MaxKeySize {
+		return fmt.Errorf("invalid key length")
+	}
+	return nil
+}
+
 // readResponse reads and parses the response header and body. Assumes lock is held.
 // It returns the response type code, the value (if applicable), and any error encountered.
 func (c *Client) readResponse() (respType uint8, value []byte, err error) {
@@ -245,13 +504,19 @@ func (c *Client) readResponse() (respType uint8, value []byte, err error) {
 	respType = header[0]
 	valLen := binary.BigEndian.Uint32(header[1:5])
 
-	if (respType == protocol.RespOK || respType == protocol.RespNotFound) && valLen != 0 {
+	if respType == protocol.RespFloat && valLen != 8 {
+		err = fmt.Errorf("protocol error: float response with length %d", valLen)
+		c.closeConnOnError(err)
+		return respType, nil, err
+	}
+	if (respType == protocol.RespOK || respType == protocol.RespNotFound || respType == protocol.RespNotStored ||
+		respType == protocol.RespStream) && valLen != 0 {
 		err = fmt.Errorf("protocol error: unexpected non-zero length %d for response type %d", valLen, respType)
 		c.closeConnOnError(err)
 		return respType, nil, err
 	}
-	if valLen > protocol.MaxValueSize {
-		err = fmt.Errorf("protocol error: response value length %d exceeds client maximum %d", valLen, protocol.MaxValueSize)
+	if valLen > protocol.MaxPayloadSize {
+		err = fmt.Errorf("protocol error: response value length %d exceeds client maximum %d", valLen, protocol.MaxPayloadSize)
 		c.closeConnOnError(err)
 		return respType, nil, err
 	}
@@ -265,11 +530,11 @@ func (c *Client) readResponse() (respType uint8, value []byte, err error) {
 			clientBufferPool.Put(bufPtr)
 			readBuf = make([]byte, valLen)
 		} else {
-			// Pooled buffer is large enough; slice it to the exact size needed.
+			// Pooled buffer is large enough; slice it to the exact size needed,
+			// and return it to the pool when this function exits.
 			readBuf = (*bufPtr)[:valLen]
+			defer clientBufferPool.Put(bufPtr)
 		}
-		// Ensure the read buffer is returned to the pool when this function exits.
-		defer clientBufferPool.Put(bufPtr)
 
 		// Read the value data fully into the readBuf.
 		if _, err = io.ReadFull(c.reader, readBuf); err != nil {
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/jasonrowsell/zerocache/pkg/protocol"
+)
+
+// HSet sets fields of the hash stored at key, creating it if needed.
+// It returns the number of fields that were newly added.
+func (c *Client) HSet(key string, fields map[string][]byte) (int, error) {
+	if err := checkKey(key); err != nil {
+		return 0, err
+	}
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("no fields to set")
+	}
+
+	var payload []byte
+	for field, value := range fields {
+		payload = protocol.AppendArg(payload, []byte(field))
+		payload = protocol.AppendArg(payload, value)
+	}
+	n, err := c.intCommand(protocol.CmdHSet, "HSET", key, payload)
+	return int(n), err
+}
+
+// HGet returns the value of a field in the hash stored at key.
+// It returns ErrNotFound if the key or the field does not exist.
+func (c *Client) HGet(key string, field string) ([]byte, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	return c.valueCommand(protocol.CmdHGet, "HGET", key, protocol.EncodeArgs([]byte(field)))
+}
+
+// HDel removes fields from the hash stored at key and returns how many were removed.
+func (c *Client) HDel(key string, fields ...string) (int, error) {
+	if err := checkKey(key); err != nil {
+		return 0, err
+	}
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("no fields to delete")
+	}
+
+	var payload []byte
+	for _, field := range fields {
+		payload = protocol.AppendArg(payload, []byte(field))
+	}
+	n, err := c.intCommand(protocol.CmdHDel, "HDEL", key, payload)
+	return int(n), err
+}
+
+// HGetAll returns all fields of the hash stored at key. A missing key yields an empty map.
+func (c *Client) HGetAll(key string) (map[string][]byte, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+
+	elems, err := c.arrayCommand(protocol.CmdHGetAll, "HGETALL", key, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(elems)%2 != 0 {
+		return nil, fmt.Errorf("protocol error: odd number of elements in HGETALL response")
+	}
+	fields := make(map[string][]byte, len(elems)/2)
+	for i := 0; i < len(elems); i += 2 {
+		fields[string(elems[i])] = elems[i+1]
+	}
+	return fields, nil
+}
+
+// HLen returns the number of fields in the hash stored at key.
+func (c *Client) HLen(key string) (int, error) {
+	if err := checkKey(key); err != nil {
+		return 0, err
+	}
+	n, err := c.intCommand(protocol.CmdHLen, "HLEN", key, nil)
+	return int(n), err
+}
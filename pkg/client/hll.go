@@ -0,0 +1,78 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/jasonrowsell/zerocache/pkg/protocol"
+)
+
+// PFAdd adds elements to the HyperLogLog stored at key, creating it if needed,
+// and reports whether its estimate may have changed.
+//
+// A HyperLogLog is stored as a plain value: Get returns a dump of it, and Set
+// restores one, on this server or another.
+func (c *Client) PFAdd(key string, elements ...string) (bool, error) {
+	if err := checkKey(key); err != nil {
+		return false, err
+	}
+	if len(elements) == 0 {
+		return false, fmt.Errorf("no elements given")
+	}
+	var payload []byte
+	for _, element := range elements {
+		payload = protocol.AppendArg(payload, []byte(element))
+	}
+	n, err := c.intCommand(protocol.CmdPFAdd, "PFADD", key, payload)
+	return n == 1, err
+}
+
+// PFCount returns the estimated number of distinct elements added to the
+// HyperLogLogs stored at keys, taken together, with a standard error of about
+// 0.8%. Missing keys count as empty.
+func (c *Client) PFCount(keys ...string) (uint64, error) {
+	if len(keys) == 0 {
+		return 0, fmt.Errorf("no keys given")
+	}
+	payload, err := keysPayload(keys)
+	if err != nil {
+		return 0, err
+	}
+	n, err := c.intCommand(protocol.CmdPFCount, "PFCOUNT", "", payload)
+	return uint64(n), err
+}
+
+// PFMerge stores at dest the union of the HyperLogLogs stored at dest and
+// sources.
+func (c *Client) PFMerge(dest string, sources ...string) error {
+	if err := checkKey(dest); err != nil {
+		return err
+	}
+	payload, err := keysPayload(sources)
+	if err != nil {
+		return err
+	}
+	respType, respValue, err := c.roundTrip(protocol.CmdPFMerge, dest, payload)
+	if err != nil {
+		return err
+	}
+
+	switch respType {
+	case protocol.RespOK:
+		return nil
+	case protocol.RespError:
+		return Error(respValue)
+	default:
+		return c.protocolError("PFMERGE", respType)
+	}
+}
+
+func keysPayload(keys []string) ([]byte, error) {
+	var payload []byte
+	for _, key := range keys {
+		if err := checkKey(key); err != nil {
+			return nil, err
+		}
+		payload = protocol.AppendArg(payload, []byte(key))
+	}
+	return payload, nil
+}
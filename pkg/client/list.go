@@ -0,0 +1,115 @@
+package client
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jasonrowsell/zerocache/pkg/protocol"
+)
+
+// LPush prepends values to the list stored at key and returns its new length.
+func (c *Client) LPush(key string, values ...[]byte) (int, error) {
+	return c.push(protocol.CmdLPush, "LPUSH", key, values)
+}
+
+// RPush appends values to the list stored at key and returns its new length.
+func (c *Client) RPush(key string, values ...[]byte) (int, error) {
+	return c.push(protocol.CmdRPush, "RPUSH", key, values)
+}
+
+func (c *Client) push(cmdType uint8, name string, key string, values [][]byte) (int, error) {
+	if err := checkKey(key); err != nil {
+		return 0, err
+	}
+	if len(values) == 0 {
+		return 0, fmt.Errorf("no values to push")
+	}
+	for _, value := range values {
+		if len(value) > protocol.MaxValueSize {
+			return 0, fmt.Errorf("invalid value length")
+		}
+	}
+	n, err := c.intCommand(cmdType, name, key, protocol.EncodeArgs(values...))
+	return int(n), err
+}
+
+// LPop removes and returns the first element of the list stored at key.
+// It returns ErrNotFound if the list is empty.
+func (c *Client) LPop(key string) ([]byte, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	return c.valueCommand(protocol.CmdLPop, "LPOP", key, nil)
+}
+
+// RPop removes and returns the last element of the list stored at key.
+// It returns ErrNotFound if the list is empty.
+func (c *Client) RPop(key string) ([]byte, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	return c.valueCommand(protocol.CmdRPop, "RPOP", key, nil)
+}
+
+// LRange returns the elements between start and stop (inclusive) of the list
+// stored at key. Negative indexes count from the end, -1 being the last element.
+func (c *Client) LRange(key string, start, stop int) ([][]byte, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	payload := protocol.EncodeArgs(protocol.EncodeInt64(int64(start)), protocol.EncodeInt64(int64(stop)))
+	return c.arrayCommand(protocol.CmdLRange, "LRANGE", key, payload)
+}
+
+// LLen returns the length of the list stored at key.
+func (c *Client) LLen(key string) (int, error) {
+	if err := checkKey(key); err != nil {
+		return 0, err
+	}
+	n, err := c.intCommand(protocol.CmdLLen, "LLEN", key, nil)
+	return int(n), err
+}
+
+// BLPop pops the first element of the first non-empty list among keys, waiting
+// up to timeout for one to become available (0 waits forever). It returns the
+// key the element was popped from, or ErrNotFound if the timeout passed.
+// The client is busy for the whole wait; use a dedicated client for blocking pops.
+func (c *Client) BLPop(timeout time.Duration, keys ...string) (string, []byte, error) {
+	if len(keys) == 0 {
+		return "", nil, fmt.Errorf("no keys to pop from")
+	}
+	if timeout < 0 {
+		return "", nil, fmt.Errorf("invalid timeout: %v", timeout)
+	}
+	timeoutMillis := timeout.Milliseconds()
+	if timeout > 0 && timeoutMillis == 0 {
+		timeoutMillis = 1 // 0 would mean waiting forever
+	}
+	payload := protocol.AppendArg(nil, protocol.EncodeUint64(uint64(timeoutMillis)))
+	for _, key := range keys {
+		if err := checkKey(key); err != nil {
+			return "", nil, err
+		}
+		payload = protocol.AppendArg(payload, []byte(key))
+	}
+
+	respType, respValue, err := c.roundTrip(protocol.CmdBLPop, "", payload)
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch respType {
+	case protocol.RespArray:
+		elems, err := protocol.DecodeArgs(respValue)
+		if err != nil || len(elems) != 2 {
+			return "", nil, c.protocolError("BLPOP", respType)
+		}
+		return string(elems[0]), elems[1], nil
+	case protocol.RespNotFound:
+		return "", nil, ErrNotFound
+	case protocol.RespError:
+		return "", nil, Error(respValue)
+	default:
+		return "", nil, c.protocolError("BLPOP", respType)
+	}
+}
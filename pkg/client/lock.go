@@ -0,0 +1,265 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	mathrand "math/rand"
+	"sync"
+	"time"
+
+	"github.com/jasonrowsell/zerocache/pkg/protocol"
+)
+
+var (
+	// ErrLockHeld is returned by Lock when another owner holds the lock.
+	ErrLockHeld = Error("lock is held by another owner")
+	// ErrLockNotHeld is returned by Unlock and ExtendLock when the owner does
+	// not hold the lock, typically because it expired.
+	ErrLockNotHeld = Error("lock is not held by this owner")
+)
+
+// lockRetryInterval is the average pause of Mutex.Lock between attempts.
+const lockRetryInterval = 50 * time.Millisecond
+
+// Lock acquires the lock at key for owner until ttl passes, and returns its
+// fencing token. It returns ErrLockHeld if another owner holds the lock.
+// Calling it again as the holder resets the TTL and returns the same token.
+//
+// Tokens only increase between acquisitions of a key, so a resource guarded by
+// the lock can reject writes with a lower token than one it has seen. That is
+// what keeps it safe when a holder stalls past its TTL, or the lock is lost to
+// expiry or eviction, and another client takes over.
+func (c *Client) Lock(key, owner string, ttl time.Duration) (uint64, error) {
+	if err := checkLock(key, owner, ttl); err != nil {
+		return 0, err
+	}
+	payload := protocol.EncodeArgs([]byte(owner), protocol.EncodeUint64(uint64(ttl.Milliseconds())))
+	respType, respValue, err := c.roundTrip(protocol.CmdLock, key, payload)
+	if err != nil {
+		return 0, err
+	}
+
+	switch respType {
+	case protocol.RespInt:
+		fence, err := protocol.DecodeUint64(respValue)
+		if err != nil {
+			return 0, c.protocolError("LOCK", respType)
+		}
+		return fence, nil
+	case protocol.RespNotStored:
+		return 0, ErrLockHeld
+	case protocol.RespError:
+		return 0, Error(respValue)
+	default:
+		return 0, c.protocolError("LOCK", respType)
+	}
+}
+
+// Unlock releases the lock at key if owner holds it, and otherwise returns
+// ErrLockNotHeld.
+func (c *Client) Unlock(key, owner string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	return c.lockCommand(protocol.CmdUnlock, "UNLOCK", key, protocol.EncodeArgs([]byte(owner)))
+}
+
+// ExtendLock resets the TTL of the lock at key to ttl if owner holds it, and
+// otherwise returns ErrLockNotHeld.
+func (c *Client) ExtendLock(key, owner string, ttl time.Duration) error {
+	if err := checkLock(key, owner, ttl); err != nil {
+		return err
+	}
+	payload := protocol.EncodeArgs([]byte(owner), protocol.EncodeUint64(uint64(ttl.Milliseconds())))
+	return c.lockCommand(protocol.CmdExtend, "EXTEND", key, payload)
+}
+
+func (c *Client) lockCommand(cmdType uint8, name string, key string, payload []byte) error {
+	respType, respValue, err := c.roundTrip(cmdType, key, payload)
+	if err != nil {
+		return err
+	}
+
+	switch respType {
+	case protocol.RespOK:
+		return nil
+	case protocol.RespNotStored:
+		return ErrLockNotHeld
+	case protocol.RespError:
+		return Error(respValue)
+	default:
+		return c.protocolError(name, respType)
+	}
+}
+
+func checkLock(key, owner string, ttl time.Duration) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	if owner == "" || len(owner) > protocol.MaxKeySize {
+		return fmt.Errorf("invalid lock owner length")
+	}
+	if ttl < time.Millisecond {
+		return fmt.Errorf("invalid lock TTL: %v", ttl)
+	}
+	return nil
+}
+
+// Mutex is a lock on a key held by a random owner token, and kept alive while
+// held by renewing its TTL in the background. Only one goroutine should use a
+// Mutex at a time; give each contender its own.
+type Mutex struct {
+	c     *Client
+	key   string
+	ttl   time.Duration
+	owner string
+
+	mu     sync.Mutex
+	fence  uint64
+	cancel context.CancelFunc // Stops the renewal goroutine
+	done   chan struct{}      // Closed once the lock is no longer held
+	err    error              // Why the lock was lost, if it was
+}
+
+// NewMutex returns a Mutex on key whose lock expires ttl after its last
+// renewal, should the holder die. Renewals happen every ttl/3.
+func NewMutex(c *Client, key string, ttl time.Duration) *Mutex {
+	var token [16]byte
+	_, _ = rand.Read(token[:])
+	return &Mutex{c: c, key: key, ttl: ttl, owner: hex.EncodeToString(token[:])}
+}
+
+// Lock waits until it acquires the lock or ctx is done, in which case it
+// returns ctx's error. Once acquired, the lock is renewed until Unlock is
+// called or ctx is done, whichever comes first; after ctx is done the lock is
+// released. If a renewal fails, e.g. because the server was unreachable for
+// longer than the TTL, the lock is lost. Done reports both.
+func (m *Mutex) Lock(ctx context.Context) error {
+	if done := m.Done(); done != nil && !isClosed(done) {
+		return errors.New("mutex already locked")
+	}
+
+	var fence uint64
+	for {
+		var err error
+		if fence, err = m.c.Lock(m.key, m.owner, m.ttl); err == nil {
+			break
+		}
+		if err != ErrLockHeld {
+			return err
+		}
+		// Jitter spreads out contenders retrying at once.
+		pause := lockRetryInterval/2 + time.Duration(mathrand.Int63n(int64(lockRetryInterval)))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pause):
+		}
+	}
+
+	renewCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	m.mu.Lock()
+	m.fence, m.err, m.cancel, m.done = fence, nil, cancel, done
+	m.mu.Unlock()
+	go m.renew(renewCtx, done)
+	return nil
+}
+
+// renew extends the lock every ttl/3 until ctx is done or the lock is lost,
+// then closes done. Failed renewals are retried as long as the lock may still
+// be held.
+func (m *Mutex) renew(ctx context.Context, done chan struct{}) {
+	ticker := time.NewTicker(m.ttl / 3)
+	defer ticker.Stop()
+	renewed := time.Now()
+
+	var lost error
+	for lost == nil {
+		select {
+		case <-ctx.Done():
+			// Released by Unlock, or by ctx on the caller's behalf.
+			_ = m.c.Unlock(m.key, m.owner)
+			lost = ctx.Err()
+		case <-ticker.C:
+			err := m.c.ExtendLock(m.key, m.owner, m.ttl)
+			switch {
+			case err == nil:
+				renewed = time.Now()
+			case err == ErrLockNotHeld, time.Since(renewed) >= m.ttl:
+				lost = fmt.Errorf("lock lost: %w", err)
+			}
+		}
+	}
+
+	m.mu.Lock()
+	if m.err == nil {
+		m.err = lost
+	}
+	m.mu.Unlock()
+	close(done)
+}
+
+// Unlock stops renewing the lock and releases it. It returns ErrLockNotHeld
+// if the lock was no longer held, having been lost or released when the
+// context passed to Lock was done.
+func (m *Mutex) Unlock() error {
+	m.mu.Lock()
+	cancel, done := m.cancel, m.done
+	if done == nil {
+		m.mu.Unlock()
+		return errors.New("mutex not locked")
+	}
+	if isClosed(done) {
+		m.mu.Unlock()
+		return ErrLockNotHeld
+	}
+	m.err = errUnlocked
+	m.mu.Unlock()
+
+	cancel()
+	<-done
+	return nil
+}
+
+var errUnlocked = errors.New("mutex unlocked")
+
+// Fence returns the fencing token of the current or last acquisition. Pass it
+// along with writes to the resource the lock guards.
+func (m *Mutex) Fence() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.fence
+}
+
+// Done returns a channel closed when the lock is no longer held: after Unlock,
+// once the context passed to Lock is done, or when it was lost. Work guarded by
+// the lock should stop then. It returns nil before the first Lock.
+func (m *Mutex) Done() <-chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.done
+}
+
+// Err returns why the lock is no longer held, once Done is closed: the
+// context's error, or the error that made it be lost.
+func (m *Mutex) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err == errUnlocked {
+		return nil
+	}
+	return m.err
+}
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,86 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/jasonrowsell/zerocache/pkg/protocol"
+)
+
+// Stats is a snapshot of a namespace's size and counters.
+type Stats struct {
+	Namespace string
+	Keys      int64
+	Bytes     int64  // Approximate memory accounted by the server
+	Hits      uint64 // GET lookups that found the key
+	Misses    uint64 // GET lookups that did not
+	Evictions uint64 // Entries dropped to stay within the namespace's limits
+	Expired   uint64 // Entries dropped because their TTL passed
+}
+
+// Select switches the connection to the named namespace; subsequent commands
+// run against it. It returns ErrUnknownNamespace if the server has no such
+// namespace. Namespace views created with Namespace are not affected.
+func (c *Client) Select(namespace string) error {
+	respType, respValue, err := c.roundTrip(protocol.CmdSelect, "", protocol.EncodeArgs([]byte(namespace)))
+	if err != nil {
+		return err
+	}
+
+	switch respType {
+	case protocol.RespOK:
+		return nil
+	case protocol.RespError:
+		return Error(respValue)
+	default:
+		return c.protocolError("SELECT", respType)
+	}
+}
+
+// Namespace returns a view of the client whose commands run in the named
+// namespace, whatever namespace the connection has selected. The view shares
+// the connection with c; closing either closes both. Commands for an unknown
+// namespace fail with ErrUnknownNamespace.
+func (c *Client) Namespace(namespace string) *Client {
+	return &Client{clientConn: c.clientConn, namespace: namespace}
+}
+
+// Stats returns the stats of the current namespace.
+func (c *Client) Stats() (Stats, error) {
+	elems, err := c.arrayCommand(protocol.CmdStats, "STATS", "", nil)
+	if err != nil {
+		return Stats{}, err
+	}
+	if len(elems)%2 != 0 {
+		return Stats{}, c.protocolError("STATS", protocol.RespArray)
+	}
+
+	var stats Stats
+	for i := 0; i < len(elems); i += 2 {
+		name, value := string(elems[i]), string(elems[i+1])
+		if name == "namespace" {
+			stats.Namespace = value
+			continue
+		}
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return Stats{}, fmt.Errorf("invalid value %q for stat %s", value, name)
+		}
+		switch name {
+		case "keys":
+			stats.Keys = int64(n)
+		case "bytes":
+			stats.Bytes = int64(n)
+		case "hits":
+			stats.Hits = n
+		case "misses":
+			stats.Misses = n
+		case "evictions":
+			stats.Evictions = n
+		case "expired":
+			stats.Expired = n
+		}
+		// Stats unknown to this client version are ignored.
+	}
+	return stats, nil
+}
@@ -0,0 +1,268 @@
+package client
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/jasonrowsell/zerocache/internal/glob"
+	"github.com/jasonrowsell/zerocache/pkg/protocol"
+)
+
+// subscriptionBuffer is the number of messages a Subscription holds before it
+// stops reading from the server. A subscriber that stays behind long enough for
+// the server's own queue to fill up is disconnected.
+const subscriptionBuffer = 256
+
+// Message is a message received on a Subscription.
+type Message struct {
+	// Pattern is the subscribed pattern that matched Channel, or empty if the
+	// message was received through a channel subscription.
+	Pattern string
+	Channel string
+	Payload []byte
+}
+
+// Subscription receives the messages published to its channels and patterns.
+type Subscription struct {
+	// C delivers messages in the order they were published. It is closed when
+	// the subscription ends; Err then reports why.
+	C <-chan Message
+
+	conn   net.Conn
+	writer *bufio.Writer
+	mu     sync.Mutex // Serializes writes
+
+	closing   chan struct{}
+	closeOnce sync.Once
+	done      chan struct{}
+	err       error // Set before done is closed
+}
+
+// Publish sends message to every subscriber of channel, or of a pattern
+// matching it, and returns how many received it.
+func (c *Client) Publish(channel string, message []byte) (int, error) {
+	if len(message) > protocol.MaxValueSize {
+		return 0, fmt.Errorf("invalid message length")
+	}
+	n, err := c.intCommand(protocol.CmdPublish, "PUBLISH", "", protocol.EncodeArgs([]byte(channel), message))
+	return int(n), err
+}
+
+// Subscribe subscribes to channels. The subscription takes over the client's
+// connection, so the client and its namespace views are closed afterwards; use
+// a separate client for other commands.
+func (c *Client) Subscribe(channels ...string) (*Subscription, error) {
+	return c.subscribe(protocol.CmdSubscribe, "SUBSCRIBE", channels)
+}
+
+// PSubscribe subscribes to glob patterns such as "news.*", like Subscribe.
+func (c *Client) PSubscribe(patterns ...string) (*Subscription, error) {
+	return c.subscribe(protocol.CmdPSubscribe, "PSUBSCRIBE", patterns)
+}
+
+// SubscribeKeyEvents subscribes to the keyspace events of namespace for keys
+// starting with keyPrefix, e.g. "user:". events are event names ("set", "del",
+// "expired", "evicted"); none means all of them. Each Message carries the key
+// as its payload. The server only publishes the events enabled with its
+// -keyspace-events flag.
+func (c *Client) SubscribeKeyEvents(namespace, keyPrefix string, events ...string) (*Subscription, error) {
+	if len(events) == 0 {
+		// Not a "*" pattern, which could also match inside the key.
+		events = []string{"set", "del", "expired", "evicted"}
+	}
+	patterns := make([]string, len(events))
+	for i, event := range events {
+		patterns[i] = protocol.KeyEventChannel(glob.Escape(namespace), glob.Escape(event), glob.Escape(keyPrefix)+"*")
+	}
+	return c.PSubscribe(patterns...)
+}
+
+func (c *Client) subscribe(cmdType uint8, name string, names []string) (*Subscription, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%s requires at least one channel", name)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return nil, fmt.Errorf("client closed")
+	}
+	conn, reader := c.conn, c.reader
+	if err := c.sendCommand(cmdType, "", encodeNames(names)); err != nil {
+		return nil, err
+	}
+	c.conn = nil // The connection now belongs to the subscription
+
+	// Wait for the confirmations so that no message published after
+	// Subscribe returns is missed.
+	for range names {
+		if _, err := readPush(reader); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	msgs := make(chan Message, subscriptionBuffer)
+	sub := &Subscription{
+		C:       msgs,
+		conn:    conn,
+		writer:  c.writer,
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go sub.receive(reader, msgs)
+	return sub, nil
+}
+
+// Subscribe adds channels to the subscription.
+func (s *Subscription) Subscribe(channels ...string) error {
+	return s.send(protocol.CmdSubscribe, channels, false)
+}
+
+// PSubscribe adds patterns to the subscription.
+func (s *Subscription) PSubscribe(patterns ...string) error {
+	return s.send(protocol.CmdPSubscribe, patterns, false)
+}
+
+// Unsubscribe removes channels from the subscription, or all channels if none
+// are given. The subscription stays open even with nothing subscribed.
+func (s *Subscription) Unsubscribe(channels ...string) error {
+	return s.send(protocol.CmdUnsubscribe, channels, true)
+}
+
+// PUnsubscribe removes patterns from the subscription, or all patterns if none
+// are given.
+func (s *Subscription) PUnsubscribe(patterns ...string) error {
+	return s.send(protocol.CmdPUnsubscribe, patterns, true)
+}
+
+func (s *Subscription) send(cmdType uint8, names []string, allowEmpty bool) error {
+	if len(names) == 0 && !allowEmpty {
+		return fmt.Errorf("no channels given")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.done:
+		return fmt.Errorf("subscription closed")
+	default:
+	}
+
+	payload := encodeNames(names)
+	var header [9]byte
+	header[0] = cmdType
+	binary.BigEndian.PutUint32(header[5:9], uint32(len(payload)))
+	_, _ = s.writer.Write(header[:])
+	_, _ = s.writer.Write(payload)
+	if err := s.writer.Flush(); err != nil {
+		return fmt.Errorf("write error: %w", err)
+	}
+	return nil
+}
+
+// Close ends the subscription and closes its connection.
+func (s *Subscription) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.closing)
+		err = s.conn.Close()
+	})
+	<-s.done
+	if errors.Is(err, net.ErrClosed) {
+		err = nil // The subscription had already ended
+	}
+	return err
+}
+
+// Err returns the error that ended the subscription, or nil if it is still
+// open or was ended by Close.
+func (s *Subscription) Err() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+		return nil
+	}
+}
+
+// receive delivers messages to msgs until the connection fails or is closed.
+func (s *Subscription) receive(reader *bufio.Reader, msgs chan<- Message) {
+	defer close(msgs)
+	defer close(s.done)
+
+	for {
+		elems, err := readPush(reader)
+		if err != nil {
+			select {
+			case <-s.closing:
+			default:
+				s.err = err
+			}
+			s.conn.Close()
+			return
+		}
+
+		var msg Message
+		switch kind := string(elems[0]); {
+		case kind == "message" && len(elems) == 3:
+			msg = Message{Channel: string(elems[1]), Payload: elems[2]}
+		case kind == "pmessage" && len(elems) == 4:
+			msg = Message{Pattern: string(elems[1]), Channel: string(elems[2]), Payload: elems[3]}
+		default:
+			continue // Confirms a (un)subscription
+		}
+		select {
+		case msgs <- msg:
+		case <-s.closing:
+			s.conn.Close()
+			return
+		}
+	}
+}
+
+// readPush reads one push frame and returns its elements. An error response
+// is returned as an Error.
+func readPush(reader *bufio.Reader) ([][]byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return nil, fmt.Errorf("read header error: %w", err)
+	}
+	respType, valLen := header[0], binary.BigEndian.Uint32(header[1:5])
+	if valLen > protocol.MaxPayloadSize {
+		return nil, fmt.Errorf("protocol error: response value length %d exceeds client maximum %d", valLen, protocol.MaxPayloadSize)
+	}
+	value := make([]byte, valLen)
+	if _, err := io.ReadFull(reader, value); err != nil {
+		return nil, fmt.Errorf("read value error: %w", err)
+	}
+
+	switch respType {
+	case protocol.RespPush:
+		elems, err := protocol.DecodeArgs(value)
+		if err != nil || len(elems) == 0 {
+			return nil, fmt.Errorf("protocol error: malformed push")
+		}
+		return elems, nil
+	case protocol.RespError:
+		return nil, Error(value)
+	default:
+		return nil, fmt.Errorf("protocol error: unexpected response type %d while subscribed", respType)
+	}
+}
+
+// encodeNames encodes channel or pattern names as arguments.
+func encodeNames(names []string) []byte {
+	var payload []byte
+	for _, name := range names {
+		payload = protocol.AppendArg(payload, []byte(name))
+	}
+	return payload
+}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"fmt"
+	"iter"
+
+	"github.com/jasonrowsell/zerocache/pkg/protocol"
+)
+
+// Scan runs one step of a keyspace scan. Start with cursor 0 and keep passing
+// the returned cursor back until it is 0 again. Each step examines about count
+// keys (0 uses the server default) and returns those matching the glob pattern
+// match (every key if match is empty); a step may return no keys even though
+// the scan is not over. Every key present for the whole scan is returned at
+// least once, possibly more than once.
+func (c *Client) Scan(cursor uint64, match string, count int) ([]string, uint64, error) {
+	if count < 0 {
+		return nil, 0, fmt.Errorf("invalid count: %d", count)
+	}
+	payload := protocol.EncodeArgs(protocol.EncodeUint64(cursor), protocol.EncodeUint64(uint64(count)))
+	if match != "" {
+		payload = protocol.AppendArg(payload, []byte(match))
+	}
+
+	elems, err := c.arrayCommand(protocol.CmdScan, "SCAN", "", payload)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(elems) == 0 {
+		return nil, 0, c.protocolError("SCAN", protocol.RespArray)
+	}
+	next, err := protocol.DecodeUint64(elems[0])
+	if err != nil {
+		return nil, 0, c.protocolError("SCAN", protocol.RespArray)
+	}
+	return elemStrings(elems[1:]), next, nil
+}
+
+// ScanKeys returns an iterator over the keys matching the glob pattern match,
+// issuing SCAN steps of about count keys as it goes. Iteration stops at the
+// first error, which is yielded with an empty key. The same guarantees as Scan
+// apply, so a key may be yielded more than once.
+func (c *Client) ScanKeys(match string, count int) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		var cursor uint64
+		for {
+			keys, next, err := c.Scan(cursor, match, count)
+			if err != nil {
+				yield("", err)
+				return
+			}
+			for _, key := range keys {
+				if !yield(key, nil) {
+					return
+				}
+			}
+			if next == 0 {
+				return
+			}
+			cursor = next
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+
+	"github.com/jasonrowsell/zerocache/pkg/protocol"
+)
+
+// Eval runs src on the server, atomically, with KEYS bound to keys and ARGV to
+// args. The script may only touch the keys it is given. Its result is returned
+// as a Reply: a nil result makes Reply.Err return ErrNotFound. Errors raised by
+// the script, or limits it exceeded, are returned as an Error.
+func (c *Client) Eval(src string, keys []string, args ...string) (Reply, error) {
+	return c.eval(protocol.CmdEval, "EVAL", src, keys, args)
+}
+
+// EvalSHA runs a script previously cached on the server, identified by the
+// hex SHA1 of its source, like Eval. It returns ErrNoScript if the server does
+// not have it; Script.Run handles that case.
+func (c *Client) EvalSHA(sha string, keys []string, args ...string) (Reply, error) {
+	return c.eval(protocol.CmdEvalSHA, "EVALSHA", sha, keys, args)
+}
+
+func (c *Client) eval(cmdType uint8, name string, script string, keys []string, args []string) (Reply, error) {
+	payload, err := encodeEval(script, keys, args)
+	if err != nil {
+		return Reply{}, err
+	}
+	respType, respValue, err := c.roundTrip(cmdType, "", payload)
+	if err != nil {
+		return Reply{}, err
+	}
+	switch respType {
+	case protocol.RespNotFound, protocol.RespInt, protocol.RespValue, protocol.RespArray:
+		return Reply{Type: respType, Value: respValue}, nil
+	case protocol.RespError:
+		return Reply{}, Error(respValue)
+	default:
+		return Reply{}, c.protocolError(name, respType)
+	}
+}
+
+// encodeEval frames the arguments of EVAL and EVALSHA.
+func encodeEval(script string, keys []string, args []string) ([]byte, error) {
+	payload := make([][]byte, 0, 2+len(keys)+len(args))
+	payload = append(payload, []byte(script), protocol.EncodeUint64(uint64(len(keys))))
+	for _, key := range keys {
+		if err := checkKey(key); err != nil {
+			return nil, err
+		}
+		payload = append(payload, []byte(key))
+	}
+	for _, arg := range args {
+		payload = append(payload, []byte(arg))
+	}
+	return protocol.EncodeArgs(payload...), nil
+}
+
+// Eval queues an EVAL of src.
+func (tx *Tx) Eval(src string, keys []string, args ...string) {
+	payload, err := encodeEval(src, keys, args)
+	if err != nil && tx.err == nil {
+		tx.err = err
+	}
+	tx.cmds = append(tx.cmds, txCommand{cmdType: protocol.CmdEval, payload: payload})
+}
+
+// ScriptLoad compiles and caches src on the server without running it, and
+// returns the SHA1 to pass to EvalSHA.
+func (c *Client) ScriptLoad(src string) (string, error) {
+	sha, err := c.valueCommand(protocol.CmdScriptLoad, "SCRIPT LOAD", "", protocol.EncodeArgs([]byte(src)))
+	if err != nil {
+		return "", err
+	}
+	return string(sha), nil
+}
+
+// Script is a script run by its SHA1, so that its source is only sent when
+// the server has not cached it yet.
+type Script struct {
+	src string
+	sha string
+}
+
+// NewScript returns a Script for src.
+func NewScript(src string) *Script {
+	sum := sha1.Sum([]byte(src))
+	return &Script{src: src, sha: hex.EncodeToString(sum[:])}
+}
+
+// SHA returns the hex SHA1 the server caches the script under.
+func (s *Script) SHA() string {
+	return s.sha
+}
+
+// Run runs the script with EvalSHA, falling back to Eval, which also caches
+// it, if the server does not have it.
+func (s *Script) Run(c *Client, keys []string, args ...string) (Reply, error) {
+	reply, err := c.EvalSHA(s.sha, keys, args...)
+	if err == ErrNoScript {
+		return c.Eval(s.src, keys, args...)
+	}
+	return reply, err
+}
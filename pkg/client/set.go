@@ -0,0 +1,113 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/jasonrowsell/zerocache/pkg/protocol"
+)
+
+// SAdd adds members to the set stored at key and returns how many were newly added.
+func (c *Client) SAdd(key string, members ...string) (int, error) {
+	return c.setMembersCommand(protocol.CmdSAdd, "SADD", key, members)
+}
+
+// SRem removes members from the set stored at key and returns how many were removed.
+func (c *Client) SRem(key string, members ...string) (int, error) {
+	return c.setMembersCommand(protocol.CmdSRem, "SREM", key, members)
+}
+
+func (c *Client) setMembersCommand(cmdType uint8, name string, key string, members []string) (int, error) {
+	if err := checkKey(key); err != nil {
+		return 0, err
+	}
+	if len(members) == 0 {
+		return 0, fmt.Errorf("no members given")
+	}
+
+	var payload []byte
+	for _, member := range members {
+		payload = protocol.AppendArg(payload, []byte(member))
+	}
+	n, err := c.intCommand(cmdType, name, key, payload)
+	return int(n), err
+}
+
+// SIsMember reports whether member belongs to the set stored at key.
+func (c *Client) SIsMember(key string, member string) (bool, error) {
+	if err := checkKey(key); err != nil {
+		return false, err
+	}
+	n, err := c.intCommand(protocol.CmdSIsMember, "SISMEMBER", key, protocol.EncodeArgs([]byte(member)))
+	return n == 1, err
+}
+
+// SMembers returns all members of the set stored at key, in no particular order.
+func (c *Client) SMembers(key string) ([]string, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	elems, err := c.arrayCommand(protocol.CmdSMembers, "SMEMBERS", key, nil)
+	return elemStrings(elems), err
+}
+
+// SCard returns the number of members in the set stored at key.
+func (c *Client) SCard(key string) (int, error) {
+	if err := checkKey(key); err != nil {
+		return 0, err
+	}
+	n, err := c.intCommand(protocol.CmdSCard, "SCARD", key, nil)
+	return int(n), err
+}
+
+// SRandMember returns random members of the set stored at key without removing
+// them. A positive count returns up to count distinct members; a negative count
+// returns exactly -count members, possibly with repeats.
+func (c *Client) SRandMember(key string, count int) ([]string, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	payload := protocol.EncodeArgs(protocol.EncodeInt64(int64(count)))
+	elems, err := c.arrayCommand(protocol.CmdSRandMember, "SRANDMEMBER", key, payload)
+	return elemStrings(elems), err
+}
+
+// SInter returns the members present in all of the sets stored at keys.
+func (c *Client) SInter(keys ...string) ([]string, error) {
+	return c.setAlgebra(protocol.CmdSInter, "SINTER", keys)
+}
+
+// SUnion returns the members present in any of the sets stored at keys.
+func (c *Client) SUnion(keys ...string) ([]string, error) {
+	return c.setAlgebra(protocol.CmdSUnion, "SUNION", keys)
+}
+
+// SDiff returns the members of the first set that are in none of the others.
+func (c *Client) SDiff(keys ...string) ([]string, error) {
+	return c.setAlgebra(protocol.CmdSDiff, "SDIFF", keys)
+}
+
+func (c *Client) setAlgebra(cmdType uint8, name string, keys []string) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys given")
+	}
+	var payload []byte
+	for _, key := range keys {
+		if err := checkKey(key); err != nil {
+			return nil, err
+		}
+		payload = protocol.AppendArg(payload, []byte(key))
+	}
+	elems, err := c.arrayCommand(cmdType, name, "", payload)
+	return elemStrings(elems), err
+}
+
+func elemStrings(elems [][]byte) []string {
+	if elems == nil {
+		return nil
+	}
+	strs := make([]string, len(elems))
+	for i, elem := range elems {
+		strs[i] = string(elem)
+	}
+	return strs
+}
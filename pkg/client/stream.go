@@ -0,0 +1,193 @@
+package client
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/jasonrowsell/zerocache/pkg/protocol"
+)
+
+// chunkPool holds the buffers SetStream reads values into, one chunk at a time.
+var chunkPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, protocol.StreamChunkSize)
+		return &b
+	},
+}
+
+// SetStream sets key to the value read from r until EOF, sending it in chunks
+// as it is read, so values larger than protocol.MaxValueSize can be stored
+// without holding them in memory at once. The server rejects values above its
+// limit (-max-stream-value-size). If reading r fails, the write is abandoned
+// and the key left untouched.
+func (c *Client) SetStream(key string, r io.Reader) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	respType, respValue, err := c.sendStream(key, r)
+	if err != nil {
+		return err
+	}
+
+	switch respType {
+	case protocol.RespOK:
+		return nil
+	case protocol.RespError:
+		return Error(respValue)
+	default:
+		return c.protocolError("SETSTREAM", respType)
+	}
+}
+
+// sendStream runs SETSTREAM with the value read from r while holding the
+// client lock.
+func (c *Client) sendStream(key string, r io.Reader) (uint8, []byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return 0, nil, fmt.Errorf("client closed")
+	}
+	if err := c.sendCommand(protocol.CmdSetStream, key, nil); err != nil {
+		return 0, nil, err
+	}
+
+	bufPtr := chunkPool.Get().(*[]byte)
+	defer chunkPool.Put(bufPtr)
+	var header [4]byte
+	var readErr error
+	for readErr == nil {
+		var n int
+		n, readErr = io.ReadFull(r, *bufPtr)
+		if n > 0 {
+			// bufio.Writer errors are sticky and reported by the Flush below.
+			_, _ = c.writer.Write(binary.BigEndian.AppendUint32(header[:0], uint32(n)))
+			_, _ = c.writer.Write((*bufPtr)[:n])
+		}
+	}
+	end := uint32(0)
+	if readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+		end = protocol.StreamAbort
+	}
+	_, _ = c.writer.Write(binary.BigEndian.AppendUint32(header[:0], end))
+	if err := c.writer.Flush(); err != nil {
+		c.closeConnOnError(err)
+		return 0, nil, fmt.Errorf("write error: %w", err)
+	}
+
+	respType, respValue, err := c.readResponse()
+	if err == nil && end == protocol.StreamAbort {
+		return 0, nil, fmt.Errorf("failed to read value: %w", readErr)
+	}
+	return respType, respValue, err
+}
+
+// GetStream returns a reader of the value stored at key, which receives the
+// value in chunks as it is read, or ErrNotFound. Use it for values stored with
+// SetStream that may exceed protocol.MaxPayloadSize, which GET refuses.
+//
+// The connection is dedicated to the stream until it is read to EOF or
+// closed, and other calls on the client wait until then, so always close it.
+// Closing early still reads the rest of the value off the connection.
+func (c *Client) GetStream(key string) (io.ReadCloser, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if c.conn == nil {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("client closed")
+	}
+	if err := c.sendCommand(protocol.CmdGetStream, key, nil); err != nil {
+		c.mu.Unlock()
+		return nil, err
+	}
+	respType, respValue, err := c.readResponse()
+	if err == nil && respType == protocol.RespStream {
+		return &streamReader{c: c}, nil // Keeps the lock until done
+	}
+	c.mu.Unlock()
+
+	switch {
+	case err != nil:
+		return nil, err
+	case respType == protocol.RespNotFound:
+		return nil, ErrNotFound
+	case respType == protocol.RespError:
+		return nil, Error(respValue)
+	default:
+		return nil, c.protocolError("GETSTREAM", respType)
+	}
+}
+
+// streamReader reads the chunk stream of a GETSTREAM response straight off
+// the connection, holding the client lock until the stream ends.
+type streamReader struct {
+	c         *Client
+	remaining int   // Bytes left in the current chunk
+	err       error // Set once the stream has ended, io.EOF if it ended well
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for r.remaining == 0 {
+		var header [4]byte
+		if _, err := io.ReadFull(r.c.reader, header[:]); err != nil {
+			return 0, r.fail(fmt.Errorf("read chunk error: %w", err))
+		}
+		n := binary.BigEndian.Uint32(header[:])
+		if n == 0 {
+			r.end(io.EOF)
+			return 0, io.EOF
+		}
+		if n > protocol.MaxChunkSize {
+			return 0, r.fail(fmt.Errorf("protocol error: chunk length %d exceeds maximum %d", n, protocol.MaxChunkSize))
+		}
+		r.remaining = int(n)
+	}
+
+	n, err := r.c.reader.Read(p[:min(len(p), r.remaining)])
+	r.remaining -= n
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return n, r.fail(fmt.Errorf("read chunk error: %w", err))
+	}
+	return n, nil
+}
+
+// Close reads the rest of the stream, if any, and releases the connection.
+func (r *streamReader) Close() error {
+	if r.err == nil {
+		_, _ = io.Copy(io.Discard, r)
+	}
+	if r.err == io.EOF || r.err == errStreamClosed {
+		r.err = errStreamClosed
+		return nil
+	}
+	return r.err
+}
+
+var errStreamClosed = errors.New("read from closed stream")
+
+// fail ends the stream with err, closing the connection, which is out of sync.
+func (r *streamReader) fail(err error) error {
+	r.c.closeConnOnError(err)
+	r.end(err)
+	return err
+}
+
+func (r *streamReader) end(err error) {
+	r.err = err
+	r.c.mu.Unlock()
+}
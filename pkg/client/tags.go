@@ -0,0 +1,45 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/jasonrowsell/zerocache/pkg/protocol"
+)
+
+// SetWithTags stores value under key and attaches tags to it, replacing any
+// tags the key had. Writing the key again without tags detaches them.
+func (c *Client) SetWithTags(key string, value []byte, tags ...string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	if len(value) > protocol.MaxValueSize {
+		return fmt.Errorf("invalid value length")
+	}
+	if len(tags) == 0 {
+		return c.Set(key, value)
+	}
+
+	payload := protocol.AppendArg(nil, value)
+	for _, tag := range tags {
+		payload = protocol.AppendArg(payload, []byte(tag))
+	}
+	respType, respValue, err := c.roundTrip(protocol.CmdSetTags, key, payload)
+	if err != nil {
+		return err
+	}
+
+	switch respType {
+	case protocol.RespOK:
+		return nil
+	case protocol.RespError:
+		return Error(respValue)
+	default:
+		return c.protocolError("SETTAGS", respType)
+	}
+}
+
+// InvalidateTag deletes every key carrying tag and returns how many were removed.
+func (c *Client) InvalidateTag(tag string) (int, error) {
+	n, err := c.intCommand(protocol.CmdInvalidateTag, "INVALIDATE", "", protocol.EncodeArgs([]byte(tag)))
+	return int(n), err
+}
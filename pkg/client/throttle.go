@@ -0,0 +1,59 @@
+package client
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jasonrowsell/zerocache/pkg/protocol"
+)
+
+// ThrottleResult is the outcome of a THROTTLE call.
+type ThrottleResult struct {
+	Allowed    bool
+	Remaining  int           // Requests that would be allowed right now
+	RetryAfter time.Duration // Until the request would be allowed; 0 if it was
+	ResetAfter time.Duration // Until the full quota is available again
+}
+
+// Throttle counts a request against the rate limit of limit requests per
+// window kept at key, and reports whether it is allowed. Bursts of up to limit
+// requests are allowed, after which quota comes back at an even pace. The
+// check and update happen atomically on the server, so any number of clients
+// can share a limit. window is rounded down to whole milliseconds.
+func (c *Client) Throttle(key string, limit int, window time.Duration) (ThrottleResult, error) {
+	return c.ThrottleN(key, limit, window, 1)
+}
+
+// ThrottleN is Throttle for a request that counts cost times, e.g. a batch.
+func (c *Client) ThrottleN(key string, limit int, window time.Duration, cost int) (ThrottleResult, error) {
+	if err := checkKey(key); err != nil {
+		return ThrottleResult{}, err
+	}
+	if limit <= 0 || window < time.Millisecond || cost <= 0 {
+		return ThrottleResult{}, fmt.Errorf("invalid throttle: limit and cost must be positive and window at least 1ms")
+	}
+	payload := protocol.EncodeArgs(
+		protocol.EncodeUint64(uint64(limit)),
+		protocol.EncodeUint64(uint64(window.Milliseconds())),
+		protocol.EncodeUint64(uint64(cost)),
+	)
+	elems, err := c.arrayCommand(protocol.CmdThrottle, "THROTTLE", key, payload)
+	if err != nil {
+		return ThrottleResult{}, err
+	}
+	var values [4]int64
+	if len(elems) != len(values) {
+		return ThrottleResult{}, c.protocolError("THROTTLE", protocol.RespArray)
+	}
+	for i, elem := range elems {
+		if values[i], err = protocol.DecodeInt64(elem); err != nil {
+			return ThrottleResult{}, c.protocolError("THROTTLE", protocol.RespArray)
+		}
+	}
+	return ThrottleResult{
+		Allowed:    values[0] == 1,
+		Remaining:  int(values[1]),
+		RetryAfter: time.Duration(values[2]) * time.Millisecond,
+		ResetAfter: time.Duration(values[3]) * time.Millisecond,
+	}, nil
+}
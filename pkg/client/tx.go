@@ -0,0 +1,268 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/jasonrowsell/zerocache/pkg/protocol"
+)
+
+// ErrTxAborted is returned by Tx.Exec when a watched key changed before the
+// transaction ran. Nothing was executed; read the keys again and retry.
+const ErrTxAborted = Error("transaction aborted: a watched key was modified")
+
+// Reply is the response to one command of a transaction, or a script result.
+type Reply struct {
+	Type  uint8 // One of the protocol.Resp* types
+	Value []byte
+}
+
+// Err returns the error the command failed with: ErrNotFound for a missing
+// key, the server's error, or nil.
+func (r Reply) Err() error {
+	switch r.Type {
+	case protocol.RespError:
+		return Error(r.Value)
+	case protocol.RespNotFound:
+		return ErrNotFound
+	default:
+		return nil
+	}
+}
+
+// Bytes returns the value of a GET-like reply.
+func (r Reply) Bytes() ([]byte, error) {
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+	if r.Type != protocol.RespValue {
+		return nil, fmt.Errorf("reply of type %d is not a value", r.Type)
+	}
+	return r.Value, nil
+}
+
+// Int returns the value of an integer reply, such as INCRBY's or SADD's.
+func (r Reply) Int() (int64, error) {
+	if err := r.Err(); err != nil {
+		return 0, err
+	}
+	if r.Type != protocol.RespInt {
+		return 0, fmt.Errorf("reply of type %d is not an integer", r.Type)
+	}
+	return protocol.DecodeInt64(r.Value)
+}
+
+// Strings returns the elements of an array reply, such as a script's list.
+func (r Reply) Strings() ([]string, error) {
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+	if r.Type != protocol.RespArray {
+		return nil, fmt.Errorf("reply of type %d is not an array", r.Type)
+	}
+	elems, err := protocol.DecodeArgs(r.Value)
+	if err != nil {
+		return nil, err
+	}
+	strs := make([]string, len(elems))
+	for i, elem := range elems {
+		strs[i] = string(elem)
+	}
+	return strs, nil
+}
+
+// Tx buffers commands to run atomically with Exec. Nothing is sent to the
+// server before Exec, so queuing methods only report argument errors, and
+// they do so from Exec.
+type Tx struct {
+	c    *Client
+	cmds []txCommand
+	err  error // First argument error
+}
+
+type txCommand struct {
+	cmdType uint8
+	key     string
+	payload []byte
+}
+
+// Watch makes the next transaction on this connection abort with
+// ErrTxAborted if any of keys is modified before it runs. Watches are cleared
+// by Exec, Discard and Unwatch.
+func (c *Client) Watch(keys ...string) error {
+	if len(keys) == 0 {
+		return fmt.Errorf("no keys to watch")
+	}
+	payload := make([][]byte, len(keys))
+	for i, key := range keys {
+		if err := checkKey(key); err != nil {
+			return err
+		}
+		payload[i] = []byte(key)
+	}
+	return c.okCommand(protocol.CmdWatch, "WATCH", protocol.EncodeArgs(payload...))
+}
+
+// Unwatch clears the keys watched with Watch.
+func (c *Client) Unwatch() error {
+	return c.okCommand(protocol.CmdUnwatch, "UNWATCH", nil)
+}
+
+func (c *Client) okCommand(cmdType uint8, name string, payload []byte) error {
+	respType, respValue, err := c.roundTrip(cmdType, "", payload)
+	if err != nil {
+		return err
+	}
+
+	switch respType {
+	case protocol.RespOK:
+		return nil
+	case protocol.RespError:
+		return Error(respValue)
+	default:
+		return c.protocolError(name, respType)
+	}
+}
+
+// Multi starts a transaction. Commands queued on it run atomically, in order,
+// when Exec is called: no other client observes a state in which only some of
+// them have run. A command failing at run time does not undo the others.
+func (c *Client) Multi() *Tx {
+	return &Tx{c: c}
+}
+
+func (tx *Tx) queue(cmdType uint8, key string, payload []byte) {
+	if tx.err == nil {
+		tx.err = checkKey(key)
+	}
+	tx.cmds = append(tx.cmds, txCommand{cmdType: cmdType, key: key, payload: payload})
+}
+
+// Set queues a SET.
+func (tx *Tx) Set(key string, value []byte) {
+	if len(value) > protocol.MaxValueSize && tx.err == nil {
+		tx.err = fmt.Errorf("invalid value length")
+	}
+	tx.queue(protocol.CmdSet, key, value)
+}
+
+// Get queues a GET.
+func (tx *Tx) Get(key string) {
+	tx.queue(protocol.CmdGet, key, nil)
+}
+
+// Delete queues a DELETE.
+func (tx *Tx) Delete(key string) {
+	tx.queue(protocol.CmdDel, key, nil)
+}
+
+// IncrBy queues an INCRBY.
+func (tx *Tx) IncrBy(key string, delta int64) {
+	tx.queue(protocol.CmdIncrBy, key, protocol.EncodeArgs(protocol.EncodeInt64(delta)))
+}
+
+// HSet queues an HSET of a single field.
+func (tx *Tx) HSet(key string, field string, value []byte) {
+	tx.queue(protocol.CmdHSet, key, protocol.EncodeArgs([]byte(field), value))
+}
+
+// HDel queues an HDEL.
+func (tx *Tx) HDel(key string, fields ...string) {
+	tx.queue(protocol.CmdHDel, key, encodeNames(fields))
+}
+
+// SAdd queues an SADD.
+func (tx *Tx) SAdd(key string, members ...string) {
+	tx.queue(protocol.CmdSAdd, key, encodeNames(members))
+}
+
+// SRem queues an SREM.
+func (tx *Tx) SRem(key string, members ...string) {
+	tx.queue(protocol.CmdSRem, key, encodeNames(members))
+}
+
+// RPush queues an RPUSH.
+func (tx *Tx) RPush(key string, values ...[]byte) {
+	tx.queue(protocol.CmdRPush, key, protocol.EncodeArgs(values...))
+}
+
+// Discard drops the queued commands and clears the connection's watches.
+func (tx *Tx) Discard() error {
+	tx.cmds = nil
+	return tx.c.Unwatch()
+}
+
+// Exec runs the queued commands atomically and returns one Reply per command,
+// in order. It returns ErrTxAborted if a watched key changed, in which case
+// nothing ran. Watches are cleared either way.
+func (tx *Tx) Exec() ([]Reply, error) {
+	if tx.err != nil {
+		return nil, tx.err
+	}
+
+	c := tx.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return nil, fmt.Errorf("client closed")
+	}
+	// Pipeline MULTI, the commands and EXEC, then collect the responses.
+	if err := c.sendCommand(protocol.CmdMulti, "", nil); err != nil {
+		return nil, err
+	}
+	for _, cmd := range tx.cmds {
+		if err := c.sendCommand(cmd.cmdType, cmd.key, cmd.payload); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.sendCommand(protocol.CmdExec, "", nil); err != nil {
+		return nil, err
+	}
+
+	var queueErr error
+	for range len(tx.cmds) + 1 {
+		respType, respValue, err := c.readResponse()
+		if err != nil {
+			return nil, err
+		}
+		if respType == protocol.RespError && queueErr == nil {
+			queueErr = Error(respValue)
+		}
+	}
+
+	respType, respValue, err := c.readResponse()
+	if err != nil {
+		return nil, err
+	}
+	switch respType {
+	case protocol.RespArray:
+		elems, err := protocol.DecodeArgs(respValue)
+		if err != nil || len(elems) != len(tx.cmds) {
+			err = fmt.Errorf("protocol error: malformed EXEC response")
+			c.closeConnOnError(err)
+			return nil, err
+		}
+		replies := make([]Reply, len(elems))
+		for i, elem := range elems {
+			if len(elem) == 0 {
+				err = fmt.Errorf("protocol error: empty reply in EXEC response")
+				c.closeConnOnError(err)
+				return nil, err
+			}
+			replies[i] = Reply{Type: elem[0], Value: elem[1:]}
+		}
+		return replies, nil
+	case protocol.RespNotStored:
+		return nil, ErrTxAborted
+	case protocol.RespError:
+		if queueErr != nil {
+			// More telling than EXECABORT.
+			return nil, queueErr
+		}
+		return nil, Error(respValue)
+	default:
+		err = fmt.Errorf("protocol error: unexpected response type %d for EXEC", respType)
+		c.closeConnOnError(err)
+		return nil, err
+	}
+}
@@ -0,0 +1,169 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/jasonrowsell/zerocache/pkg/protocol"
+)
+
+// ZMember is a member of a sorted set together with its score.
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+// ZAdd adds members to the sorted set stored at key, or updates their scores if
+// already present, and returns the number of members newly added.
+func (c *Client) ZAdd(key string, members ...ZMember) (int, error) {
+	if err := checkKey(key); err != nil {
+		return 0, err
+	}
+	if len(members) == 0 {
+		return 0, fmt.Errorf("no members to add")
+	}
+
+	var payload []byte
+	for _, m := range members {
+		payload = protocol.AppendArg(payload, protocol.EncodeFloat64(m.Score))
+		payload = protocol.AppendArg(payload, []byte(m.Member))
+	}
+	n, err := c.intCommand(protocol.CmdZAdd, "ZADD", key, payload)
+	return int(n), err
+}
+
+// ZRem removes members from the sorted set stored at key and returns how many were removed.
+func (c *Client) ZRem(key string, members ...string) (int, error) {
+	if err := checkKey(key); err != nil {
+		return 0, err
+	}
+	if len(members) == 0 {
+		return 0, fmt.Errorf("no members to remove")
+	}
+
+	var payload []byte
+	for _, member := range members {
+		payload = protocol.AppendArg(payload, []byte(member))
+	}
+	n, err := c.intCommand(protocol.CmdZRem, "ZREM", key, payload)
+	return int(n), err
+}
+
+// ZScore returns the score of member in the sorted set stored at key.
+// It returns ErrNotFound if the key or the member does not exist.
+func (c *Client) ZScore(key string, member string) (float64, error) {
+	if err := checkKey(key); err != nil {
+		return 0, err
+	}
+
+	respType, respValue, err := c.roundTrip(protocol.CmdZScore, key, protocol.EncodeArgs([]byte(member)))
+	if err != nil {
+		return 0, err
+	}
+
+	switch respType {
+	case protocol.RespFloat:
+		score, err := protocol.DecodeFloat64(respValue)
+		if err != nil {
+			return 0, c.protocolError("ZSCORE", respType)
+		}
+		return score, nil
+	case protocol.RespNotFound:
+		return 0, ErrNotFound
+	case protocol.RespError:
+		return 0, Error(respValue)
+	default:
+		return 0, c.protocolError("ZSCORE", respType)
+	}
+}
+
+// ZRange returns members by rank between start and stop (inclusive) in ascending
+// score order. Negative indexes count from the end, -1 being the last member.
+func (c *Client) ZRange(key string, start, stop int) ([]ZMember, error) {
+	return c.zrange(protocol.CmdZRange, "ZRANGE", key, protocol.EncodeInt64(int64(start)), protocol.EncodeInt64(int64(stop)))
+}
+
+// ZRevRange is like ZRange but ranks members in descending score order.
+func (c *Client) ZRevRange(key string, start, stop int) ([]ZMember, error) {
+	return c.zrange(protocol.CmdZRevRange, "ZREVRANGE", key, protocol.EncodeInt64(int64(start)), protocol.EncodeInt64(int64(stop)))
+}
+
+// ZRangeByScore returns members with scores between min and max (inclusive) in
+// ascending score order. Use math.Inf for open-ended ranges.
+func (c *Client) ZRangeByScore(key string, min, max float64) ([]ZMember, error) {
+	return c.zrange(protocol.CmdZRangeByScore, "ZRANGEBYSCORE", key, protocol.EncodeFloat64(min), protocol.EncodeFloat64(max))
+}
+
+// ZRevRangeByScore is like ZRangeByScore but returns members in descending score order.
+func (c *Client) ZRevRangeByScore(key string, min, max float64) ([]ZMember, error) {
+	return c.zrange(protocol.CmdZRevRangeByScore, "ZREVRANGEBYSCORE", key, protocol.EncodeFloat64(min), protocol.EncodeFloat64(max))
+}
+
+func (c *Client) zrange(cmdType uint8, name string, key string, from, to []byte) ([]ZMember, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+
+	elems, err := c.arrayCommand(cmdType, name, key, protocol.EncodeArgs(from, to))
+	if err != nil {
+		return nil, err
+	}
+	if len(elems)%2 != 0 {
+		return nil, fmt.Errorf("protocol error: odd number of elements in %s response", name)
+	}
+	members := make([]ZMember, 0, len(elems)/2)
+	for i := 0; i < len(elems); i += 2 {
+		score, err := protocol.DecodeFloat64(elems[i+1])
+		if err != nil {
+			return nil, fmt.Errorf("protocol error: invalid score in %s response: %w", name, err)
+		}
+		members = append(members, ZMember{Member: string(elems[i]), Score: score})
+	}
+	return members, nil
+}
+
+// ZRank returns the 0-based rank of member in ascending score order.
+// It returns ErrNotFound if the key or the member does not exist.
+func (c *Client) ZRank(key string, member string) (int, error) {
+	return c.zrank(protocol.CmdZRank, "ZRANK", key, member)
+}
+
+// ZRevRank returns the 0-based rank of member in descending score order.
+// It returns ErrNotFound if the key or the member does not exist.
+func (c *Client) ZRevRank(key string, member string) (int, error) {
+	return c.zrank(protocol.CmdZRevRank, "ZREVRANK", key, member)
+}
+
+func (c *Client) zrank(cmdType uint8, name string, key string, member string) (int, error) {
+	if err := checkKey(key); err != nil {
+		return 0, err
+	}
+
+	respType, respValue, err := c.roundTrip(cmdType, key, protocol.EncodeArgs([]byte(member)))
+	if err != nil {
+		return 0, err
+	}
+
+	switch respType {
+	case protocol.RespInt:
+		rank, err := protocol.DecodeInt64(respValue)
+		if err != nil {
+			return 0, c.protocolError(name, respType)
+		}
+		return int(rank), nil
+	case protocol.RespNotFound:
+		return 0, ErrNotFound
+	case protocol.RespError:
+		return 0, Error(respValue)
+	default:
+		return 0, c.protocolError(name, respType)
+	}
+}
+
+// ZCard returns the number of members in the sorted set stored at key.
+func (c *Client) ZCard(key string) (int, error) {
+	if err := checkKey(key); err != nil {
+		return 0, err
+	}
+	n, err := c.intCommand(protocol.CmdZCard, "ZCARD", key, nil)
+	return int(n), err
+}
@@ -1,18 +1,162 @@
 package protocol
 
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
 // Command types
 const (
-	CmdSet uint8 = 1
-	CmdGet uint8 = 2
-	CmdDel uint8 = 3
+	CmdSet    uint8 = 1
+	CmdGet    uint8 = 2
+	CmdDel    uint8 = 3
+	CmdGetV   uint8 = 4  // GET returning the entry version alongside the value
+	CmdCAS    uint8 = 5  // SET only if the entry version still matches
+	CmdSetNX  uint8 = 6  // SET only if the key does not exist (add)
+	CmdSetXX  uint8 = 7  // SET only if the key already exists (replace)
+	CmdIncr   uint8 = 8  // Add 1 to an integer value; optional TTL (ms) argument for new keys
+	CmdDecr   uint8 = 9  // Subtract 1 from an integer value; optional TTL (ms) argument
+	CmdIncrBy uint8 = 10 // Add a signed delta argument; optional TTL (ms) argument
+
+	// Hash commands; fields and values are passed as arguments
+	CmdHSet    uint8 = 11 // Arguments: field, value [, field, value ...]
+	CmdHGet    uint8 = 12 // Arguments: field
+	CmdHDel    uint8 = 13 // Arguments: field [, field ...]
+	CmdHGetAll uint8 = 14 // Responds with an array of alternating fields and values
+	CmdHLen    uint8 = 15
+
+	// List commands
+	CmdLPush  uint8 = 16 // Arguments: value [, value ...]
+	CmdRPush  uint8 = 17 // Arguments: value [, value ...]
+	CmdLPop   uint8 = 18
+	CmdRPop   uint8 = 19
+	CmdLRange uint8 = 20 // Arguments: start, stop (signed integers, inclusive)
+	CmdLLen   uint8 = 21
+	CmdBLPop  uint8 = 22 // Keyless. Arguments: timeout (ms, 0 blocks forever), key [, key ...]
+
+	// Sorted set commands; scores are encoded with EncodeFloat64. Range commands
+	// respond with an array of alternating members and scores.
+	CmdZAdd             uint8 = 23 // Arguments: score, member [, score, member ...]
+	CmdZRem             uint8 = 24 // Arguments: member [, member ...]
+	CmdZScore           uint8 = 25 // Arguments: member
+	CmdZRange           uint8 = 26 // Arguments: start, stop (signed integers, inclusive)
+	CmdZRevRange        uint8 = 27 // Arguments: start, stop (signed integers, inclusive)
+	CmdZRangeByScore    uint8 = 28 // Arguments: min, max (inclusive)
+	CmdZRevRangeByScore uint8 = 29 // Arguments: min, max (inclusive)
+	CmdZRank            uint8 = 30 // Arguments: member
+	CmdZRevRank         uint8 = 31 // Arguments: member
+	CmdZCard            uint8 = 32
+
+	// Set commands. Member lists are returned as arrays in no particular order.
+	CmdSAdd        uint8 = 33 // Arguments: member [, member ...]
+	CmdSRem        uint8 = 34 // Arguments: member [, member ...]
+	CmdSIsMember   uint8 = 35 // Arguments: member; responds with 1 or 0
+	CmdSMembers    uint8 = 36
+	CmdSCard       uint8 = 37
+	CmdSRandMember uint8 = 38 // Arguments: count (signed integer, negative allows repeats)
+	CmdSInter      uint8 = 39 // Keyless. Arguments: key [, key ...]
+	CmdSUnion      uint8 = 40 // Keyless. Arguments: key [, key ...]
+	CmdSDiff       uint8 = 41 // Keyless. Arguments: key [, key ...]
+
+	// CmdScan walks the keyspace. Keyless. Arguments: cursor, count [, pattern].
+	// Responds with an array holding the next cursor followed by the keys found.
+	CmdScan uint8 = 42
+
+	// Bulk deletion; both respond with the number of keys removed.
+	CmdDelPattern uint8 = 43 // Keyless. Arguments: glob pattern
+	CmdFlushAll   uint8 = 44 // Keyless
+
+	// Tagging
+	CmdSetTags       uint8 = 45 // SET attaching tags. Arguments: value, tag [, tag ...]
+	CmdInvalidateTag uint8 = 46 // Keyless. Arguments: tag; responds with the number of keys removed
+
+	// Namespaces
+	CmdSelect uint8 = 47 // Keyless. Arguments: namespace; switches the connection's namespace
+	CmdIn     uint8 = 48 // Envelope: the key is a namespace, the payload a complete command frame to run in it
+	CmdStats  uint8 = 49 // Keyless. Responds with an array of alternating stat names and decimal values
+
+	// Pub/sub. SUBSCRIBE and PSUBSCRIBE switch the connection into push mode,
+	// where every reply is a RespPush frame and only the four subscription
+	// commands are accepted, until nothing is subscribed any more.
+	CmdSubscribe    uint8 = 50 // Keyless. Arguments: channel [, channel ...]
+	CmdPSubscribe   uint8 = 51 // Keyless. Arguments: glob pattern [, pattern ...]
+	CmdUnsubscribe  uint8 = 52 // Keyless. Arguments: [channel ...]; none means all
+	CmdPUnsubscribe uint8 = 53 // Keyless. Arguments: [pattern ...]; none means all
+	CmdPublish      uint8 = 54 // Keyless. Arguments: channel, message; responds with the number of receivers
+
+	// Transactions. After MULTI, commands are answered with RespOK and queued
+	// instead of run; EXEC then runs them all atomically and responds with an
+	// array holding each command's response encoded as [type:1][value], or with
+	// RespNotStored if a key watched with WATCH changed since. Commands
+	// rejected while queuing make EXEC fail without running anything.
+	CmdMulti   uint8 = 55 // Keyless
+	CmdExec    uint8 = 56 // Keyless
+	CmdDiscard uint8 = 57 // Keyless. Drops the queued commands and the watched keys
+	CmdWatch   uint8 = 58 // Keyless. Arguments: key [, key ...]
+	CmdUnwatch uint8 = 59 // Keyless
+
+	// Scripting. EVAL and EVALSHA run a script atomically on the keys it
+	// declares, which are the only keys it may touch. Their arguments are the
+	// script (or its SHA1 in hex), the key count (EncodeUint64), the keys, then
+	// the script's own arguments. The result is RespNotFound for nil, RespInt
+	// for integers and booleans, RespValue for strings and RespArray for lists.
+	CmdEval       uint8 = 60 // Keyless
+	CmdEvalSHA    uint8 = 61 // Keyless. Fails with a NOSCRIPT error if the script is not cached
+	CmdScriptLoad uint8 = 62 // Keyless. Arguments: script; caches it and responds with its SHA1 in hex
+
+	// Rate limit the key (GCRA). Arguments: limit, window (ms) [, cost], each
+	// EncodeUint64. Responds with an array of four EncodeInt64 values: allowed
+	// (0 or 1), remaining quota, retry-after (ms) and reset-after (ms).
+	CmdThrottle uint8 = 63
+
+	// Locks with owner tokens and automatic expiry. LOCK responds with the
+	// fencing token (RespInt, EncodeUint64); all three respond with
+	// RespNotStored if another owner holds the lock, or no one does for UNLOCK
+	// and EXTEND.
+	CmdLock   uint8 = 64 // Arguments: owner, TTL (ms)
+	CmdUnlock uint8 = 65 // Arguments: owner
+	CmdExtend uint8 = 66 // Arguments: owner, TTL (ms)
+
+	// HyperLogLog cardinality estimation. A HyperLogLog is a plain value, so
+	// GET dumps it and SET restores it.
+	CmdPFAdd   uint8 = 67 // Arguments: element [, element ...]; responds 1 if the estimate may have changed
+	CmdPFCount uint8 = 68 // Keyless. Arguments: key [, key ...]; responds with the estimated union size
+	CmdPFMerge uint8 = 69 // Arguments: source key [, source key ...]; stores the union at the key
+
+	// Scalable Bloom filters. BF.ADD creates a filter with the server's
+	// defaults if the key is missing. BF.ADD and BF.EXISTS respond with an
+	// array holding 1 or 0 (EncodeInt64) per element: whether it was added,
+	// or whether it may be present.
+	CmdBFReserve uint8 = 70 // Arguments: error rate (EncodeFloat64), capacity; RespNotStored if the key exists
+	CmdBFAdd     uint8 = 71 // Arguments: element [, element ...]
+	CmdBFExists  uint8 = 72 // Arguments: element [, element ...]
+
+	// Streamed SET and GET for values of any size up to the server's limit.
+	// The value travels as a chunk stream (see StreamChunkSize) after the
+	// frame: following the SETSTREAM command, which has no payload, and after
+	// the header of a RespStream response to GETSTREAM.
+	CmdSetStream uint8 = 73
+	CmdGetStream uint8 = 74
 )
 
 // Response types
 const (
-	RespOK       uint8 = 1 // Generic OK
-	RespError    uint8 = 2 // Error message follows
-	RespValue    uint8 = 3 // Value data follows
-	RespNotFound uint8 = 4 // Key not found (specific to GET)
+	RespOK        uint8 = 1 // Generic OK
+	RespError     uint8 = 2 // Error message follows
+	RespValue     uint8 = 3 // Value data follows
+	RespNotFound  uint8 = 4 // Key not found (specific to GET)
+	RespInt       uint8 = 5 // 8-byte big-endian integer follows
+	RespArray     uint8 = 6 // Length-prefixed elements follow (see EncodeArgs)
+	RespNotStored uint8 = 7 // Conditional write was not applied
+	RespFloat     uint8 = 8 // 8-byte IEEE 754 float follows (see EncodeFloat64)
+	// RespPush is an array sent in push mode, led by its kind:
+	//   subscribe|psubscribe|unsubscribe|punsubscribe, name, subscription count (EncodeInt64)
+	//   message, channel, payload
+	//   pmessage, pattern, channel, payload
+	RespPush uint8 = 9
+	// RespStream carries no payload of its own and is followed by a chunk stream.
+	RespStream uint8 = 10
 )
 
 // Size constants
@@ -20,4 +164,110 @@ const (
 	MaxKeySize   = 1028      // 1KB limit for keys
 	MaxValueSize = 64 * 1028 // 64KB limit for values
 
+	// MaxPayloadSize bounds a whole command or response body, which may carry
+	// a value plus argument framing (e.g. CAS) or several elements (arrays).
+	MaxPayloadSize = 1 << 20
+)
+
+// A chunk stream is a series of chunks, each a 4-byte big-endian length
+// followed by that many bytes, ending with an empty chunk. A sender may end a
+// SETSTREAM early with a StreamAbort length instead, after which the server
+// discards the value and responds with an error.
+const (
+	StreamChunkSize = 32 * 1024      // Size of the chunks senders cut values into
+	MaxChunkSize    = MaxPayloadSize // Largest chunk a receiver accepts
+	StreamAbort     = math.MaxUint32
 )
+
+// KeyEventChannelPrefix starts the pub/sub channels keyspace events are
+// published on, when the server has them enabled (see KeyEventChannel).
+const KeyEventChannelPrefix = "__keyevent@"
+
+// KeyEventChannel returns the channel an event of type event ("set", "del",
+// "expired" or "evicted") on key in namespace is published on, with the key as
+// the message:
+//
+//	__keyevent@<namespace>__:<event>:<key>
+//
+// PSUBSCRIBE filters by event type and key prefix, e.g.
+// "__keyevent@default__:del:user:*".
+func KeyEventChannel(namespace, event, key string) string {
+	return KeyEventChannelPrefix + namespace + "__:" + event + ":" + key
+}
+
+// argLenSize is the size of the length prefix in front of each argument.
+const argLenSize = 4
+
+// AppendArg appends a length-prefixed argument to buf.
+func AppendArg(buf []byte, arg []byte) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(arg)))
+	return append(buf, arg...)
+}
+
+// EncodeArgs packs arguments into a single payload of length-prefixed elements.
+// It is used for command payloads carrying more than one value and for array responses.
+func EncodeArgs(args ...[]byte) []byte {
+	size := 0
+	for _, arg := range args {
+		size += argLenSize + len(arg)
+	}
+	buf := make([]byte, 0, size)
+	for _, arg := range args {
+		buf = AppendArg(buf, arg)
+	}
+	return buf
+}
+
+// DecodeArgs splits a payload produced by EncodeArgs back into its elements.
+// The returned slices alias payload.
+func DecodeArgs(payload []byte) ([][]byte, error) {
+	var args [][]byte
+	for len(payload) > 0 {
+		if len(payload) < argLenSize {
+			return nil, fmt.Errorf("truncated argument header")
+		}
+		n := binary.BigEndian.Uint32(payload[:argLenSize])
+		payload = payload[argLenSize:]
+		if uint64(n) > uint64(len(payload)) {
+			return nil, fmt.Errorf("argument length %d exceeds remaining payload %d", n, len(payload))
+		}
+		args = append(args, payload[:n:n])
+		payload = payload[n:]
+	}
+	return args, nil
+}
+
+// EncodeUint64 encodes an integer argument or response value.
+func EncodeUint64(v uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, v)
+}
+
+// DecodeUint64 decodes an integer produced by EncodeUint64.
+func DecodeUint64(b []byte) (uint64, error) {
+	if len(b) != 8 {
+		return 0, fmt.Errorf("invalid integer length: %d", len(b))
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// EncodeInt64 encodes a signed integer argument or response value.
+func EncodeInt64(v int64) []byte {
+	return EncodeUint64(uint64(v))
+}
+
+// DecodeInt64 decodes a signed integer produced by EncodeInt64.
+func DecodeInt64(b []byte) (int64, error) {
+	v, err := DecodeUint64(b)
+	return int64(v), err
+}
+
+// EncodeFloat64 encodes a floating point argument or response value.
+func EncodeFloat64(v float64) []byte {
+	return EncodeUint64(math.Float64bits(v))
+}
+
+// DecodeFloat64 decodes a float produced by EncodeFloat64.
+func DecodeFloat64(b []byte) (float64, error) {
+	v, err := DecodeUint64(b)
+	return math.Float64frombits(v), err
+}